@@ -0,0 +1,80 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/go-kit/log"
+)
+
+// accessLog logs a JSON record of every scrape request, independent of the
+// log level of the exporter, to audit who scrapes which devices.
+type accessLog struct {
+	logger log.Logger
+}
+
+func newAccessLog(w io.Writer) *accessLog {
+	return &accessLog{logger: log.NewJSONLogger(log.NewSyncWriter(w))}
+}
+
+// openAccessLogFile opens the given file for appending, - referring to
+// standard error.
+func openAccessLogFile(filename string) (io.WriteCloser, error) {
+	if filename == "-" {
+		return nopCloser{os.Stderr}, nil
+	}
+
+	return os.OpenFile(filename, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
+
+// wrap returns a handler logging the requests served by the given one.
+func (l *accessLog) wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		l.logger.Log(
+			"ts", start.UTC().Format(time.RFC3339Nano),
+			"client", r.RemoteAddr,
+			"method", r.Method,
+			"path", r.URL.Path,
+			"params", r.URL.Query(),
+			"status", rec.status,
+			"duration_seconds", time.Since(start).Seconds(),
+			"scrape_id", w.Header().Get(scrapeIDHeader),
+		)
+	})
+}
+
+// statusRecorder records the status code written to a response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
@@ -0,0 +1,55 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestAccessLog(t *testing.T) {
+	var buf bytes.Buffer
+	h := newAccessLog(&buf).wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(scrapeIDHeader, "abc")
+		http.Error(w, "no module", http.StatusBadRequest)
+	}))
+
+	r := httptest.NewRequest("GET", "/modbus?target=10.0.0.5:502&sub_target=1", nil)
+	r.RemoteAddr = "192.0.2.1:4321"
+	h.ServeHTTP(httptest.NewRecorder(), r)
+
+	var entry struct {
+		Client   string              `json:"client"`
+		Path     string              `json:"path"`
+		Params   map[string][]string `json:"params"`
+		Status   int                 `json:"status"`
+		Duration float64             `json:"duration_seconds"`
+		ScrapeID string              `json:"scrape_id"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatal(err)
+	}
+
+	if entry.Client != "192.0.2.1:4321" || entry.Path != "/modbus" || entry.Status != http.StatusBadRequest || entry.ScrapeID != "abc" {
+		t.Fatalf("unexpected access log entry %+v", entry)
+	}
+	expected := map[string][]string{"target": {"10.0.0.5:502"}, "sub_target": {"1"}}
+	if !reflect.DeepEqual(entry.Params, expected) {
+		t.Fatalf("expected params %v but got %v", expected, entry.Params)
+	}
+}
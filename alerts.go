@@ -0,0 +1,157 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/prometheus/common/model"
+
+	"github.com/RichiH/modbus_exporter/config"
+)
+
+// ruleFile is a Prometheus rule file.
+type ruleFile struct {
+	Groups []ruleGroup `yaml:"groups"`
+}
+
+type ruleGroup struct {
+	Name  string `yaml:"name"`
+	Rules []rule `yaml:"rules"`
+}
+
+type rule struct {
+	Alert       string            `yaml:"alert"`
+	Expr        string            `yaml:"expr"`
+	For         model.Duration    `yaml:"for,omitempty"`
+	Labels      map[string]string `yaml:"labels,omitempty"`
+	Annotations map[string]string `yaml:"annotations,omitempty"`
+}
+
+// generateAlerts prints a Prometheus rule file with alerts for the given
+// modules of the configuration file, or all of them, and returns the exit
+// code.
+func generateAlerts(w io.Writer, configFile string, opts config.LoadOptions, moduleNames []string, scrapeTimeout time.Duration) int {
+	c, err := config.LoadConfigWithOptions(configFile, opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v: %v\n", configFile, err)
+		return 1
+	}
+
+	modules := []*config.Module{}
+	for _, name := range moduleNames {
+		module := c.GetModule(name)
+		if module == nil {
+			fmt.Fprintf(os.Stderr, "%v: module '%v' not defined\n", configFile, name)
+			return 1
+		}
+		modules = append(modules, module)
+	}
+	if len(moduleNames) == 0 {
+		for i := range c.Modules {
+			modules = append(modules, &c.Modules[i])
+		}
+	}
+
+	b, err := yaml.Marshal(newAlertRules(modules, c.Targets, scrapeTimeout))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	w.Write(b)
+
+	return 0
+}
+
+// newAlertRules returns baseline alerts for the given modules. Devices being
+// down and exception spikes are alerted on in a group of their own, as
+// modbus_up and modbus_exceptions_total don't carry the module. Each module
+// gets a group alerting on scrapes taking close to its scrape timeout, the
+// given one unless the module has its own, and on the data of its background
+// polls going stale.
+func newAlertRules(modules []*config.Module, targets []config.PollTarget, scrapeTimeout time.Duration) ruleFile {
+	f := ruleFile{Groups: []ruleGroup{{
+		Name: "modbus",
+		Rules: []rule{
+			{
+				Alert:  "ModbusDeviceDown",
+				Expr:   "modbus_up == 0",
+				For:    model.Duration(5 * time.Minute),
+				Labels: map[string]string{"severity": "critical"},
+				Annotations: map[string]string{
+					"summary":     "Modbus device {{ $labels.instance }} is down",
+					"description": "Scrapes of {{ $labels.instance }} failed for 5 minutes.",
+				},
+			},
+			{
+				Alert:  "ModbusExceptionSpike",
+				Expr:   "sum by (instance, target, sub_target, exception_code) (increase(modbus_exceptions_total[10m])) > 10",
+				Labels: map[string]string{"severity": "warning"},
+				Annotations: map[string]string{
+					"summary":     "Modbus device {{ $labels.target }} responds with exceptions",
+					"description": "{{ $labels.target }} (sub target {{ $labels.sub_target }}) responded with exception code {{ $labels.exception_code }} {{ $value }} times in 10 minutes.",
+				},
+			},
+		},
+	}}}
+
+	for _, module := range modules {
+		timeout := scrapeTimeout
+		if module.ScrapeTimeout > 0 {
+			timeout = time.Duration(module.ScrapeTimeout)
+		}
+		threshold := strconv.FormatFloat(0.8*timeout.Seconds(), 'g', -1, 64)
+
+		g := ruleGroup{Name: "modbus_" + module.Name, Rules: []rule{{
+			Alert:  "ModbusScrapeDurationNearTimeout",
+			Expr:   fmt.Sprintf("histogram_quantile(0.9, sum by (instance, target, module, le) (rate(modbus_target_scrape_duration_seconds_bucket{module=%q}[10m]))) > %v", module.Name, threshold),
+			For:    model.Duration(15 * time.Minute),
+			Labels: map[string]string{"severity": "warning"},
+			Annotations: map[string]string{
+				"summary":     "Scrapes of {{ $labels.target }} take close to the timeout",
+				"description": fmt.Sprintf("90%% of the scrapes of {{ $labels.target }} with module {{ $labels.module }} take up to {{ $value }}s, close to the timeout of %v.", timeout),
+			},
+		}}}
+
+		var interval time.Duration
+		for _, t := range targets {
+			if t.Module == module.Name && time.Duration(t.Interval) > interval {
+				interval = time.Duration(t.Interval)
+			}
+		}
+		if interval > 0 {
+			stale := 3 * interval
+			g.Rules = append(g.Rules, rule{
+				Alert:  "ModbusPollDataStale",
+				Expr:   fmt.Sprintf("time() - modbus_poll_last_success_timestamp_seconds{module=%q} > %v", module.Name, stale.Seconds()),
+				For:    model.Duration(5 * time.Minute),
+				Labels: map[string]string{"severity": "warning"},
+				Annotations: map[string]string{
+					"summary":     "Background polls of {{ $labels.target }} are stale",
+					"description": fmt.Sprintf("The last successful poll of {{ $labels.target }} (sub target {{ $labels.sub_target }}) with module {{ $labels.module }} is more than %v ago.", stale),
+				},
+			})
+		}
+
+		f.Groups = append(f.Groups, g)
+	}
+
+	return f
+}
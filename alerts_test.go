@@ -0,0 +1,68 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+
+	"github.com/RichiH/modbus_exporter/config"
+)
+
+func TestNewAlertRules(t *testing.T) {
+	modules := []*config.Module{
+		{Name: "sdm630", ScrapeTimeout: model.Duration(5 * time.Second)},
+		{Name: "inverter"},
+	}
+	targets := []config.PollTarget{
+		{Target: "10.0.0.5:502", SubTarget: 1, Module: "sdm630", Interval: model.Duration(20 * time.Second)},
+		{Target: "10.0.0.6:502", SubTarget: 1, Module: "sdm630", Interval: model.Duration(time.Minute)},
+	}
+
+	f := newAlertRules(modules, targets, 10*time.Second)
+	if len(f.Groups) != 3 {
+		t.Fatalf("expected a common group and one per module but got %d groups", len(f.Groups))
+	}
+	if f.Groups[0].Name != "modbus" || f.Groups[0].Rules[0].Expr != "modbus_up == 0" {
+		t.Errorf("expected device down alert in common group but got %+v", f.Groups[0])
+	}
+
+	for _, test := range []struct {
+		group    ruleGroup
+		name     string
+		expected []string
+	}{
+		{f.Groups[1], "modbus_sdm630", []string{
+			`histogram_quantile(0.9, sum by (instance, target, module, le) (rate(modbus_target_scrape_duration_seconds_bucket{module="sdm630"}[10m]))) > 4`,
+			`time() - modbus_poll_last_success_timestamp_seconds{module="sdm630"} > 180`,
+		}},
+		{f.Groups[2], "modbus_inverter", []string{
+			`histogram_quantile(0.9, sum by (instance, target, module, le) (rate(modbus_target_scrape_duration_seconds_bucket{module="inverter"}[10m]))) > 8`,
+		}},
+	} {
+		if test.group.Name != test.name {
+			t.Errorf("expected group %v but got %v", test.name, test.group.Name)
+		}
+		if len(test.group.Rules) != len(test.expected) {
+			t.Fatalf("%v: expected %d rules but got %+v", test.name, len(test.expected), test.group.Rules)
+		}
+		for i, expr := range test.expected {
+			if test.group.Rules[i].Expr != expr {
+				t.Errorf("%v: expected %q but got %q", test.name, expr, test.group.Rules[i].Expr)
+			}
+		}
+	}
+}
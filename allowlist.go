@@ -0,0 +1,47 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/RichiH/modbus_exporter/config"
+)
+
+// targetsDenied counts the requests for targets not on the allowlist. It has
+// no target label, as the targets are chosen by whoever sends the requests.
+var targetsDenied = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "modbus_target_denied_requests_total",
+	Help: "Requests rejected for targets not on the allowlist of the configuration file.",
+})
+
+// checkTarget returns an error if any address of the given, possibly comma
+// separated, target is not allowed by the configuration, logging the attempt.
+func checkTarget(c *config.Config, target string, r *http.Request, logger log.Logger) error {
+	for _, address := range strings.Split(target, ",") {
+		if !c.TargetAllowed(address) {
+			targetsDenied.Inc()
+			level.Warn(logger).Log("msg", "Rejected request for target not on the allowlist", "target", address, "remote_addr", r.RemoteAddr)
+			return fmt.Errorf("target '%v' not allowed", address)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,217 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/RichiH/modbus_exporter/config"
+	"github.com/RichiH/modbus_exporter/modbus"
+)
+
+// benchResult is the outcome of the reads of one block size.
+type benchResult struct {
+	quantity  uint16
+	durations []time.Duration
+	failed    int
+	err       error
+}
+
+// benchQuantities returns the block sizes to benchmark, doubling from a
+// single register up to max, which is included.
+func benchQuantities(max uint16) []uint16 {
+	quantities := []uint16{}
+	for q := uint16(1); q < max; q *= 2 {
+		quantities = append(quantities, q)
+	}
+
+	return append(quantities, max)
+}
+
+// benchReads reads blocks of increasing size starting at the given address of
+// the target, the given number of times each, and prints the distribution of
+// the round-trip times and the failures per block size. The reads go through
+// a scrape of a module with maxReadRegisters set to the block size, so they
+// are sent like the ones of scrapes, while the round-trip times exclude
+// connecting. Larger blocks aren't tried once all reads of a size fail. The
+// largest size read without failures and the slowest read are what
+// maxReadRegisters and timeout of modules for the device should be based on.
+// It returns the exit code, non-zero if single registers can't be read.
+func benchReads(w io.Writer, target string, subTarget uint8, address uint32, maxQuantity uint16, requests int, timeout time.Duration) int {
+	functionCode, start, err := config.RegisterAddr(address).Split()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	max := uint16(config.MaxReadRegisters)
+	if config.IsBitAccess(functionCode) {
+		max = config.MaxReadBits
+	}
+	if maxQuantity == 0 || maxQuantity > max {
+		fmt.Fprintf(os.Stderr, "maximum quantity must be from 1 to %d\n", max)
+		return 1
+	}
+	if requests <= 0 {
+		fmt.Fprintln(os.Stderr, "number of requests must be positive")
+		return 1
+	}
+
+	quantities := benchQuantities(maxQuantity)
+	modules := make([]config.Module, 0, len(quantities))
+	for _, q := range quantities {
+		if int(start)+int(q) > 65536 {
+			break
+		}
+		modules = append(modules, benchModule(functionCode, start, q, timeout))
+	}
+
+	var mtx sync.Mutex
+	var observed []modbus.Request
+	e := modbus.NewExporter(config.Config{Modules: modules}, modbus.WithRequestListener(func(r modbus.Request) {
+		mtx.Lock()
+		defer mtx.Unlock()
+		observed = append(observed, r)
+	}))
+
+	results := []benchResult{}
+	for _, m := range modules {
+		result := benchResult{quantity: uint16(m.MaxReadRegisters)}
+		for i := 0; i < requests; i++ {
+			mtx.Lock()
+			observed = nil
+			mtx.Unlock()
+
+			_, err := e.Scrape(context.Background(), target, subTarget, m.Name)
+
+			mtx.Lock()
+			sent := observed
+			mtx.Unlock()
+			if len(sent) == 0 {
+				result.failed++
+				if result.err == nil {
+					result.err = err
+				}
+				continue
+			}
+			for _, r := range sent {
+				if r.Err != nil {
+					result.failed++
+					if result.err == nil {
+						result.err = r.Err
+					}
+					continue
+				}
+				result.durations = append(result.durations, r.Duration)
+			}
+		}
+		results = append(results, result)
+
+		if len(result.durations) == 0 {
+			break
+		}
+	}
+
+	writeBenchResults(w, results)
+	if len(results[0].durations) == 0 {
+		return 1
+	}
+
+	return 0
+}
+
+// benchModule returns a module reading the given quantity of registers (or
+// coils, discrete inputs) with a single request.
+func benchModule(functionCode uint8, start, quantity uint16, timeout time.Duration) config.Module {
+	m := config.Module{
+		Name:             fmt.Sprintf("bench_%d", quantity),
+		Protocol:         config.ModbusProtocolTCPIP,
+		Timeout:          int(timeout / time.Millisecond),
+		MaxReadRegisters: int(quantity),
+		Metrics:          make([]config.MetricDef, 0, quantity),
+	}
+	for i := uint16(0); i < quantity; i++ {
+		d := config.MetricDef{
+			Name:       fmt.Sprintf("register_%d", i),
+			Address:    config.RegisterAddr(uint32(functionCode)*100000 + uint32(start) + uint32(i)),
+			DataType:   config.ModbusUInt16,
+			MetricType: config.MetricTypeGauge,
+		}
+		if config.IsBitAccess(functionCode) {
+			bitOffset := 0
+			d.DataType = config.ModbusBool
+			d.BitOffset = &bitOffset
+		}
+		m.Metrics = append(m.Metrics, d)
+	}
+
+	return m
+}
+
+// writeBenchResults prints a table of the round-trip times per block size,
+// followed by the largest size read without failures, the first size failing
+// and the slowest successful read.
+func writeBenchResults(w io.Writer, results []benchResult) {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "quantity\tok\tfailed\tmin\tmedian\tp90\tmax\terror")
+
+	var reliable uint16
+	var slowest time.Duration
+	var failing *benchResult
+	for i, r := range results {
+		sort.Slice(r.durations, func(i, j int) bool { return r.durations[i] < r.durations[j] })
+		fmt.Fprintf(tw, "%d\t%d\t%d", r.quantity, len(r.durations), r.failed)
+		if n := len(r.durations); n > 0 {
+			fmt.Fprintf(tw, "\t%v\t%v\t%v\t%v",
+				r.durations[0].Round(time.Microsecond),
+				r.durations[n/2].Round(time.Microsecond),
+				r.durations[(n-1)*9/10].Round(time.Microsecond),
+				r.durations[n-1].Round(time.Microsecond))
+			if r.durations[n-1] > slowest {
+				slowest = r.durations[n-1]
+			}
+		} else {
+			fmt.Fprint(tw, "\t-\t-\t-\t-")
+		}
+		if r.err != nil {
+			fmt.Fprintf(tw, "\t%v", r.err)
+		}
+		fmt.Fprintln(tw)
+
+		if r.failed == 0 && failing == nil {
+			reliable = r.quantity
+		}
+		if r.failed > 0 && failing == nil {
+			failing = &results[i]
+		}
+	}
+	tw.Flush()
+
+	fmt.Fprintln(w)
+	if reliable > 0 {
+		fmt.Fprintf(w, "largest quantity read without failures: %d\n", reliable)
+	}
+	if failing != nil {
+		fmt.Fprintf(w, "reads start failing at quantity %d: %v\n", failing.quantity, failing.err)
+	}
+	if slowest > 0 {
+		fmt.Fprintf(w, "slowest successful read: %v\n", slowest.Round(time.Microsecond))
+	}
+}
@@ -0,0 +1,60 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/binary"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tbrandon/mbserver"
+)
+
+func TestBenchReads(t *testing.T) {
+	s, address := startServer(t)
+	s.RegisterFunctionHandler(3, func(s *mbserver.Server, frame mbserver.Framer) ([]byte, *mbserver.Exception) {
+		if binary.BigEndian.Uint16(frame.GetData()[2:]) > 16 {
+			return []byte{}, &mbserver.IllegalDataAddress
+		}
+		return mbserver.ReadHoldingRegisters(s, frame)
+	})
+
+	var b strings.Builder
+	if code := benchReads(&b, address, 1, 300000, 100, 3, time.Second); code != 0 {
+		t.Fatalf("expected exit code 0 but got %v:\n%v", code, b.String())
+	}
+
+	for _, pattern := range []string{
+		`(?m)^1\s+3\s+0\s+\S+\s+\S+\s+\S+\s+\S+$`,
+		`(?m)^16\s+3\s+0\s`,
+		`(?m)^32\s+0\s+3\s+-\s+-\s+-\s+-\s+.*exception '2'`,
+		`(?m)^largest quantity read without failures: 16$`,
+		`(?m)^reads start failing at quantity 32: `,
+		`(?m)^slowest successful read: `,
+	} {
+		if !regexp.MustCompile(pattern).MatchString(b.String()) {
+			t.Errorf("expected output to match %v but got:\n%v", pattern, b.String())
+		}
+	}
+	if strings.Contains(b.String(), "\n64 ") {
+		t.Errorf("expected larger blocks not to be read once all reads failed but got:\n%v", b.String())
+	}
+
+	b.Reset()
+	if code := benchReads(&b, freeAddress(t), 1, 300000, 1, 1, 100*time.Millisecond); code != 1 {
+		t.Fatalf("expected exit code 1 without responses but got %v:\n%v", code, b.String())
+	}
+}
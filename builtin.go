@@ -0,0 +1,68 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/RichiH/modbus_exporter/config"
+)
+
+// listBuiltins prints the names of the builtin modules along with the first
+// line of their description.
+func listBuiltins(w io.Writer) int {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, name := range config.BuiltinModules() {
+		source, _ := config.BuiltinModuleSource(name)
+		fmt.Fprintf(tw, "%v\t%v\n", config.BuiltinPrefix+name, builtinDescription(source))
+	}
+	if err := tw.Flush(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	return 0
+}
+
+// builtinDescription returns the first line of the comment the configuration
+// file of a builtin module starts with.
+func builtinDescription(source []byte) string {
+	line, _, _ := strings.Cut(string(source), "\n")
+	if !strings.HasPrefix(line, "#") {
+		return ""
+	}
+
+	return strings.TrimSpace(strings.TrimPrefix(line, "#"))
+}
+
+// exportBuiltin prints the configuration file defining the builtin module
+// with the given name, with or without BuiltinPrefix.
+func exportBuiltin(w io.Writer, name string) int {
+	source, ok := config.BuiltinModuleSource(strings.TrimPrefix(name, config.BuiltinPrefix))
+	if !ok {
+		fmt.Fprintf(os.Stderr, "no builtin module '%v', see the builtin list command\n", name)
+		return 1
+	}
+
+	if _, err := w.Write(source); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,51 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/RichiH/modbus_exporter/config"
+)
+
+func TestListBuiltins(t *testing.T) {
+	var b strings.Builder
+	if code := listBuiltins(&b); code != 0 {
+		t.Fatalf("expected exit code 0 but got %v", code)
+	}
+
+	if !regexp.MustCompile(`(?m)^builtin:sdm630 +Eastron SDM630 three phase energy meter`).MatchString(b.String()) {
+		t.Fatalf("expected sdm630 to be listed with description but got:\n%v", b.String())
+	}
+}
+
+func TestExportBuiltin(t *testing.T) {
+	for _, name := range []string{"sdm630", "builtin:sdm630"} {
+		var b strings.Builder
+		if code := exportBuiltin(&b, name); code != 0 {
+			t.Fatalf("%v: expected exit code 0 but got %v", name, code)
+		}
+
+		source, _ := config.BuiltinModuleSource("sdm630")
+		if b.String() != string(source) {
+			t.Fatalf("%v: expected the source of the module but got:\n%v", name, b.String())
+		}
+	}
+
+	if code := exportBuiltin(&strings.Builder{}, "undefined"); code != 1 {
+		t.Fatalf("expected exit code 1 for undefined module but got %v", code)
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// clientsThrottled counts the scrape requests rejected for exceeding the rate
+// limit of their client. Like targetsDenied, it has no client label.
+var clientsThrottled = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "modbus_client_throttled_requests_total",
+	Help: "Scrape requests rejected for exceeding the rate limit of their client.",
+})
+
+// clientLimiter limits the rate of requests per client IP address with a
+// token bucket each, so that e.g. a dashboard refreshing every second can't
+// saturate a slow bus.
+type clientLimiter struct {
+	rate  float64
+	burst float64
+	now   func() time.Time
+
+	mtx       sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// newClientLimiter returns a limiter allowing the given number of requests
+// per second and client, with bursts of up to the given size.
+func newClientLimiter(rate float64, burst int) *clientLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &clientLimiter{rate: rate, burst: float64(burst), now: time.Now, buckets: map[string]*bucket{}}
+}
+
+// allow takes a token from the bucket of the given client. If it is empty, it
+// returns false and the time until the next token.
+func (l *clientLimiter) allow(client string) (bool, time.Duration) {
+	now := l.now()
+
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	l.sweep(now)
+
+	b, ok := l.buckets[client]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[client] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	}
+	b.tokens--
+
+	return true, 0
+}
+
+// sweep forgets the clients whose buckets refilled completely, at most once
+// a minute.
+func (l *clientLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < time.Minute {
+		return
+	}
+	l.lastSweep = now
+
+	for client, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, client)
+		}
+	}
+}
+
+// wrap returns a handler responding with 429 Too Many Requests to clients
+// exceeding their rate, passing all other requests to the given one.
+func (l *clientLimiter) wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			client = r.RemoteAddr
+		}
+
+		if ok, wait := l.allow(client); !ok {
+			clientsThrottled.Inc()
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			http.Error(w, "rate limit of client exceeded", http.StatusTooManyRequests)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,70 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestClientLimiter(t *testing.T) {
+	now := time.Unix(0, 0)
+	l := newClientLimiter(0.5, 2)
+	l.now = func() time.Time { return now }
+	handler := l.wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	request := func(remoteAddr string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/modbus", nil)
+		r.RemoteAddr = remoteAddr
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, r)
+		return rr
+	}
+
+	throttled := testutil.ToFloat64(clientsThrottled)
+	for i, expected := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		if rr := request("192.0.2.1:40000"); rr.Code != expected {
+			t.Fatalf("request %d: expected status %v but got %v", i, expected, rr.Code)
+		}
+	}
+	// Other clients have their own limit.
+	if rr := request("192.0.2.2:40000"); rr.Code != http.StatusOK {
+		t.Fatalf("expected other client to be allowed but got %v", rr.Code)
+	}
+
+	now = now.Add(time.Second)
+	rr := request("192.0.2.1:40001")
+	if rr.Code != http.StatusTooManyRequests || rr.Header().Get("Retry-After") != "1" {
+		t.Fatalf("expected 429 with Retry-After 1 but got %v with %q", rr.Code, rr.Header().Get("Retry-After"))
+	}
+	if got := testutil.ToFloat64(clientsThrottled) - throttled; got != 2 {
+		t.Fatalf("expected 2 throttled requests but got %v", got)
+	}
+
+	now = now.Add(time.Second)
+	if rr := request("192.0.2.1:40002"); rr.Code != http.StatusOK {
+		t.Fatalf("expected request to be allowed after refill but got %v", rr.Code)
+	}
+
+	// Clients with full buckets are forgotten.
+	now = now.Add(time.Hour)
+	request("192.0.2.3:40000")
+	if len(l.buckets) != 1 {
+		t.Fatalf("expected only the last client to be remembered but got %v", l.buckets)
+	}
+}
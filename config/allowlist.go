@@ -0,0 +1,100 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"net"
+	"path"
+)
+
+// AllowedTargets restricts the targets scraped on request to the ones
+// matching any of its entries, besides the polled targets.
+type AllowedTargets struct {
+	// Networks in CIDR notation, e.g. 10.0.0.0/8, matched against targets
+	// given by IP address. Hostnames aren't resolved.
+	Networks []string `yaml:"networks,omitempty"`
+	// Shell patterns matched against the host of targets, e.g.
+	// "*.plant.example.com".
+	Hosts []string `yaml:"hosts,omitempty"`
+	// Targets allowed as given, including the port, e.g. "10.1.2.3:502".
+	Targets []string `yaml:"targets,omitempty"`
+}
+
+// validate semantically validates the given allowlist.
+func (a *AllowedTargets) validate() error {
+	if len(a.Networks) == 0 && len(a.Hosts) == 0 && len(a.Targets) == 0 {
+		return fmt.Errorf("allowedTargets: no networks, hosts or targets defined")
+	}
+
+	for _, n := range a.Networks {
+		if _, _, err := net.ParseCIDR(n); err != nil {
+			return fmt.Errorf("allowedTargets: invalid network '%v': %v", n, err)
+		}
+	}
+
+	for _, h := range a.Hosts {
+		if _, err := path.Match(h, ""); err != nil {
+			return fmt.Errorf("allowedTargets: invalid host pattern '%v': %v", h, err)
+		}
+	}
+
+	return nil
+}
+
+// allows returns whether the given target matches any entry.
+func (a *AllowedTargets) allows(target string) bool {
+	for _, t := range a.Targets {
+		if t == target {
+			return true
+		}
+	}
+
+	host, _, err := net.SplitHostPort(target)
+	if err != nil {
+		host = target
+	}
+
+	for _, h := range a.Hosts {
+		if ok, _ := path.Match(h, host); ok {
+			return true
+		}
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		for _, n := range a.Networks {
+			if _, network, err := net.ParseCIDR(n); err == nil && network.Contains(ip) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// TargetAllowed returns whether the given target may be scraped on request.
+// Without allowlist, all targets are.
+func (c *Config) TargetAllowed(target string) bool {
+	if c.AllowedTargets == nil {
+		return true
+	}
+
+	for _, t := range c.Targets {
+		if t.Target == target {
+			return true
+		}
+	}
+
+	return c.AllowedTargets.allows(target)
+}
@@ -0,0 +1,111 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"embed"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// BuiltinPrefix prefixes the names of the modules shipped with the exporter,
+// e.g. builtin:sdm630, to tell them apart from the ones of the configuration.
+const BuiltinPrefix = "builtin:"
+
+//go:embed builtin/*.yml
+var builtinFiles embed.FS
+
+var builtins struct {
+	once    sync.Once
+	modules map[string]Module
+	err     error
+}
+
+// loadBuiltins parses and validates all builtin modules once.
+func loadBuiltins() (map[string]Module, error) {
+	builtins.once.Do(func() {
+		builtins.modules = map[string]Module{}
+		for _, name := range BuiltinModules() {
+			source, _ := BuiltinModuleSource(name)
+			c, err := parseConfig(name+".yml", source, LoadOptions{Strict: true})
+			if err != nil {
+				builtins.err = fmt.Errorf("builtin module %v: %v", name, err)
+				return
+			}
+			if len(c.Modules) != 1 {
+				builtins.err = fmt.Errorf("builtin module %v: expected exactly one module but got %d", name, len(c.Modules))
+				return
+			}
+
+			m := c.Modules[0]
+			m.Name = BuiltinPrefix + name
+			if err := m.validate(); err != nil {
+				builtins.err = fmt.Errorf("builtin module %v: %v", name, err)
+				return
+			}
+			builtins.modules[name] = m
+		}
+	})
+
+	return builtins.modules, builtins.err
+}
+
+// BuiltinModules returns the sorted names of the builtin modules, without
+// BuiltinPrefix.
+func BuiltinModules() []string {
+	entries, _ := builtinFiles.ReadDir("builtin")
+
+	names := make([]string, 0, len(entries))
+	for _, e := range entries {
+		names = append(names, strings.TrimSuffix(e.Name(), path.Ext(e.Name())))
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// BuiltinModuleSource returns the configuration file defining the builtin
+// module with the given name, without BuiltinPrefix, e.g. as a starting point
+// for a customized module.
+func BuiltinModuleSource(name string) ([]byte, bool) {
+	source, err := builtinFiles.ReadFile(path.Join("builtin", name+".yml"))
+	if err != nil {
+		return nil, false
+	}
+
+	return source, true
+}
+
+// builtinModule returns the builtin module referred to by the given name
+// including BuiltinPrefix, or nil if there is none.
+func builtinModule(n string) *Module {
+	name, ok := strings.CutPrefix(n, BuiltinPrefix)
+	if !ok {
+		return nil
+	}
+
+	modules, err := loadBuiltins()
+	if err != nil {
+		return nil
+	}
+	m, ok := modules[name]
+	if !ok {
+		return nil
+	}
+
+	return &m
+}
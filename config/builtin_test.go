@@ -0,0 +1,65 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"testing"
+)
+
+func TestBuiltinModules(t *testing.T) {
+	names := BuiltinModules()
+	if len(names) == 0 {
+		t.Fatal("expected builtin modules")
+	}
+
+	if _, err := loadBuiltins(); err != nil {
+		t.Fatal(err)
+	}
+
+	c := &Config{}
+	for _, name := range names {
+		m := c.GetModule(BuiltinPrefix + name)
+		if m == nil {
+			t.Fatalf("expected builtin module %v", name)
+		}
+		if m.Name != BuiltinPrefix+name {
+			t.Errorf("expected name %v but got %v", BuiltinPrefix+name, m.Name)
+		}
+		if _, err := m.ReadPlan(); err != nil {
+			t.Errorf("builtin module %v: %v", name, err)
+		}
+	}
+
+	for _, n := range []string{"sdm630", BuiltinPrefix + "undefined"} {
+		if c.HasModule(n) {
+			t.Errorf("expected no module %v", n)
+		}
+	}
+
+	if _, ok := BuiltinModuleSource("sdm630"); !ok {
+		t.Error("expected source of builtin module sdm630")
+	}
+}
+
+func TestConfigValidateBuiltinPrefix(t *testing.T) {
+	c := Config{Modules: []Module{{
+		Name:     BuiltinPrefix + "sdm630",
+		Protocol: ModbusProtocolTCPIP,
+		Metrics:  []MetricDef{{Name: "a", Address: 300001, DataType: ModbusInt16, MetricType: MetricTypeGauge}},
+	}}}
+
+	if err := c.validate(); err == nil {
+		t.Fatal("expected error for module name with builtin prefix")
+	}
+}
@@ -11,23 +11,183 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
+// Package config defines, loads and validates the configuration of the
+// exporter: the modules describing the register maps of devices, targets
+// polled in the background and the settings shared by them.
 package config
 
 import (
 	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
 
 	multierror "github.com/hashicorp/go-multierror"
+	promconfig "github.com/prometheus/common/config"
+	"github.com/prometheus/common/model"
 )
 
 // Config represents the configuration of the modbus exporter.
 type Config struct {
 	Modules []Module `yaml:"modules"`
+
+	// Targets polled continuously in the background.
+	Targets []PollTarget `yaml:"targets,omitempty"`
+
+	// Named metric definitions shared by several modules via include.
+	MetricGroups []MetricGroup `yaml:"metricGroups,omitempty"`
+
+	// Settings inherited by all modules not defining them themselves.
+	Defaults Defaults `yaml:"defaults,omitempty"`
+
+	// Broker the results of polled targets are published to.
+	MQTT *MQTT `yaml:"mqtt,omitempty"`
+
+	// Discovery of devices announcing themselves via mDNS.
+	MDNS *MDNS `yaml:"mdns,omitempty"`
+
+	// Selection of the module of scrapes not specifying one by the
+	// identification of the device.
+	Identification *Identification `yaml:"identification,omitempty"`
+
+	// Targets which may be scraped on request. Without, all targets may be.
+	AllowedTargets *AllowedTargets `yaml:"allowedTargets,omitempty"`
+}
+
+// Defaults holds settings inherited by all modules unless overridden.
+type Defaults struct {
+	Timeout          int            `yaml:"timeout,omitempty"`
+	ScrapeTimeout    model.Duration `yaml:"scrapeTimeout,omitempty"`
+	MaxReadRegisters int            `yaml:"maxReadRegisters,omitempty"`
+	Baudrate         int            `yaml:"baudrate,omitempty"`
+	Databits         int            `yaml:"databits,omitempty"`
+	Stopbits         int            `yaml:"stopbits,omitempty"`
+	Parity           string         `yaml:"parity,omitempty"`
+
+	// Endianness of metric definitions not defining one.
+	Endianness EndiannessType `yaml:"endianness,omitempty"`
+}
+
+// applyDefaults sets the settings of all modules left unset to the
+// configured defaults.
+func (c *Config) applyDefaults() {
+	d := c.Defaults
+	for i := range c.Modules {
+		m := &c.Modules[i]
+		if m.Timeout == 0 {
+			m.Timeout = d.Timeout
+		}
+		if m.ScrapeTimeout == 0 {
+			m.ScrapeTimeout = d.ScrapeTimeout
+		}
+		if m.MaxReadRegisters == 0 {
+			m.MaxReadRegisters = d.MaxReadRegisters
+		}
+		if m.Baudrate == 0 {
+			m.Baudrate = d.Baudrate
+		}
+		if m.Databits == 0 {
+			m.Databits = d.Databits
+		}
+		if m.Stopbits == 0 {
+			m.Stopbits = d.Stopbits
+		}
+		if m.Parity == "" {
+			m.Parity = d.Parity
+		}
+
+		for j := range m.Metrics {
+			if m.Metrics[j].Endianness == "" {
+				m.Metrics[j].Endianness = d.Endianness
+			}
+		}
+	}
+}
+
+// MetricGroup is a named set of metric definitions, e.g. a common block of
+// serial number, firmware and status registers shared by a device family.
+type MetricGroup struct {
+	Name    string      `yaml:"name"`
+	Metrics []MetricDef `yaml:"metrics"`
+}
+
+// resolveIncludes adds the metric definitions of the groups included by each
+// module to the module.
+func (c *Config) resolveIncludes() error {
+	groups := map[string][]MetricDef{}
+	for _, g := range c.MetricGroups {
+		if _, ok := groups[g.Name]; ok {
+			return fmt.Errorf("metric group '%v' defined more than once", g.Name)
+		}
+		groups[g.Name] = g.Metrics
+	}
+
+	for i := range c.Modules {
+		m := &c.Modules[i]
+		if len(m.Include) == 0 {
+			continue
+		}
+
+		metrics := []MetricDef{}
+		for _, name := range m.Include {
+			g, ok := groups[name]
+			if !ok {
+				return fmt.Errorf("module '%v' includes undefined metric group '%v'", m.Name, name)
+			}
+			metrics = append(metrics, g...)
+		}
+		m.Metrics = append(metrics, m.Metrics...)
+	}
+
+	return nil
 }
 
 // validate semantically validates the given config.
 func (c *Config) validate() error {
-	for _, t := range c.Modules {
-		if err := t.validate(); err != nil {
+	names := map[string]bool{}
+	for _, m := range c.Modules {
+		if names[m.Name] {
+			return fmt.Errorf("module '%v' defined more than once", m.Name)
+		}
+		if strings.HasPrefix(m.Name, BuiltinPrefix) {
+			return fmt.Errorf("module name '%v' must not start with reserved prefix '%v'", m.Name, BuiltinPrefix)
+		}
+		names[m.Name] = true
+	}
+
+	for i := range c.Modules {
+		if err := c.Modules[i].validate(); err != nil {
+			return err
+		}
+	}
+
+	for _, t := range c.Targets {
+		if err := t.validate(c); err != nil {
+			return err
+		}
+	}
+
+	if c.MQTT != nil {
+		if err := c.MQTT.validate(); err != nil {
+			return err
+		}
+	}
+
+	if c.MDNS != nil {
+		if err := c.MDNS.validate(c); err != nil {
+			return err
+		}
+	}
+
+	if c.Identification != nil {
+		if err := c.Identification.validate(c); err != nil {
+			return err
+		}
+	}
+
+	if c.AllowedTargets != nil {
+		if err := c.AllowedTargets.validate(); err != nil {
 			return err
 		}
 	}
@@ -35,13 +195,43 @@ func (c *Config) validate() error {
 	return nil
 }
 
+// PollTarget defines a target scraped by the exporter in the background at a
+// fixed interval instead of on every Prometheus scrape.
+type PollTarget struct {
+	Target    string         `yaml:"target"`
+	SubTarget int            `yaml:"subTarget"`
+	Module    string         `yaml:"module"`
+	Interval  model.Duration `yaml:"interval"`
+}
+
+func (t *PollTarget) validate(c *Config) error {
+	if t.Target == "" {
+		return fmt.Errorf("poll target without target address")
+	}
+
+	if t.SubTarget < 0 || t.SubTarget > 255 {
+		return fmt.Errorf("poll target %v: sub target must be from 0 to 255 but got %d", t.Target, t.SubTarget)
+	}
+
+	if !c.HasModule(t.Module) {
+		return fmt.Errorf("poll target %v: module '%v' not defined", t.Target, t.Module)
+	}
+
+	if t.Interval <= 0 {
+		return fmt.Errorf("poll target %v: interval must be positive", t.Target)
+	}
+
+	return nil
+}
+
 // HasModule returns whether the given config has a module with the given name.
 func (c *Config) HasModule(n string) bool {
 	return c.GetModule(n) != nil
 }
 
 // GetModule returns the module matching the given string or nil if none was
-// found.
+// found. Names prefixed with BuiltinPrefix refer to the modules shipped with
+// the exporter.
 func (c *Config) GetModule(n string) *Module {
 	for _, m := range c.Modules {
 		m := m
@@ -50,7 +240,7 @@ func (c *Config) GetModule(n string) *Module {
 		}
 	}
 
-	return nil
+	return builtinModule(n)
 }
 
 // ListTargets is the list of configurations of the targets from the configuration
@@ -67,6 +257,131 @@ type Module struct {
 	Stopbits int            `yaml:"stopbits"`
 	Parity   string         `yaml:"parity"`
 	Metrics  []MetricDef    `yaml:"metrics"`
+
+	// Labels added to every metric of this module, e.g. vendor and model of
+	// the device. Labels of the metric definition take precedence.
+	Labels map[string]string `yaml:"labels,omitempty"`
+
+	// Whether to add a "module" label with the name of this module to all
+	// its metrics. Defaults to true.
+	ModuleLabel *bool `yaml:"moduleLabel,omitempty"`
+
+	// Prefix joined with an underscore to the names of all metrics of this
+	// module, e.g. the device family.
+	MetricPrefix string `yaml:"metricPrefix,omitempty"`
+
+	// Names of metric groups whose definitions are added to the ones of
+	// this module.
+	Include []string `yaml:"include,omitempty"`
+
+	// Timeouts in milliseconds overriding Timeout for individual sub targets
+	// (unit IDs), e.g. slow slaves behind a shared gateway.
+	SubTargetTimeouts map[int]int `yaml:"subTargetTimeouts,omitempty"`
+
+	// Names of sub targets (unit IDs), accepted instead of the number in the
+	// sub_target parameter, e.g. inverter_1: 3.
+	SubTargetNames map[string]int `yaml:"subTargetNames,omitempty"`
+
+	// Duration successful scrape results are served from cache for the same
+	// target, sub target and module instead of querying the device again.
+	CacheTTL model.Duration `yaml:"cacheTTL,omitempty"`
+
+	// Number of connections reading registers in parallel during one scrape,
+	// for TCP devices tolerating concurrent requests. Defaults to 1.
+	Parallelism int `yaml:"parallelism,omitempty"`
+
+	// Upper bound for the duration of a whole scrape of this module, as
+	// opposed to Timeout bounding a single request.
+	ScrapeTimeout model.Duration `yaml:"scrapeTimeout,omitempty"`
+
+	// Minimum duration between two scrapes of the same target, sub target
+	// and module. Scrapes arriving earlier get the previous result.
+	MinInterval model.Duration `yaml:"minInterval,omitempty"`
+
+	// Number of read requests per chunk. Each chunk gets a share of the
+	// scrape deadline and failing chunks don't fail the whole scrape. 0
+	// disables chunking.
+	ChunkSize int `yaml:"chunkSize,omitempty"`
+
+	// Number of times a request answered with the slave busy exception is
+	// retried, e.g. for drives busy after parameter writes. 0 disables
+	// retrying.
+	BusyRetries int `yaml:"busyRetries,omitempty"`
+
+	// Delay before retrying a request answered with the slave busy
+	// exception. Defaults to 100ms.
+	BusyRetryDelay model.Duration `yaml:"busyRetryDelay,omitempty"`
+
+	// Interval at which requests answered with the acknowledge exception,
+	// sent by devices taking long to process them, are repeated until the
+	// device responds otherwise. 0 disables polling.
+	AcknowledgePollInterval model.Duration `yaml:"acknowledgePollInterval,omitempty"`
+
+	// Duration after the first acknowledge exception after which polling
+	// gives up. Required with AcknowledgePollInterval.
+	AcknowledgeTimeout model.Duration `yaml:"acknowledgeTimeout,omitempty"`
+
+	// Maximum number of registers (or coils, discrete inputs) read with a
+	// single request. Defaults to the protocol maximum.
+	MaxReadRegisters int `yaml:"maxReadRegisters,omitempty"`
+
+	// Register holding the time the data of the device was last valid, e.g.
+	// of RTUs buffering values during communication outages. If set, the
+	// samples of the module are exposed with this timestamp.
+	TimestampRegister *TimestampRegister `yaml:"timestampRegister,omitempty"`
+
+	// Reads the models of devices implementing SunSpec, e.g. most solar
+	// inverters, and exposes their values as standardized metrics in
+	// addition to Metrics, which may then be empty.
+	SunSpec *SunSpec `yaml:"sunspec,omitempty"`
+
+	// Ranges of coils and holding registers which may be written via the
+	// write endpoint. Without, none may be.
+	Writable []WritableRange `yaml:"writable,omitempty"`
+
+	// Secures the connections to the targets with Modbus/TCP Security, i.e.
+	// TLS, usually on port 802. Modules of sites running their own PKI set
+	// their own CA and client certificate.
+	TLSConfig *promconfig.TLSConfig `yaml:"tlsConfig,omitempty"`
+
+	plan *ReadPlan
+}
+
+// SubTarget returns the sub target with the given name, or false if the
+// module doesn't define it.
+func (s *Module) SubTarget(name string) (byte, bool) {
+	id, ok := s.SubTargetNames[name]
+	if !ok {
+		return 0, false
+	}
+
+	return byte(id), true
+}
+
+// HasModuleLabel returns whether the metrics of the module get a "module"
+// label.
+func (s *Module) HasModuleLabel() bool {
+	return s.ModuleLabel == nil || *s.ModuleLabel
+}
+
+// MetricName returns the name the metric with the given name of the module is
+// exposed with.
+func (s *Module) MetricName(name string) string {
+	if s.MetricPrefix == "" {
+		return name
+	}
+
+	return s.MetricPrefix + "_" + name
+}
+
+// TimeoutFor returns the timeout in milliseconds to use for the given sub
+// target, 0 meaning the transport default.
+func (s *Module) TimeoutFor(subTarget byte) int {
+	if t, ok := s.SubTargetTimeouts[int(subTarget)]; ok {
+		return t
+	}
+
+	return s.Timeout
 }
 
 // RegisterAddr specifies the register in the possible output of _digital
@@ -78,23 +393,11 @@ type RegisterAddr uint32
 type ModbusDataType string
 
 func (t *ModbusDataType) validate() error {
-	possibleModbusDataTypes := []ModbusDataType{
-		ModbusBool,
-		ModbusInt16,
-		ModbusUInt16,
-		ModbusFloat16,
-		ModbusInt32,
-		ModbusUInt32,
-		ModbusFloat32,
-		ModbusInt64,
-		ModbusUInt64,
-		ModbusFloat64,
-	}
-
 	if t == nil {
 		return fmt.Errorf("expected data type not to be nil")
 	}
 
+	possibleModbusDataTypes := dataTypes()
 	for _, possibleType := range possibleModbusDataTypes {
 		if *t == possibleType {
 			return nil
@@ -160,6 +463,7 @@ func (t *MetricType) validate() error {
 	possibleMetricTypes := []MetricType{
 		MetricTypeGauge,
 		MetricTypeCounter,
+		MetricTypeHistogram,
 	}
 
 	if t == nil {
@@ -180,6 +484,9 @@ func (t *MetricType) validate() error {
 const (
 	MetricTypeGauge   MetricType = "gauge"
 	MetricTypeCounter MetricType = "counter"
+	// MetricTypeHistogram assembles a histogram from consecutive registers
+	// each holding the count of one bucket.
+	MetricTypeHistogram MetricType = "histogram"
 )
 
 // MetricDef defines how to construct Prometheus metrics based on one or more
@@ -188,7 +495,8 @@ type MetricDef struct {
 	// Name of the metric in the Prometheus output format.
 	Name string `yaml:"name"`
 
-	// Help text of the metric in the Prometheus output format.
+	// Help text of the metric in the Prometheus output format. May contain
+	// placeholders like {{ .Module }}, {{ .Address }} or {{ .DataType }}.
 	Help string `yaml:"help"`
 
 	// Labels to be applied to the metric in the Prometheus output format.
@@ -209,6 +517,173 @@ type MetricDef struct {
 
 	// Scaling factor
 	Factor *float64 `yaml:"factor,omitempty"`
+
+	// Labels whose values are read from string registers of the device,
+	// e.g. a channel name programmed into the PLC.
+	LabelRegisters map[string]LabelRegister `yaml:"labelRegisters,omitempty"`
+
+	// Drop the sample if the parsed value is exactly zero, e.g. for registers
+	// of hardware options not installed.
+	OmitZero bool `yaml:"omitZero,omitempty"`
+
+	// Upper bounds of the buckets of a histogram, in the order of the
+	// registers holding their counts starting at Address. Each bucket counts
+	// the observations since the previous bound.
+	Buckets []float64 `yaml:"buckets,omitempty"`
+
+	// Register holding the sum of the observations of a histogram, parsed
+	// with DataType, Endianness and Factor. Factor doesn't apply to the
+	// bucket counts.
+	SumAddress *RegisterAddr `yaml:"sumAddress,omitempty"`
+
+	// Expression computing the exposed value from the parsed one, available
+	// as `value`, and the parsed values of other metrics of the module, e.g.
+	// to linearize the curve of a sensor. See Transform for the syntax.
+	Transform string `yaml:"transform,omitempty"`
+
+	help      string
+	transform *Transform
+}
+
+// LabelRegister locates a string in holding or input registers, each of which
+// holds two ASCII characters. Trailing NUL characters and spaces are trimmed.
+type LabelRegister struct {
+	Address RegisterAddr `yaml:"address"`
+	// Number of registers holding the string.
+	Length int `yaml:"length"`
+}
+
+// validate semantically validates the given label register.
+func (r *LabelRegister) validate() error {
+	functionCode, _, err := r.Address.Split()
+	if err != nil {
+		return err
+	}
+	if IsBitAccess(functionCode) {
+		return fmt.Errorf("address '%v': labels can only be read from holding or input registers", r.Address)
+	}
+	if r.Length <= 0 || r.Length > MaxReadRegisters {
+		return fmt.Errorf("address '%v': length must be from 1 to %d", r.Address, MaxReadRegisters)
+	}
+
+	return nil
+}
+
+// TimestampRegister locates a timestamp in holding or input registers, in
+// seconds since epoch after applying Factor.
+type TimestampRegister struct {
+	Address    RegisterAddr   `yaml:"address"`
+	DataType   ModbusDataType `yaml:"dataType"`
+	Endianness EndiannessType `yaml:"endianness,omitempty"`
+	// Factor converting the register value to seconds, e.g. 0.001 for
+	// milliseconds.
+	Factor *float64 `yaml:"factor,omitempty"`
+}
+
+// MetricDef returns a metric definition reading the timestamp, for parsing it
+// like any other value.
+func (r *TimestampRegister) MetricDef() MetricDef {
+	return MetricDef{
+		Name:       "timestamp",
+		Address:    r.Address,
+		DataType:   r.DataType,
+		Endianness: r.Endianness,
+		Factor:     r.Factor,
+		MetricType: MetricTypeGauge,
+	}
+}
+
+// validate semantically validates the given timestamp register.
+func (r *TimestampRegister) validate() error {
+	functionCode, _, err := r.Address.Split()
+	if err != nil {
+		return err
+	}
+	if IsBitAccess(functionCode) || r.DataType == ModbusBool {
+		return fmt.Errorf("address '%v': timestamps can only be read from holding or input registers", r.Address)
+	}
+
+	def := r.MetricDef()
+	if err := def.validate(); err != nil {
+		return err
+	}
+	r.Endianness = def.Endianness
+
+	return nil
+}
+
+// SunSpec configures reading the SunSpec model chain of a device.
+type SunSpec struct {
+	// Holding register of the "SunS" marker starting the model chain.
+	// Defaults to trying 40000, 0 and 50000, the base addresses defined by
+	// SunSpec.
+	BaseAddress *RegisterAddr `yaml:"baseAddress,omitempty"`
+}
+
+// validate semantically validates the given SunSpec configuration.
+func (s *SunSpec) validate() error {
+	if s.BaseAddress == nil {
+		return nil
+	}
+
+	functionCode, _, err := s.BaseAddress.Split()
+	if err != nil {
+		return err
+	}
+	if functionCode != FuncCodeReadHoldingRegisters {
+		return fmt.Errorf("base address '%v' must be a holding register", *s.BaseAddress)
+	}
+
+	return nil
+}
+
+// helpData is passed to the help text template of a metric definition.
+type helpData struct {
+	Module     string
+	Name       string
+	Address    RegisterAddr
+	DataType   ModbusDataType
+	MetricType MetricType
+	Labels     map[string]string
+}
+
+// renderHelp renders the help text template of the metric definition within
+// the given module.
+func (d *MetricDef) renderHelp(module string) error {
+	if !strings.Contains(d.Help, "{{") {
+		d.help = d.Help
+		return nil
+	}
+
+	tmpl, err := template.New(d.Name).Option("missingkey=error").Parse(d.Help)
+	if err != nil {
+		return fmt.Errorf("invalid help text of metric definition %v: %v", d.Name, err)
+	}
+
+	var b strings.Builder
+	err = tmpl.Execute(&b, helpData{
+		Module:     module,
+		Name:       d.Name,
+		Address:    d.Address,
+		DataType:   d.DataType,
+		MetricType: d.MetricType,
+		Labels:     d.Labels,
+	})
+	if err != nil {
+		return fmt.Errorf("invalid help text of metric definition %v: %v", d.Name, err)
+	}
+	d.help = b.String()
+
+	return nil
+}
+
+// HelpText returns the help text of the metric with placeholders rendered.
+func (d *MetricDef) HelpText() string {
+	if d.help == "" {
+		return d.Help
+	}
+
+	return d.help
 }
 
 // Validate semantically validates the given metric definition.
@@ -226,6 +701,15 @@ func (d *MetricDef) validate() error {
 		return fmt.Errorf("bitPosition can only be used with boolean data type")
 	}
 
+	if d.BitOffset != nil && (*d.BitOffset < 0 || *d.BitOffset > 15) {
+		return fmt.Errorf("invalid metric definition %v: bitOffset must be from 0 to 15 but got %d", d.Name, *d.BitOffset)
+	}
+
+	// Parsers of custom data types get the registers as read.
+	if _, _, ok := CustomDataType(d.DataType); ok && d.Endianness != "" && d.Endianness != EndiannessBigEndian {
+		return fmt.Errorf("invalid metric definition %v: endianness can't be used with custom data type %v", d.Name, d.DataType)
+	}
+
 	if d.Endianness != "" {
 		if err := d.Endianness.validate(); err != nil {
 			return fmt.Errorf("invalid endianness definition %v: %v", d.Name, err)
@@ -242,9 +726,177 @@ func (d *MetricDef) validate() error {
 		return fmt.Errorf("factor cannot be 0")
 	}
 
+	if err := d.validateHistogram(); err != nil {
+		return fmt.Errorf("invalid metric definition %v: %v", d.Name, err)
+	}
+
+	if d.Transform != "" {
+		if d.MetricType == MetricTypeHistogram {
+			return fmt.Errorf("invalid metric definition %v: transform can't be used with metric type %v", d.Name, MetricTypeHistogram)
+		}
+		t, err := ParseTransform(d.Transform)
+		if err != nil {
+			return fmt.Errorf("invalid metric definition %v: %v", d.Name, err)
+		}
+		d.transform = t
+	}
+
+	for name, r := range d.LabelRegisters {
+		if !model.LabelName(name).IsValid() {
+			return fmt.Errorf("invalid metric definition %v: invalid label name '%v'", d.Name, name)
+		}
+		if _, ok := d.Labels[name]; ok {
+			return fmt.Errorf("invalid metric definition %v: label '%v' defined both as label and label register", d.Name, name)
+		}
+		if err := r.validate(); err != nil {
+			return fmt.Errorf("invalid metric definition %v: label '%v': %v", d.Name, name, err)
+		}
+	}
+
+	return nil
+}
+
+// ValueTransform returns the compiled transform of the metric definition, or
+// nil if it has none, compiling it if the definition has not been validated
+// as part of loading a configuration.
+func (d *MetricDef) ValueTransform() (*Transform, error) {
+	if d.transform != nil || d.Transform == "" {
+		return d.transform, nil
+	}
+
+	return ParseTransform(d.Transform)
+}
+
+// validateHistogram validates the histogram specific fields of the metric
+// definition.
+func (d *MetricDef) validateHistogram() error {
+	if d.MetricType != MetricTypeHistogram {
+		if len(d.Buckets) > 0 || d.SumAddress != nil {
+			return fmt.Errorf("buckets and sumAddress can only be used with metric type %v", MetricTypeHistogram)
+		}
+		return nil
+	}
+
+	if len(d.Buckets) == 0 {
+		return fmt.Errorf("histogram requires buckets")
+	}
+	for i := 1; i < len(d.Buckets); i++ {
+		if d.Buckets[i] <= d.Buckets[i-1] {
+			return fmt.Errorf("histogram buckets must be in increasing order")
+		}
+	}
+
+	if d.DataType == ModbusBool {
+		return fmt.Errorf("histogram can't use boolean data type")
+	}
+	if _, ok := d.Labels["le"]; ok {
+		return fmt.Errorf("label name 'le' is reserved for the buckets of histograms")
+	}
+	if _, ok := d.LabelRegisters["le"]; ok {
+		return fmt.Errorf("label name 'le' is reserved for the buckets of histograms")
+	}
+	if d.SumAddress != nil {
+		functionCode, _, err := d.SumAddress.Split()
+		if err != nil {
+			return err
+		}
+		if IsBitAccess(functionCode) {
+			return fmt.Errorf("sumAddress '%v' must be a holding or input register", *d.SumAddress)
+		}
+	}
+
 	return nil
 }
 
+// validateTransformReferences makes sure the metrics referred to by the
+// transforms of the module are defined once in it, so their value is
+// unambiguous, and aren't histograms.
+func (s *Module) validateTransformReferences() error {
+	var err error
+
+	defs := map[string][]MetricDef{}
+	for _, def := range s.Metrics {
+		defs[def.Name] = append(defs[def.Name], def)
+	}
+	for _, def := range s.Metrics {
+		if def.transform == nil {
+			continue
+		}
+		for _, name := range def.transform.References() {
+			switch refs := defs[name]; {
+			case len(refs) == 0:
+				err = multierror.Append(err, fmt.Errorf("transform of metric %v in module %s refers to undefined metric %v", def.Name, s.Name, name))
+			case len(refs) > 1:
+				err = multierror.Append(err, fmt.Errorf("transform of metric %v in module %s refers to metric %v defined several times", def.Name, s.Name, name))
+			case refs[0].MetricType == MetricTypeHistogram:
+				err = multierror.Append(err, fmt.Errorf("transform of metric %v in module %s refers to histogram %v", def.Name, s.Name, name))
+			}
+		}
+	}
+
+	return err
+}
+
+// validateMetricNames makes sure metrics sharing a name can be exposed
+// together: same metric type, same label names and distinct label values.
+func (s *Module) validateMetricNames() error {
+	var err error
+
+	first := map[string]MetricDef{}
+	seen := map[string]bool{}
+	for _, def := range s.Metrics {
+		labelNames := make([]string, 0, len(def.Labels))
+		labelValues := make([]string, 0, len(def.Labels))
+		for k := range def.Labels {
+			labelNames = append(labelNames, k)
+		}
+		sort.Strings(labelNames)
+		for _, k := range labelNames {
+			labelValues = append(labelValues, k+"="+def.Labels[k])
+		}
+
+		if name := s.MetricName(def.Name); !model.IsValidMetricName(model.LabelValue(name)) {
+			err = multierror.Append(err, fmt.Errorf("invalid metric name '%v' in module %s", name, s.Name))
+		}
+
+		key := def.Name + "{" + strings.Join(labelValues, ",") + "}"
+		if seen[key] {
+			err = multierror.Append(err, fmt.Errorf("duplicate metric %v in module %s", key, s.Name))
+		}
+		seen[key] = true
+
+		f, ok := first[def.Name]
+		if !ok {
+			first[def.Name] = def
+			continue
+		}
+
+		if f.MetricType != def.MetricType {
+			err = multierror.Append(err, fmt.Errorf("metric %v in module %s defined with both metric type %v and %v",
+				def.Name, s.Name, f.MetricType, def.MetricType))
+		}
+
+		if len(f.Labels) != len(def.Labels) || len(f.LabelRegisters) != len(def.LabelRegisters) {
+			err = multierror.Append(err, fmt.Errorf("metric %v in module %s defined with different label names", def.Name, s.Name))
+			continue
+		}
+		for k := range def.Labels {
+			if _, ok := f.Labels[k]; !ok {
+				err = multierror.Append(err, fmt.Errorf("metric %v in module %s defined with different label names", def.Name, s.Name))
+				break
+			}
+		}
+		for k := range def.LabelRegisters {
+			if _, ok := f.LabelRegisters[k]; !ok {
+				err = multierror.Append(err, fmt.Errorf("metric %v in module %s defined with different label names", def.Name, s.Name))
+				break
+			}
+		}
+	}
+
+	return err
+}
+
 // ModbusProtocol specifies the protocol used to retrieve modbus data.
 type ModbusProtocol string
 
@@ -293,16 +945,112 @@ func (s *Module) validate() error {
 	}
 
 	// track that error if we have no register definitions
-	if len(s.Metrics) == 0 {
+	if len(s.Metrics) == 0 && s.SunSpec == nil {
 		noRegErr := fmt.Errorf("no metric definitions found in module %s", s.Name)
 		err = multierror.Append(err, noRegErr)
 	}
 
-	for _, def := range s.Metrics {
-		if err := def.validate(); err != nil {
+	if s.ChunkSize < 0 {
+		err = multierror.Append(err, fmt.Errorf("chunk size of module %s must not be negative", s.Name))
+	}
+
+	if s.Parallelism < 0 {
+		err = multierror.Append(err, fmt.Errorf("parallelism of module %s must not be negative", s.Name))
+	}
+
+	if s.BusyRetries < 0 {
+		err = multierror.Append(err, fmt.Errorf("busy retries of module %s must not be negative", s.Name))
+	}
+
+	if s.AcknowledgePollInterval > 0 && s.AcknowledgeTimeout <= 0 {
+		err = multierror.Append(err, fmt.Errorf("acknowledge poll interval of module %s requires an acknowledge timeout", s.Name))
+	}
+
+	for subTarget, timeout := range s.SubTargetTimeouts {
+		if subTarget < 0 || subTarget > 255 {
+			err = multierror.Append(err, fmt.Errorf("sub target timeout for invalid sub target %d in module %s", subTarget, s.Name))
+		}
+		if timeout <= 0 {
+			err = multierror.Append(err, fmt.Errorf("sub target timeout for sub target %d in module %s must be positive", subTarget, s.Name))
+		}
+	}
+
+	for name, subTarget := range s.SubTargetNames {
+		if subTarget < 0 || subTarget > 255 {
+			err = multierror.Append(err, fmt.Errorf("sub target name %v in module %s refers to invalid sub target %d", name, s.Name, subTarget))
+		}
+		if _, parseErr := strconv.Atoi(name); parseErr == nil || name == "" {
+			err = multierror.Append(err, fmt.Errorf("invalid sub target name '%v' in module %s", name, s.Name))
+		}
+	}
+
+	if s.MaxReadRegisters < 0 {
+		err = multierror.Append(err, fmt.Errorf("max read registers of module %s must not be negative", s.Name))
+	}
+
+	if s.MetricPrefix != "" && (!model.IsValidMetricName(model.LabelValue(s.MetricPrefix)) || strings.HasSuffix(s.MetricPrefix, "_")) {
+		err = multierror.Append(err, fmt.Errorf("invalid metric prefix '%v' in module %s", s.MetricPrefix, s.Name))
+	}
+
+	for name := range s.Labels {
+		if !model.LabelName(name).IsValid() {
+			err = multierror.Append(err, fmt.Errorf("invalid label name '%v' in module %s", name, s.Name))
+		}
+		if name == "module" && s.HasModuleLabel() {
+			err = multierror.Append(err, fmt.Errorf("label name 'module' in module %s is reserved unless moduleLabel is disabled", s.Name))
+		}
+	}
+
+	if s.TimestampRegister != nil {
+		if tsErr := s.TimestampRegister.validate(); tsErr != nil {
+			err = multierror.Append(err, fmt.Errorf("invalid timestamp register in module %s: %v", s.Name, tsErr))
+		}
+	}
+
+	if s.SunSpec != nil {
+		if sunSpecErr := s.SunSpec.validate(); sunSpecErr != nil {
+			err = multierror.Append(err, fmt.Errorf("invalid sunspec configuration in module %s: %v", s.Name, sunSpecErr))
+		}
+	}
+
+	for _, r := range s.Writable {
+		if writableErr := r.validate(); writableErr != nil {
+			err = multierror.Append(err, fmt.Errorf("invalid writable range in module %s: %v", s.Name, writableErr))
+		}
+	}
+
+	if s.TLSConfig != nil {
+		if _, tlsErr := promconfig.NewTLSConfig(s.TLSConfig); tlsErr != nil {
+			err = multierror.Append(err, fmt.Errorf("invalid TLS configuration in module %s: %v", s.Name, tlsErr))
+		}
+	}
+
+	for i := range s.Metrics {
+		if err := s.Metrics[i].validate(); err != nil {
+			return fmt.Errorf("failed to validate module %v: %v", s.Name, err)
+		}
+		if err := s.Metrics[i].renderHelp(s.Name); err != nil {
 			return fmt.Errorf("failed to validate module %v: %v", s.Name, err)
 		}
 	}
 
-	return err
+	if dupErr := s.validateMetricNames(); dupErr != nil {
+		err = multierror.Append(err, dupErr)
+	}
+
+	if refErr := s.validateTransformReferences(); refErr != nil {
+		err = multierror.Append(err, refErr)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	plan, planErr := s.compilePlan()
+	if planErr != nil {
+		return fmt.Errorf("failed to validate module %v: %v", s.Name, planErr)
+	}
+	s.plan = plan
+
+	return nil
 }
@@ -16,6 +16,10 @@ package config
 import (
 	"fmt"
 	"testing"
+	"time"
+
+	promconfig "github.com/prometheus/common/config"
+	"github.com/prometheus/common/model"
 )
 
 func TestMetricDefValidate(t *testing.T) {
@@ -72,3 +76,522 @@ func TestModuleValidate(t *testing.T) {
 		t.Fatal("expected validation to fail with invalid modbus protocol")
 	}
 }
+
+func TestModuleTimeoutFor(t *testing.T) {
+	m := Module{
+		Timeout:           1000,
+		SubTargetTimeouts: map[int]int{10: 8000},
+	}
+
+	if timeout := m.TimeoutFor(10); timeout != 8000 {
+		t.Fatalf("expected timeout of sub target 10 to be 8000 but got %v", timeout)
+	}
+
+	if timeout := m.TimeoutFor(1); timeout != 1000 {
+		t.Fatalf("expected timeout of sub target 1 to be 1000 but got %v", timeout)
+	}
+}
+
+func TestModuleValidateSubTargetTimeouts(t *testing.T) {
+	m := Module{
+		Protocol:          ModbusProtocolTCPIP,
+		SubTargetTimeouts: map[int]int{256: 1000},
+		Metrics: []MetricDef{
+			{
+				DataType:   ModbusInt16,
+				MetricType: MetricTypeGauge,
+			},
+		},
+	}
+
+	if err := m.validate(); err == nil {
+		t.Fatal("expected validation to fail with invalid sub target")
+	}
+}
+
+func TestModuleValidateAcknowledgeTimeout(t *testing.T) {
+	m := Module{
+		Name:                    "m",
+		Protocol:                ModbusProtocolTCPIP,
+		AcknowledgePollInterval: model.Duration(time.Second),
+		Metrics: []MetricDef{
+			{Name: "a", Address: 300001, DataType: ModbusInt16, MetricType: MetricTypeGauge},
+		},
+	}
+
+	if err := m.validate(); err == nil {
+		t.Fatal("expected validation to fail without acknowledge timeout")
+	}
+
+	m.AcknowledgeTimeout = model.Duration(5 * time.Second)
+	if err := m.validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestModuleValidateSubTargetNames(t *testing.T) {
+	for _, test := range []struct {
+		names map[string]int
+		valid bool
+	}{
+		{map[string]int{"inverter_1": 3, "meter": 10}, true},
+		{map[string]int{"inverter_1": 256}, false},
+		{map[string]int{"3": 4}, false},
+	} {
+		m := Module{
+			Name:           "m",
+			Protocol:       ModbusProtocolTCPIP,
+			SubTargetNames: test.names,
+			Metrics: []MetricDef{
+				{Name: "a", Address: 300001, DataType: ModbusInt16, MetricType: MetricTypeGauge},
+			},
+		}
+
+		if err := m.validate(); (err == nil) != test.valid {
+			t.Errorf("%v: expected valid to be %v but got error %v", test.names, test.valid, err)
+		}
+	}
+
+	m := Module{SubTargetNames: map[string]int{"inverter_1": 3}}
+	if id, ok := m.SubTarget("inverter_1"); !ok || id != 3 {
+		t.Fatalf("expected inverter_1 to be sub target 3 but got %v, %v", id, ok)
+	}
+	if _, ok := m.SubTarget("inverter_2"); ok {
+		t.Fatal("expected inverter_2 not to be defined")
+	}
+}
+
+func TestModuleValidateLabels(t *testing.T) {
+	for _, test := range []struct {
+		labels map[string]string
+		valid  bool
+	}{
+		{map[string]string{"vendor": "eastron", "model": "sdm630"}, true},
+		{map[string]string{"0vendor": "eastron"}, false},
+		{map[string]string{"module": "sdm630"}, false},
+	} {
+		m := Module{
+			Name:     "m",
+			Protocol: ModbusProtocolTCPIP,
+			Labels:   test.labels,
+			Metrics: []MetricDef{
+				{Name: "a", Address: 300001, DataType: ModbusInt16, MetricType: MetricTypeGauge},
+			},
+		}
+
+		if err := m.validate(); (err == nil) != test.valid {
+			t.Errorf("%v: expected valid to be %v but got error %v", test.labels, test.valid, err)
+		}
+	}
+}
+
+func TestModuleValidateMetricPrefix(t *testing.T) {
+	for _, test := range []struct {
+		prefix string
+		valid  bool
+	}{
+		{"", true},
+		{"sdm630", true},
+		{"huawei_sun2000", true},
+		{"sdm630_", false},
+		{"0sdm", false},
+		{"sdm-630", false},
+	} {
+		m := Module{
+			Name:         "m",
+			Protocol:     ModbusProtocolTCPIP,
+			MetricPrefix: test.prefix,
+			Metrics: []MetricDef{
+				{Name: "voltage_volts", Address: 300001, DataType: ModbusInt16, MetricType: MetricTypeGauge},
+			},
+		}
+
+		if err := m.validate(); (err == nil) != test.valid {
+			t.Errorf("%q: expected valid to be %v but got error %v", test.prefix, test.valid, err)
+		}
+	}
+
+	m := Module{MetricPrefix: "sdm630"}
+	if name := m.MetricName("voltage_volts"); name != "sdm630_voltage_volts" {
+		t.Fatalf("expected sdm630_voltage_volts but got %v", name)
+	}
+}
+
+func TestModuleValidateHelpTemplate(t *testing.T) {
+	for _, test := range []struct {
+		help     string
+		expected string
+		valid    bool
+	}{
+		{"plain help", "plain help", true},
+		{"{{ .Name }} of {{ .Module }} at {{ .Address }} as {{ .DataType }}", "a of m at 300001 as int16", true},
+		{"phase {{ .Labels.phase }}", "phase 1", true},
+		{"{{ .Unknown }}", "", false},
+		{"{{ .Name ", "", false},
+	} {
+		m := Module{
+			Name:     "m",
+			Protocol: ModbusProtocolTCPIP,
+			Metrics: []MetricDef{
+				{Name: "a", Help: test.help, Labels: map[string]string{"phase": "1"}, Address: 300001, DataType: ModbusInt16, MetricType: MetricTypeGauge},
+			},
+		}
+
+		err := m.validate()
+		if (err == nil) != test.valid {
+			t.Fatalf("%q: expected valid to be %v but got error %v", test.help, test.valid, err)
+		}
+		if err == nil && m.Metrics[0].HelpText() != test.expected {
+			t.Fatalf("%q: expected help text %q but got %q", test.help, test.expected, m.Metrics[0].HelpText())
+		}
+	}
+}
+
+func TestModuleValidateMetricNames(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		metrics []MetricDef
+		valid   bool
+	}{
+		{
+			"distinct label values",
+			[]MetricDef{
+				{Name: "a", Labels: map[string]string{"phase": "1"}, MetricType: MetricTypeGauge},
+				{Name: "a", Labels: map[string]string{"phase": "2"}, MetricType: MetricTypeGauge},
+			},
+			true,
+		},
+		{
+			"duplicate label values",
+			[]MetricDef{
+				{Name: "a", Labels: map[string]string{"phase": "1"}, MetricType: MetricTypeGauge},
+				{Name: "a", Labels: map[string]string{"phase": "1"}, MetricType: MetricTypeGauge},
+			},
+			false,
+		},
+		{
+			"different label names",
+			[]MetricDef{
+				{Name: "a", Labels: map[string]string{"phase": "1"}, MetricType: MetricTypeGauge},
+				{Name: "a", Labels: map[string]string{"line": "2"}, MetricType: MetricTypeGauge},
+			},
+			false,
+		},
+		{
+			"invalid name",
+			[]MetricDef{
+				{Name: "power-watts", MetricType: MetricTypeGauge},
+			},
+			false,
+		},
+		{
+			"different metric types",
+			[]MetricDef{
+				{Name: "a", Labels: map[string]string{"phase": "1"}, MetricType: MetricTypeGauge},
+				{Name: "a", Labels: map[string]string{"phase": "2"}, MetricType: MetricTypeCounter},
+			},
+			false,
+		},
+	} {
+		m := Module{Name: "m", Metrics: test.metrics}
+		if err := m.validateMetricNames(); (err == nil) != test.valid {
+			t.Fatalf("%v: expected valid to be %v but got error %v", test.name, test.valid, err)
+		}
+	}
+}
+
+func TestResolveIncludes(t *testing.T) {
+	c := Config{
+		MetricGroups: []MetricGroup{
+			{Name: "common", Metrics: []MetricDef{{Name: "serial_number"}, {Name: "firmware"}}},
+		},
+		Modules: []Module{
+			{Name: "a", Include: []string{"common"}, Metrics: []MetricDef{{Name: "voltage"}}},
+			{Name: "b", Include: []string{"common"}},
+		},
+	}
+
+	if err := c.resolveIncludes(); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := len(c.GetModule("a").Metrics); n != 3 {
+		t.Fatalf("expected 3 metrics in module a but got %v", n)
+	}
+	if n := len(c.GetModule("b").Metrics); n != 2 {
+		t.Fatalf("expected 2 metrics in module b but got %v", n)
+	}
+
+	c.Modules[0].Include = []string{"undefined"}
+	if err := c.resolveIncludes(); err == nil {
+		t.Fatal("expected including an undefined group to fail")
+	}
+}
+
+func TestApplyDefaults(t *testing.T) {
+	c := Config{
+		Defaults: Defaults{Timeout: 2000, MaxReadRegisters: 64, Endianness: EndiannessLittleEndian},
+		Modules: []Module{
+			{Name: "a", Metrics: []MetricDef{{Name: "voltage"}, {Name: "current", Endianness: EndiannessBigEndian}}},
+			{Name: "b", Timeout: 500},
+		},
+	}
+
+	c.applyDefaults()
+
+	a, b := c.GetModule("a"), c.GetModule("b")
+	if a.Timeout != 2000 || a.MaxReadRegisters != 64 {
+		t.Fatalf("expected module a to inherit defaults but got timeout %v, max read registers %v", a.Timeout, a.MaxReadRegisters)
+	}
+	if b.Timeout != 500 {
+		t.Fatalf("expected module b to keep its timeout but got %v", b.Timeout)
+	}
+	if a.Metrics[0].Endianness != EndiannessLittleEndian {
+		t.Fatalf("expected voltage to inherit little endianness but got %v", a.Metrics[0].Endianness)
+	}
+	if a.Metrics[1].Endianness != EndiannessBigEndian {
+		t.Fatalf("expected current to keep big endianness but got %v", a.Metrics[1].Endianness)
+	}
+}
+
+func TestMQTTValidate(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		mqtt  MQTT
+		valid bool
+	}{
+		{"defaults", MQTT{Broker: "tcp://localhost:1883"}, true},
+		{"topic", MQTT{Broker: "tcp://localhost:1883", Topic: "{{ .Module }}/{{ .Name }}", QoS: 2}, true},
+		{"missing broker", MQTT{}, false},
+		{"invalid qos", MQTT{Broker: "tcp://localhost:1883", QoS: 3}, false},
+		{"invalid topic", MQTT{Broker: "tcp://localhost:1883", Topic: "{{ .Name "}, false},
+		{"missing ca", MQTT{Broker: "ssl://localhost:8883", TLSConfig: promconfig.TLSConfig{CAFile: "/nonexistent"}}, false},
+	} {
+		if err := test.mqtt.validate(); (err == nil) != test.valid {
+			t.Errorf("%v: expected valid to be %v but got error %v", test.name, test.valid, err)
+		}
+	}
+}
+
+func TestModuleValidateTimestampRegister(t *testing.T) {
+	for _, test := range []struct {
+		register TimestampRegister
+		valid    bool
+	}{
+		{TimestampRegister{Address: 300100, DataType: ModbusUInt32}, true},
+		{TimestampRegister{Address: 400100, DataType: ModbusUInt64, Endianness: EndiannessLittleEndian}, true},
+		{TimestampRegister{Address: 100100, DataType: ModbusBool}, false},
+		{TimestampRegister{Address: 300100, DataType: "invalid"}, false},
+	} {
+		register := test.register
+		m := Module{
+			Name:              "m",
+			Protocol:          ModbusProtocolTCPIP,
+			TimestampRegister: &register,
+			Metrics: []MetricDef{
+				{Name: "a", Address: 300001, DataType: ModbusInt16, MetricType: MetricTypeGauge},
+			},
+		}
+
+		if err := m.validate(); (err == nil) != test.valid {
+			t.Errorf("%v: expected valid to be %v but got error %v", test.register, test.valid, err)
+		}
+	}
+}
+
+func TestModuleValidateSunSpec(t *testing.T) {
+	holding := RegisterAddr(340000)
+	input := RegisterAddr(440000)
+	for _, test := range []struct {
+		name    string
+		sunspec *SunSpec
+		valid   bool
+	}{
+		{"no metrics", nil, false},
+		{"default base address", &SunSpec{}, true},
+		{"holding register", &SunSpec{BaseAddress: &holding}, true},
+		{"input register", &SunSpec{BaseAddress: &input}, false},
+	} {
+		m := Module{Name: "m", Protocol: ModbusProtocolTCPIP, SunSpec: test.sunspec}
+
+		if err := m.validate(); (err == nil) != test.valid {
+			t.Errorf("%v: expected valid to be %v but got error %v", test.name, test.valid, err)
+		}
+	}
+}
+
+func TestMetricDefValidateHistogram(t *testing.T) {
+	sum := RegisterAddr(300100)
+	coil := RegisterAddr(100100)
+	for _, test := range []struct {
+		name  string
+		def   MetricDef
+		valid bool
+	}{
+		{"valid", MetricDef{Buckets: []float64{1, 2, 5}, SumAddress: &sum}, true},
+		{"no buckets", MetricDef{}, false},
+		{"unordered buckets", MetricDef{Buckets: []float64{1, 5, 2}}, false},
+		{"sum from coil", MetricDef{Buckets: []float64{1}, SumAddress: &coil}, false},
+		{"le label", MetricDef{Buckets: []float64{1}, Labels: map[string]string{"le": "1"}}, false},
+	} {
+		test.def.Name = "a"
+		test.def.Address = 300001
+		test.def.DataType = ModbusUInt16
+		test.def.MetricType = MetricTypeHistogram
+		if err := test.def.validate(); (err == nil) != test.valid {
+			t.Errorf("%v: expected valid to be %v but got error %v", test.name, test.valid, err)
+		}
+	}
+
+	def := MetricDef{Name: "a", Address: 300001, DataType: ModbusUInt16, MetricType: MetricTypeGauge, Buckets: []float64{1}}
+	if err := def.validate(); err == nil {
+		t.Fatal("expected buckets of a gauge to fail validation")
+	}
+}
+
+func TestMDNSValidate(t *testing.T) {
+	c := &Config{Modules: []Module{{Name: "sdm630"}}}
+
+	m := MDNS{Modules: map[string]string{"SDM630-TCP": "sdm630"}}
+	if err := m.validate(c); err != nil {
+		t.Fatal(err)
+	}
+	if m.Service != DefaultMDNSService || m.Domain != DefaultMDNSDomain || m.ModelKey != DefaultMDNSModelKey || m.Interval != DefaultMDNSInterval || m.SubTarget != 1 {
+		t.Fatalf("expected defaults to be applied but got %+v", m)
+	}
+
+	for _, invalid := range []MDNS{
+		{},
+		{Modules: map[string]string{"SDM630-TCP": "undefined"}},
+		{Modules: map[string]string{"SDM630-TCP": "sdm630"}, SubTarget: 256},
+	} {
+		if err := invalid.validate(c); err == nil {
+			t.Errorf("expected %+v to fail validation", invalid)
+		}
+	}
+}
+
+func TestIdentificationModuleFor(t *testing.T) {
+	c := &Config{Modules: []Module{{Name: "sdm630"}, {Name: "eastron"}}}
+
+	i := Identification{Modules: []IdentifiedModule{
+		{Match: "^Eastron SDM630", Module: "sdm630"},
+		{Match: "^Eastron ", Module: "eastron"},
+	}}
+	if err := i.validate(c); err != nil {
+		t.Fatal(err)
+	}
+	if i.CacheTTL != DefaultIdentificationCacheTTL {
+		t.Fatalf("expected default cache TTL but got %v", i.CacheTTL)
+	}
+
+	for identification, expected := range map[string]string{
+		"Eastron SDM630 1.2": "sdm630",
+		"Eastron SDM120 1.0": "eastron",
+		"Other Meter 1.0":    "",
+	} {
+		if module, _ := i.ModuleFor(identification); module != expected {
+			t.Errorf("%v: expected module %q but got %q", identification, expected, module)
+		}
+	}
+
+	for _, invalid := range []Identification{
+		{},
+		{Modules: []IdentifiedModule{{Match: "(", Module: "sdm630"}}},
+		{Modules: []IdentifiedModule{{Match: "", Module: "undefined"}}},
+		{Register: &LabelRegister{Address: 100000, Length: 1}, Modules: []IdentifiedModule{{Match: "", Module: "sdm630"}}},
+	} {
+		if err := invalid.validate(c); err == nil {
+			t.Errorf("expected %+v to fail validation", invalid)
+		}
+	}
+}
+
+func TestTargetAllowed(t *testing.T) {
+	c := &Config{
+		Modules: []Module{{Name: "sdm630"}},
+		Targets: []PollTarget{{Target: "192.168.1.10:502", SubTarget: 1, Module: "sdm630", Interval: 1}},
+	}
+	if !c.TargetAllowed("203.0.113.1:502") {
+		t.Fatal("expected all targets to be allowed without allowlist")
+	}
+
+	c.AllowedTargets = &AllowedTargets{
+		Networks: []string{"10.0.0.0/8", "2001:db8::/32"},
+		Hosts:    []string{"*.plant.example.com"},
+		Targets:  []string{"gateway:1502"},
+	}
+	if err := c.AllowedTargets.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	for target, expected := range map[string]bool{
+		"10.1.2.3:502":             true,
+		"[2001:db8::1]:502":        true,
+		"meter1.plant.example.com": true,
+		"gateway:1502":             true,
+		"192.168.1.10:502":         true,
+		"gateway:502":              false,
+		"192.168.1.11:502":         false,
+		"plant.example.com:502":    false,
+		"meter1.plant.example.org": false,
+		"10.1.2.3.nip.example.com": false,
+	} {
+		if allowed := c.TargetAllowed(target); allowed != expected {
+			t.Errorf("%v: expected allowed %v but got %v", target, expected, allowed)
+		}
+	}
+
+	for _, invalid := range []AllowedTargets{
+		{},
+		{Networks: []string{"10.0.0.0"}},
+		{Hosts: []string{"["}},
+	} {
+		if err := invalid.validate(); err == nil {
+			t.Errorf("expected %+v to fail validation", invalid)
+		}
+	}
+}
+
+func TestModuleCanWrite(t *testing.T) {
+	m := Module{Name: "m", Protocol: ModbusProtocolTCPIP, Writable: []WritableRange{
+		{From: 100010, To: 100019},
+		{From: 300100, To: 300120},
+	}}
+	m.Metrics = []MetricDef{{Name: "my_metric", Address: 300100, DataType: ModbusInt16, MetricType: MetricTypeGauge}}
+	if err := m.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		functionCode uint8
+		address      uint16
+		quantity     uint16
+		writable     bool
+	}{
+		{FuncCodeReadCoils, 10, 1, true},
+		{FuncCodeReadCoils, 19, 1, true},
+		{FuncCodeReadCoils, 20, 1, false},
+		{FuncCodeReadHoldingRegisters, 10, 1, false},
+		{FuncCodeReadHoldingRegisters, 100, 21, true},
+		{FuncCodeReadHoldingRegisters, 110, 12, false},
+		{FuncCodeReadHoldingRegisters, 99, 2, false},
+		{FuncCodeReadHoldingRegisters, 100, 0, false},
+	} {
+		if writable := m.CanWrite(test.functionCode, test.address, test.quantity); writable != test.writable {
+			t.Errorf("%+v: expected writable to be %v", test, test.writable)
+		}
+	}
+
+	for _, invalid := range []WritableRange{
+		{From: 200010, To: 200019},
+		{From: 400010, To: 400019},
+		{From: 100010, To: 300019},
+		{From: 300020, To: 300010},
+	} {
+		m.Writable = []WritableRange{invalid}
+		if err := m.validate(); err == nil {
+			t.Errorf("expected %+v to fail validation", invalid)
+		}
+	}
+}
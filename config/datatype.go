@@ -0,0 +1,99 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// DataTypeParser parses the data of the registers of a custom data type, in
+// the order they were read, into a value. The factor of the metric definition
+// is applied to the value afterwards.
+type DataTypeParser func(rawData []byte) (float64, error)
+
+type customDataType struct {
+	registers uint16
+	parse     DataTypeParser
+}
+
+var customDataTypes = struct {
+	sync.RWMutex
+	types map[ModbusDataType]customDataType
+}{types: map[ModbusDataType]customDataType{}}
+
+// builtinDataTypes are the data types the exporter parses itself.
+var builtinDataTypes = []ModbusDataType{
+	ModbusBool,
+	ModbusInt16,
+	ModbusUInt16,
+	ModbusFloat16,
+	ModbusInt32,
+	ModbusUInt32,
+	ModbusFloat32,
+	ModbusInt64,
+	ModbusUInt64,
+	ModbusFloat64,
+}
+
+// RegisterDataType makes an additional data type spanning the given number of
+// registers available to metric definitions, e.g. a vendor specific packed
+// format, parsed by the given function. Like the registration functions of
+// the standard library it is meant to be called from init functions of
+// programs embedding the exporter, before configurations are loaded, and
+// panics if the name is taken or the register count is out of range.
+func RegisterDataType(name ModbusDataType, registers uint16, parse DataTypeParser) {
+	if registers == 0 || registers > MaxReadRegisters {
+		panic(fmt.Sprintf("data type %v: register count must be from 1 to %d", name, MaxReadRegisters))
+	}
+	if parse == nil {
+		panic(fmt.Sprintf("data type %v: parser is nil", name))
+	}
+	for _, t := range builtinDataTypes {
+		if t == name {
+			panic(fmt.Sprintf("data type %v is builtin", name))
+		}
+	}
+
+	customDataTypes.Lock()
+	defer customDataTypes.Unlock()
+	if _, ok := customDataTypes.types[name]; ok {
+		panic(fmt.Sprintf("data type %v registered twice", name))
+	}
+	customDataTypes.types[name] = customDataType{registers: registers, parse: parse}
+}
+
+// CustomDataType returns the parser and register count of the data type
+// registered with the given name, or false if there is none.
+func CustomDataType(name ModbusDataType) (DataTypeParser, uint16, bool) {
+	customDataTypes.RLock()
+	defer customDataTypes.RUnlock()
+	t, ok := customDataTypes.types[name]
+
+	return t.parse, t.registers, ok
+}
+
+// dataTypes returns the builtin data types followed by the registered ones.
+func dataTypes() []ModbusDataType {
+	customDataTypes.RLock()
+	defer customDataTypes.RUnlock()
+	custom := make([]ModbusDataType, 0, len(customDataTypes.types))
+	for name := range customDataTypes.types {
+		custom = append(custom, name)
+	}
+	sort.Slice(custom, func(i, j int) bool { return custom[i] < custom[j] })
+
+	return append(append([]ModbusDataType{}, builtinDataTypes...), custom...)
+}
@@ -0,0 +1,63 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRegisterDataType(t *testing.T) {
+	parse := func([]byte) (float64, error) { return 0, nil }
+	if _, _, ok := CustomDataType("packed48"); !ok {
+		RegisterDataType("packed48", 3, parse)
+	}
+
+	if n := ModbusDataType("packed48").RegisterCount(); n != 3 {
+		t.Errorf("expected 3 registers but got %v", n)
+	}
+
+	d := MetricDef{Name: "m", Address: 300000, DataType: "packed48", MetricType: MetricTypeGauge}
+	if err := d.validate(); err != nil {
+		t.Errorf("expected custom data type to be valid but got %v", err)
+	}
+	d = MetricDef{Name: "m", Address: 300000, DataType: "packed48", Endianness: EndiannessLittleEndian, MetricType: MetricTypeGauge}
+	if err := d.validate(); err == nil || !strings.Contains(err.Error(), "custom data type") {
+		t.Errorf("expected endianness to be refused with custom data type but got %v", err)
+	}
+	d = MetricDef{Name: "m", Address: 300000, DataType: "packed64", MetricType: MetricTypeGauge}
+	if err := d.validate(); err == nil || !strings.Contains(err.Error(), "packed48") {
+		t.Errorf("expected unregistered data type to be refused, listing the registered ones, but got %v", err)
+	}
+
+	for _, test := range []struct {
+		name      ModbusDataType
+		registers uint16
+		parse     DataTypeParser
+	}{
+		{"int16", 1, parse},
+		{"packed48", 3, parse},
+		{"huge", MaxReadRegisters + 1, parse},
+		{"nil", 1, nil},
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%v: expected registration to panic", test.name)
+				}
+			}()
+			RegisterDataType(test.name, test.registers, test.parse)
+		}()
+	}
+}
@@ -0,0 +1,100 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/prometheus/common/model"
+)
+
+// DefaultIdentificationCacheTTL is the duration the identification of a
+// device is remembered for by default.
+const DefaultIdentificationCacheTTL = model.Duration(time.Hour)
+
+// Identification configures selecting the module of scrapes not specifying
+// one by the identification of the device.
+type Identification struct {
+	// Register holding an identifying string, e.g. the model name, read
+	// instead of the device identification objects via function code 43.
+	Register *LabelRegister `yaml:"register,omitempty"`
+	// Duration the identification of a device is remembered for. Defaults
+	// to DefaultIdentificationCacheTTL.
+	CacheTTL model.Duration `yaml:"cacheTTL,omitempty"`
+	// Modules with the pattern their devices are identified by, the first
+	// matching one is selected.
+	Modules []IdentifiedModule `yaml:"modules"`
+}
+
+// IdentifiedModule maps identifications matching a pattern to a module.
+type IdentifiedModule struct {
+	// Regular expression matched against the identification, which is the
+	// vendor name, product code and revision separated by spaces for
+	// function code 43.
+	Match  string `yaml:"match"`
+	Module string `yaml:"module"`
+
+	regexp *regexp.Regexp
+}
+
+// validate semantically validates the given identification and applies
+// defaults.
+func (i *Identification) validate(c *Config) error {
+	if i.CacheTTL == 0 {
+		i.CacheTTL = DefaultIdentificationCacheTTL
+	}
+	if i.CacheTTL < 0 {
+		return fmt.Errorf("identification: cache TTL must not be negative")
+	}
+
+	if i.Register != nil {
+		if err := i.Register.validate(); err != nil {
+			return fmt.Errorf("identification: register: %v", err)
+		}
+	}
+
+	if len(i.Modules) == 0 {
+		return fmt.Errorf("identification: no modules defined")
+	}
+	for n, m := range i.Modules {
+		re, err := regexp.Compile(m.Match)
+		if err != nil {
+			return fmt.Errorf("identification: invalid pattern '%v': %v", m.Match, err)
+		}
+		if !c.HasModule(m.Module) {
+			return fmt.Errorf("identification: module '%v' not found in config", m.Module)
+		}
+		i.Modules[n].regexp = re
+	}
+
+	return nil
+}
+
+// ModuleFor returns the name of the module of devices with the given
+// identification, or false if none matches.
+func (i *Identification) ModuleFor(identification string) (string, bool) {
+	for _, m := range i.Modules {
+		re := m.regexp
+		if re == nil {
+			re = regexp.MustCompile(m.Match)
+		}
+		if re.MatchString(identification) {
+			return m.Module, true
+		}
+	}
+
+	return "", false
+}
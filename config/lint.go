@@ -0,0 +1,124 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Lint returns likely mistakes in a valid configuration, which would only
+// surface as wrong data or parse errors at scrape time.
+func (c *Config) Lint() []string {
+	problems := []string{}
+
+	for i := range c.Modules {
+		problems = append(problems, c.Modules[i].lint()...)
+	}
+
+	included := map[string]bool{}
+	for _, g := range c.MetricGroups {
+		included[g.Name] = false
+	}
+	for _, m := range c.Modules {
+		for _, name := range m.Include {
+			included[name] = true
+		}
+	}
+	for _, g := range c.MetricGroups {
+		if !included[g.Name] {
+			problems = append(problems, fmt.Sprintf("metric group %v is not included by any module", g.Name))
+		}
+	}
+
+	// With background polling configured, modules neither polled nor
+	// scraped via /modbus are likely left overs.
+	if len(c.Targets) > 0 {
+		polled := map[string]bool{}
+		for _, t := range c.Targets {
+			polled[t.Module] = true
+		}
+		for _, m := range c.Modules {
+			if !polled[m.Name] {
+				problems = append(problems, fmt.Sprintf("module %v is not used by any poll target", m.Name))
+			}
+		}
+	}
+
+	return problems
+}
+
+type lintRange struct {
+	def          MetricDef
+	functionCode uint8
+	address      uint16
+	quantity     uint16
+}
+
+func (s *Module) lint() []string {
+	problems := []string{}
+
+	ranges := []lintRange{}
+	for _, def := range s.Metrics {
+		functionCode, address, err := def.Address.Split()
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("module %v: metric %v: %v", s.Name, def.Name, err))
+			continue
+		}
+
+		quantity := def.RegisterCount()
+		if IsBitAccess(functionCode) {
+			quantity = 1
+		}
+		if max := s.maxRead(functionCode); quantity > max {
+			problems = append(problems, fmt.Sprintf(
+				"module %v: metric %v: data type %v spans %v registers, more than the maximum read size of %v",
+				s.Name, def.Name, def.DataType, quantity, max))
+		}
+
+		ranges = append(ranges, lintRange{def, functionCode, address, quantity})
+	}
+
+	sort.SliceStable(ranges, func(i, j int) bool {
+		if ranges[i].functionCode != ranges[j].functionCode {
+			return ranges[i].functionCode < ranges[j].functionCode
+		}
+		return ranges[i].address < ranges[j].address
+	})
+
+	for i, a := range ranges {
+		for _, b := range ranges[i+1:] {
+			if b.functionCode != a.functionCode || uint32(b.address) >= uint32(a.address)+uint32(a.quantity) {
+				break
+			}
+			// Several booleans reading different bits of one register
+			// are fine.
+			if a.def.DataType == ModbusBool && b.def.DataType == ModbusBool && !sameBitOffset(a.def, b.def) {
+				continue
+			}
+			problems = append(problems, fmt.Sprintf("module %v: metric %v at address %v overlaps metric %v at address %v",
+				s.Name, a.def.Name, a.def.Address, b.def.Name, b.def.Address))
+		}
+	}
+
+	return problems
+}
+
+func sameBitOffset(a, b MetricDef) bool {
+	if a.BitOffset == nil || b.BitOffset == nil {
+		return a.BitOffset == b.BitOffset
+	}
+
+	return *a.BitOffset == *b.BitOffset
+}
@@ -0,0 +1,46 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"testing"
+)
+
+func TestLint(t *testing.T) {
+	zero, one := 0, 1
+	c := Config{
+		MetricGroups: []MetricGroup{{Name: "unused"}},
+		Modules: []Module{
+			{
+				Name:             "m",
+				MaxReadRegisters: 1,
+				Metrics: []MetricDef{
+					{Name: "a", Address: 300010, DataType: ModbusInt16},
+					// Overlaps a.
+					{Name: "b", Address: 300010, DataType: ModbusUInt16},
+					// Exceeds the maximum read size.
+					{Name: "c", Address: 300020, DataType: ModbusInt32},
+					// Distinct bits of the same register.
+					{Name: "d", Address: 300030, DataType: ModbusBool, BitOffset: &zero},
+					{Name: "e", Address: 300030, DataType: ModbusBool, BitOffset: &one},
+				},
+			},
+		},
+	}
+
+	problems := c.Lint()
+	if len(problems) != 3 {
+		t.Fatalf("expected 3 problems but got %v: %v", len(problems), problems)
+	}
+}
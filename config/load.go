@@ -14,28 +14,234 @@
 package config
 
 import (
+	"encoding/json"
+	"fmt"
 	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
 
 	yaml "gopkg.in/yaml.v2"
 )
 
-// LoadConfig unmarshals the targets configuration file.
+// LoadOptions control how configuration files are loaded.
+type LoadOptions struct {
+	// Strict rejects fields unknown to the configuration format, e.g.
+	// misspelled ones, instead of ignoring them.
+	Strict bool
+
+	// BearerToken is sent when loading the configuration from a HTTP(S) URL.
+	BearerToken string
+	// CacheFile keeps a copy of the last valid configuration loaded from a
+	// HTTP(S) URL, used whenever the URL can't be fetched.
+	CacheFile string
+	// OnFallback is called with the fetch error whenever the configuration
+	// is loaded from CacheFile instead of the URL.
+	OnFallback func(error)
+}
+
+// LoadConfig unmarshals the targets configuration file. The path may also
+// point to a directory or be a glob pattern, in which case all matching YAML
+// and JSON files are merged into one configuration, or be a HTTP(S) URL.
 func LoadConfig(pathToTargets string) (Config, error) {
-	ls := Config{}
-	yamlFile, err := os.ReadFile(pathToTargets)
+	return LoadConfigWithOptions(pathToTargets, LoadOptions{})
+}
+
+// LoadConfigWithOptions is like LoadConfig with the given options applied.
+func LoadConfigWithOptions(pathToTargets string, opts LoadOptions) (Config, error) {
+	if isURL(pathToTargets) {
+		return loadURL(pathToTargets, opts)
+	}
+
+	files, err := configFiles(pathToTargets)
 	if err != nil {
 		return Config{}, err
+	}
+
+	ls := Config{}
+	moduleFiles := map[string]string{}
+	for _, f := range files {
+		c, err := loadFile(f, opts)
+		if err != nil {
+			return Config{}, err
+		}
+
+		for _, m := range c.Modules {
+			if other, ok := moduleFiles[m.Name]; ok {
+				return Config{}, fmt.Errorf("module '%v' defined in both %v and %v", m.Name, other, f)
+			}
+			moduleFiles[m.Name] = f
+		}
 
+		ls.Modules = append(ls.Modules, c.Modules...)
+		ls.Targets = append(ls.Targets, c.Targets...)
+		ls.MetricGroups = append(ls.MetricGroups, c.MetricGroups...)
+		if c.Defaults != (Defaults{}) {
+			if ls.Defaults != (Defaults{}) {
+				return Config{}, fmt.Errorf("defaults defined in more than one file, found another in %v", f)
+			}
+			ls.Defaults = c.Defaults
+		}
+		if c.MQTT != nil {
+			if ls.MQTT != nil {
+				return Config{}, fmt.Errorf("mqtt defined in more than one file, found another in %v", f)
+			}
+			ls.MQTT = c.MQTT
+		}
+		if c.MDNS != nil {
+			if ls.MDNS != nil {
+				return Config{}, fmt.Errorf("mdns defined in more than one file, found another in %v", f)
+			}
+			ls.MDNS = c.MDNS
+		}
+		if c.Identification != nil {
+			if ls.Identification != nil {
+				return Config{}, fmt.Errorf("identification defined in more than one file, found another in %v", f)
+			}
+			ls.Identification = c.Identification
+		}
+		if c.AllowedTargets != nil {
+			if ls.AllowedTargets != nil {
+				return Config{}, fmt.Errorf("allowedTargets defined in more than one file, found another in %v", f)
+			}
+			ls.AllowedTargets = c.AllowedTargets
+		}
 	}
 
-	err = yaml.Unmarshal(yamlFile, &ls)
+	return complete(ls)
+}
+
+// Parse parses, completes and validates the given configuration like
+// LoadConfigWithOptions does with files, e.g. for programs embedding the
+// exporter with a configuration of their own.
+func Parse(data []byte, opts LoadOptions) (Config, error) {
+	c, err := parseConfig("configuration", data, opts)
 	if err != nil {
 		return Config{}, err
 	}
 
+	return complete(c)
+}
+
+// complete resolves includes, applies defaults and validates the given
+// configuration.
+func complete(ls Config) (Config, error) {
+	if err := ls.resolveIncludes(); err != nil {
+		return Config{}, err
+	}
+	ls.applyDefaults()
+
 	if err := ls.validate(); err != nil {
 		return Config{}, err
 	}
 
 	return ls, nil
 }
+
+// configFiles returns the configuration files the given path refers to.
+func configFiles(path string) ([]string, error) {
+	if strings.ContainsAny(path, "*?[") {
+		files, err := filepath.Glob(path)
+		if err != nil {
+			return nil, err
+		}
+		if len(files) == 0 {
+			return nil, fmt.Errorf("no configuration files match %v", path)
+		}
+		sort.Strings(files)
+		return files, nil
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []string{path}, nil
+	}
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+
+	files := []string{}
+	for _, e := range entries {
+		ext := filepath.Ext(e.Name())
+		if e.IsDir() || (ext != ".yml" && ext != ".yaml" && ext != ".json") {
+			continue
+		}
+		files = append(files, filepath.Join(path, e.Name()))
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no configuration files found in %v", path)
+	}
+
+	return files, nil
+}
+
+// loadFile unmarshals a single configuration file without validating it.
+func loadFile(path string, opts LoadOptions) (Config, error) {
+	yamlFile, err := os.ReadFile(path)
+	if err != nil {
+		return Config{}, err
+
+	}
+
+	return parseConfig(path, yamlFile, opts)
+}
+
+// parseConfig unmarshals the given configuration without validating it.
+// Files with a .json extension must be valid JSON; as JSON is a subset of
+// YAML they are otherwise handled the same way.
+func parseConfig(path string, yamlFile []byte, opts LoadOptions) (Config, error) {
+	ls := Config{}
+	yamlFile, err := expandEnv(yamlFile)
+	if err != nil {
+		return Config{}, fmt.Errorf("%v: %v", path, err)
+	}
+
+	if filepath.Ext(path) == ".json" {
+		var v interface{}
+		if err := json.Unmarshal(yamlFile, &v); err != nil {
+			return Config{}, fmt.Errorf("%v: invalid JSON: %v", path, err)
+		}
+	}
+
+	unmarshal := yaml.Unmarshal
+	if opts.Strict {
+		unmarshal = yaml.UnmarshalStrict
+	}
+
+	err = unmarshal(yamlFile, &ls)
+	if err != nil {
+		return Config{}, fmt.Errorf("%v: %v", path, err)
+	}
+
+	return ls, nil
+}
+
+var envPattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// expandEnv replaces ${VAR} and ${VAR:-default} with the value of the
+// environment variable VAR, falling back to default if it is unset or empty.
+// Referencing an unset variable without default is an error.
+func expandEnv(b []byte) ([]byte, error) {
+	var err error
+	expanded := envPattern.ReplaceAllFunc(b, func(match []byte) []byte {
+		groups := envPattern.FindSubmatch(match)
+		if v := os.Getenv(string(groups[1])); v != "" {
+			return []byte(v)
+		}
+		if groups[2] != nil {
+			return groups[3]
+		}
+		if err == nil {
+			err = fmt.Errorf("environment variable %v is not set", string(groups[1]))
+		}
+		return match
+	})
+
+	return expanded, err
+}
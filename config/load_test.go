@@ -0,0 +1,215 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeModule(t *testing.T, path, name string) {
+	content := `modules:
+  - name: "` + name + `"
+    protocol: 'tcp/ip'
+    metrics:
+      - name: "some_gauge"
+        address: 300023
+        dataType: int16
+        metricType: gauge
+`
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadConfigExample(t *testing.T) {
+	if _, err := LoadConfig("../modbus.yml"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadConfigDirectory(t *testing.T) {
+	dir := t.TempDir()
+	writeModule(t, filepath.Join(dir, "a.yml"), "a")
+	writeModule(t, filepath.Join(dir, "b.yaml"), "b")
+	if err := os.WriteFile(filepath.Join(dir, "README"), []byte("not a config"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{dir, filepath.Join(dir, "*.y*ml")} {
+		c, err := LoadConfig(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !c.HasModule("a") || !c.HasModule("b") {
+			t.Fatalf("%v: expected modules of both files but got %v", path, c.Modules)
+		}
+	}
+}
+
+func TestLoadConfigDirectoryCollision(t *testing.T) {
+	dir := t.TempDir()
+	writeModule(t, filepath.Join(dir, "a.yml"), "a")
+	writeModule(t, filepath.Join(dir, "b.yml"), "a")
+
+	if _, err := LoadConfig(dir); err == nil {
+		t.Fatal("expected module name collision to fail")
+	}
+}
+
+func TestExpandEnv(t *testing.T) {
+	t.Setenv("MODBUS_TEST_SITE", "berlin")
+
+	for _, test := range []struct {
+		in    string
+		out   string
+		valid bool
+	}{
+		{"site: ${MODBUS_TEST_SITE}", "site: berlin", true},
+		{"site: ${MODBUS_TEST_SITE:-paris}", "site: berlin", true},
+		{"rack: ${MODBUS_TEST_RACK:-r12}", "rack: r12", true},
+		{"rack: ${MODBUS_TEST_RACK:-}", "rack: ", true},
+		{"rack: $MODBUS_TEST_RACK", "rack: $MODBUS_TEST_RACK", true},
+		{"rack: ${MODBUS_TEST_RACK}", "", false},
+	} {
+		out, err := expandEnv([]byte(test.in))
+		if (err == nil) != test.valid {
+			t.Fatalf("%v: expected valid to be %v but got error %v", test.in, test.valid, err)
+		}
+		if test.valid && string(out) != test.out {
+			t.Fatalf("%v: expected %q but got %q", test.in, test.out, out)
+		}
+	}
+}
+
+func TestLoadConfigStrict(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "modbus.yml")
+	content := `modules:
+  - name: "a"
+    protocol: 'tcp/ip'
+    metrics:
+      - name: "some_gauge"
+        address: 300023
+        datatype: int16
+        metricType: gauge
+`
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := LoadConfigWithOptions(path, LoadOptions{Strict: true})
+	if err == nil {
+		t.Fatal("expected unknown field to fail in strict mode")
+	}
+	if !strings.Contains(err.Error(), "line 7") {
+		t.Fatalf("expected error to point to line 7 but got %v", err)
+	}
+}
+
+func TestLoadConfigJSON(t *testing.T) {
+	dir := t.TempDir()
+	writeModule(t, filepath.Join(dir, "a.yml"), "a")
+	content := `{
+	"modules": [
+		{
+			"name": "b",
+			"protocol": "tcp/ip",
+			"timeout": 1000,
+			"metrics": [
+				{"name": "some_gauge", "address": 300023, "dataType": "int16", "metricType": "gauge"}
+			]
+		}
+	]
+}`
+	if err := os.WriteFile(filepath.Join(dir, "b.json"), []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := LoadConfigWithOptions(dir, LoadOptions{Strict: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.HasModule("a") || !c.HasModule("b") {
+		t.Fatalf("expected modules of both files but got %v", c.Modules)
+	}
+	if c.Modules[1].Timeout != 1000 {
+		t.Fatalf("expected timeout 1000 but got %v", c.Modules[1].Timeout)
+	}
+
+	// YAML is not accepted in files claiming to be JSON.
+	path := filepath.Join(dir, "c.json")
+	writeModule(t, path, "c")
+	if _, err := LoadConfig(path); err == nil || !strings.Contains(err.Error(), "invalid JSON") {
+		t.Fatalf("expected invalid JSON error but got %v", err)
+	}
+}
+
+func TestLoadConfigAllowedTargets(t *testing.T) {
+	dir := t.TempDir()
+	writeModule(t, filepath.Join(dir, "a.yml"), "a")
+	content := `allowedTargets:
+  hosts:
+    - "*.plant.example.com"
+`
+	if err := os.WriteFile(filepath.Join(dir, "b.yml"), []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := LoadConfig(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.TargetAllowed("meter.plant.example.com:502") {
+		t.Fatal("expected listed host to be allowed")
+	}
+	if c.TargetAllowed("meter.office.example.com:502") {
+		t.Fatal("expected unlisted host not to be allowed")
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "c.yml"), []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadConfig(dir); err == nil {
+		t.Fatal("expected allowedTargets defined in two files to fail")
+	}
+}
+
+func TestParse(t *testing.T) {
+	c, err := Parse([]byte(`modules:
+  - name: "embedded"
+    protocol: 'tcp/ip'
+    metrics:
+      - name: "some_gauge"
+        address: 300023
+        dataType: int16
+        metricType: gauge
+`), LoadOptions{Strict: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan, err := c.GetModule("embedded").ReadPlan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Blocks) != 1 || plan.Blocks[0].Address != 23 {
+		t.Fatalf("expected a block reading address 23 but got %+v", plan.Blocks)
+	}
+
+	if _, err := Parse([]byte("modules:\n  - name: \"invalid\"\n"), LoadOptions{}); err == nil {
+		t.Fatal("expected module without protocol to be invalid")
+	}
+}
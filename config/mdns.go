@@ -0,0 +1,83 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/prometheus/common/model"
+)
+
+// Defaults of the mDNS discovery.
+const (
+	DefaultMDNSService  = "_modbus._tcp"
+	DefaultMDNSDomain   = "local"
+	DefaultMDNSModelKey = "model"
+	DefaultMDNSInterval = model.Duration(5 * time.Minute)
+)
+
+// MDNS configures the discovery of Modbus TCP devices announcing themselves
+// via mDNS, e.g. gateways. Devices are assigned a module by the model they
+// advertise in their TXT record.
+type MDNS struct {
+	// Service type browsed for. Defaults to DefaultMDNSService.
+	Service string `yaml:"service,omitempty"`
+	// Domain browsed. Defaults to DefaultMDNSDomain.
+	Domain string `yaml:"domain,omitempty"`
+	// Interval between two browses. Defaults to DefaultMDNSInterval.
+	Interval model.Duration `yaml:"interval,omitempty"`
+	// Key of the TXT record holding the model. Defaults to
+	// DefaultMDNSModelKey.
+	ModelKey string `yaml:"modelKey,omitempty"`
+	// Modules by advertised model. Devices of other models are ignored.
+	Modules map[string]string `yaml:"modules"`
+	// Sub target (unit ID) to scrape discovered devices with.
+	SubTarget int `yaml:"subTarget,omitempty"`
+}
+
+func (m *MDNS) validate(c *Config) error {
+	if m.Service == "" {
+		m.Service = DefaultMDNSService
+	}
+	if m.Domain == "" {
+		m.Domain = DefaultMDNSDomain
+	}
+	if m.Interval == 0 {
+		m.Interval = DefaultMDNSInterval
+	}
+	if m.ModelKey == "" {
+		m.ModelKey = DefaultMDNSModelKey
+	}
+	if m.SubTarget == 0 {
+		m.SubTarget = 1
+	}
+
+	if m.Interval < 0 {
+		return fmt.Errorf("mdns: interval must be positive")
+	}
+	if m.SubTarget < 0 || m.SubTarget > 255 {
+		return fmt.Errorf("mdns: sub target must be from 0 to 255 but got %d", m.SubTarget)
+	}
+	if len(m.Modules) == 0 {
+		return fmt.Errorf("mdns: no modules by model defined")
+	}
+	for model, module := range m.Modules {
+		if c.GetModule(module) == nil {
+			return fmt.Errorf("mdns: model %v refers to undefined module '%v'", model, module)
+		}
+	}
+
+	return nil
+}
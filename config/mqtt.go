@@ -0,0 +1,71 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"text/template"
+
+	promconfig "github.com/prometheus/common/config"
+)
+
+// DefaultMQTTTopic is the topic template used if none is configured.
+const DefaultMQTTTopic = "modbus/{{ .Target }}/{{ .SubTarget }}/{{ .Module }}/{{ .Name }}"
+
+// MQTT configures publishing the results of polled targets to a MQTT broker.
+type MQTT struct {
+	// Broker URL, e.g. tcp://localhost:1883 or ssl://localhost:8883.
+	Broker   string            `yaml:"broker"`
+	ClientID string            `yaml:"clientID,omitempty"`
+	Username string            `yaml:"username,omitempty"`
+	Password promconfig.Secret `yaml:"password,omitempty"`
+
+	// Template of the topic each series is published to. Defaults to
+	// DefaultMQTTTopic.
+	Topic  string `yaml:"topic,omitempty"`
+	QoS    byte   `yaml:"qos,omitempty"`
+	Retain bool   `yaml:"retain,omitempty"`
+
+	TLSConfig promconfig.TLSConfig `yaml:"tlsConfig,omitempty"`
+}
+
+// TopicTemplate returns the parsed topic template.
+func (m *MQTT) TopicTemplate() (*template.Template, error) {
+	topic := m.Topic
+	if topic == "" {
+		topic = DefaultMQTTTopic
+	}
+
+	return template.New("topic").Option("missingkey=error").Parse(topic)
+}
+
+func (m *MQTT) validate() error {
+	if m.Broker == "" {
+		return fmt.Errorf("mqtt: broker must be specified")
+	}
+
+	if m.QoS > 2 {
+		return fmt.Errorf("mqtt: qos must be from 0 to 2 but got %d", m.QoS)
+	}
+
+	if _, err := m.TopicTemplate(); err != nil {
+		return fmt.Errorf("mqtt: invalid topic: %v", err)
+	}
+
+	if _, err := promconfig.NewTLSConfig(&m.TLSConfig); err != nil {
+		return fmt.Errorf("mqtt: %v", err)
+	}
+
+	return nil
+}
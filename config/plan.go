@@ -0,0 +1,286 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// Modbus function codes used to read data.
+const (
+	FuncCodeReadCoils            uint8 = 1
+	FuncCodeReadDiscreteInputs   uint8 = 2
+	FuncCodeReadHoldingRegisters uint8 = 3
+	FuncCodeReadInputRegisters   uint8 = 4
+)
+
+const (
+	// MaxReadRegisters is the maximum number of registers a single read
+	// request may ask for.
+	MaxReadRegisters = 125
+	// MaxReadBits is the maximum number of coils or discrete inputs a single
+	// read request may ask for.
+	MaxReadBits = 2000
+)
+
+// Split splits the register address into the function code given by its first
+// digit and the register address given by the remaining ones.
+func (a RegisterAddr) Split() (uint8, uint16, error) {
+	s := fmt.Sprint(a)
+	if len(s) < 2 {
+		return 0, 0, fmt.Errorf("modbus register address '%v' too short", a)
+	}
+
+	// Here we are parcing Modbus Address from config file
+	// for function code and register address
+	modFunction, err := strconv.ParseUint(s[0:1], 10, 8)
+	if err != nil {
+		return 0, 0, fmt.Errorf("modbus function code parcing failed: %v", err)
+	}
+
+	// And here we are parcing Modbus Address from config file
+	// for register address
+	modAddress, err := strconv.ParseUint(s[1:], 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("modbus register address parcing failed: %v", err)
+	}
+
+	if modAddress > 65535 {
+		return 0, 0, fmt.Errorf("modbus register address is out of range: %v", a)
+	}
+
+	switch uint8(modFunction) {
+	case FuncCodeReadCoils, FuncCodeReadDiscreteInputs, FuncCodeReadHoldingRegisters, FuncCodeReadInputRegisters:
+	default:
+		return 0, 0, fmt.Errorf(
+			"address '%v': metric address should be within the range of 10 - 465535."+
+				"'1xxxxx' for read coil / digital output, '2xxxxx' for read discrete inputs / digital input,"+
+				"'3xxxxx' read holding registers / analog output, '4xxxxx' read input registers / analog input",
+			a,
+		)
+	}
+
+	return uint8(modFunction), uint16(modAddress), nil
+}
+
+// RegisterCount returns the number of registers holding a value of the data
+// type.
+func (t ModbusDataType) RegisterCount() uint16 {
+	switch t {
+	case ModbusFloat16,
+		ModbusInt16,
+		ModbusBool,
+		ModbusUInt16:
+		return 1
+	case ModbusFloat32,
+		ModbusInt32,
+		ModbusUInt32:
+		return 2
+	}
+	if _, registers, ok := CustomDataType(t); ok {
+		return registers
+	}
+
+	return 4
+}
+
+// RegisterCount returns the number of registers holding the value of the
+// metric definition, or the counts of all buckets for histograms.
+func (d *MetricDef) RegisterCount() uint16 {
+	if d.MetricType == MetricTypeHistogram {
+		return d.DataType.RegisterCount() * uint16(len(d.Buckets))
+	}
+
+	return d.DataType.RegisterCount()
+}
+
+// IsBitAccess returns whether the function code reads single bits (coils,
+// discrete inputs) rather than 16 bit registers.
+func IsBitAccess(functionCode uint8) bool {
+	return functionCode == FuncCodeReadCoils || functionCode == FuncCodeReadDiscreteInputs
+}
+
+// ReadPlan describes the read requests needed to scrape all metrics of a
+// module. It is computed once when the configuration is loaded.
+type ReadPlan struct {
+	Blocks []ReadBlock
+	// LabelBlocks read the label values of metrics sourced from string
+	// registers. They are read before Blocks.
+	LabelBlocks []ReadBlock
+}
+
+// ReadBlock is a single read request covering one or more metrics.
+type ReadBlock struct {
+	FunctionCode uint8
+	// Address of the first register (or coil, discrete input) to read.
+	Address uint16
+	// Number of registers (or coils, discrete inputs) to read.
+	Quantity uint16
+	// Reads lists the metrics parsed from the data of this block.
+	Reads []PlannedRead
+}
+
+// PlannedRead locates the data of one metric within a read block.
+type PlannedRead struct {
+	// Index of the metric definition within the module.
+	Metric int
+	// Offset in registers (or bits) from the start of the block.
+	Offset uint16
+	// Quantity of registers (or bits).
+	Quantity uint16
+	// Label is the name of the label read, if the read is one of the label
+	// registers of the metric instead of its value.
+	Label string
+	// Sum is whether the read is the sum register of a histogram instead of
+	// its bucket counts.
+	Sum bool
+}
+
+// planRead is the location of a single metric before grouping into blocks.
+type planRead struct {
+	metric       int
+	functionCode uint8
+	address      uint16
+	quantity     uint16
+	label        string
+	sum          bool
+}
+
+// maxRead returns the maximum quantity a single read of the module may ask for
+// with the given function code.
+func (s *Module) maxRead(functionCode uint8) uint16 {
+	max := uint16(MaxReadRegisters)
+	if IsBitAccess(functionCode) {
+		max = MaxReadBits
+	}
+	if s.MaxReadRegisters > 0 && uint16(s.MaxReadRegisters) < max {
+		max = uint16(s.MaxReadRegisters)
+	}
+
+	return max
+}
+
+// compilePlan groups the metric definitions of the module into as few read
+// requests as possible. Only contiguous or overlapping definitions sharing a
+// function code are merged, never reading registers not asked for.
+func (s *Module) compilePlan() (*ReadPlan, error) {
+	reads := make([]planRead, 0, len(s.Metrics))
+	for i, def := range s.Metrics {
+		functionCode, address, err := def.Address.Split()
+		if err != nil {
+			return nil, fmt.Errorf("metric '%v': %v", def.Name, err)
+		}
+
+		quantity := def.RegisterCount()
+		if IsBitAccess(functionCode) && def.DataType != ModbusBool {
+			return nil, fmt.Errorf("metric '%v', address '%v': coils and discrete inputs can only be read as %v",
+				def.Name, def.Address, ModbusBool)
+		}
+		if int(address)+int(quantity) > 65536 {
+			return nil, fmt.Errorf("metric '%v', address '%v': %v registers exceed the address space",
+				def.Name, def.Address, quantity)
+		}
+
+		if quantity > s.maxRead(functionCode) {
+			return nil, fmt.Errorf("metric '%v', address '%v': %v registers exceed the maximum read size",
+				def.Name, def.Address, quantity)
+		}
+
+		reads = append(reads, planRead{i, functionCode, address, quantity, "", false})
+
+		if def.SumAddress != nil {
+			functionCode, address, err := def.SumAddress.Split()
+			if err != nil {
+				return nil, fmt.Errorf("metric '%v': %v", def.Name, err)
+			}
+			reads = append(reads, planRead{i, functionCode, address, def.DataType.RegisterCount(), "", true})
+		}
+	}
+
+	labelReads := []planRead{}
+	for i, def := range s.Metrics {
+		names := make([]string, 0, len(def.LabelRegisters))
+		for name := range def.LabelRegisters {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			r := def.LabelRegisters[name]
+			functionCode, address, err := r.Address.Split()
+			if err != nil {
+				return nil, fmt.Errorf("metric '%v', label '%v': %v", def.Name, name, err)
+			}
+			if int(address)+r.Length > 65536 {
+				return nil, fmt.Errorf("metric '%v', label '%v', address '%v': %v registers exceed the address space",
+					def.Name, name, r.Address, r.Length)
+			}
+
+			labelReads = append(labelReads, planRead{i, functionCode, address, uint16(r.Length), name, false})
+		}
+	}
+
+	return &ReadPlan{
+		Blocks:      s.groupReads(reads),
+		LabelBlocks: s.groupReads(labelReads),
+	}, nil
+}
+
+// groupReads merges the given reads into as few blocks as possible.
+func (s *Module) groupReads(reads []planRead) []ReadBlock {
+	sort.SliceStable(reads, func(i, j int) bool {
+		if reads[i].functionCode != reads[j].functionCode {
+			return reads[i].functionCode < reads[j].functionCode
+		}
+		return reads[i].address < reads[j].address
+	})
+
+	blocks := []ReadBlock{}
+	for _, r := range reads {
+		if n := len(blocks); n > 0 {
+			b := &blocks[n-1]
+			start, end := uint32(b.Address), uint32(b.Address)+uint32(b.Quantity)
+			rEnd := uint32(r.address) + uint32(r.quantity)
+			if rEnd < end {
+				rEnd = end
+			}
+			if b.FunctionCode == r.functionCode && uint32(r.address) <= end && rEnd-start <= uint32(s.maxRead(r.functionCode)) {
+				b.Quantity = uint16(rEnd - start)
+				b.Reads = append(b.Reads, PlannedRead{r.metric, r.address - b.Address, r.quantity, r.label, r.sum})
+				continue
+			}
+		}
+
+		blocks = append(blocks, ReadBlock{
+			FunctionCode: r.functionCode,
+			Address:      r.address,
+			Quantity:     r.quantity,
+			Reads:        []PlannedRead{{r.metric, 0, r.quantity, r.label, r.sum}},
+		})
+	}
+
+	return blocks
+}
+
+// ReadPlan returns the read plan of the module, computing it if the module
+// has not been validated as part of loading a configuration.
+func (s *Module) ReadPlan() (*ReadPlan, error) {
+	if s.plan != nil {
+		return s.plan, nil
+	}
+
+	return s.compilePlan()
+}
@@ -0,0 +1,134 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRegisterAddrSplit(t *testing.T) {
+	for _, test := range []struct {
+		addr         RegisterAddr
+		functionCode uint8
+		address      uint16
+		valid        bool
+	}{
+		{300022, FuncCodeReadHoldingRegisters, 22, true},
+		{124, FuncCodeReadCoils, 24, true},
+		{465535, FuncCodeReadInputRegisters, 65535, true},
+		{465536, 0, 0, false},
+		{500001, 0, 0, false},
+		{3, 0, 0, false},
+	} {
+		functionCode, address, err := test.addr.Split()
+		if (err == nil) != test.valid {
+			t.Fatalf("address %v: expected valid to be %v but got error %v", test.addr, test.valid, err)
+		}
+		if functionCode != test.functionCode || address != test.address {
+			t.Fatalf("address %v: expected %v/%v but got %v/%v",
+				test.addr, test.functionCode, test.address, functionCode, address)
+		}
+	}
+}
+
+func TestCompilePlan(t *testing.T) {
+	m := Module{
+		Metrics: []MetricDef{
+			{Name: "a", Address: 300010, DataType: ModbusInt32},
+			{Name: "b", Address: 300012, DataType: ModbusInt16},
+			{Name: "c", Address: 300011, DataType: ModbusInt16},
+			{Name: "d", Address: 300020, DataType: ModbusInt16},
+			{Name: "e", Address: 400012, DataType: ModbusInt16},
+			{Name: "f", Address: 110, DataType: ModbusBool},
+			{Name: "g", Address: 111, DataType: ModbusBool},
+		},
+	}
+
+	plan, err := m.compilePlan()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []ReadBlock{
+		{FuncCodeReadCoils, 10, 2, []PlannedRead{{5, 0, 1, "", false}, {6, 1, 1, "", false}}},
+		{FuncCodeReadHoldingRegisters, 10, 3, []PlannedRead{{0, 0, 2, "", false}, {2, 1, 1, "", false}, {1, 2, 1, "", false}}},
+		{FuncCodeReadHoldingRegisters, 20, 1, []PlannedRead{{3, 0, 1, "", false}}},
+		{FuncCodeReadInputRegisters, 12, 1, []PlannedRead{{4, 0, 1, "", false}}},
+	}
+	if !reflect.DeepEqual(plan.Blocks, expected) {
+		t.Fatalf("expected blocks %v but got %v", expected, plan.Blocks)
+	}
+}
+
+func TestCompilePlanLabelRegisters(t *testing.T) {
+	m := Module{
+		Metrics: []MetricDef{
+			{Name: "a", Address: 300010, DataType: ModbusInt16, LabelRegisters: map[string]LabelRegister{
+				"channel": {Address: 300100, Length: 8},
+				"site":    {Address: 300108, Length: 4},
+			}},
+			{Name: "b", Address: 300011, DataType: ModbusInt16, LabelRegisters: map[string]LabelRegister{
+				"channel": {Address: 300112, Length: 8},
+			}},
+		},
+	}
+
+	plan, err := m.compilePlan()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []ReadBlock{
+		{FuncCodeReadHoldingRegisters, 100, 20, []PlannedRead{{0, 0, 8, "channel", false}, {0, 8, 4, "site", false}, {1, 12, 8, "channel", false}}},
+	}
+	if !reflect.DeepEqual(plan.LabelBlocks, expected) {
+		t.Fatalf("expected label blocks %v but got %v", expected, plan.LabelBlocks)
+	}
+	if len(plan.Blocks) != 1 {
+		t.Fatalf("expected label registers not to be read with the values but got %v", plan.Blocks)
+	}
+}
+
+func TestCompilePlanMaxReadRegisters(t *testing.T) {
+	m := Module{
+		MaxReadRegisters: 2,
+		Metrics: []MetricDef{
+			{Name: "a", Address: 300010, DataType: ModbusInt16},
+			{Name: "b", Address: 300011, DataType: ModbusInt16},
+			{Name: "c", Address: 300012, DataType: ModbusInt16},
+		},
+	}
+
+	plan, err := m.compilePlan()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(plan.Blocks) != 2 {
+		t.Fatalf("expected 2 blocks but got %v", plan.Blocks)
+	}
+}
+
+func TestCompilePlanInvalidLayout(t *testing.T) {
+	for _, def := range []MetricDef{
+		{Name: "coil as int", Address: 110, DataType: ModbusInt16},
+		{Name: "beyond address space", Address: 365534, DataType: ModbusInt64},
+	} {
+		m := Module{Metrics: []MetricDef{def}}
+		if _, err := m.compilePlan(); err == nil {
+			t.Fatalf("%v: expected invalid layout to fail", def.Name)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const fetchTimeout = 30 * time.Second
+
+func isURL(path string) bool {
+	return strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://")
+}
+
+// loadURL loads the configuration from the given HTTP(S) URL, falling back to
+// the cache file of the options if the URL can't be fetched.
+func loadURL(url string, opts LoadOptions) (Config, error) {
+	data, err := fetch(url, opts.BearerToken)
+	if err != nil {
+		if opts.CacheFile == "" {
+			return Config{}, err
+		}
+
+		cached, cacheErr := os.ReadFile(opts.CacheFile)
+		if cacheErr != nil {
+			return Config{}, fmt.Errorf("%v, no cached configuration: %v", err, cacheErr)
+		}
+		if opts.OnFallback != nil {
+			opts.OnFallback(err)
+		}
+
+		c, err := parseConfig(opts.CacheFile, cached, opts)
+		if err != nil {
+			return Config{}, err
+		}
+		return complete(c)
+	}
+
+	c, err := parseConfig(url, data, opts)
+	if err != nil {
+		return Config{}, err
+	}
+	if c, err = complete(c); err != nil {
+		return Config{}, err
+	}
+
+	// Only cache valid configurations.
+	if opts.CacheFile != "" {
+		if err := writeFileAtomic(opts.CacheFile, data); err != nil {
+			return Config{}, fmt.Errorf("failed to cache configuration: %v", err)
+		}
+	}
+
+	return c, nil
+}
+
+func fetch(url, bearerToken string) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if bearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+bearerToken)
+	}
+
+	client := &http.Client{Timeout: fetchTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch configuration: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch configuration from %v: %v", url, resp.Status)
+	}
+
+	return io.ReadAll(resp.Body)
+}
+
+// writeFileAtomic writes the file via a temporary file, so readers never see a
+// partially written one.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,67 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadConfigURL(t *testing.T) {
+	example, err := os.ReadFile("../modbus.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		w.Write(example)
+	}))
+
+	cacheFile := filepath.Join(t.TempDir(), "modbus.yml")
+	opts := LoadOptions{BearerToken: "secret", CacheFile: cacheFile}
+
+	if _, err := LoadConfigWithOptions(srv.URL, LoadOptions{}); err == nil {
+		t.Fatal("expected loading without token to fail")
+	}
+
+	c, err := LoadConfigWithOptions(srv.URL, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.HasModule("fake") {
+		t.Fatal("expected module fake to be loaded")
+	}
+
+	srv.Close()
+
+	var fallbackErr error
+	opts.OnFallback = func(err error) { fallbackErr = err }
+	c, err = LoadConfigWithOptions(srv.URL, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.HasModule("fake") {
+		t.Fatal("expected module fake to be loaded from cache")
+	}
+	if fallbackErr == nil {
+		t.Fatal("expected fallback to be reported")
+	}
+}
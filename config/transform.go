@@ -0,0 +1,592 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// TransformValue is the identifier of the parsed value of the metric in
+// transform expressions.
+const TransformValue = "value"
+
+// Transform is a compiled transform expression of a metric definition. The
+// expressions are arithmetic on float64 values:
+//
+//   - numbers, the parsed value of the metric as `value` and the parsed
+//     values of other metrics of the module by name
+//   - the operators `+ - * / %`, comparisons `== != < <= > >=`, which result
+//     in 1 or 0, and the conditional `cond ? a : b`, taking a if cond isn't 0
+//   - the functions abs, min, max, pow, sqrt, exp, ln, log10, floor, ceil,
+//     round, clamp(x, lo, hi) and interp(x, x0, y0, x1, y1, ...), which
+//     interpolates linearly between the points of a curve with increasing
+//     x, e.g. the resistance to temperature table of a thermistor, and
+//     clamps x to the range of the curve
+//
+// Results that aren't finite, e.g. of a division by zero, are errors.
+type Transform struct {
+	source     string
+	root       transformNode
+	references []string
+}
+
+// ParseTransform compiles the given transform expression.
+func ParseTransform(s string) (*Transform, error) {
+	p := &transformParser{src: s}
+	p.next()
+	root, err := p.parseConditional()
+	if err == nil && p.tok.kind != tokEOF {
+		err = p.errorf("unexpected %q", p.tok.text)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	refs := make([]string, 0, len(p.refs))
+	for name := range p.refs {
+		refs = append(refs, name)
+	}
+	sort.Strings(refs)
+
+	return &Transform{source: s, root: root, references: refs}, nil
+}
+
+// String returns the source of the expression.
+func (t *Transform) String() string {
+	return t.source
+}
+
+// References returns the sorted names of the other metrics the expression
+// refers to.
+func (t *Transform) References() []string {
+	return t.references
+}
+
+// Eval evaluates the expression for the given parsed value of the metric and
+// parsed values of the other metrics of the scrape by name.
+func (t *Transform) Eval(value float64, metrics map[string]float64) (float64, error) {
+	v, err := t.root.eval(&transformEnv{value: value, metrics: metrics})
+	if err != nil {
+		return 0, err
+	}
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return 0, fmt.Errorf("transform %q: result %v is not finite", t.source, v)
+	}
+
+	return v, nil
+}
+
+type transformEnv struct {
+	value   float64
+	metrics map[string]float64
+}
+
+type transformNode interface {
+	eval(env *transformEnv) (float64, error)
+}
+
+type numberNode float64
+
+func (n numberNode) eval(*transformEnv) (float64, error) {
+	return float64(n), nil
+}
+
+type identNode string
+
+func (n identNode) eval(env *transformEnv) (float64, error) {
+	if n == TransformValue {
+		return env.value, nil
+	}
+	v, ok := env.metrics[string(n)]
+	if !ok {
+		return 0, fmt.Errorf("metric %v not available", string(n))
+	}
+
+	return v, nil
+}
+
+type negNode struct {
+	operand transformNode
+}
+
+func (n *negNode) eval(env *transformEnv) (float64, error) {
+	v, err := n.operand.eval(env)
+	if err != nil {
+		return 0, err
+	}
+
+	return -v, nil
+}
+
+type binaryNode struct {
+	op          string
+	left, right transformNode
+}
+
+func (n *binaryNode) eval(env *transformEnv) (float64, error) {
+	l, err := n.left.eval(env)
+	if err != nil {
+		return 0, err
+	}
+	r, err := n.right.eval(env)
+	if err != nil {
+		return 0, err
+	}
+
+	switch n.op {
+	case "+":
+		return l + r, nil
+	case "-":
+		return l - r, nil
+	case "*":
+		return l * r, nil
+	case "/":
+		return l / r, nil
+	case "%":
+		return math.Mod(l, r), nil
+	case "==":
+		return boolValue(l == r), nil
+	case "!=":
+		return boolValue(l != r), nil
+	case "<":
+		return boolValue(l < r), nil
+	case "<=":
+		return boolValue(l <= r), nil
+	case ">":
+		return boolValue(l > r), nil
+	default:
+		return boolValue(l >= r), nil
+	}
+}
+
+type conditionalNode struct {
+	cond, then, otherwise transformNode
+}
+
+func (n *conditionalNode) eval(env *transformEnv) (float64, error) {
+	c, err := n.cond.eval(env)
+	if err != nil {
+		return 0, err
+	}
+	if c != 0 {
+		return n.then.eval(env)
+	}
+
+	return n.otherwise.eval(env)
+}
+
+type callNode struct {
+	name string
+	fn   transformFunc
+	args []transformNode
+}
+
+func (n *callNode) eval(env *transformEnv) (float64, error) {
+	args := make([]float64, len(n.args))
+	for i, a := range n.args {
+		v, err := a.eval(env)
+		if err != nil {
+			return 0, err
+		}
+		args[i] = v
+	}
+
+	v, err := n.fn.call(args)
+	if err != nil {
+		return 0, fmt.Errorf("%v: %v", n.name, err)
+	}
+
+	return v, nil
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// transformFunc is a function available in transform expressions taking from
+// minArgs to maxArgs arguments, any number if maxArgs is negative.
+type transformFunc struct {
+	minArgs, maxArgs int
+	call             func(args []float64) (float64, error)
+}
+
+func mathFunc(f func(float64) float64) transformFunc {
+	return transformFunc{1, 1, func(args []float64) (float64, error) { return f(args[0]), nil }}
+}
+
+var transformFuncs = map[string]transformFunc{
+	"abs":   mathFunc(math.Abs),
+	"sqrt":  mathFunc(math.Sqrt),
+	"exp":   mathFunc(math.Exp),
+	"ln":    mathFunc(math.Log),
+	"log10": mathFunc(math.Log10),
+	"floor": mathFunc(math.Floor),
+	"ceil":  mathFunc(math.Ceil),
+	"round": mathFunc(math.Round),
+	"pow": {2, 2, func(args []float64) (float64, error) {
+		return math.Pow(args[0], args[1]), nil
+	}},
+	"min": {1, -1, func(args []float64) (float64, error) {
+		v := args[0]
+		for _, a := range args[1:] {
+			v = math.Min(v, a)
+		}
+		return v, nil
+	}},
+	"max": {1, -1, func(args []float64) (float64, error) {
+		v := args[0]
+		for _, a := range args[1:] {
+			v = math.Max(v, a)
+		}
+		return v, nil
+	}},
+	"clamp": {3, 3, func(args []float64) (float64, error) {
+		if args[1] > args[2] {
+			return 0, fmt.Errorf("lower bound %v above upper bound %v", args[1], args[2])
+		}
+		return math.Max(args[1], math.Min(args[2], args[0])), nil
+	}},
+	"interp": {5, -1, interp},
+}
+
+// interp interpolates args[0] linearly on the curve given by the x and y
+// pairs of the remaining arguments.
+func interp(args []float64) (float64, error) {
+	x, points := args[0], args[1:]
+	for i := 2; i < len(points); i += 2 {
+		if points[i] <= points[i-2] {
+			return 0, fmt.Errorf("x of the points must be increasing")
+		}
+	}
+
+	if x <= points[0] {
+		return points[1], nil
+	}
+	for i := 2; i < len(points); i += 2 {
+		if x <= points[i] {
+			x0, y0, x1, y1 := points[i-2], points[i-1], points[i], points[i+1]
+			return y0 + (x-x0)*(y1-y0)/(x1-x0), nil
+		}
+	}
+
+	return points[len(points)-1], nil
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokNumber
+	tokIdent
+	tokOp
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+// maxTransformDepth is the deepest nesting of parentheses, calls,
+// conditionals and unary minus accepted, bounding the recursion of the parser.
+const maxTransformDepth = 32
+
+// transformParser is a recursive descent parser of transform expressions,
+// with the precedence of the operators of C.
+type transformParser struct {
+	src   string
+	pos   int
+	tok   token
+	refs  map[string]bool
+	depth int
+}
+
+func (p *transformParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("transform %q: position %d: %v", p.src, p.tok.pos+1, fmt.Sprintf(format, args...))
+}
+
+// next advances to the next token. Characters that don't start a token are
+// returned as operator tokens of their own and refused by the parser.
+func (p *transformParser) next() {
+	for p.pos < len(p.src) && strings.ContainsRune(" \t\r\n", rune(p.src[p.pos])) {
+		p.pos++
+	}
+	start := p.pos
+	if p.pos >= len(p.src) {
+		p.tok = token{tokEOF, "end of expression", start}
+		return
+	}
+
+	c := p.src[p.pos]
+	switch {
+	case isDigit(c) || c == '.':
+		for p.pos < len(p.src) && (isDigit(p.src[p.pos]) || p.src[p.pos] == '.') {
+			p.pos++
+		}
+		// Exponent, e.g. 1e-3.
+		if p.pos < len(p.src) && (p.src[p.pos] == 'e' || p.src[p.pos] == 'E') {
+			end := p.pos + 1
+			if end < len(p.src) && (p.src[end] == '+' || p.src[end] == '-') {
+				end++
+			}
+			if end < len(p.src) && isDigit(p.src[end]) {
+				for end < len(p.src) && isDigit(p.src[end]) {
+					end++
+				}
+				p.pos = end
+			}
+		}
+		p.tok = token{tokNumber, p.src[start:p.pos], start}
+	case isIdentStart(c):
+		for p.pos < len(p.src) && (isIdentStart(p.src[p.pos]) || isDigit(p.src[p.pos])) {
+			p.pos++
+		}
+		p.tok = token{tokIdent, p.src[start:p.pos], start}
+	default:
+		p.pos++
+		if p.pos < len(p.src) {
+			switch two := p.src[start : p.pos+1]; two {
+			case "==", "!=", "<=", ">=":
+				p.pos++
+			}
+		}
+		p.tok = token{tokOp, p.src[start:p.pos], start}
+	}
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// accept advances past the current token if it is the given operator.
+func (p *transformParser) accept(op string) bool {
+	if p.tok.kind != tokOp || p.tok.text != op {
+		return false
+	}
+	p.next()
+	return true
+}
+
+func (p *transformParser) expect(op string) error {
+	if !p.accept(op) {
+		return p.errorf("expected %q, got %q", op, p.tok.text)
+	}
+	return nil
+}
+
+// enter descends one level of nesting, failing beyond maxTransformDepth. The
+// returned function ascends again.
+func (p *transformParser) enter() (func(), error) {
+	if p.depth == maxTransformDepth {
+		return nil, p.errorf("nested more than %d levels deep", maxTransformDepth)
+	}
+	p.depth++
+	return func() { p.depth-- }, nil
+}
+
+func (p *transformParser) parseConditional() (transformNode, error) {
+	leave, err := p.enter()
+	if err != nil {
+		return nil, err
+	}
+	defer leave()
+
+	cond, err := p.parseBinary(0)
+	if err != nil || !p.accept("?") {
+		return cond, err
+	}
+	then, err := p.parseConditional()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(":"); err != nil {
+		return nil, err
+	}
+	otherwise, err := p.parseConditional()
+	if err != nil {
+		return nil, err
+	}
+
+	return &conditionalNode{cond, then, otherwise}, nil
+}
+
+// binaryOperators are the left associative binary operators by precedence,
+// lowest first.
+var binaryOperators = [][]string{
+	{"==", "!="},
+	{"<", "<=", ">", ">="},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+func (p *transformParser) parseBinary(level int) (transformNode, error) {
+	if level == len(binaryOperators) {
+		return p.parseUnary()
+	}
+
+	left, err := p.parseBinary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op := ""
+		for _, o := range binaryOperators[level] {
+			if p.tok.kind == tokOp && p.tok.text == o {
+				op = o
+			}
+		}
+		if op == "" {
+			return left, nil
+		}
+		p.next()
+		right, err := p.parseBinary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryNode{op, left, right}
+	}
+}
+
+func (p *transformParser) parseUnary() (transformNode, error) {
+	if !p.accept("-") {
+		return p.parsePrimary()
+	}
+
+	leave, err := p.enter()
+	if err != nil {
+		return nil, err
+	}
+	defer leave()
+
+	operand, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	// Negative numbers stay numbers, e.g. for the checks of interp.
+	if n, ok := operand.(numberNode); ok {
+		return -n, nil
+	}
+
+	return &negNode{operand}, nil
+}
+
+func (p *transformParser) parsePrimary() (transformNode, error) {
+	tok := p.tok
+	switch tok.kind {
+	case tokNumber:
+		v, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			return nil, p.errorf("invalid number %q", tok.text)
+		}
+		p.next()
+		return numberNode(v), nil
+	case tokIdent:
+		p.next()
+		if p.accept("(") {
+			return p.parseCall(tok)
+		}
+		if tok.text != TransformValue {
+			if p.refs == nil {
+				p.refs = map[string]bool{}
+			}
+			p.refs[tok.text] = true
+		}
+		return identNode(tok.text), nil
+	case tokOp:
+		if p.accept("(") {
+			n, err := p.parseConditional()
+			if err != nil {
+				return nil, err
+			}
+			return n, p.expect(")")
+		}
+	}
+
+	return nil, p.errorf("unexpected %q", tok.text)
+}
+
+// parseCall parses the arguments of a call of the function named by the
+// given token, whose opening parenthesis has been consumed.
+func (p *transformParser) parseCall(name token) (transformNode, error) {
+	fn, ok := transformFuncs[name.text]
+	if !ok {
+		p.tok = name
+		return nil, p.errorf("unknown function %v", name.text)
+	}
+
+	args := []transformNode{}
+	if !p.accept(")") {
+		for {
+			arg, err := p.parseConditional()
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+			if p.accept(")") {
+				break
+			}
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if len(args) < fn.minArgs || (fn.maxArgs >= 0 && len(args) > fn.maxArgs) {
+		p.tok = name
+		return nil, p.errorf("wrong number of arguments to %v: %d", name.text, len(args))
+	}
+	if name.text == "interp" {
+		if len(args)%2 == 0 {
+			p.tok = name
+			return nil, p.errorf("interp takes x and pairs of point coordinates")
+		}
+		if err := checkInterpPoints(args[1:]); err != nil {
+			p.tok = name
+			return nil, p.errorf("interp: %v", err)
+		}
+	}
+
+	return &callNode{name.text, fn, args}, nil
+}
+
+// checkInterpPoints refuses curves whose points are given as numbers and are
+// not in increasing order of x. Points computed from metrics are checked on
+// evaluation.
+func checkInterpPoints(points []transformNode) error {
+	var prev *numberNode
+	for i := 0; i < len(points); i += 2 {
+		x, ok := points[i].(numberNode)
+		if !ok {
+			prev = nil
+			continue
+		}
+		if prev != nil && x <= *prev {
+			return fmt.Errorf("x of the points must be increasing")
+		}
+		prev = &x
+	}
+
+	return nil
+}
@@ -0,0 +1,191 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTransformEval(t *testing.T) {
+	metrics := map[string]float64{"scale_factor": -1, "offset": 2.5}
+	for _, test := range []struct {
+		expr     string
+		value    float64
+		expected float64
+	}{
+		{"value", 7, 7},
+		{"value * pow(10, scale_factor)", 235, 23.5},
+		{"--value", 2, 2},
+		{"-(value + 1)", 2, -3},
+		{"(value - 32) * 5 / 9", 212, 100},
+		{"value + offset", 1, 3.5},
+		{"value % 10", 123, 3},
+		{"value > 100 ? 100 : value", 150, 100},
+		{"value > 100 ? 100 : value < 0 ? 0 : value", -3, 0},
+		{"value <= 2", 1.5, 1},
+		{"value == 3", 3, 1},
+		{"value != 3", 3, 0},
+		{"abs(value)", -2, 2},
+		{"min(value, 4, 2)", 3, 2},
+		{"max(value, 4, 2)", 3, 4},
+		{"clamp(value, 0, 10)", 11, 10},
+		{"round(value * 1e2) / 100", 1.23456, 1.23},
+		{"floor(value) + ceil(value)", 1.5, 3},
+		{"sqrt(value) + pow(2, 3)", 9, 11},
+		{"ln(exp(value)) + log10(100)", 1, 3},
+		{"interp(value, 0, 0, 10, 100, 20, 150)", 15, 125},
+		{"interp(value, 0, 0, 10, 100, 20, 150)", -5, 0},
+		{"interp(value, 0, 0, 10, 100, 20, 150)", 25, 150},
+		{"interp(value, -10, 5, 10, -5)", 0, 0},
+		// The branch not taken may refer to metrics not available.
+		{"value > 0 ? value : missing", 1, 1},
+	} {
+		tr, err := ParseTransform(test.expr)
+		if err != nil {
+			t.Fatalf("%v: %v", test.expr, err)
+		}
+		v, err := tr.Eval(test.value, metrics)
+		if err != nil {
+			t.Fatalf("%v: %v", test.expr, err)
+		}
+		if v < test.expected-1e-9 || v > test.expected+1e-9 {
+			t.Errorf("%v with value %v: expected %v but got %v", test.expr, test.value, test.expected, v)
+		}
+	}
+}
+
+func TestTransformEvalErrors(t *testing.T) {
+	for _, test := range []struct {
+		expr string
+		err  string
+	}{
+		{"value / 0", "not finite"},
+		{"sqrt(-1)", "not finite"},
+		{"value * missing", "metric missing not available"},
+		{"clamp(value, 10, 0)", "lower bound"},
+		{"interp(value, 0, 0, missing, 1)", "metric missing"},
+		{"interp(value, 10, 0, offset, 1)", "increasing"},
+	} {
+		tr, err := ParseTransform(test.expr)
+		if err != nil {
+			t.Fatalf("%v: %v", test.expr, err)
+		}
+		if _, err := tr.Eval(1, map[string]float64{"offset": 2}); err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%v: expected error containing %q but got %v", test.expr, test.err, err)
+		}
+	}
+}
+
+func TestParseTransform(t *testing.T) {
+	tr, err := ParseTransform("value * b + a - b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refs := tr.References(); !reflect.DeepEqual(refs, []string{"a", "b"}) {
+		t.Errorf("expected references a and b but got %v", refs)
+	}
+	if tr.String() != "value * b + a - b" {
+		t.Errorf("expected the source but got %v", tr.String())
+	}
+
+	for _, test := range []struct {
+		expr string
+		err  string
+	}{
+		{"", "unexpected \"end of expression\""},
+		{"value +", "position 8: unexpected"},
+		{"(value", "expected \")\""},
+		{"value value", "unexpected \"value\""},
+		{"value $ 2", "unexpected \"$\""},
+		{"value ? 1", "expected \":\""},
+		{"foo(value)", "unknown function foo"},
+		{"abs(value, 2)", "wrong number of arguments to abs: 2"},
+		{"pow(value)", "wrong number of arguments"},
+		{"interp(value, 0, 0)", "wrong number of arguments"},
+		{"interp(value, 0, 0, 1, 1, 2)", "pairs of point coordinates"},
+		{"interp(value, 0, 0, -1, 1)", "increasing"},
+		{"1.2.3", "invalid number"},
+		{"value ^ 2", "unexpected \"^\""},
+		{"value > 0 && value < 2", "unexpected \"&\""},
+		{"!value", "unexpected \"!\""},
+		{strings.Repeat("(", 33) + "value" + strings.Repeat(")", 33), "nested more than 32 levels deep"},
+		{strings.Repeat("-", 40) + "value", "nested more than 32 levels deep"},
+		{strings.Repeat("abs(", 40) + "value" + strings.Repeat(")", 40), "nested more than 32 levels deep"},
+	} {
+		if _, err := ParseTransform(test.expr); err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%q: expected error containing %q but got %v", test.expr, test.err, err)
+		}
+	}
+}
+
+func TestModuleValidateTransforms(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		metrics []MetricDef
+		err     string
+	}{
+		{
+			"reference",
+			[]MetricDef{
+				{Name: "a", Address: 300000, DataType: ModbusInt16, MetricType: MetricTypeGauge, Transform: "value * pow(10, b)"},
+				{Name: "b", Address: 300001, DataType: ModbusInt16, MetricType: MetricTypeGauge},
+			},
+			"",
+		},
+		{
+			"invalid expression",
+			[]MetricDef{
+				{Name: "a", Address: 300000, DataType: ModbusInt16, MetricType: MetricTypeGauge, Transform: "value *"},
+			},
+			"invalid metric definition a: transform",
+		},
+		{
+			"histogram",
+			[]MetricDef{
+				{Name: "a", Address: 300000, DataType: ModbusUInt16, MetricType: MetricTypeHistogram, Buckets: []float64{1}, Transform: "value"},
+			},
+			"can't be used with metric type histogram",
+		},
+		{
+			"undefined reference",
+			[]MetricDef{
+				{Name: "a", Address: 300000, DataType: ModbusInt16, MetricType: MetricTypeGauge, Transform: "value * c"},
+			},
+			"refers to undefined metric c",
+		},
+		{
+			"ambiguous reference",
+			[]MetricDef{
+				{Name: "a", Address: 300000, DataType: ModbusInt16, MetricType: MetricTypeGauge, Transform: "value * b"},
+				{Name: "b", Labels: map[string]string{"phase": "1"}, Address: 300001, DataType: ModbusInt16, MetricType: MetricTypeGauge},
+				{Name: "b", Labels: map[string]string{"phase": "2"}, Address: 300002, DataType: ModbusInt16, MetricType: MetricTypeGauge},
+			},
+			"refers to metric b defined several times",
+		},
+	} {
+		m := Module{Name: "m", Protocol: ModbusProtocolTCPIP, Metrics: test.metrics}
+		err := m.validate()
+		if test.err == "" {
+			if err != nil {
+				t.Errorf("%v: expected module to be valid but got %v", test.name, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%v: expected error containing %q but got %v", test.name, test.err, err)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import "fmt"
+
+// MaxWriteRegisters is the maximum number of registers a single write request
+// may set.
+const MaxWriteRegisters = 123
+
+// WritableRange is a range of coils or holding registers which may be written,
+// in the address format of metrics, e.g. 100010 for coil 10 or 300100 for
+// holding register 100. Both ends are included.
+type WritableRange struct {
+	From RegisterAddr `yaml:"from"`
+	To   RegisterAddr `yaml:"to"`
+}
+
+// validate semantically validates the given writable range.
+func (r *WritableRange) validate() error {
+	fromCode, from, err := r.From.Split()
+	if err != nil {
+		return err
+	}
+	toCode, to, err := r.To.Split()
+	if err != nil {
+		return err
+	}
+
+	if fromCode != FuncCodeReadCoils && fromCode != FuncCodeReadHoldingRegisters {
+		return fmt.Errorf("writable range %v-%v: only coils and holding registers can be written", r.From, r.To)
+	}
+	if fromCode != toCode || from > to {
+		return fmt.Errorf("writable range %v-%v: ends must be of the same type and in order", r.From, r.To)
+	}
+
+	return nil
+}
+
+// CanWrite returns whether the given quantity of coils or holding registers,
+// as given by the function code reading them, starting at address may be
+// written.
+func (s *Module) CanWrite(functionCode uint8, address, quantity uint16) bool {
+	if quantity == 0 {
+		return false
+	}
+
+	for _, r := range s.Writable {
+		fromCode, from, err := r.From.Split()
+		if err != nil || fromCode != functionCode {
+			continue
+		}
+		_, to, err := r.To.Split()
+		if err != nil {
+			continue
+		}
+		if address >= from && int(address)+int(quantity)-1 <= int(to) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,234 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/RichiH/modbus_exporter/config"
+)
+
+// grafanaUnits maps the base unit suffixes of metric names, following the
+// Prometheus naming conventions, to Grafana units.
+var grafanaUnits = []struct {
+	suffix string
+	unit   string
+}{
+	{"_volt_amperes", "voltamp"},
+	{"_watt_hours", "watth"},
+	{"_volts", "volt"},
+	{"_amperes", "amp"},
+	{"_watts", "watt"},
+	{"_seconds", "s"},
+	{"_celsius", "celsius"},
+	{"_hertz", "hertz"},
+	{"_percent", "percent"},
+	{"_ratio", "percentunit"},
+	{"_bytes", "bytes"},
+}
+
+type dashboard struct {
+	Title         string     `json:"title"`
+	Tags          []string   `json:"tags"`
+	SchemaVersion int        `json:"schemaVersion"`
+	Time          timeRange  `json:"time"`
+	Templating    templating `json:"templating"`
+	Panels        []panel    `json:"panels"`
+}
+
+type timeRange struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+type templating struct {
+	List []variable `json:"list"`
+}
+
+type variable struct {
+	Name       string      `json:"name"`
+	Label      string      `json:"label"`
+	Type       string      `json:"type"`
+	Query      string      `json:"query"`
+	Datasource *datasource `json:"datasource,omitempty"`
+	Multi      bool        `json:"multi,omitempty"`
+	IncludeAll bool        `json:"includeAll,omitempty"`
+	Refresh    int         `json:"refresh,omitempty"`
+}
+
+type datasource struct {
+	Type string `json:"type"`
+	UID  string `json:"uid"`
+}
+
+type panel struct {
+	ID          int         `json:"id"`
+	Type        string      `json:"type"`
+	Title       string      `json:"title"`
+	Description string      `json:"description,omitempty"`
+	Datasource  datasource  `json:"datasource"`
+	GridPos     gridPos     `json:"gridPos"`
+	FieldConfig fieldConfig `json:"fieldConfig"`
+	Targets     []target    `json:"targets"`
+}
+
+type gridPos struct {
+	H int `json:"h"`
+	W int `json:"w"`
+	X int `json:"x"`
+	Y int `json:"y"`
+}
+
+type fieldConfig struct {
+	Defaults fieldDefaults `json:"defaults"`
+}
+
+type fieldDefaults struct {
+	Unit string `json:"unit,omitempty"`
+}
+
+type target struct {
+	RefID        string `json:"refId"`
+	Expr         string `json:"expr"`
+	LegendFormat string `json:"legendFormat"`
+}
+
+// generateDashboard prints a Grafana dashboard for the given module of the
+// configuration file and returns the exit code.
+func generateDashboard(configFile string, opts config.LoadOptions, moduleName string) int {
+	c, err := config.LoadConfigWithOptions(configFile, opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v: %v\n", configFile, err)
+		return 1
+	}
+
+	module := c.GetModule(moduleName)
+	if module == nil {
+		fmt.Fprintf(os.Stderr, "%v: module '%v' not defined\n", configFile, moduleName)
+		return 1
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(newDashboard(module)); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	return 0
+}
+
+// newDashboard returns a dashboard with one panel per metric of the module.
+// Metrics sharing a name, e.g. one per phase, share a panel.
+func newDashboard(module *config.Module) dashboard {
+	ds := datasource{Type: "prometheus", UID: "${datasource}"}
+	d := dashboard{
+		Title:         "Modbus " + module.Name,
+		Tags:          []string{"modbus"},
+		SchemaVersion: 39,
+		Time:          timeRange{From: "now-6h", To: "now"},
+		Panels:        []panel{},
+	}
+
+	selector := `instance=~"$instance"`
+	if module.HasModuleLabel() {
+		selector = fmt.Sprintf(`module=%q,`, module.Name) + selector
+	}
+
+	seen := map[string]bool{}
+	for _, def := range module.Metrics {
+		name := module.MetricName(def.Name)
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+
+		if len(d.Panels) == 0 {
+			d.Templating.List = []variable{
+				{Name: "datasource", Label: "Data source", Type: "datasource", Query: "prometheus"},
+				{
+					Name: "instance", Label: "Instance", Type: "query", Datasource: &ds,
+					Query: fmt.Sprintf("label_values(%v, instance)", metricSeries(name, def.MetricType)),
+					Multi: true, IncludeAll: true, Refresh: 2,
+				},
+			}
+		}
+
+		labels := make([]string, 0, len(def.Labels)+len(def.LabelRegisters))
+		for l := range def.Labels {
+			labels = append(labels, l)
+		}
+		for l := range def.LabelRegisters {
+			labels = append(labels, l)
+		}
+		sort.Strings(labels)
+
+		legend := "{{instance}}"
+		for _, l := range labels {
+			legend += fmt.Sprintf(" %v={{%v}}", l, l)
+		}
+
+		var expr string
+		switch def.MetricType {
+		case config.MetricTypeCounter:
+			expr = fmt.Sprintf("increase(%v{%v}[$__rate_interval])", name, selector)
+		case config.MetricTypeHistogram:
+			expr = fmt.Sprintf("histogram_quantile(0.9, sum by (le, instance) (rate(%v_bucket{%v}[$__rate_interval])))", name, selector)
+			legend = "p90 {{instance}}"
+		default:
+			expr = fmt.Sprintf("%v{%v}", name, selector)
+		}
+
+		i := len(d.Panels)
+		d.Panels = append(d.Panels, panel{
+			ID:          i + 1,
+			Type:        "timeseries",
+			Title:       name,
+			Description: def.HelpText(),
+			Datasource:  ds,
+			GridPos:     gridPos{H: 8, W: 12, X: (i % 2) * 12, Y: (i / 2) * 8},
+			FieldConfig: fieldConfig{Defaults: fieldDefaults{Unit: grafanaUnit(name)}},
+			Targets:     []target{{RefID: "A", Expr: expr, LegendFormat: legend}},
+		})
+	}
+
+	return d
+}
+
+// metricSeries returns the name of a series of the given metric, which for
+// histograms is one of the buckets.
+func metricSeries(name string, t config.MetricType) string {
+	if t == config.MetricTypeHistogram {
+		return name + "_bucket"
+	}
+
+	return name
+}
+
+// grafanaUnit returns the Grafana unit of the given metric, derived from the
+// unit suffix of its name.
+func grafanaUnit(name string) string {
+	name = strings.TrimSuffix(name, "_total")
+	for _, u := range grafanaUnits {
+		if strings.HasSuffix(name, u.suffix) {
+			return u.unit
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,70 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/RichiH/modbus_exporter/config"
+)
+
+func TestNewDashboard(t *testing.T) {
+	module := &config.Module{
+		Name:         "sdm630",
+		MetricPrefix: "sdm",
+		Metrics: []config.MetricDef{
+			{Name: "voltage_volts", Help: "voltage", Labels: map[string]string{"phase": "1"}, MetricType: config.MetricTypeGauge},
+			{Name: "voltage_volts", Help: "voltage", Labels: map[string]string{"phase": "2"}, MetricType: config.MetricTypeGauge},
+			{Name: "energy_watt_hours_total", MetricType: config.MetricTypeCounter},
+			{Name: "dip_duration_seconds", MetricType: config.MetricTypeHistogram},
+		},
+	}
+
+	d := newDashboard(module)
+	if d.Title != "Modbus sdm630" {
+		t.Fatalf("expected title 'Modbus sdm630' but got %v", d.Title)
+	}
+
+	expected := []panel{
+		{
+			ID: 1, Type: "timeseries", Title: "sdm_voltage_volts", Description: "voltage",
+			Datasource:  datasource{Type: "prometheus", UID: "${datasource}"},
+			GridPos:     gridPos{H: 8, W: 12, X: 0, Y: 0},
+			FieldConfig: fieldConfig{Defaults: fieldDefaults{Unit: "volt"}},
+			Targets:     []target{{RefID: "A", Expr: `sdm_voltage_volts{module="sdm630",instance=~"$instance"}`, LegendFormat: "{{instance}} phase={{phase}}"}},
+		},
+		{
+			ID: 2, Type: "timeseries", Title: "sdm_energy_watt_hours_total",
+			Datasource:  datasource{Type: "prometheus", UID: "${datasource}"},
+			GridPos:     gridPos{H: 8, W: 12, X: 12, Y: 0},
+			FieldConfig: fieldConfig{Defaults: fieldDefaults{Unit: "watth"}},
+			Targets:     []target{{RefID: "A", Expr: `increase(sdm_energy_watt_hours_total{module="sdm630",instance=~"$instance"}[$__rate_interval])`, LegendFormat: "{{instance}}"}},
+		},
+		{
+			ID: 3, Type: "timeseries", Title: "sdm_dip_duration_seconds",
+			Datasource:  datasource{Type: "prometheus", UID: "${datasource}"},
+			GridPos:     gridPos{H: 8, W: 12, X: 0, Y: 8},
+			FieldConfig: fieldConfig{Defaults: fieldDefaults{Unit: "s"}},
+			Targets:     []target{{RefID: "A", Expr: `histogram_quantile(0.9, sum by (le, instance) (rate(sdm_dip_duration_seconds_bucket{module="sdm630",instance=~"$instance"}[$__rate_interval])))`, LegendFormat: "p90 {{instance}}"}},
+		},
+	}
+	if !reflect.DeepEqual(d.Panels, expected) {
+		t.Fatalf("expected panels\n%+v\nbut got\n%+v", expected, d.Panels)
+	}
+
+	if q := d.Templating.List[1].Query; q != "label_values(sdm_voltage_volts, instance)" {
+		t.Fatalf("expected instance variable query label_values(sdm_voltage_volts, instance) but got %v", q)
+	}
+}
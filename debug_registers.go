@@ -0,0 +1,159 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/go-kit/log"
+
+	"github.com/RichiH/modbus_exporter/config"
+	"github.com/RichiH/modbus_exporter/modbus"
+)
+
+// registerTypes maps the type parameter of the register debug endpoint to the
+// function code to read with.
+var registerTypes = map[string]uint8{
+	"coil":     config.FuncCodeReadCoils,
+	"discrete": config.FuncCodeReadDiscreteInputs,
+	"holding":  config.FuncCodeReadHoldingRegisters,
+	"input":    config.FuncCodeReadInputRegisters,
+}
+
+// registersHandler responds with the raw contents of the requested registers
+// along with candidate decodings, to help with undocumented register maps.
+func registersHandler(e *modbus.Exporter, w http.ResponseWriter, r *http.Request, logger log.Logger) {
+	q := r.URL.Query()
+
+	target := q.Get("target")
+	if target == "" {
+		http.Error(w, "'target' parameter must be specified", http.StatusBadRequest)
+		return
+	}
+	c := e.GetConfig()
+	if err := checkTarget(c, target, r, logger); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	subTarget, err := modbus.ParseSubTarget(q.Get("sub_target"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	t := q.Get("type")
+	if t == "" {
+		t = "holding"
+	}
+	functionCode, ok := registerTypes[t]
+	if !ok {
+		http.Error(w, fmt.Sprintf("'type' parameter must be one of coil, discrete, holding or input but got '%v'", t), http.StatusBadRequest)
+		return
+	}
+
+	address, err := strconv.ParseUint(q.Get("address"), 10, 16)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("'address' parameter must be from 0 to 65535: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	quantity := uint64(1)
+	if v := q.Get("quantity"); v != "" {
+		quantity, err = strconv.ParseUint(v, 10, 16)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("'quantity' parameter must be a valid integer: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+	// The limits of a single read request of the Modbus specification.
+	maxQuantity := uint64(125)
+	if config.IsBitAccess(functionCode) {
+		maxQuantity = 2000
+	}
+	if quantity < 1 || quantity > maxQuantity {
+		http.Error(w, fmt.Sprintf("'quantity' parameter must be from 1 to %d for type %v. Invalid value: %d", maxQuantity, t, quantity), http.StatusBadRequest)
+		return
+	}
+
+	// The timeouts of a module may optionally be used.
+	var module *config.Module
+	if name := q.Get("module"); name != "" {
+		module = c.GetModule(name)
+		if module == nil {
+			http.Error(w, fmt.Sprintf("module '%v' not defined in configuration file", name), http.StatusBadRequest)
+			return
+		}
+	}
+
+	data, err := e.ReadRaw(r.Context(), target, byte(subTarget), module, functionCode, uint16(address), uint16(quantity))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to read from target '%v': %v", target, err), http.StatusInternalServerError)
+		return
+	}
+
+	size := 2 * int(quantity)
+	if config.IsBitAccess(functionCode) {
+		size = (int(quantity) + 7) / 8
+	}
+	if len(data) < size {
+		http.Error(w, fmt.Sprintf("short response from target '%v': expected %d bytes but got %d", target, size, len(data)), http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if config.IsBitAccess(functionCode) {
+		writeBits(w, uint16(address), uint16(quantity), data)
+		return
+	}
+	writeRegisters(w, uint16(address), data)
+}
+
+// writeBits writes the given coils or discrete inputs, one per line.
+func writeBits(w io.Writer, address, quantity uint16, data []byte) {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "address\tvalue")
+	for i := uint16(0); i < quantity; i++ {
+		fmt.Fprintf(tw, "%d\t%d\n", uint32(address)+uint32(i), data[i/8]>>(i%8)&1)
+	}
+	tw.Flush()
+}
+
+// writeRegisters writes the given registers, one per line, with candidate
+// decodings. 32 bit values start at the register of their line and are given
+// in both word orders, big endian (ABCD) and word swapped (CDAB).
+func writeRegisters(w io.Writer, address uint16, data []byte) {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "address\thex\tint16\tuint16\tint32\tuint32\tfloat32\tint32 swapped\tuint32 swapped\tfloat32 swapped")
+	for i := 0; i+1 < len(data); i += 2 {
+		reg := binary.BigEndian.Uint16(data[i:])
+		fmt.Fprintf(tw, "%d\t%04x\t%d\t%d", uint32(address)+uint32(i/2), reg, int16(reg), reg)
+
+		if i+3 < len(data) {
+			abcd := binary.BigEndian.Uint32(data[i:])
+			cdab := uint32(binary.BigEndian.Uint16(data[i+2:]))<<16 | uint32(reg)
+			fmt.Fprintf(tw, "\t%d\t%d\t%g\t%d\t%d\t%g",
+				int32(abcd), abcd, math.Float32frombits(abcd),
+				int32(cdab), cdab, math.Float32frombits(cdab))
+		}
+		fmt.Fprintln(tw)
+	}
+	tw.Flush()
+}
@@ -0,0 +1,109 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/tbrandon/mbserver"
+
+	"github.com/RichiH/modbus_exporter/config"
+	"github.com/RichiH/modbus_exporter/modbus"
+)
+
+func TestRegistersHandler(t *testing.T) {
+	s, address := startServer(t)
+	// 1.5 as float32 is 0x3fc00000.
+	s.HoldingRegisters[100] = 0x3fc0
+	s.HoldingRegisters[101] = 0x0000
+	s.Coils[10] = 1
+
+	e := modbus.NewExporter(config.Config{})
+
+	for _, test := range []struct {
+		name     string
+		params   url.Values
+		code     int
+		patterns []string
+	}{
+		{
+			name:   "holding registers",
+			params: url.Values{"target": {address}, "sub_target": {"1"}, "address": {"100"}, "quantity": {"2"}},
+			code:   http.StatusOK,
+			patterns: []string{
+				`(?m)^100\s+3fc0\s+16320\s+16320\s+1069547520\s+1069547520\s+1\.5\s+16320\s+16320\s`,
+				`(?m)^101\s+0000\s+0\s+0\s*$`,
+			},
+		},
+		{
+			name:     "coils",
+			params:   url.Values{"target": {address}, "sub_target": {"1"}, "type": {"coil"}, "address": {"9"}, "quantity": {"2"}},
+			code:     http.StatusOK,
+			patterns: []string{`(?m)^9\s+0$`, `(?m)^10\s+1$`},
+		},
+		{
+			name:   "invalid type",
+			params: url.Values{"target": {address}, "sub_target": {"1"}, "type": {"bits"}, "address": {"100"}},
+			code:   http.StatusBadRequest,
+		},
+		{
+			name:   "too many registers",
+			params: url.Values{"target": {address}, "sub_target": {"1"}, "address": {"100"}, "quantity": {"126"}},
+			code:   http.StatusBadRequest,
+		},
+		{
+			name:   "too many coils",
+			params: url.Values{"target": {address}, "sub_target": {"1"}, "type": {"coil"}, "address": {"0"}, "quantity": {"2001"}},
+			code:   http.StatusBadRequest,
+		},
+		{
+			name:   "no registers",
+			params: url.Values{"target": {address}, "sub_target": {"1"}, "address": {"100"}, "quantity": {"0"}},
+			code:   http.StatusBadRequest,
+		},
+	} {
+		rr := httptest.NewRecorder()
+		registersHandler(e, rr, httptest.NewRequest("GET", "/debug/registers?"+test.params.Encode(), nil), log.NewNopLogger())
+
+		if rr.Code != test.code {
+			t.Fatalf("%v: expected status %v but got %v: %v", test.name, test.code, rr.Code, rr.Body.String())
+		}
+		for _, p := range test.patterns {
+			if !regexp.MustCompile(p).MatchString(rr.Body.String()) {
+				t.Fatalf("%v: expected response to match %q but got\n%v", test.name, p, rr.Body.String())
+			}
+		}
+	}
+}
+
+func TestRegistersHandlerShortResponse(t *testing.T) {
+	s, address := startServer(t)
+	// Answers with a single byte of coils regardless of the quantity.
+	s.RegisterFunctionHandler(config.FuncCodeReadCoils, func(*mbserver.Server, mbserver.Framer) ([]byte, *mbserver.Exception) {
+		return []byte{1, 0xff}, &mbserver.Success
+	})
+
+	params := url.Values{"target": {address}, "sub_target": {"1"}, "type": {"coil"}, "address": {"0"}, "quantity": {"16"}}
+	rr := httptest.NewRecorder()
+	registersHandler(modbus.NewExporter(config.Config{}), rr, httptest.NewRequest("GET", "/debug/registers?"+params.Encode(), nil), log.NewNopLogger())
+
+	if rr.Code != http.StatusBadGateway {
+		t.Fatalf("expected status %v but got %v: %v", http.StatusBadGateway, rr.Code, rr.Body.String())
+	}
+}
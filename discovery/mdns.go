@@ -0,0 +1,154 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package discovery finds Modbus TCP devices on the network.
+package discovery
+
+import (
+	"context"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/hashicorp/mdns"
+
+	"github.com/RichiH/modbus_exporter/config"
+)
+
+// browseTimeout bounds waiting for the responses of one browse.
+const browseTimeout = 5 * time.Second
+
+// Device is a device discovered on the network.
+type Device struct {
+	// Address including the port.
+	Target string
+	// Name of the announced service instance.
+	Name string
+	// Model advertised by the device.
+	Model string
+	// Module assigned to the model.
+	Module    string
+	SubTarget int
+}
+
+// MDNS periodically browses for devices announcing themselves via mDNS.
+type MDNS struct {
+	config config.MDNS
+	logger log.Logger
+
+	mtx     sync.RWMutex
+	devices []Device
+}
+
+// NewMDNS returns a discovery of the devices configured by c.
+func NewMDNS(c *config.MDNS, logger log.Logger) *MDNS {
+	return &MDNS{config: *c, logger: logger, devices: []Device{}}
+}
+
+// Run browses at the configured interval until the given context is
+// canceled.
+func (d *MDNS) Run(ctx context.Context) {
+	ticker := time.NewTicker(time.Duration(d.config.Interval))
+	defer ticker.Stop()
+
+	for {
+		d.browse()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Devices returns the devices found by the latest browse, sorted by target.
+func (d *MDNS) Devices() []Device {
+	d.mtx.RLock()
+	defer d.mtx.RUnlock()
+
+	return append([]Device{}, d.devices...)
+}
+
+func (d *MDNS) browse() {
+	entries := make(chan *mdns.ServiceEntry, 16)
+	devices := []Device{}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for e := range entries {
+			if device, ok := d.device(e); ok {
+				devices = append(devices, device)
+			}
+		}
+	}()
+
+	params := mdns.DefaultParams(d.config.Service)
+	params.Domain = d.config.Domain
+	params.Timeout = browseTimeout
+	params.Entries = entries
+	err := mdns.Query(params)
+	close(entries)
+	<-done
+
+	if err != nil {
+		level.Error(d.logger).Log("msg", "Failed to browse for devices via mDNS", "service", d.config.Service, "err", err)
+		return
+	}
+
+	sort.Slice(devices, func(i, j int) bool {
+		return devices[i].Target < devices[j].Target
+	})
+
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	d.devices = devices
+}
+
+// device returns the device announced by the given entry, or false if it
+// doesn't advertise a model with a module assigned.
+func (d *MDNS) device(e *mdns.ServiceEntry) (Device, bool) {
+	model := ""
+	for _, f := range e.InfoFields {
+		if k, v, ok := strings.Cut(f, "="); ok && k == d.config.ModelKey {
+			model = v
+		}
+	}
+
+	module, ok := d.config.Modules[model]
+	if !ok {
+		level.Debug(d.logger).Log("msg", "Ignoring device of unknown model", "name", e.Name, "model", model)
+		return Device{}, false
+	}
+
+	ip := e.AddrV4
+	if ip == nil {
+		ip = e.AddrV6
+	}
+	if ip == nil {
+		return Device{}, false
+	}
+
+	return Device{
+		Target:    net.JoinHostPort(ip.String(), strconv.Itoa(e.Port)),
+		Name:      e.Name,
+		Model:     model,
+		Module:    module,
+		SubTarget: d.config.SubTarget,
+	}, true
+}
@@ -0,0 +1,59 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package discovery
+
+import (
+	"net"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/hashicorp/mdns"
+
+	"github.com/RichiH/modbus_exporter/config"
+)
+
+func TestMDNSDevice(t *testing.T) {
+	d := NewMDNS(&config.MDNS{
+		ModelKey:  "model",
+		Modules:   map[string]string{"SDM630-TCP": "sdm630"},
+		SubTarget: 1,
+	}, log.NewNopLogger())
+
+	device, ok := d.device(&mdns.ServiceEntry{
+		Name:       "meter-1._modbus._tcp.local.",
+		AddrV4:     net.ParseIP("10.0.0.5"),
+		Port:       502,
+		InfoFields: []string{"vendor=eastron", "model=SDM630-TCP"},
+	})
+	if !ok {
+		t.Fatal("expected device of known model to be discovered")
+	}
+	expected := Device{Target: "10.0.0.5:502", Name: "meter-1._modbus._tcp.local.", Model: "SDM630-TCP", Module: "sdm630", SubTarget: 1}
+	if device != expected {
+		t.Fatalf("expected %+v but got %+v", expected, device)
+	}
+
+	device, ok = d.device(&mdns.ServiceEntry{
+		AddrV6:     net.ParseIP("fd00::5"),
+		Port:       502,
+		InfoFields: []string{"model=SDM630-TCP"},
+	})
+	if !ok || device.Target != "[fd00::5]:502" {
+		t.Fatalf("expected device with IPv6 address [fd00::5]:502 but got %+v", device)
+	}
+
+	if _, ok := d.device(&mdns.ServiceEntry{AddrV4: net.ParseIP("10.0.0.6"), Port: 502, InfoFields: []string{"model=other"}}); ok {
+		t.Fatal("expected device of unknown model to be ignored")
+	}
+}
@@ -0,0 +1,125 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/prometheus/common/expfmt"
+
+	"github.com/RichiH/modbus_exporter/config"
+	"github.com/RichiH/modbus_exporter/modbus"
+)
+
+// dryRunScrape scrapes the target with the given comma separated modules of
+// the configuration file like the exporter would, e.g. to verify a new module
+// end-to-end, and prints the metrics in the text exposition format to w and
+// the timing of each request, i.e. block, to timings. It returns the exit
+// code. The requests answered are written to the record file if set, which
+// replay: targets answer scrapes from.
+func dryRunScrape(w, timings io.Writer, configFile string, opts config.LoadOptions, target, subTargets, modules, record string, timeout time.Duration) int {
+	c, err := config.LoadConfigWithOptions(configFile, opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v: %v\n", configFile, err)
+		return 1
+	}
+
+	moduleNames := strings.Split(modules, ",")
+	definitions := []*config.Module{}
+	for _, name := range moduleNames {
+		module := c.GetModule(name)
+		if module == nil {
+			fmt.Fprintf(os.Stderr, "module '%v' not defined in configuration file\n", name)
+			return 1
+		}
+		definitions = append(definitions, module)
+	}
+	parsed, err := modbus.ParseSubTargets(definitions, subTargets)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	var mtx sync.Mutex
+	requests := []modbus.Request{}
+	e := modbus.NewExporter(c, modbus.WithRequestListener(func(r modbus.Request) {
+		mtx.Lock()
+		defer mtx.Unlock()
+		requests = append(requests, r)
+	}), modbus.WithReplays())
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	start := time.Now()
+	gatherer, scrapeErr := e.ScrapeAll(ctx, modbus.ScrapeRequest{Target: target, Modules: moduleNames, SubTargets: parsed})
+	duration := time.Since(start)
+
+	tw := tabwriter.NewWriter(timings, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "sub target\tmodule\tfunction code\taddress\tquantity\tduration\terror")
+	for _, r := range requests {
+		errMsg := ""
+		if r.Err != nil {
+			errMsg = r.Err.Error()
+		}
+		fmt.Fprintf(tw, "%v\t%v\t%v\t%v\t%v\t%v\t%v\n", r.SubTarget, r.Module, r.FunctionCode, r.Address, r.Quantity, r.Duration.Round(time.Microsecond), errMsg)
+	}
+	tw.Flush()
+	fmt.Fprintf(timings, "%v requests in %v\n", len(requests), duration.Round(time.Microsecond))
+
+	if record != "" {
+		if err := writeRecording(record, requests); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to write recording: %v\n", err)
+			return 1
+		}
+	}
+
+	if scrapeErr != nil {
+		fmt.Fprintln(os.Stderr, scrapeErr)
+		return 1
+	}
+	metricFamilies, err := gatherer.Gather()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	for _, mf := range metricFamilies {
+		if _, err := expfmt.MetricFamilyToText(w, mf); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+
+	return 0
+}
+
+// writeRecording writes the given requests to the given file.
+func writeRecording(file string, requests []modbus.Request) error {
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	if err := modbus.WriteRecording(f, requests); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
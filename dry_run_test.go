@@ -0,0 +1,82 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/RichiH/modbus_exporter/config"
+)
+
+func TestDryRunScrape(t *testing.T) {
+	s, address := startServer(t)
+	s.HoldingRegisters[10] = 42
+	s.HoldingRegisters[20] = 7
+
+	file := filepath.Join(t.TempDir(), "modbus.yml")
+	if err := os.WriteFile(file, []byte(`
+modules:
+  - name: "meter"
+    protocol: "tcp/ip"
+    timeout: 1000
+    metrics:
+      - name: "voltage"
+        help: "Voltage"
+        address: 300010
+        dataType: uint16
+        metricType: gauge
+      - name: "frequency"
+        help: "Frequency"
+        address: 300020
+        dataType: uint16
+        metricType: gauge
+`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var metrics, timings strings.Builder
+	if code := dryRunScrape(&metrics, &timings, file, config.LoadOptions{}, address, "1", "meter", "", time.Second); code != 0 {
+		t.Fatalf("expected exit code 0 but got %v: %v", code, timings.String())
+	}
+	for _, p := range []string{`(?m)^voltage\{.*\} 42$`, `(?m)^frequency\{.*\} 7$`} {
+		if !regexp.MustCompile(p).MatchString(metrics.String()) {
+			t.Errorf("expected metrics to match %q but got\n%v", p, metrics.String())
+		}
+	}
+	if !regexp.MustCompile(`(?m)^1\s+meter\s+3\s+10\s+1\s+\S+\s*$`).MatchString(timings.String()) {
+		t.Errorf("expected timing of the block read but got\n%v", timings.String())
+	}
+
+	if code := dryRunScrape(&metrics, &timings, file, config.LoadOptions{}, address, "1", "other", "", time.Second); code != 1 {
+		t.Fatalf("expected exit code 1 for undefined module but got %v", code)
+	}
+
+	record := filepath.Join(t.TempDir(), "session.jsonl")
+	if code := dryRunScrape(&metrics, &timings, file, config.LoadOptions{}, address, "1", "meter", record, time.Second); code != 0 {
+		t.Fatalf("expected exit code 0 but got %v: %v", code, timings.String())
+	}
+	s.Close()
+	var replayed strings.Builder
+	if code := dryRunScrape(&replayed, &timings, file, config.LoadOptions{}, "replay:"+record, "1", "meter", "", time.Second); code != 0 {
+		t.Fatalf("expected exit code 0 replaying but got %v: %v", code, timings.String())
+	}
+	if !strings.Contains(replayed.String(), "voltage{module=\"meter\"} 42") {
+		t.Errorf("expected replayed metrics but got\n%v", replayed.String())
+	}
+}
@@ -0,0 +1,96 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/RichiH/modbus_exporter/config"
+)
+
+// targetGroup is a target group of the file based service discovery of
+// Prometheus.
+type targetGroup struct {
+	Targets []string          `json:"targets" yaml:"targets"`
+	Labels  map[string]string `json:"labels" yaml:"labels"`
+}
+
+// generateFileSD prints the targets of the configuration file as file based
+// service discovery file and returns the exit code.
+func generateFileSD(configFile string, opts config.LoadOptions, exporterAddress, format string) int {
+	c, err := config.LoadConfigWithOptions(configFile, opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v: %v\n", configFile, err)
+		return 1
+	}
+
+	if err := writeFileSD(os.Stdout, fileSDGroups(c.Targets, exporterAddress), format); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	return 0
+}
+
+// fileSDGroups returns one target group per configured target. The labels
+// contain what the relabeling of prometheus.yml does otherwise: scraping the
+// exporter with the device as parameter.
+func fileSDGroups(targets []config.PollTarget, exporterAddress string) []targetGroup {
+	groups := make([]targetGroup, 0, len(targets))
+	for _, t := range targets {
+		labels := map[string]string{
+			"__metrics_path__":   "/modbus",
+			"__param_target":     t.Target,
+			"__param_module":     t.Module,
+			"__param_sub_target": strconv.Itoa(t.SubTarget),
+			"instance":           t.Target,
+			"sub_target":         strconv.Itoa(t.SubTarget),
+		}
+		// The exporter polls the target at this interval, scraping it more
+		// often returns the same result.
+		if t.Interval > 0 {
+			labels["__scrape_interval__"] = t.Interval.String()
+		}
+
+		groups = append(groups, targetGroup{Targets: []string{exporterAddress}, Labels: labels})
+	}
+
+	return groups
+}
+
+// writeFileSD writes the given target groups in the given format, json or
+// yaml.
+func writeFileSD(w io.Writer, groups []targetGroup, format string) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(groups)
+	case "yaml":
+		b, err := yaml.Marshal(groups)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(b)
+		return err
+	default:
+		return fmt.Errorf("unknown format '%v'", format)
+	}
+}
@@ -0,0 +1,78 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+
+	"github.com/RichiH/modbus_exporter/config"
+)
+
+func TestWriteFileSD(t *testing.T) {
+	groups := fileSDGroups([]config.PollTarget{
+		{Target: "10.0.0.5:502", SubTarget: 3, Module: "sdm630", Interval: model.Duration(30 * time.Second)},
+	}, "exporter:9602")
+
+	for _, test := range []struct {
+		format   string
+		expected string
+	}{
+		{
+			"json",
+			`[
+  {
+    "targets": [
+      "exporter:9602"
+    ],
+    "labels": {
+      "__metrics_path__": "/modbus",
+      "__param_module": "sdm630",
+      "__param_sub_target": "3",
+      "__param_target": "10.0.0.5:502",
+      "__scrape_interval__": "30s",
+      "instance": "10.0.0.5:502",
+      "sub_target": "3"
+    }
+  }
+]
+`,
+		},
+		{
+			"yaml",
+			`- targets:
+  - exporter:9602
+  labels:
+    __metrics_path__: /modbus
+    __param_module: sdm630
+    __param_sub_target: "3"
+    __param_target: 10.0.0.5:502
+    __scrape_interval__: 30s
+    instance: 10.0.0.5:502
+    sub_target: "3"
+`,
+		},
+	} {
+		var b strings.Builder
+		if err := writeFileSD(&b, groups, test.format); err != nil {
+			t.Fatal(err)
+		}
+		if b.String() != test.expected {
+			t.Errorf("%v: expected\n%s\nbut got\n%s", test.format, test.expected, b.String())
+		}
+	}
+}
@@ -0,0 +1,44 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/RichiH/modbus_exporter/config"
+	"github.com/RichiH/modbus_exporter/discovery"
+	"github.com/RichiH/modbus_exporter/modbus"
+)
+
+// sdHandler responds with the configured targets and the devices returned by
+// the given function, which may be nil, for the HTTP based service discovery
+// of Prometheus. Targets point to the exporter as reached by the request.
+func sdHandler(e *modbus.Exporter, devices func() []discovery.Device, w http.ResponseWriter, r *http.Request) {
+	groups := fileSDGroups(e.GetConfig().Targets, r.Host)
+
+	if devices != nil {
+		for _, d := range devices() {
+			g := fileSDGroups([]config.PollTarget{{Target: d.Target, SubTarget: d.SubTarget, Module: d.Module}}, r.Host)[0]
+			g.Labels["model"] = d.Model
+			g.Labels["__meta_mdns_name"] = d.Name
+			groups = append(groups, g)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(groups); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/RichiH/modbus_exporter/config"
+	"github.com/RichiH/modbus_exporter/discovery"
+	"github.com/RichiH/modbus_exporter/modbus"
+)
+
+func TestSDHandler(t *testing.T) {
+	e := modbus.NewExporter(config.Config{
+		Targets: []config.PollTarget{{Target: "10.0.0.5:502", SubTarget: 1, Module: "sdm630"}},
+	})
+	devices := func() []discovery.Device {
+		return []discovery.Device{
+			{Target: "10.0.0.6:502", Name: "meter-2._modbus._tcp.local.", Model: "SDM630-TCP", Module: "sdm630", SubTarget: 1},
+		}
+	}
+
+	req := httptest.NewRequest("GET", "http://exporter:9602/sd", nil)
+	rr := httptest.NewRecorder()
+	sdHandler(e, devices, rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200 but got %v", rr.Code)
+	}
+
+	var groups []targetGroup
+	if err := json.Unmarshal(rr.Body.Bytes(), &groups); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []targetGroup{
+		{
+			Targets: []string{"exporter:9602"},
+			Labels: map[string]string{
+				"__metrics_path__": "/modbus", "__param_target": "10.0.0.5:502", "__param_module": "sdm630",
+				"__param_sub_target": "1", "instance": "10.0.0.5:502", "sub_target": "1",
+			},
+		},
+		{
+			Targets: []string{"exporter:9602"},
+			Labels: map[string]string{
+				"__metrics_path__": "/modbus", "__param_target": "10.0.0.6:502", "__param_module": "sdm630",
+				"__param_sub_target": "1", "instance": "10.0.0.6:502", "sub_target": "1",
+				"model": "SDM630-TCP", "__meta_mdns_name": "meter-2._modbus._tcp.local.",
+			},
+		},
+	}
+	if !reflect.DeepEqual(groups, expected) {
+		t.Fatalf("expected %v but got %v", expected, groups)
+	}
+}
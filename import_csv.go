@@ -0,0 +1,324 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/RichiH/modbus_exporter/config"
+)
+
+// csvDataTypes maps the data type names commonly used in register maps of
+// vendors to the data types of the configuration.
+var csvDataTypes = map[string]config.ModbusDataType{
+	"bool": config.ModbusBool, "boolean": config.ModbusBool, "bit": config.ModbusBool,
+	"int16": config.ModbusInt16, "s16": config.ModbusInt16, "i16": config.ModbusInt16, "int": config.ModbusInt16, "short": config.ModbusInt16, "sint16": config.ModbusInt16,
+	"uint16": config.ModbusUInt16, "u16": config.ModbusUInt16, "word": config.ModbusUInt16, "uint": config.ModbusUInt16, "ushort": config.ModbusUInt16, "unsigned": config.ModbusUInt16,
+	"int32": config.ModbusInt32, "s32": config.ModbusInt32, "i32": config.ModbusInt32, "dint": config.ModbusInt32, "long": config.ModbusInt32, "sint32": config.ModbusInt32,
+	"uint32": config.ModbusUInt32, "u32": config.ModbusUInt32, "dword": config.ModbusUInt32, "udint": config.ModbusUInt32, "ulong": config.ModbusUInt32, "acc32": config.ModbusUInt32,
+	"int64": config.ModbusInt64, "s64": config.ModbusInt64, "i64": config.ModbusInt64, "lint": config.ModbusInt64,
+	"uint64": config.ModbusUInt64, "u64": config.ModbusUInt64, "ulint": config.ModbusUInt64, "acc64": config.ModbusUInt64,
+	"float16": config.ModbusFloat16, "f16": config.ModbusFloat16, "half": config.ModbusFloat16,
+	"float32": config.ModbusFloat32, "f32": config.ModbusFloat32, "float": config.ModbusFloat32, "real": config.ModbusFloat32,
+	"float64": config.ModbusFloat64, "f64": config.ModbusFloat64, "double": config.ModbusFloat64, "lreal": config.ModbusFloat64,
+}
+
+// csvUnits maps units commonly used in register maps to the base unit suffix
+// of the metric name and the factor converting to the base unit. Energy is
+// exposed as counter.
+var csvUnits = map[string]struct {
+	suffix  string
+	factor  float64
+	counter bool
+}{
+	"v":    {"volts", 1, false},
+	"kv":   {"volts", 1000, false},
+	"mv":   {"volts", 0.001, false},
+	"a":    {"amperes", 1, false},
+	"ma":   {"amperes", 0.001, false},
+	"w":    {"watts", 1, false},
+	"kw":   {"watts", 1000, false},
+	"va":   {"volt_amperes", 1, false},
+	"kva":  {"volt_amperes", 1000, false},
+	"var":  {"var", 1, false},
+	"kvar": {"var", 1000, false},
+	"wh":   {"watt_hours", 1, true},
+	"kwh":  {"watt_hours", 1000, true},
+	"mwh":  {"watt_hours", 1000000, true},
+	"hz":   {"hertz", 1, false},
+	"°c":   {"celsius", 1, false},
+	"c":    {"celsius", 1, false},
+	"degc": {"celsius", 1, false},
+	"%":    {"percent", 1, false},
+	"s":    {"seconds", 1, false},
+	"ms":   {"seconds", 0.001, false},
+	"min":  {"seconds", 60, false},
+	"h":    {"seconds", 3600, false},
+}
+
+// csvImportOptions control how a CSV register map is converted.
+type csvImportOptions struct {
+	module        string
+	registerType  string
+	addressOffset int
+	endianness    string
+}
+
+// importCSV converts the CSV register map in the given file into a module and
+// prints it as configuration file. Rows that can't be converted are reported
+// on stderr and as comments in the output.
+func importCSV(file string, opts csvImportOptions) int {
+	f, err := os.Open(file)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer f.Close()
+
+	if err := convertCSV(f, os.Stdout, os.Stderr, opts); err != nil {
+		fmt.Fprintf(os.Stderr, "%v: %v\n", file, err)
+		return 1
+	}
+
+	return 0
+}
+
+// csvColumns are the positions of the known columns in the header row of a
+// register map, -1 if missing.
+type csvColumns struct {
+	address, name, dataType, scale, unit, help int
+}
+
+func parseCSVHeader(header []string) (csvColumns, error) {
+	c := csvColumns{-1, -1, -1, -1, -1, -1}
+	for i, h := range header {
+		switch strings.ToLower(strings.TrimSpace(h)) {
+		case "address", "register", "addr":
+			c.address = i
+		case "name":
+			c.name = i
+		case "type", "data type", "datatype":
+			c.dataType = i
+		case "scale", "factor", "gain", "multiplier":
+			c.scale = i
+		case "unit", "units":
+			c.unit = i
+		case "description", "help":
+			c.help = i
+		}
+	}
+
+	if c.address < 0 || c.name < 0 || c.dataType < 0 {
+		return c, fmt.Errorf("header must contain address, name and type columns but got %v", header)
+	}
+
+	return c, nil
+}
+
+// convertCSV writes the module converted from the CSV register map read from r
+// to w and the rows that can't be converted to warn.
+func convertCSV(r io.Reader, w, warn io.Writer, opts csvImportOptions) error {
+	functionCode, ok := registerTypes[opts.registerType]
+	if !ok {
+		return fmt.Errorf("unknown register type '%v'", opts.registerType)
+	}
+
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return err
+	}
+	if len(rows) == 0 {
+		return fmt.Errorf("no header row")
+	}
+
+	columns, err := parseCSVHeader(rows[0])
+	if err != nil {
+		return err
+	}
+
+	var b strings.Builder
+	b.WriteString("modules:\n")
+	writeImportedModule(&b, opts.module)
+
+	names := map[string]int{}
+	for i, row := range rows[1:] {
+		line := i + 2
+		def, err := csvMetric(row, columns, functionCode, opts)
+		if err != nil {
+			fmt.Fprintf(warn, "line %d: %v\n", line, err)
+			fmt.Fprintf(&b, "      # line %d skipped: %v\n", line, strings.ReplaceAll(err.Error(), "\n", " "))
+			continue
+		}
+
+		if uniqueMetricName(names, &def) {
+			fmt.Fprintf(warn, "line %d: duplicate name, renamed to %v\n", line, def.Name)
+		}
+		writeImportedMetric(&b, def)
+	}
+
+	_, err = io.WriteString(w, b.String())
+	return err
+}
+
+func csvColumn(row []string, i int) string {
+	if i < 0 || i >= len(row) {
+		return ""
+	}
+
+	return strings.TrimSpace(row[i])
+}
+
+// csvMetric converts a row of a register map into a metric definition.
+func csvMetric(row []string, columns csvColumns, functionCode uint8, opts csvImportOptions) (config.MetricDef, error) {
+	name := csvColumn(row, columns.name)
+	address, err := strconv.ParseInt(csvColumn(row, columns.address), 0, 32)
+	if err != nil {
+		return config.MetricDef{}, fmt.Errorf("invalid address of %v: %v", name, err)
+	}
+	address += int64(opts.addressOffset)
+	if address < 0 || address > 65535 {
+		return config.MetricDef{}, fmt.Errorf("address %v of %v out of range", address, name)
+	}
+
+	typeName := csvColumn(row, columns.dataType)
+	dataType, ok := csvDataTypes[strings.ToLower(typeName)]
+	if !ok {
+		return config.MetricDef{}, fmt.Errorf("unsupported type '%v' of %v", typeName, name)
+	}
+	if config.IsBitAccess(functionCode) != (dataType == config.ModbusBool) {
+		return config.MetricDef{}, fmt.Errorf("type '%v' of %v can't be read from %v registers", typeName, name, opts.registerType)
+	}
+
+	factor := 1.0
+	if s := csvColumn(row, columns.scale); s != "" {
+		factor, err = strconv.ParseFloat(s, 64)
+		if err != nil || factor == 0 {
+			return config.MetricDef{}, fmt.Errorf("invalid scale '%v' of %v", s, name)
+		}
+	}
+
+	def, err := importedMetric(name, csvColumn(row, columns.help), csvColumn(row, columns.unit), factor)
+	if err != nil {
+		return config.MetricDef{}, err
+	}
+	def.Address = config.RegisterAddr(uint32(functionCode)*100000 + uint32(address))
+	def.DataType = dataType
+	if dataType != config.ModbusBool {
+		def.Endianness = config.EndiannessType(opts.endianness)
+	} else {
+		def.Factor = nil
+	}
+
+	return def, nil
+}
+
+// importedMetric returns the metric definition of a value of a register map
+// or configuration of another tool with the given name, description, unit and
+// scale. Units known are converted to the base unit, which is appended to
+// the name. Address and data type are left to the caller.
+func importedMetric(name, help, unit string, factor float64) (config.MetricDef, error) {
+	metricName := sanitizeMetricName(name)
+	if metricName == "" {
+		return config.MetricDef{}, fmt.Errorf("missing name")
+	}
+	metricType := config.MetricType(config.MetricTypeGauge)
+	if u, ok := csvUnits[strings.ToLower(unit)]; ok {
+		factor *= u.factor
+		if !strings.HasSuffix(metricName, "_"+u.suffix) {
+			metricName += "_" + u.suffix
+		}
+		if u.counter {
+			metricType = config.MetricTypeCounter
+		}
+	}
+
+	if help == "" {
+		help = name
+	}
+	if unit != "" {
+		help = fmt.Sprintf("%v (%v)", help, unit)
+	}
+
+	def := config.MetricDef{
+		Name:       metricName,
+		Help:       help,
+		MetricType: metricType,
+	}
+	if factor != 1 {
+		def.Factor = &factor
+	}
+
+	return def, nil
+}
+
+var invalidNameChars = regexp.MustCompile(`[^a-z0-9]+`)
+
+// sanitizeMetricName turns a name of the register map into a valid metric
+// name in snake case.
+func sanitizeMetricName(name string) string {
+	s := strings.Trim(invalidNameChars.ReplaceAllString(strings.ToLower(name), "_"), "_")
+	if s != "" && s[0] >= '0' && s[0] <= '9' {
+		s = "_" + s
+	}
+
+	return s
+}
+
+// uniqueMetricName makes the name of the given imported definition unique
+// among the ones seen so far and appends the suffix of counters. It returns
+// whether the name was taken already.
+func uniqueMetricName(names map[string]int, def *config.MetricDef) bool {
+	names[def.Name]++
+	n := names[def.Name]
+	if n > 1 {
+		def.Name = fmt.Sprintf("%v_%d", def.Name, n)
+	}
+	if def.MetricType == config.MetricTypeCounter {
+		def.Name += "_total"
+	}
+
+	return n > 1
+}
+
+// writeImportedModule writes the start of an imported module, to be followed
+// by its metrics.
+func writeImportedModule(b *strings.Builder, name string) {
+	fmt.Fprintf(b, "  - name: %q\n    protocol: %q\n    metrics:\n", name, config.ModbusProtocolTCPIP)
+}
+
+func writeImportedMetric(b *strings.Builder, def config.MetricDef) {
+	fmt.Fprintf(b, "      - name: %q\n", def.Name)
+	fmt.Fprintf(b, "        help: %q\n", def.Help)
+	fmt.Fprintf(b, "        address: %v\n", def.Address)
+	fmt.Fprintf(b, "        dataType: %v\n", def.DataType)
+	if def.BitOffset != nil {
+		fmt.Fprintf(b, "        bitOffset: %v\n", *def.BitOffset)
+	}
+	if def.Endianness != "" {
+		fmt.Fprintf(b, "        endianness: %v\n", def.Endianness)
+	}
+	fmt.Fprintf(b, "        metricType: %v\n", def.MetricType)
+	if def.Factor != nil {
+		fmt.Fprintf(b, "        factor: %v\n", strconv.FormatFloat(*def.Factor, 'g', -1, 64))
+	}
+}
@@ -0,0 +1,116 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/RichiH/modbus_exporter/config"
+)
+
+func TestConvertCSV(t *testing.T) {
+	in := `Address,Name,Type,Scale,Unit,Description
+0x0000,Phase 1 Voltage,FLOAT,,V,Phase 1 line to neutral volts
+6,Phase 1 Current,float32,,A,
+72,Import Active Energy,float,,kWh,
+100,Serial Number,STRING,,,
+102,Power,int16,0.1,kW,
+104,Power,int16,,W,
+`
+
+	var out, warn strings.Builder
+	err := convertCSV(strings.NewReader(in), &out, &warn, csvImportOptions{
+		module: "meter", registerType: "input", endianness: "big",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(warn.String(), "line 5: unsupported type 'STRING' of Serial Number") {
+		t.Errorf("expected warning about unsupported type but got:\n%v", warn.String())
+	}
+	if !strings.Contains(out.String(), "# line 5 skipped") {
+		t.Errorf("expected skipped row to be noted in the output but got:\n%v", out.String())
+	}
+
+	file := filepath.Join(t.TempDir(), "meter.yml")
+	if err := os.WriteFile(file, []byte(out.String()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	c, err := config.LoadConfigWithOptions(file, config.LoadOptions{Strict: true})
+	if err != nil {
+		t.Fatalf("expected valid configuration but got %v:\n%v", err, out.String())
+	}
+
+	expected := []struct {
+		name       string
+		address    config.RegisterAddr
+		metricType config.MetricType
+		factor     float64
+	}{
+		{"phase_1_voltage_volts", 400000, config.MetricTypeGauge, 1},
+		{"phase_1_current_amperes", 400006, config.MetricTypeGauge, 1},
+		{"import_active_energy_watt_hours_total", 400072, config.MetricTypeCounter, 1000},
+		{"power_watts", 400102, config.MetricTypeGauge, 100},
+		{"power_watts_2", 400104, config.MetricTypeGauge, 1},
+	}
+	metrics := c.Modules[0].Metrics
+	if len(metrics) != len(expected) {
+		t.Fatalf("expected %d metrics but got %d:\n%v", len(expected), len(metrics), out.String())
+	}
+	for i, e := range expected {
+		m := metrics[i]
+		factor := 1.0
+		if m.Factor != nil {
+			factor = *m.Factor
+		}
+		if m.Name != e.name || m.Address != e.address || m.MetricType != e.metricType || factor != e.factor {
+			t.Errorf("expected %+v but got %v %v %v %v", e, m.Name, m.Address, m.MetricType, factor)
+		}
+	}
+	if metrics[0].Help != "Phase 1 line to neutral volts (V)" {
+		t.Errorf("expected help from description but got %q", metrics[0].Help)
+	}
+}
+
+func TestConvertCSVAddressOffset(t *testing.T) {
+	in := "register,name,data type\n40001,Setpoint,uint16\n"
+
+	var out strings.Builder
+	err := convertCSV(strings.NewReader(in), &out, &strings.Builder{}, csvImportOptions{
+		module: "m", registerType: "holding", addressOffset: -40001, endianness: "big",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "address: 300000\n") {
+		t.Fatalf("expected address 300000 but got:\n%v", out.String())
+	}
+}
+
+func TestSanitizeMetricName(t *testing.T) {
+	for in, expected := range map[string]string{
+		"Phase 1 Voltage":    "phase_1_voltage",
+		"Total kWh (Import)": "total_kwh_import",
+		"1st Input":          "_1st_input",
+		"--":                 "",
+	} {
+		if got := sanitizeMetricName(in); got != expected {
+			t.Errorf("%q: expected %q but got %q", in, expected, got)
+		}
+	}
+}
@@ -0,0 +1,273 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/RichiH/modbus_exporter/config"
+)
+
+// haDataTypes maps the data types of the Home Assistant modbus integration to
+// the data types of the configuration.
+var haDataTypes = map[string]config.ModbusDataType{
+	"int16": config.ModbusInt16, "uint16": config.ModbusUInt16,
+	"int32": config.ModbusInt32, "uint32": config.ModbusUInt32,
+	"int64": config.ModbusInt64, "uint64": config.ModbusUInt64,
+	"float32": config.ModbusFloat32, "float64": config.ModbusFloat64,
+}
+
+// haSwaps maps the swap options of the Home Assistant modbus integration to
+// the endianness of values of one register and of several ones.
+var haSwaps = map[string][2]config.EndiannessType{
+	"":          {config.EndiannessBigEndian, config.EndiannessBigEndian},
+	"none":      {config.EndiannessBigEndian, config.EndiannessBigEndian},
+	"byte":      {config.EndiannessLittleEndian, config.EndiannessMixedEndian},
+	"word":      {config.EndiannessBigEndian, config.EndiannessYolo},
+	"word_byte": {config.EndiannessLittleEndian, config.EndiannessLittleEndian},
+}
+
+// haHub is a hub of the Home Assistant modbus integration. Entities other than
+// sensors and binary sensors can't be converted.
+type haHub struct {
+	Name          string     `yaml:"name"`
+	Sensors       []haEntity `yaml:"sensors"`
+	BinarySensors []haEntity `yaml:"binary_sensors"`
+	Climates      []haEntity `yaml:"climates"`
+	Covers        []haEntity `yaml:"covers"`
+	Fans          []haEntity `yaml:"fans"`
+	Lights        []haEntity `yaml:"lights"`
+	Switches      []haEntity `yaml:"switches"`
+}
+
+type haEntity struct {
+	Name          string   `yaml:"name"`
+	Address       int      `yaml:"address"`
+	InputType     string   `yaml:"input_type"`
+	DataType      string   `yaml:"data_type"`
+	Scale         *float64 `yaml:"scale"`
+	Offset        float64  `yaml:"offset"`
+	Swap          string   `yaml:"swap"`
+	Unit          string   `yaml:"unit_of_measurement"`
+	Slave         *int     `yaml:"slave"`
+	DeviceAddress *int     `yaml:"device_address"`
+}
+
+// slave returns the unit ID of the entity.
+func (e haEntity) slave() int {
+	if e.DeviceAddress != nil {
+		return *e.DeviceAddress
+	}
+	if e.Slave != nil {
+		return *e.Slave
+	}
+
+	return 0
+}
+
+// importHomeAssistant converts the hubs of the Home Assistant modbus
+// configuration in the given file into modules and prints them as
+// configuration file.
+func importHomeAssistant(file string) int {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if err := convertHomeAssistant(data, os.Stdout, os.Stderr); err != nil {
+		fmt.Fprintf(os.Stderr, "%v: %v\n", file, err)
+		return 1
+	}
+
+	return 0
+}
+
+// parseHomeAssistant parses either a whole Home Assistant configuration or the
+// list of hubs of the modbus integration, as included by it.
+func parseHomeAssistant(data []byte) ([]haHub, error) {
+	hubs := []haHub{}
+	if err := yaml.Unmarshal(data, &hubs); err == nil && len(hubs) > 0 {
+		return hubs, nil
+	}
+
+	var c struct {
+		Modbus []haHub `yaml:"modbus"`
+	}
+	if err := yaml.Unmarshal(data, &c); err != nil {
+		return nil, err
+	}
+	if len(c.Modbus) == 0 {
+		return nil, fmt.Errorf("no modbus hubs found")
+	}
+
+	return c.Modbus, nil
+}
+
+// convertHomeAssistant writes the modules converted from the given Home
+// Assistant configuration to w and the entities that can't be converted to
+// warn. Each hub becomes a module, or a module per slave if its entities are
+// read from several ones.
+func convertHomeAssistant(data []byte, w, warn io.Writer) error {
+	hubs, err := parseHomeAssistant(data)
+	if err != nil {
+		return err
+	}
+
+	var b strings.Builder
+	b.WriteString("modules:\n")
+	for i, hub := range hubs {
+		hubName := sanitizeMetricName(hub.Name)
+		if hubName == "" {
+			hubName = fmt.Sprintf("home_assistant_%d", i+1)
+		}
+		if n := len(hub.Climates) + len(hub.Covers) + len(hub.Fans) + len(hub.Lights) + len(hub.Switches); n > 0 {
+			fmt.Fprintf(warn, "hub %v: %d climates, covers, fans, lights and switches skipped, only sensors and binary sensors are supported\n", hubName, n)
+		}
+
+		slaves := []int{}
+		bySlave := map[int][]config.MetricDef{}
+		skipped := map[int][]string{}
+		add := func(e haEntity, def config.MetricDef, err error) {
+			slave := e.slave()
+			if _, ok := bySlave[slave]; !ok {
+				slaves = append(slaves, slave)
+				bySlave[slave] = []config.MetricDef{}
+			}
+			if err != nil {
+				fmt.Fprintf(warn, "hub %v: %v\n", hubName, err)
+				skipped[slave] = append(skipped[slave], err.Error())
+				return
+			}
+			bySlave[slave] = append(bySlave[slave], def)
+		}
+		for _, e := range hub.Sensors {
+			def, err := haSensor(e)
+			add(e, def, err)
+		}
+		for _, e := range hub.BinarySensors {
+			def, err := haBinarySensor(e)
+			add(e, def, err)
+		}
+
+		for _, slave := range slaves {
+			moduleName := hubName
+			if len(slaves) > 1 {
+				moduleName = fmt.Sprintf("%v_%d", hubName, slave)
+			}
+			fmt.Fprintf(&b, "  # Scraped with sub_target=%d like slave of Home Assistant.\n", slave)
+			writeImportedModule(&b, moduleName)
+
+			names := map[string]int{}
+			for _, def := range bySlave[slave] {
+				def := def
+				if uniqueMetricName(names, &def) {
+					fmt.Fprintf(warn, "module %v: duplicate name, renamed to %v\n", moduleName, def.Name)
+				}
+				writeImportedMetric(&b, def)
+			}
+			for _, s := range skipped[slave] {
+				fmt.Fprintf(&b, "      # skipped: %v\n", s)
+			}
+		}
+	}
+
+	_, err = io.WriteString(w, b.String())
+	return err
+}
+
+// haSensor converts a sensor of the Home Assistant modbus integration into a
+// metric definition.
+func haSensor(e haEntity) (config.MetricDef, error) {
+	if e.Address < 0 || e.Address > 65535 {
+		return config.MetricDef{}, fmt.Errorf("address %v of %v out of range", e.Address, e.Name)
+	}
+	if e.Offset != 0 {
+		return config.MetricDef{}, fmt.Errorf("offset of %v isn't supported", e.Name)
+	}
+
+	var functionCode uint8
+	switch e.InputType {
+	case "", "holding":
+		functionCode = config.FuncCodeReadHoldingRegisters
+	case "input":
+		functionCode = config.FuncCodeReadInputRegisters
+	default:
+		return config.MetricDef{}, fmt.Errorf("unsupported input type %v of %v", e.InputType, e.Name)
+	}
+
+	typeName := e.DataType
+	if typeName == "" {
+		typeName = "int16"
+	}
+	dataType, ok := haDataTypes[typeName]
+	if !ok {
+		return config.MetricDef{}, fmt.Errorf("unsupported data type %v of %v", typeName, e.Name)
+	}
+	swap, ok := haSwaps[e.Swap]
+	if !ok {
+		return config.MetricDef{}, fmt.Errorf("unsupported swap %v of %v", e.Swap, e.Name)
+	}
+
+	factor := 1.0
+	if e.Scale != nil && *e.Scale != 0 {
+		factor = *e.Scale
+	}
+	def, err := importedMetric(e.Name, "", e.Unit, factor)
+	if err != nil {
+		return config.MetricDef{}, err
+	}
+	def.Address = config.RegisterAddr(uint32(functionCode)*100000 + uint32(e.Address))
+	def.DataType = dataType
+	def.Endianness = swap[1]
+	if dataType.RegisterCount() == 1 {
+		def.Endianness = swap[0]
+	}
+
+	return def, nil
+}
+
+// haBinarySensor converts a binary sensor of the Home Assistant modbus
+// integration reading a coil or discrete input into a metric definition.
+func haBinarySensor(e haEntity) (config.MetricDef, error) {
+	if e.Address < 0 || e.Address > 65535 {
+		return config.MetricDef{}, fmt.Errorf("address %v of %v out of range", e.Address, e.Name)
+	}
+
+	var functionCode uint8
+	switch e.InputType {
+	case "", "coil":
+		functionCode = config.FuncCodeReadCoils
+	case "discrete_input":
+		functionCode = config.FuncCodeReadDiscreteInputs
+	default:
+		return config.MetricDef{}, fmt.Errorf("unsupported input type %v of binary sensor %v", e.InputType, e.Name)
+	}
+
+	def, err := importedMetric(e.Name, "", "", 1)
+	if err != nil {
+		return config.MetricDef{}, err
+	}
+	bitOffset := 0
+	def.Address = config.RegisterAddr(uint32(functionCode)*100000 + uint32(e.Address))
+	def.DataType = config.ModbusBool
+	def.BitOffset = &bitOffset
+
+	return def, nil
+}
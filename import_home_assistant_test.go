@@ -0,0 +1,109 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/RichiH/modbus_exporter/config"
+)
+
+func TestConvertHomeAssistant(t *testing.T) {
+	in := `
+homeassistant:
+  name: Home
+modbus:
+  - name: Heat Pump
+    type: tcp
+    host: 192.168.1.20
+    port: 502
+    sensors:
+      - name: Flow Temperature
+        address: 10
+        input_type: input
+        data_type: int16
+        scale: 0.1
+        unit_of_measurement: °C
+      - name: Energy
+        address: 20
+        data_type: uint32
+        swap: word
+        unit_of_measurement: kWh
+      - name: Outdoor Temperature
+        address: 30
+        scale: 0.1
+        offset: -40
+      - name: Power
+        slave: 2
+        address: 5
+        data_type: float32
+    binary_sensors:
+      - name: Compressor
+        address: 1
+        input_type: discrete_input
+    switches:
+      - name: Boost
+        address: 2
+`
+
+	var out, warn strings.Builder
+	if err := convertHomeAssistant([]byte(in), &out, &warn); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, w := range []string{
+		"hub heat_pump: 1 climates, covers, fans, lights and switches skipped",
+		"hub heat_pump: offset of Outdoor Temperature isn't supported",
+	} {
+		if !strings.Contains(warn.String(), w) {
+			t.Errorf("expected warning %q but got:\n%v", w, warn.String())
+		}
+	}
+
+	file := filepath.Join(t.TempDir(), "home_assistant.yml")
+	if err := os.WriteFile(file, []byte(out.String()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	c, err := config.LoadConfigWithOptions(file, config.LoadOptions{Strict: true})
+	if err != nil {
+		t.Fatalf("expected valid configuration but got %v:\n%v", err, out.String())
+	}
+
+	expected := map[string][]config.RegisterAddr{
+		"heat_pump_0": {400010, 300020, 200001},
+		"heat_pump_2": {300005},
+	}
+	if len(c.Modules) != len(expected) {
+		t.Fatalf("expected modules per slave but got:\n%v", out.String())
+	}
+	for _, m := range c.Modules {
+		addresses, ok := expected[m.Name]
+		if !ok || len(m.Metrics) != len(addresses) {
+			t.Fatalf("unexpected module %v:\n%v", m.Name, out.String())
+		}
+		for i, a := range addresses {
+			if m.Metrics[i].Address != a {
+				t.Errorf("module %v: expected address %v but got %v", m.Name, a, m.Metrics[i].Address)
+			}
+		}
+	}
+
+	energy := c.Modules[0].Metrics[1]
+	if energy.Name != "energy_watt_hours_total" || energy.Endianness != config.EndiannessYolo || energy.Factor == nil || *energy.Factor != 1000 {
+		t.Errorf("expected energy counter with swapped words but got %+v", energy)
+	}
+}
@@ -0,0 +1,447 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/RichiH/modbus_exporter/config"
+)
+
+// telegrafRegisterTypes maps the register sections of the Telegraf modbus
+// input to function codes.
+var telegrafRegisterTypes = []struct {
+	key          string
+	functionCode uint8
+}{
+	{"coils", config.FuncCodeReadCoils},
+	{"discrete_inputs", config.FuncCodeReadDiscreteInputs},
+	{"holding_registers", config.FuncCodeReadHoldingRegisters},
+	{"input_registers", config.FuncCodeReadInputRegisters},
+}
+
+// telegrafDataTypes maps the data types of the Telegraf modbus input with a
+// fixed size to the data types of the configuration.
+var telegrafDataTypes = map[string]config.ModbusDataType{
+	"INT16": config.ModbusInt16, "UINT16": config.ModbusUInt16,
+	"INT32": config.ModbusInt32, "UINT32": config.ModbusUInt32,
+	"INT64": config.ModbusInt64, "UINT64": config.ModbusUInt64,
+	"FLOAT32-IEEE": config.ModbusFloat32, "FLOAT64-IEEE": config.ModbusFloat64,
+}
+
+// telegrafFixedDataTypes maps the number of registers of the fixed point data
+// types of the Telegraf modbus input, sized by their addresses, to signed and
+// unsigned data types of the configuration.
+var telegrafFixedDataTypes = map[int][2]config.ModbusDataType{
+	1: {config.ModbusInt16, config.ModbusUInt16},
+	2: {config.ModbusInt32, config.ModbusUInt32},
+	4: {config.ModbusInt64, config.ModbusUInt64},
+}
+
+// telegrafByteOrders maps the byte orders of the Telegraf modbus input to
+// endianness.
+var telegrafByteOrders = map[string]config.EndiannessType{
+	"AB": config.EndiannessBigEndian, "BA": config.EndiannessLittleEndian,
+	"ABCD": config.EndiannessBigEndian, "DCBA": config.EndiannessLittleEndian,
+	"BADC": config.EndiannessMixedEndian, "CDAB": config.EndiannessYolo,
+	"ABCDEFGH": config.EndiannessBigEndian, "HGFEDCBA": config.EndiannessLittleEndian,
+	"BADCFEHG": config.EndiannessMixedEndian, "GHEFCDAB": config.EndiannessYolo,
+}
+
+// importTelegraf converts the modbus inputs of the Telegraf configuration in
+// the given file into modules and prints them as configuration file.
+func importTelegraf(file string) int {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if err := convertTelegraf(string(data), os.Stdout, os.Stderr); err != nil {
+		fmt.Fprintf(os.Stderr, "%v: %v\n", file, err)
+		return 1
+	}
+
+	return 0
+}
+
+// telegrafInput is the text of the table of a modbus input, without the
+// tables nested within, along with the line it starts at.
+type telegrafInput struct {
+	line int
+	text string
+	// request is set if the input defines its registers as requests.
+	request bool
+}
+
+// tomlTableHeader matches the header of a table or array of tables.
+var tomlTableHeader = regexp.MustCompile(`^\[\[?\s*([A-Za-z0-9_.\-]+)\s*\]\]?$`)
+
+// telegrafInputs returns the modbus inputs of the given Telegraf
+// configuration.
+func telegrafInputs(data string) []telegrafInput {
+	inputs := []telegrafInput{}
+	var current *telegrafInput
+	for i, line := range strings.Split(data, "\n") {
+		m := tomlTableHeader.FindStringSubmatch(strings.TrimSpace(stripTOMLComment(line)))
+		if m == nil {
+			if current != nil {
+				current.text += line + "\n"
+			}
+			continue
+		}
+
+		// The keys of tables nested in the input, like tags, aren't the
+		// input's.
+		current = nil
+		switch {
+		case m[1] == "inputs.modbus":
+			inputs = append(inputs, telegrafInput{line: i + 1})
+			current = &inputs[len(inputs)-1]
+		case m[1] == "inputs.modbus.request" && len(inputs) > 0:
+			inputs[len(inputs)-1].request = true
+		}
+	}
+
+	return inputs
+}
+
+// convertTelegraf writes the modules converted from the modbus inputs of the
+// given Telegraf configuration to w and the fields that can't be converted to
+// warn. Only inputs defining registers rather than requests are converted.
+func convertTelegraf(data string, w, warn io.Writer) error {
+	inputs := telegrafInputs(data)
+	if len(inputs) == 0 {
+		return fmt.Errorf("no [[inputs.modbus]] found")
+	}
+
+	var b strings.Builder
+	b.WriteString("modules:\n")
+	for i, input := range inputs {
+		if input.request {
+			fmt.Fprintf(warn, "line %d: inputs defining requests aren't supported, skipped\n", input.line)
+			continue
+		}
+		values, err := parseTOMLTable(input.text)
+		if err != nil {
+			return fmt.Errorf("input at line %d: %v", input.line, err)
+		}
+
+		name, _ := values["name"].(string)
+		moduleName := sanitizeMetricName(name)
+		if moduleName == "" {
+			moduleName = fmt.Sprintf("telegraf_%d", i+1)
+		}
+		if id, ok := values["slave_id"].(float64); ok {
+			fmt.Fprintf(&b, "  # Scraped with sub_target=%v like slave_id of Telegraf.\n", id)
+		}
+		writeImportedModule(&b, moduleName)
+
+		names := map[string]int{}
+		for _, t := range telegrafRegisterTypes {
+			fields, _ := values[t.key].([]interface{})
+			for _, f := range fields {
+				field, _ := f.(map[string]interface{})
+				def, err := telegrafMetric(field, t.functionCode)
+				if err != nil {
+					fmt.Fprintf(warn, "module %v: %v\n", moduleName, err)
+					fmt.Fprintf(&b, "      # skipped: %v\n", err)
+					continue
+				}
+				if uniqueMetricName(names, &def) {
+					fmt.Fprintf(warn, "module %v: duplicate name, renamed to %v\n", moduleName, def.Name)
+				}
+				writeImportedMetric(&b, def)
+			}
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// telegrafMetric converts a field of a register section of the Telegraf
+// modbus input into a metric definition.
+func telegrafMetric(field map[string]interface{}, functionCode uint8) (config.MetricDef, error) {
+	name, _ := field["name"].(string)
+	addresses, _ := field["address"].([]interface{})
+	if len(addresses) == 0 {
+		return config.MetricDef{}, fmt.Errorf("missing address of %v", name)
+	}
+	first, _ := addresses[0].(float64)
+	for i, a := range addresses {
+		if v, ok := a.(float64); !ok || v != first+float64(i) || v < 0 || v > 65535 {
+			return config.MetricDef{}, fmt.Errorf("addresses of %v must be consecutive registers", name)
+		}
+	}
+
+	factor := 1.0
+	if scale, ok := field["scale"].(float64); ok && scale != 0 {
+		factor = scale
+	}
+	def, err := importedMetric(name, "", "", factor)
+	if err != nil {
+		return config.MetricDef{}, err
+	}
+	def.Address = config.RegisterAddr(uint32(functionCode)*100000 + uint32(first))
+
+	if config.IsBitAccess(functionCode) {
+		bitOffset := 0
+		def.DataType = config.ModbusBool
+		def.BitOffset = &bitOffset
+		def.Factor = nil
+		return def, nil
+	}
+
+	dataType, _ := field["data_type"].(string)
+	if dataType == "" {
+		dataType = "INT16"
+	}
+	switch dataType {
+	case "FIXED", "UFIXED", "FLOAT32", "FLOAT64":
+		types, ok := telegrafFixedDataTypes[len(addresses)]
+		if !ok {
+			return config.MetricDef{}, fmt.Errorf("%v of %v must span 1, 2 or 4 registers", dataType, name)
+		}
+		def.DataType = types[1]
+		if dataType == "FIXED" {
+			def.DataType = types[0]
+		}
+	default:
+		t, ok := telegrafDataTypes[dataType]
+		if !ok {
+			return config.MetricDef{}, fmt.Errorf("unsupported data type %v of %v", dataType, name)
+		}
+		if int(t.RegisterCount()) != len(addresses) {
+			return config.MetricDef{}, fmt.Errorf("data type %v of %v doesn't match its %d addresses", dataType, name, len(addresses))
+		}
+		def.DataType = t
+	}
+
+	byteOrder, _ := field["byte_order"].(string)
+	if byteOrder == "" {
+		byteOrder = "ABCD"
+	}
+	endianness, ok := telegrafByteOrders[byteOrder]
+	if !ok {
+		return config.MetricDef{}, fmt.Errorf("unsupported byte order %v of %v", byteOrder, name)
+	}
+	def.Endianness = endianness
+
+	return def, nil
+}
+
+// stripTOMLComment removes a comment from the given line, ignoring # within
+// strings.
+func stripTOMLComment(line string) string {
+	var quote rune
+	for i, r := range line {
+		switch {
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote == 0 && (r == '"' || r == '\''):
+			quote = r
+		case quote == 0 && r == '#':
+			return line[:i]
+		}
+	}
+
+	return line
+}
+
+// tomlParser parses the subset of TOML used by the key/value pairs of a table:
+// strings, numbers, booleans, arrays and inline tables. Numbers are parsed as
+// float64.
+type tomlParser struct {
+	s   string
+	pos int
+}
+
+// parseTOMLTable parses the key/value pairs of the given table text.
+func parseTOMLTable(text string) (map[string]interface{}, error) {
+	lines := strings.Split(text, "\n")
+	for i, l := range lines {
+		lines[i] = stripTOMLComment(l)
+	}
+	p := &tomlParser{s: strings.Join(lines, "\n")}
+
+	values := map[string]interface{}{}
+	for {
+		p.skipSpace(true)
+		if p.pos >= len(p.s) {
+			return values, nil
+		}
+		key, err := p.key()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect('='); err != nil {
+			return nil, err
+		}
+		v, err := p.value()
+		if err != nil {
+			return nil, fmt.Errorf("value of %v: %v", key, err)
+		}
+		values[key] = v
+	}
+}
+
+func (p *tomlParser) skipSpace(newlines bool) {
+	for p.pos < len(p.s) {
+		switch p.s[p.pos] {
+		case ' ', '\t', '\r':
+		case '\n':
+			if !newlines {
+				return
+			}
+		default:
+			return
+		}
+		p.pos++
+	}
+}
+
+func (p *tomlParser) expect(c byte) error {
+	p.skipSpace(true)
+	if p.pos >= len(p.s) || p.s[p.pos] != c {
+		return fmt.Errorf("expected '%c' at offset %d", c, p.pos)
+	}
+	p.pos++
+
+	return nil
+}
+
+func (p *tomlParser) key() (string, error) {
+	p.skipSpace(true)
+	if p.pos < len(p.s) && (p.s[p.pos] == '"' || p.s[p.pos] == '\'') {
+		return p.string()
+	}
+	start := p.pos
+	for p.pos < len(p.s) && (isTOMLBareKey(p.s[p.pos]) || p.s[p.pos] == '.') {
+		p.pos++
+	}
+	if start == p.pos {
+		return "", fmt.Errorf("expected key at offset %d", p.pos)
+	}
+
+	return p.s[start:p.pos], nil
+}
+
+func isTOMLBareKey(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-'
+}
+
+func (p *tomlParser) string() (string, error) {
+	quote := p.s[p.pos]
+	end := p.pos + 1
+	for end < len(p.s) && p.s[end] != quote {
+		if quote == '"' && p.s[end] == '\\' {
+			end++
+		}
+		end++
+	}
+	if end >= len(p.s) {
+		return "", fmt.Errorf("unterminated string at offset %d", p.pos)
+	}
+	literal := p.s[p.pos : end+1]
+	p.pos = end + 1
+	if quote == '\'' {
+		return literal[1 : len(literal)-1], nil
+	}
+
+	return strconv.Unquote(literal)
+}
+
+func (p *tomlParser) value() (interface{}, error) {
+	p.skipSpace(false)
+	if p.pos >= len(p.s) {
+		return nil, fmt.Errorf("missing value")
+	}
+
+	switch p.s[p.pos] {
+	case '"', '\'':
+		return p.string()
+	case '[':
+		p.pos++
+		values := []interface{}{}
+		for {
+			p.skipSpace(true)
+			if p.pos < len(p.s) && p.s[p.pos] == ']' {
+				p.pos++
+				return values, nil
+			}
+			v, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, v)
+			p.skipSpace(true)
+			if p.pos < len(p.s) && p.s[p.pos] == ',' {
+				p.pos++
+			}
+		}
+	case '{':
+		p.pos++
+		values := map[string]interface{}{}
+		for {
+			p.skipSpace(true)
+			if p.pos < len(p.s) && p.s[p.pos] == '}' {
+				p.pos++
+				return values, nil
+			}
+			key, err := p.key()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect('='); err != nil {
+				return nil, err
+			}
+			v, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			values[key] = v
+			p.skipSpace(true)
+			if p.pos < len(p.s) && p.s[p.pos] == ',' {
+				p.pos++
+			}
+		}
+	}
+
+	start := p.pos
+	for p.pos < len(p.s) && !strings.ContainsRune(",]}\n \t\r", rune(p.s[p.pos])) {
+		p.pos++
+	}
+	token := p.s[start:p.pos]
+	switch token {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	}
+	f, err := strconv.ParseFloat(strings.ReplaceAll(token, "_", ""), 64)
+	if err != nil {
+		if i, err := strconv.ParseInt(strings.ReplaceAll(token, "_", ""), 0, 64); err == nil {
+			return float64(i), nil
+		}
+		return nil, fmt.Errorf("unsupported value '%v' at offset %d", token, start)
+	}
+
+	return f, nil
+}
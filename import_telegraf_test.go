@@ -0,0 +1,108 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/RichiH/modbus_exporter/config"
+)
+
+func TestConvertTelegraf(t *testing.T) {
+	in := `
+[agent]
+  interval = "10s"
+
+[[inputs.modbus]]
+  name = "Meter 1" # the main meter
+  slave_id = 2
+  controller = "tcp://192.168.1.10:502"
+
+  coils = [
+    { name = "Relay", address = [0] },
+  ]
+  holding_registers = [
+    { name = "Voltage", byte_order = "CDAB", data_type = "FLOAT32-IEEE", scale = 1.0, address = [0, 1] },
+    { name = "Power", byte_order = "AB", data_type = "INT16", scale = 0.1, address = [2] },
+    { name = "Energy", byte_order = "ABCD", data_type = "UINT32", address = [4, 5] },
+    { name = "Serial", byte_order = "AB", data_type = "STRING", address = [10, 11] },
+  ]
+  [inputs.modbus.tags]
+    site = "a"
+
+[[inputs.modbus]]
+  name = "requests"
+  [[inputs.modbus.request]]
+    slave_id = 1
+`
+
+	var out, warn strings.Builder
+	if err := convertTelegraf(in, &out, &warn); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, w := range []string{
+		"module meter_1: unsupported data type STRING of Serial",
+		"line 22: inputs defining requests aren't supported, skipped",
+	} {
+		if !strings.Contains(warn.String(), w) {
+			t.Errorf("expected warning %q but got:\n%v", w, warn.String())
+		}
+	}
+	if !strings.Contains(out.String(), "# Scraped with sub_target=2 like slave_id of Telegraf.") {
+		t.Errorf("expected slave_id to be noted in the output but got:\n%v", out.String())
+	}
+
+	file := filepath.Join(t.TempDir(), "telegraf.yml")
+	if err := os.WriteFile(file, []byte(out.String()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	c, err := config.LoadConfigWithOptions(file, config.LoadOptions{Strict: true})
+	if err != nil {
+		t.Fatalf("expected valid configuration but got %v:\n%v", err, out.String())
+	}
+	if len(c.Modules) != 1 || c.Modules[0].Name != "meter_1" {
+		t.Fatalf("expected module meter_1 but got:\n%v", out.String())
+	}
+
+	expected := []struct {
+		name       string
+		address    config.RegisterAddr
+		dataType   config.ModbusDataType
+		endianness config.EndiannessType
+		factor     float64
+	}{
+		{"relay", 100000, config.ModbusBool, config.EndiannessBigEndian, 1},
+		{"voltage", 300000, config.ModbusFloat32, config.EndiannessYolo, 1},
+		{"power", 300002, config.ModbusInt16, config.EndiannessBigEndian, 0.1},
+		{"energy", 300004, config.ModbusUInt32, config.EndiannessBigEndian, 1},
+	}
+	metrics := c.Modules[0].Metrics
+	if len(metrics) != len(expected) {
+		t.Fatalf("expected %d metrics but got %d:\n%v", len(expected), len(metrics), out.String())
+	}
+	for i, e := range expected {
+		m := metrics[i]
+		factor := 1.0
+		if m.Factor != nil {
+			factor = *m.Factor
+		}
+		if m.Name != e.name || m.Address != e.address || m.DataType != e.dataType || m.Endianness != e.endianness || factor != e.factor {
+			t.Errorf("expected %+v but got %v %v %v %v %v", e, m.Name, m.Address, m.DataType, m.Endianness, factor)
+		}
+	}
+}
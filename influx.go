@@ -0,0 +1,109 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/proto"
+)
+
+var (
+	measurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+	tagEscaper         = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+)
+
+// writeInflux writes the given metric families in the InfluxDB line protocol,
+// one line per series with the metric name as measurement, the labels as tags
+// and the sample as "value" field. Histograms are written like in the
+// Prometheus text format, as _bucket series with an le tag along with _sum and
+// _count. NaN and infinite values are skipped as the line protocol can't
+// represent them.
+func writeInflux(w io.Writer, mfs []*dto.MetricFamily, t time.Time) error {
+	bw := bufio.NewWriter(w)
+	ts := strconv.FormatInt(t.UnixNano(), 10)
+
+	for _, mf := range mfs {
+		name := mf.GetName()
+		for _, m := range mf.Metric {
+			switch {
+			case m.Gauge != nil:
+				writeInfluxLine(bw, name, m.Label, m.Gauge.GetValue(), ts)
+			case m.Counter != nil:
+				writeInfluxLine(bw, name, m.Label, m.Counter.GetValue(), ts)
+			case m.Untyped != nil:
+				writeInfluxLine(bw, name, m.Label, m.Untyped.GetValue(), ts)
+			case m.Histogram != nil:
+				writeInfluxHistogram(bw, name, m.Label, m.Histogram, ts)
+			}
+		}
+	}
+
+	return bw.Flush()
+}
+
+// writeInfluxHistogram writes the buckets, sum and count of the given
+// histogram.
+func writeInfluxHistogram(bw *bufio.Writer, name string, labels []*dto.LabelPair, h *dto.Histogram, ts string) {
+	bucket := func(le string, count uint64) {
+		bucketLabels := append([]*dto.LabelPair{{Name: proto.String("le"), Value: proto.String(le)}}, labels...)
+		sort.Slice(bucketLabels, func(i, j int) bool {
+			return bucketLabels[i].GetName() < bucketLabels[j].GetName()
+		})
+		writeInfluxLine(bw, name+"_bucket", bucketLabels, float64(count), ts)
+	}
+
+	inf := false
+	for _, b := range h.Bucket {
+		if math.IsInf(b.GetUpperBound(), 1) {
+			inf = true
+		}
+		bucket(strconv.FormatFloat(b.GetUpperBound(), 'g', -1, 64), b.GetCumulativeCount())
+	}
+	if !inf {
+		bucket("+Inf", h.GetSampleCount())
+	}
+	writeInfluxLine(bw, name+"_sum", labels, h.GetSampleSum(), ts)
+	writeInfluxLine(bw, name+"_count", labels, float64(h.GetSampleCount()), ts)
+}
+
+// writeInfluxLine writes a single line, unless the value is NaN or infinite.
+func writeInfluxLine(bw *bufio.Writer, name string, labels []*dto.LabelPair, v float64, ts string) {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return
+	}
+
+	bw.WriteString(measurementEscaper.Replace(name))
+	for _, l := range labels {
+		if l.GetValue() == "" {
+			continue
+		}
+		bw.WriteByte(',')
+		bw.WriteString(tagEscaper.Replace(l.GetName()))
+		bw.WriteByte('=')
+		bw.WriteString(tagEscaper.Replace(l.GetValue()))
+	}
+	bw.WriteString(" value=")
+	bw.WriteString(strconv.FormatFloat(v, 'g', -1, 64))
+	bw.WriteByte(' ')
+	bw.WriteString(ts)
+	bw.WriteByte('\n')
+}
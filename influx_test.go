@@ -0,0 +1,57 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestWriteInflux(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	g := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "voltage_volts", Help: "help"}, []string{"module", "site"})
+	g.WithLabelValues("sdm630", "berlin west").Set(230.5)
+	g.WithLabelValues("sdm630", "a,b=c").Set(math.NaN())
+	c := prometheus.NewCounter(prometheus.CounterOpts{Name: "energy_total", Help: "help"})
+	c.Add(42)
+	h := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "power_watts", Help: "help", Buckets: []float64{100, 1000}})
+	h.Observe(50)
+	h.Observe(500)
+	reg.MustRegister(g, c, h)
+
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var b strings.Builder
+	if err := writeInflux(&b, mfs, time.Unix(1, 0)); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "energy_total value=42 1000000000\n" +
+		"power_watts_bucket,le=100 value=1 1000000000\n" +
+		"power_watts_bucket,le=1000 value=2 1000000000\n" +
+		"power_watts_bucket,le=+Inf value=2 1000000000\n" +
+		"power_watts_sum value=550 1000000000\n" +
+		"power_watts_count value=2 1000000000\n" +
+		"voltage_volts,module=sdm630,site=berlin\\ west value=230.5 1000000000\n"
+	if b.String() != expected {
+		t.Fatalf("expected\n%v\nbut got\n%v", expected, b.String())
+	}
+}
@@ -0,0 +1,61 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/prometheus/common/version"
+	"github.com/prometheus/exporter-toolkit/web"
+
+	"github.com/RichiH/modbus_exporter/modbus"
+)
+
+// landingHandler serves a landing page listing the endpoints of the exporter
+// and the configured modules. It is rendered on every request to reflect
+// configuration reloads.
+func landingHandler(e *modbus.Exporter, w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+
+	links := []web.LandingLinks{
+		{Address: "metrics", Text: "Metrics", Description: "Metrics of the exporter itself"},
+		{Address: "targets", Text: "Targets", Description: "Status of the latest scrape of each target"},
+		{Address: "sd", Text: "Service discovery", Description: "Polled and discovered targets for the HTTP service discovery of Prometheus"},
+	}
+	for _, m := range e.GetConfig().Modules {
+		links = append(links, web.LandingLinks{
+			Address:     "modbus?" + url.Values{"module": {m.Name}}.Encode(),
+			Text:        "Module " + m.Name,
+			Description: fmt.Sprintf("%d metrics, scrape with /modbus?module=%s&target=<address>&sub_target=<unit ID>", len(m.Metrics), m.Name),
+		})
+	}
+
+	page, err := web.NewLandingPage(web.LandingConfig{
+		Name:        "Modbus Exporter",
+		Description: "Prometheus exporter for Modbus TCP devices",
+		Version:     version.Info(),
+		Links:       links,
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	page.ServeHTTP(w, r)
+}
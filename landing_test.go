@@ -0,0 +1,45 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/RichiH/modbus_exporter/config"
+	"github.com/RichiH/modbus_exporter/modbus"
+)
+
+func TestLandingHandler(t *testing.T) {
+	e := modbus.NewExporter(config.Config{Modules: []config.Module{{Name: "sdm630"}}})
+
+	rr := httptest.NewRecorder()
+	landingHandler(e, rr, httptest.NewRequest("GET", "/", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200 but got %v", rr.Code)
+	}
+	for _, s := range []string{`href="metrics"`, `href="modbus?module=sdm630"`, "Module sdm630"} {
+		if !strings.Contains(rr.Body.String(), s) {
+			t.Fatalf("expected landing page to contain %q but got %v", s, rr.Body.String())
+		}
+	}
+
+	rr = httptest.NewRecorder()
+	landingHandler(e, rr, httptest.NewRequest("GET", "/unknown", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Fatalf("expected status 404 for unknown path but got %v", rr.Code)
+	}
+}
@@ -0,0 +1,41 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/RichiH/modbus_exporter/config"
+)
+
+// lint reports likely mistakes in the given configuration file and returns the
+// exit code.
+func lint(configFile string, opts config.LoadOptions) int {
+	c, err := config.LoadConfigWithOptions(configFile, opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v: %v\n", configFile, err)
+		return 1
+	}
+
+	problems := c.Lint()
+	for _, p := range problems {
+		fmt.Printf("%v: %v\n", configFile, p)
+	}
+	if len(problems) > 0 {
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,148 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+
+	"github.com/RichiH/modbus_exporter/modbus"
+)
+
+// rateLimitedLogger logs errors of the same target and cause, e.g. of a dead
+// device scraped every 15 seconds, at most once per interval. The number of
+// messages suppressed in between is logged along with the next one passed, or
+// once the interval passed without one. Lines without target or error are
+// passed as is.
+type rateLimitedLogger struct {
+	next     log.Logger
+	interval time.Duration
+
+	mtx          sync.Mutex
+	suppressions map[string]*suppression
+
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+type suppression struct {
+	target string
+	status string
+	since  time.Time
+	count  int
+}
+
+// newRateLimitedLogger returns a logger limiting the errors passed to the
+// given one to one per target, cause and interval.
+func newRateLimitedLogger(next log.Logger, interval time.Duration) *rateLimitedLogger {
+	l := &rateLimitedLogger{
+		next:         next,
+		interval:     interval,
+		suppressions: map[string]*suppression{},
+		done:         make(chan struct{}),
+		stopped:      make(chan struct{}),
+	}
+	go l.run()
+
+	return l
+}
+
+// run logs the summaries of suppressions whose interval passed, until the
+// logger is closed.
+func (l *rateLimitedLogger) run() {
+	defer close(l.stopped)
+	ticker := time.NewTicker(l.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			l.flush(now)
+		case <-l.done:
+			l.flush(time.Now().Add(l.interval))
+			return
+		}
+	}
+}
+
+// flush drops the suppressions whose interval passed by the given time,
+// logging the number of messages suppressed if any.
+func (l *rateLimitedLogger) flush(now time.Time) {
+	l.mtx.Lock()
+	expired := []*suppression{}
+	for key, s := range l.suppressions {
+		if now.Sub(s.since) >= l.interval {
+			delete(l.suppressions, key)
+			expired = append(expired, s)
+		}
+	}
+	l.mtx.Unlock()
+
+	for _, s := range expired {
+		l.logSuppressed(s)
+	}
+}
+
+// Close logs the summaries of all pending suppressions and stops logging them
+// periodically.
+func (l *rateLimitedLogger) Close() {
+	close(l.done)
+	<-l.stopped
+}
+
+func (l *rateLimitedLogger) logSuppressed(s *suppression) {
+	if s.count > 0 {
+		level.Warn(l.next).Log("msg", fmt.Sprintf("suppressed %d similar messages", s.count), "target", s.target, "status", s.status)
+	}
+}
+
+// Log implements the log.Logger interface.
+func (l *rateLimitedLogger) Log(keyvals ...interface{}) error {
+	var target string
+	var err error
+	for i := 0; i+1 < len(keyvals); i += 2 {
+		switch keyvals[i] {
+		case "target":
+			target = fmt.Sprint(keyvals[i+1])
+		case "err":
+			err, _ = keyvals[i+1].(error)
+		}
+	}
+	if target == "" || err == nil {
+		return l.next.Log(keyvals...)
+	}
+
+	status := modbus.ScrapeStatus(err)
+	key := target + "/" + status
+	now := time.Now()
+
+	l.mtx.Lock()
+	s, ok := l.suppressions[key]
+	if ok && now.Sub(s.since) < l.interval {
+		s.count++
+		l.mtx.Unlock()
+		return nil
+	}
+	l.suppressions[key] = &suppression{target: target, status: status, since: now}
+	l.mtx.Unlock()
+
+	if ok {
+		l.logSuppressed(s)
+	}
+
+	return l.next.Log(keyvals...)
+}
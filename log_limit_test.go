@@ -0,0 +1,88 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+
+	"github.com/RichiH/modbus_exporter/modbus"
+)
+
+func TestRateLimitedLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := newRateLimitedLogger(log.NewLogfmtLogger(log.NewSyncWriter(&buf)), 50*time.Millisecond)
+
+	connect := fmt.Errorf("%w 10.0.0.5:502 via module my_module", modbus.ErrConnect)
+	for i := 0; i < 3; i++ {
+		logger.Log("msg", "failed to scrape", "target", "10.0.0.5:502", "err", connect)
+	}
+	// Other targets and causes are limited separately.
+	logger.Log("msg", "failed to scrape", "target", "10.0.0.6:502", "err", connect)
+	logger.Log("msg", "failed to scrape", "target", "10.0.0.5:502", "err", errors.New("something else"))
+	logger.Log("msg", "got scrape request", "target", "10.0.0.5:502")
+
+	time.Sleep(100 * time.Millisecond)
+	logger.Log("msg", "failed to scrape", "target", "10.0.0.5:502", "err", connect)
+	logger.Close()
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 6 {
+		t.Fatalf("expected 6 log lines but got %q", lines)
+	}
+	if !strings.Contains(lines[4], `msg="suppressed 2 similar messages" target=10.0.0.5:502 status=connect_failed`) {
+		t.Fatalf("expected summary of the suppressed messages but got %v", lines[4])
+	}
+}
+
+func TestRateLimitedLoggerFlush(t *testing.T) {
+	var buf bytes.Buffer
+	logger := newRateLimitedLogger(log.NewLogfmtLogger(log.NewSyncWriter(&buf)), 20*time.Millisecond)
+
+	connect := fmt.Errorf("%w 10.0.0.5:502 via module my_module", modbus.ErrConnect)
+	for i := 0; i < 3; i++ {
+		logger.Log("msg", "failed to scrape", "target", "10.0.0.5:502", "err", connect)
+	}
+	logger.Log("msg", "failed to scrape", "target", "10.0.0.6:502", "err", connect)
+
+	// The summary is logged once the interval passed, without waiting for
+	// another message, and the suppressions are dropped.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		logger.mtx.Lock()
+		pending := len(logger.suppressions)
+		logger.mtx.Unlock()
+		if pending == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected suppressions to be dropped but %d are left", pending)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	logger.Close()
+
+	if !strings.Contains(buf.String(), `msg="suppressed 2 similar messages" target=10.0.0.5:502 status=connect_failed`) {
+		t.Fatalf("expected summary of the suppressed messages but got %v", buf.String())
+	}
+	if strings.Contains(buf.String(), "target=10.0.0.6:502 status=") {
+		t.Fatalf("expected no summary without suppressed messages but got %v", buf.String())
+	}
+}
@@ -0,0 +1,68 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package modbus
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type cacheEntry struct {
+	gatherer prometheus.Gatherer
+	expires  time.Time
+}
+
+// scrapeCache holds successful scrape results until they expire.
+type scrapeCache struct {
+	mtx     sync.Mutex
+	entries map[string]cacheEntry
+}
+
+func newScrapeCache() *scrapeCache {
+	return &scrapeCache{entries: map[string]cacheEntry{}}
+}
+
+// get returns the cached result for the given key if it has not expired yet.
+func (c *scrapeCache) get(key string, now time.Time) (prometheus.Gatherer, bool) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if !now.Before(entry.expires) {
+		delete(c.entries, key)
+		return nil, false
+	}
+
+	return entry.gatherer, true
+}
+
+// put caches the given result until expires, dropping any expired entries
+// along the way so targets that are no longer scraped don't pile up.
+func (c *scrapeCache) put(key string, g prometheus.Gatherer, now, expires time.Time) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	for k, entry := range c.entries {
+		if !now.Before(entry.expires) {
+			delete(c.entries, k)
+		}
+	}
+
+	c.entries[key] = cacheEntry{gatherer: g, expires: expires}
+}
@@ -0,0 +1,41 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package modbus
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestScrapeCache(t *testing.T) {
+	c := newScrapeCache()
+	now := time.Now()
+	reg := prometheus.NewRegistry()
+
+	c.put("a", reg, now, now.Add(time.Minute))
+
+	if g, ok := c.get("a", now.Add(30*time.Second)); !ok || g != reg {
+		t.Fatal("expected cached result within TTL")
+	}
+
+	if _, ok := c.get("a", now.Add(time.Minute)); ok {
+		t.Fatal("expected no cached result after TTL")
+	}
+
+	if _, ok := c.get("b", now); ok {
+		t.Fatal("expected no cached result for unknown key")
+	}
+}
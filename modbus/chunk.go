@@ -0,0 +1,118 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package modbus
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/RichiH/modbus_exporter/config"
+	"github.com/goburrow/modbus"
+)
+
+// chunkResult is the outcome of scraping one chunk of metric definitions.
+type chunkResult struct {
+	duration time.Duration
+	err      error
+}
+
+// scrapeChunks executes the given blocks in chunks of the given size. Each
+// chunk gets an equal share of the time left until the context deadline and a
+// failing chunk doesn't prevent the following ones from being read. An error
+// is only returned if no chunk succeeded.
+func scrapeChunks(ctx context.Context, blocks []config.ReadBlock, definitions []config.MetricDef, chunkSize int, handlers []*ctxHandler, failures *scrapeFailures) ([]metric, []chunkResult, error) {
+	clients := make([]modbus.Client, len(handlers))
+	for i, h := range handlers {
+		clients[i] = modbus.NewClient(h)
+	}
+
+	metrics := []metric{}
+	results := []chunkResult{}
+	chunks := (len(blocks) + chunkSize - 1) / chunkSize
+
+	var lastErr error
+	for i := 0; i < chunks; i++ {
+		chunk := blocks[i*chunkSize:]
+		if len(chunk) > chunkSize {
+			chunk = chunk[:chunkSize]
+		}
+
+		chunkCtx, cancel := ctx, context.CancelFunc(func() {})
+		if deadline, ok := ctx.Deadline(); ok {
+			chunkCtx, cancel = context.WithTimeout(ctx, time.Until(deadline)/time.Duration(chunks-i))
+		}
+		for _, h := range handlers {
+			h.ctx = chunkCtx
+		}
+
+		start := time.Now()
+		m, err := scrapeMetrics(chunk, definitions, clients, failures)
+		cancel()
+		results = append(results, chunkResult{duration: time.Since(start), err: err})
+
+		if err != nil {
+			lastErr = err
+			// Don't pick up late responses of the failed chunk.
+			for _, h := range handlers {
+				h.Close()
+			}
+			continue
+		}
+		metrics = append(metrics, m...)
+	}
+
+	for _, h := range handlers {
+		h.ctx = ctx
+	}
+
+	if len(metrics) == 0 && lastErr != nil {
+		return nil, results, lastErr
+	}
+
+	return metrics, results, nil
+}
+
+// registerChunkResults registers the per chunk outcome of a scrape.
+func registerChunkResults(reg prometheus.Registerer, results []chunkResult) error {
+	success := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "modbus_scrape_chunk_success",
+		Help: "Whether reading the chunk of read requests succeeded.",
+	}, []string{"chunk"})
+	duration := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "modbus_scrape_chunk_duration_seconds",
+		Help: "Duration of reading the chunk of read requests.",
+	}, []string{"chunk"})
+
+	for i, r := range results {
+		chunk := strconv.Itoa(i)
+		if r.err == nil {
+			success.WithLabelValues(chunk).Set(1)
+		} else {
+			success.WithLabelValues(chunk).Set(0)
+		}
+		duration.WithLabelValues(chunk).Set(r.duration.Seconds())
+	}
+
+	for _, c := range []prometheus.Collector{success, duration} {
+		if err := reg.Register(c); err != nil {
+			return fmt.Errorf("failed to register chunk metrics: %v", err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,91 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package modbus
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// counterStates tracks the counters read from devices in order to derive
+// their created timestamps. Device totalizers have typically been counting
+// long before the exporter first saw them, so a counter only gets a created
+// timestamp once it was seen decreasing, e.g. after a device replacement.
+type counterStates struct {
+	mtx    sync.Mutex
+	states map[string]counterState
+}
+
+type counterState struct {
+	value   float64
+	created time.Time
+}
+
+func newCounterStates() *counterStates {
+	return &counterStates{states: map[string]counterState{}}
+}
+
+// observe records the value of the given counter and returns its created
+// timestamp, which is zero if it never decreased.
+func (c *counterStates) observe(key string, value float64, now time.Time) time.Time {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	s, ok := c.states[key]
+	if ok && value < s.value {
+		s.created = now
+	}
+	s.value = value
+	c.states[key] = s
+
+	return s.created
+}
+
+// record records the counters of the given scrape result of a target and
+// sets their created timestamps.
+func (c *counterStates) record(target string, mfs []*dto.MetricFamily, now time.Time) {
+	for _, mf := range mfs {
+		if mf.GetType() != dto.MetricType_COUNTER {
+			continue
+		}
+		for _, m := range mf.Metric {
+			// The client library sets the time the counter was created
+			// during the scrape, which is meaningless for device values.
+			m.Counter.CreatedTimestamp = nil
+			if created := c.observe(seriesKey(target, mf.GetName(), m), m.Counter.GetValue(), now); !created.IsZero() {
+				m.Counter.CreatedTimestamp = timestamppb.New(created)
+			}
+		}
+	}
+}
+
+// seriesKey identifies a series of the given target.
+func seriesKey(target, name string, m *dto.Metric) string {
+	var b strings.Builder
+	b.WriteString(target)
+	b.WriteString("/")
+	b.WriteString(name)
+	for _, l := range m.Label {
+		b.WriteString("/")
+		b.WriteString(l.GetName())
+		b.WriteString("=")
+		b.WriteString(l.GetValue())
+	}
+
+	return b.String()
+}
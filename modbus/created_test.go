@@ -0,0 +1,60 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package modbus
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestCounterStatesRecord(t *testing.T) {
+	c := newCounterStates()
+	start := time.Unix(1672531200, 0)
+
+	scrape := func(value float64, now time.Time) *time.Time {
+		reg := prometheus.NewRegistry()
+		energy := prometheus.NewCounter(prometheus.CounterOpts{Name: "energy_wh_total", Help: "energy"})
+		energy.Add(value)
+		reg.MustRegister(energy)
+
+		mfs, err := reg.Gather()
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.record("10.0.0.5:502/1/sdm630", mfs, now)
+
+		if ts := mfs[0].Metric[0].Counter.CreatedTimestamp; ts != nil {
+			created := ts.AsTime()
+			return &created
+		}
+		return nil
+	}
+
+	if created := scrape(1000, start); created != nil {
+		t.Fatalf("expected no created timestamp before a reset but got %v", created)
+	}
+	if created := scrape(1100, start.Add(time.Minute)); created != nil {
+		t.Fatalf("expected no created timestamp before a reset but got %v", created)
+	}
+
+	reset := start.Add(2 * time.Minute)
+	if created := scrape(5, reset); created == nil || !created.Equal(reset) {
+		t.Fatalf("expected created timestamp %v after the reset but got %v", reset, created)
+	}
+	if created := scrape(10, start.Add(3*time.Minute)); created == nil || !created.Equal(reset) {
+		t.Fatalf("expected created timestamp to stay %v but got %v", reset, created)
+	}
+}
@@ -0,0 +1,72 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package modbus
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+
+	"github.com/RichiH/modbus_exporter/config"
+)
+
+// EncodeValue returns the raw data a device holds for the given value of the
+// metric definition, the inverse of parsing it, e.g. to simulate a device.
+// Bools are encoded at the bit offset of the definition, other values are
+// divided by the factor and rounded for integer types.
+func EncodeValue(d config.MetricDef, v float64) ([]byte, error) {
+	if d.Factor != nil && *d.Factor != 0 {
+		v /= *d.Factor
+	}
+
+	var data []byte
+	switch d.DataType {
+	case config.ModbusBool:
+		if d.BitOffset == nil {
+			return nil, fmt.Errorf("expected bit position on boolean data type")
+		}
+		data = make([]byte, 2)
+		// Parsing only looks at the first byte.
+		if v != 0 && *d.BitOffset < 8 {
+			data[0] = 1 << uint(*d.BitOffset)
+		}
+		return data, nil
+	case config.ModbusInt16:
+		data = binary.BigEndian.AppendUint16(nil, uint16(int16(math.Round(v))))
+		return convertEndianness16b(d.Endianness, data)
+	case config.ModbusUInt16:
+		data = binary.BigEndian.AppendUint16(nil, uint16(math.Round(v)))
+		return convertEndianness16b(d.Endianness, data)
+	case config.ModbusInt32:
+		data = binary.BigEndian.AppendUint32(nil, uint32(int32(math.Round(v))))
+		return convertEndianness32b(d.Endianness, data)
+	case config.ModbusUInt32:
+		data = binary.BigEndian.AppendUint32(nil, uint32(math.Round(v)))
+		return convertEndianness32b(d.Endianness, data)
+	case config.ModbusFloat32:
+		data = binary.BigEndian.AppendUint32(nil, math.Float32bits(float32(v)))
+		return convertEndianness32b(d.Endianness, data)
+	case config.ModbusInt64:
+		data = binary.BigEndian.AppendUint64(nil, uint64(int64(math.Round(v))))
+		return convertEndianness64b(d.Endianness, data)
+	case config.ModbusUInt64:
+		data = binary.BigEndian.AppendUint64(nil, uint64(math.Round(v)))
+		return convertEndianness64b(d.Endianness, data)
+	case config.ModbusFloat64:
+		data = binary.BigEndian.AppendUint64(nil, math.Float64bits(v))
+		return convertEndianness64b(d.Endianness, data)
+	default:
+		return nil, fmt.Errorf("can't encode data type %v", d.DataType)
+	}
+}
@@ -0,0 +1,56 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package modbus
+
+import (
+	"testing"
+
+	"github.com/RichiH/modbus_exporter/config"
+)
+
+func TestEncodeValue(t *testing.T) {
+	factor := 0.1
+	bitOffset := 3
+
+	for _, test := range []struct {
+		def   config.MetricDef
+		value float64
+	}{
+		{config.MetricDef{DataType: config.ModbusBool, BitOffset: &bitOffset}, 1},
+		{config.MetricDef{DataType: config.ModbusInt16}, -42},
+		{config.MetricDef{DataType: config.ModbusUInt16, Endianness: config.EndiannessLittleEndian}, 513},
+		{config.MetricDef{DataType: config.ModbusInt32, Endianness: config.EndiannessMixedEndian}, -100000},
+		{config.MetricDef{DataType: config.ModbusUInt32, Endianness: config.EndiannessYolo, Factor: &factor}, 2305},
+		{config.MetricDef{DataType: config.ModbusFloat32}, 230.5},
+		{config.MetricDef{DataType: config.ModbusInt64, Endianness: config.EndiannessLittleEndian}, -1 << 40},
+		{config.MetricDef{DataType: config.ModbusUInt64, Endianness: config.EndiannessYolo}, 1 << 50},
+		{config.MetricDef{DataType: config.ModbusFloat64, Endianness: config.EndiannessMixedEndian}, 0.125},
+	} {
+		data, err := EncodeValue(test.def, test.value)
+		if err != nil {
+			t.Fatalf("%v: %v", test.def.DataType, err)
+		}
+		v, err := parseModbusData(test.def, data)
+		if err != nil {
+			t.Fatalf("%v: %v", test.def.DataType, err)
+		}
+		if v != test.value {
+			t.Errorf("%v: expected %v after round trip but got %v", test.def.DataType, test.value, v)
+		}
+	}
+
+	if _, err := EncodeValue(config.MetricDef{DataType: config.ModbusBool}, 1); err == nil {
+		t.Error("expected bool without bit offset to fail")
+	}
+}
@@ -0,0 +1,100 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package modbus
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+
+	"github.com/goburrow/modbus"
+)
+
+var (
+	// ErrConnect is returned by Scrape if no connection to the target could
+	// be established.
+	ErrConnect = errors.New("unable to connect with target")
+	// ErrTimeout is returned by Scrape if the target didn't respond within
+	// the timeout of the module.
+	ErrTimeout = errors.New("timeout")
+	// ErrParse is returned by Scrape if data read from the target couldn't be
+	// interpreted as defined by the module.
+	ErrParse = errors.New("parse error")
+)
+
+// ExceptionError is returned by Scrape if the target responded with an
+// exception, e.g. illegal data address. Use errors.As to get its
+// ExceptionCode.
+type ExceptionError = modbus.ModbusError
+
+// classifiedError marks an error as being of the kind of one of the errors
+// above, leaving its message as is.
+type classifiedError struct {
+	err   error
+	class error
+}
+
+func (e *classifiedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *classifiedError) Unwrap() []error {
+	return []error{e.class, e.err}
+}
+
+// classifyTimeout marks network timeouts as ErrTimeout.
+func classifyTimeout(err error) error {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return &classifiedError{err: err, class: ErrTimeout}
+	}
+
+	return err
+}
+
+// ScrapeStatus returns the status of a scrape failing with the given error,
+// telling e.g. unreachable devices apart from wrong register maps: success,
+// too_many_scrapes, throttled, deadline_exceeded, connect_failed, timeout,
+// gateway_path_unavailable, gateway_target_failed, exception_<code>,
+// parse_error or error.
+func ScrapeStatus(err error) string {
+	var exception *ExceptionError
+	switch {
+	case err == nil:
+		return "success"
+	case errors.Is(err, ErrTooManyScrapes):
+		return "too_many_scrapes"
+	case errors.Is(err, ErrThrottled):
+		return "throttled"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "deadline_exceeded"
+	case errors.Is(err, ErrConnect):
+		return "connect_failed"
+	case errors.Is(err, ErrTimeout):
+		return "timeout"
+	case errors.As(err, &exception):
+		switch exception.ExceptionCode {
+		case modbus.ExceptionCodeGatewayPathUnavailable:
+			return "gateway_path_unavailable"
+		case modbus.ExceptionCodeGatewayTargetDeviceFailedToRespond:
+			return "gateway_target_failed"
+		}
+		return "exception_" + strconv.Itoa(int(exception.ExceptionCode))
+	case errors.Is(err, ErrParse):
+		return "parse_error"
+	default:
+		return "error"
+	}
+}
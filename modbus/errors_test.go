@@ -0,0 +1,105 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package modbus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/goburrow/modbus"
+
+	"github.com/RichiH/modbus_exporter/config"
+)
+
+func TestScrapeErrorConnect(t *testing.T) {
+	e := NewExporter(testConfig())
+	_, err := e.Scrape(context.Background(), freeAddress(t), 1, "my_module")
+	if !errors.Is(err, ErrConnect) {
+		t.Fatalf("expected ErrConnect but got %v", err)
+	}
+}
+
+func TestScrapeErrorTimeout(t *testing.T) {
+	// A listener never responding to requests.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer c.Close()
+		}
+	}()
+
+	c := testConfig()
+	c.Modules[0].Timeout = 50
+	e := NewExporter(c)
+	_, err = e.Scrape(context.Background(), l.Addr().String(), 1, "my_module")
+	if !errors.Is(err, ErrTimeout) {
+		t.Fatalf("expected ErrTimeout but got %v", err)
+	}
+}
+
+func TestScrapeErrorParse(t *testing.T) {
+	_, address := startServer(t)
+
+	c := testConfig()
+	c.Modules[0].TimestampRegister = &config.TimestampRegister{
+		Address:  300100,
+		DataType: config.ModbusBool,
+	}
+	e := NewExporter(c)
+	_, err := e.Scrape(context.Background(), address, 1, "my_module")
+	if !errors.Is(err, ErrParse) {
+		t.Fatalf("expected ErrParse but got %v", err)
+	}
+}
+
+func TestInsufficientRegistersErrorIsParse(t *testing.T) {
+	_, err := parseModbusData(config.MetricDef{DataType: config.ModbusInt16}, []byte{})
+	if !errors.Is(err, ErrParse) {
+		t.Fatalf("expected ErrParse but got %v", err)
+	}
+}
+
+func TestScrapeStatus(t *testing.T) {
+	for _, test := range []struct {
+		err    error
+		status string
+	}{
+		{nil, "success"},
+		{ErrTooManyScrapes, "too_many_scrapes"},
+		{ErrThrottled, "throttled"},
+		{fmt.Errorf("reading: %w", context.DeadlineExceeded), "deadline_exceeded"},
+		{fmt.Errorf("%w 10.0.0.5:502 via module my_module", ErrConnect), "connect_failed"},
+		{&classifiedError{err: errors.New("i/o timeout"), class: ErrTimeout}, "timeout"},
+		{fmt.Errorf("reading: %w", &modbus.ModbusError{FunctionCode: 3, ExceptionCode: 2}), "exception_2"},
+		{&modbus.ModbusError{FunctionCode: 0x83, ExceptionCode: 10}, "gateway_path_unavailable"},
+		{&modbus.ModbusError{FunctionCode: 0x83, ExceptionCode: 11}, "gateway_target_failed"},
+		{&InsufficientRegistersError{}, "parse_error"},
+		{errors.New("something else"), "error"},
+	} {
+		if s := ScrapeStatus(test.err); s != test.status {
+			t.Errorf("expected status %v for %v but got %v", test.status, test.err, s)
+		}
+	}
+}
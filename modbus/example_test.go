@@ -0,0 +1,69 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package modbus_test
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/RichiH/modbus_exporter/config"
+	"github.com/RichiH/modbus_exporter/modbus"
+)
+
+// Reads the values of a module block by block without Prometheus metrics,
+// as a daemon embedding the register maps would.
+func Example() {
+	c, err := config.Parse([]byte(`
+modules:
+  - name: meter
+    protocol: tcp/ip
+    metrics:
+      - name: voltage_volts
+        address: 300000
+        dataType: float32
+        metricType: gauge
+      - name: frequency_hertz
+        address: 300002
+        dataType: uint16
+        factor: 0.01
+        metricType: gauge
+`), config.LoadOptions{Strict: true})
+	if err != nil {
+		log.Fatal(err)
+	}
+	module := c.GetModule("meter")
+	plan, err := module.ReadPlan()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	e := modbus.NewExporter(c)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for _, block := range plan.Blocks {
+		data, err := e.ReadRaw(ctx, "192.0.2.10:502", 1, module, block.FunctionCode, block.Address, block.Quantity)
+		if err != nil {
+			log.Fatal(err)
+		}
+		values, err := modbus.ParseBlock(block, module.Metrics, data)
+		if err != nil {
+			log.Print(err)
+		}
+		for _, v := range values {
+			fmt.Println(module.Metrics[v.Metric].Name, v.Value)
+		}
+	}
+}
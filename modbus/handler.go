@@ -0,0 +1,249 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package modbus
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/goburrow/modbus"
+)
+
+// ctxHandler wraps a TCP client handler, bounding every request by the
+// deadline of the scrape context.
+type ctxHandler struct {
+	*modbus.TCPClientHandler
+	ctx     context.Context
+	timeout time.Duration
+
+	// Number of times and delay after which requests answered with the
+	// slave busy exception are retried.
+	busyRetries    int
+	busyRetryDelay time.Duration
+
+	// Interval at which requests answered with the acknowledge exception
+	// are repeated and duration after which to give up.
+	ackPollInterval time.Duration
+	ackTimeout      time.Duration
+
+	// telemetry observes every request, if set.
+	telemetry *requestTelemetry
+
+	// frameListeners are called with every request sent and the response
+	// received.
+	frameListeners []func(Frame)
+
+	// tlsConfig secures the connection with Modbus/TCP Security if set,
+	// in which case conn replaces the connection of the TCP client
+	// handler, which can't be wrapped.
+	tlsConfig *tls.Config
+	conn      net.Conn
+
+	// replay answers requests instead of the target if set.
+	replay *replay
+}
+
+// defaultTimeout is the transport timeout of modules not defining one, the
+// default of the modbus library.
+const defaultTimeout = 5 * time.Second
+
+// defaultBusyRetryDelay is the delay before retrying requests answered with
+// the slave busy exception of modules not defining one.
+const defaultBusyRetryDelay = 100 * time.Millisecond
+
+// tcpHeaderLength is the length of the MBAP header preceding the PDU of a
+// modbus TCP ADU.
+const tcpHeaderLength = 7
+
+// maxPDULength is the maximum length of a modbus PDU.
+const maxPDULength = 253
+
+// exceptionCode returns the exception code of the given response ADU, or false
+// if it isn't an exception response.
+func exceptionCode(aduResponse []byte) (byte, bool) {
+	// Exception responses echo the function code with the highest bit set,
+	// followed by the exception code.
+	if len(aduResponse) <= tcpHeaderLength+1 || aduResponse[tcpHeaderLength]&0x80 == 0 {
+		return 0, false
+	}
+
+	return aduResponse[tcpHeaderLength+1], true
+}
+
+func newCtxHandler(ctx context.Context, h *modbus.TCPClientHandler) *ctxHandler {
+	timeout := h.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+
+	return &ctxHandler{TCPClientHandler: h, ctx: ctx, timeout: timeout}
+}
+
+// bound shrinks the transport timeout to the time left until the context
+// deadline.
+func (h *ctxHandler) bound() error {
+	if err := h.ctx.Err(); err != nil {
+		return err
+	}
+
+	h.Timeout = h.timeout
+	if deadline, ok := h.ctx.Deadline(); ok {
+		if left := time.Until(deadline); left < h.Timeout {
+			h.Timeout = left
+		}
+	}
+
+	return nil
+}
+
+// Connect implements the modbus.Transporter interface.
+func (h *ctxHandler) Connect() error {
+	if err := h.bound(); err != nil {
+		return err
+	}
+
+	if h.replay != nil {
+		return nil
+	}
+	if h.tlsConfig == nil {
+		return classifyTimeout(h.TCPClientHandler.Connect())
+	}
+	if h.conn != nil {
+		return nil
+	}
+
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: h.Timeout}, "tcp", h.Address, h.tlsConfig)
+	if err != nil {
+		return classifyTimeout(err)
+	}
+	h.conn = conn
+
+	return nil
+}
+
+// Close implements the modbus.Transporter interface.
+func (h *ctxHandler) Close() error {
+	if h.conn != nil {
+		err := h.conn.Close()
+		h.conn = nil
+		return err
+	}
+
+	return h.TCPClientHandler.Close()
+}
+
+// send answers the request from the replay if set, or sends it via the TLS
+// connection if secured or the one of the TCP client handler otherwise.
+func (h *ctxHandler) send(aduRequest []byte) ([]byte, error) {
+	if h.replay != nil {
+		return h.replay.answer(aduRequest)
+	}
+	if h.tlsConfig == nil {
+		return h.TCPClientHandler.Send(aduRequest)
+	}
+
+	// Like the TCP client handler, reconnect after the connection was
+	// closed, e.g. to drop late responses.
+	if err := h.Connect(); err != nil {
+		return nil, err
+	}
+	if err := h.conn.SetDeadline(time.Now().Add(h.Timeout)); err != nil {
+		return nil, err
+	}
+	if _, err := h.conn.Write(aduRequest); err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, tcpHeaderLength)
+	if _, err := io.ReadFull(h.conn, header); err != nil {
+		return nil, err
+	}
+	// The length field counts the unit ID, the last byte of the header,
+	// and the PDU.
+	length := int(binary.BigEndian.Uint16(header[4:]))
+	if length < 2 || length > maxPDULength+1 {
+		return nil, fmt.Errorf("invalid length %v in response header", length)
+	}
+	pdu := make([]byte, length-1)
+	if _, err := io.ReadFull(h.conn, pdu); err != nil {
+		return nil, err
+	}
+
+	return append(header, pdu...), nil
+}
+
+// Send implements the modbus.Transporter interface. Requests answered with
+// the slave busy exception are retried up to busyRetries times, the ones
+// answered with the acknowledge exception are polled until completed.
+func (h *ctxHandler) Send(aduRequest []byte) ([]byte, error) {
+	busyRetries := 0
+	var ackDeadline time.Time
+	for {
+		if err := h.bound(); err != nil {
+			return nil, err
+		}
+
+		start := time.Now()
+		aduResponse, err := h.send(aduRequest)
+		if h.telemetry != nil {
+			h.telemetry.observe(aduRequest, aduResponse, err, time.Since(start))
+		}
+		for _, l := range h.frameListeners {
+			l(Frame{
+				Time: start, Target: h.Address, SubTarget: h.SlaveId,
+				Request: aduRequest, Response: aduResponse,
+				Duration: time.Since(start), Err: err,
+			})
+		}
+
+		code, ok := exceptionCode(aduResponse)
+		if err != nil || !ok {
+			return aduResponse, classifyTimeout(err)
+		}
+
+		var delay time.Duration
+		switch {
+		case code == modbus.ExceptionCodeServerDeviceBusy && busyRetries < h.busyRetries:
+			busyRetries++
+			if h.telemetry != nil {
+				h.telemetry.busyRetries.Inc()
+			}
+			delay = h.busyRetryDelay
+			if delay <= 0 {
+				delay = defaultBusyRetryDelay
+			}
+		case code == modbus.ExceptionCodeAcknowledge && h.ackPollInterval > 0:
+			if ackDeadline.IsZero() && h.ackTimeout > 0 {
+				ackDeadline = time.Now().Add(h.ackTimeout)
+			}
+			if !ackDeadline.IsZero() && time.Now().Add(h.ackPollInterval).After(ackDeadline) {
+				return aduResponse, nil
+			}
+			delay = h.ackPollInterval
+		default:
+			return aduResponse, nil
+		}
+
+		select {
+		case <-time.After(delay):
+		case <-h.ctx.Done():
+			return nil, h.ctx.Err()
+		}
+	}
+}
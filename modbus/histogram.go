@@ -0,0 +1,199 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package modbus
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/RichiH/modbus_exporter/config"
+)
+
+// parseBuckets parses the consecutive bucket counts of a histogram. Each
+// count is returned as a metric with an "le" label holding the upper bound
+// of its bucket.
+func parseBuckets(d config.MetricDef, data []byte) ([]metric, error) {
+	// The factor applies to the sum only, counts are counts.
+	d.Factor = nil
+	size := int(d.DataType.RegisterCount()) * 2
+	if len(data) != len(d.Buckets)*size {
+		return nil, &InsufficientRegistersError{fmt.Sprintf("expected %v bytes, got %v", len(d.Buckets)*size, len(data))}
+	}
+
+	metrics := make([]metric, 0, len(d.Buckets))
+	for i, bound := range d.Buckets {
+		v, err := parseModbusData(d, data[i*size:(i+1)*size])
+		if err != nil {
+			return nil, fmt.Errorf("bucket %v: %w", bound, err)
+		}
+		if v < 0 || math.IsNaN(v) {
+			return nil, fmt.Errorf("bucket %v: invalid count %v", bound, v)
+		}
+
+		labels := make(map[string]string, len(d.Labels)+1)
+		for k, v := range d.Labels {
+			labels[k] = v
+		}
+		labels["le"] = strconv.FormatFloat(bound, 'g', -1, 64)
+
+		metrics = append(metrics, metric{d.Name, d.HelpText(), labels, v, d.MetricType, nil})
+	}
+
+	return metrics, nil
+}
+
+// histogramSeries is a single series of a histogram, assembled from the reads
+// of its bucket counts and sum.
+type histogramSeries struct {
+	name   string
+	help   string
+	labels map[string]string
+	// Counts of the individual buckets by upper bound.
+	buckets map[float64]float64
+	sum     float64
+}
+
+// histograms collects the series of the histograms of a scrape.
+type histograms struct {
+	series map[string]*histogramSeries
+	// Keys in order of appearance, for a deterministic registration order.
+	keys []string
+}
+
+func newHistograms() *histograms {
+	return &histograms{series: map[string]*histogramSeries{}}
+}
+
+// add adds a bucket count, identified by its "le" label, or the sum of a
+// histogram series.
+func (h *histograms) add(m metric) {
+	labels := make(map[string]string, len(m.Labels))
+	for k, v := range m.Labels {
+		labels[k] = v
+	}
+	le, isBucket := labels["le"]
+	delete(labels, "le")
+
+	names := keys(labels)
+	sort.Strings(names)
+	pairs := make([]string, 0, len(names))
+	for _, n := range names {
+		pairs = append(pairs, n+"="+labels[n])
+	}
+	key := m.Name + "{" + strings.Join(pairs, ",") + "}"
+
+	s, ok := h.series[key]
+	if !ok {
+		// Devices without a sum register expose NaN, which quantile
+		// estimations don't need.
+		s = &histogramSeries{name: m.Name, help: m.Help, labels: labels, sum: math.NaN()}
+		h.series[key] = s
+		h.keys = append(h.keys, key)
+	}
+
+	if !isBucket {
+		s.sum = m.Value
+		return
+	}
+	bound, _ := strconv.ParseFloat(le, 64)
+	if s.buckets == nil {
+		s.buckets = map[float64]float64{}
+	}
+	s.buckets[bound] = m.Value
+}
+
+// register registers one collector per histogram name with all its series.
+func (h *histograms) register(reg prometheus.Registerer) error {
+	collectors := map[string]*constCollector{}
+	order := []string{}
+
+	for _, key := range h.keys {
+		s := h.series[key]
+		// The sum alone can't be exposed, e.g. if the bucket read failed
+		// as part of a failed chunk.
+		if s.buckets == nil {
+			continue
+		}
+
+		bounds := make([]float64, 0, len(s.buckets))
+		for bound := range s.buckets {
+			bounds = append(bounds, bound)
+		}
+		sort.Float64s(bounds)
+
+		// Prometheus buckets are cumulative, the +Inf one is implied by
+		// the count.
+		var count uint64
+		buckets := make(map[float64]uint64, len(bounds))
+		for _, bound := range bounds {
+			count += uint64(s.buckets[bound])
+			if !math.IsInf(bound, 1) {
+				buckets[bound] = count
+			}
+		}
+
+		labelNames := keys(s.labels)
+		sort.Strings(labelNames)
+		labelValues := make([]string, 0, len(labelNames))
+		for _, n := range labelNames {
+			labelValues = append(labelValues, s.labels[n])
+		}
+
+		m, err := prometheus.NewConstHistogram(
+			prometheus.NewDesc(s.name, s.help, labelNames, nil),
+			count, s.sum, buckets, labelValues...,
+		)
+		if err != nil {
+			return fmt.Errorf("metric '%v', labels '%v': %v", s.name, s.labels, err)
+		}
+
+		c, ok := collectors[s.name]
+		if !ok {
+			c = &constCollector{}
+			collectors[s.name] = c
+			order = append(order, s.name)
+		}
+		c.metrics = append(c.metrics, m)
+	}
+
+	for _, name := range order {
+		if err := reg.Register(collectors[name]); err != nil {
+			return fmt.Errorf("failed to register metric %v: %v", name, err.Error())
+		}
+	}
+
+	return nil
+}
+
+// constCollector collects a fixed set of metrics of the same name.
+type constCollector struct {
+	metrics []prometheus.Metric
+}
+
+// Describe implements prometheus.Collector.
+func (c *constCollector) Describe(ch chan<- *prometheus.Desc) {
+	prometheus.DescribeByCollect(c, ch)
+}
+
+// Collect implements prometheus.Collector.
+func (c *constCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, m := range c.metrics {
+		ch <- m
+	}
+}
@@ -0,0 +1,96 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package modbus
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/RichiH/modbus_exporter/config"
+)
+
+// Handler serves scrapes of an Exporter over HTTP, to mount the exporter in
+// the mux of another program, e.g. the management daemon of a gateway:
+//
+//	h := modbus.NewHandler(c)
+//	go h.Exporter().Poll(ctx)
+//	mux.Handle("/exporter/", http.StripPrefix("/exporter", h))
+//
+// It serves scrapes at /modbus, taking the parameters of the exporter's own
+// /modbus endpoint as parsed by ParseScrapeRequest, and the telemetry of the
+// exporter at /metrics. Targets not allowed by the allowedTargets section of
+// the configuration are rejected with 403.
+type Handler struct {
+	exporter *Exporter
+	mux      *http.ServeMux
+}
+
+// NewHandler returns a handler scraping with a new exporter for the given
+// configuration and options.
+func NewHandler(c config.Config, opts ...Option) *Handler {
+	h := &Handler{exporter: NewExporter(c, opts...), mux: http.NewServeMux()}
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(h.exporter)
+	h.mux.Handle("/metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
+	h.mux.HandleFunc("/modbus", h.scrape)
+
+	return h
+}
+
+// Exporter returns the exporter of the handler, e.g. to poll targets in the
+// background or to update the configuration.
+func (h *Handler) Exporter() *Exporter {
+	return h.exporter
+}
+
+// ServeHTTP implements http.Handler.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mux.ServeHTTP(w, r)
+}
+
+func (h *Handler) scrape(w http.ResponseWriter, r *http.Request) {
+	c := h.exporter.GetConfig()
+	req, err := ParseScrapeRequest(c, r.URL.Query(), "")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	for _, address := range splitTargets(req.Target) {
+		if !c.TargetAllowed(address) {
+			http.Error(w, fmt.Sprintf("target '%v' not allowed", address), http.StatusForbidden)
+			return
+		}
+	}
+
+	ctx, cancel, err := ScrapeContext(r, 0)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer cancel()
+
+	g, err := h.exporter.ScrapeAll(ctx, req)
+	if err != nil {
+		moduleName := strings.Join(req.Modules, ",")
+		http.Error(w, fmt.Sprintf("failed to scrape target '%v' with module '%v': %v", req.Target, moduleName, err), DefaultStatusCodes.Code(err))
+		return
+	}
+
+	promhttp.HandlerFor(g, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+}
@@ -0,0 +1,100 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package modbus
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/RichiH/modbus_exporter/config"
+)
+
+func TestHandler(t *testing.T) {
+	s, address := startServer(t)
+	s.HoldingRegisters[22] = 240
+
+	c := testConfig()
+	c.Modules[0].SubTargetNames = map[string]int{"meter": 3}
+	c.AllowedTargets = &config.AllowedTargets{Targets: []string{address}}
+
+	mux := http.NewServeMux()
+	mux.Handle("/exporter/", http.StripPrefix("/exporter", NewHandler(c)))
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	get := func(path string, params url.Values) (int, string) {
+		resp, err := http.Get(srv.URL + path + "?" + params.Encode())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode, string(body)
+	}
+
+	for _, subTarget := range []string{"1", "meter"} {
+		code, body := get("/exporter/modbus", url.Values{"target": {address}, "module": {"my_module"}, "sub_target": {subTarget}})
+		if code != http.StatusOK || !strings.Contains(body, `my_metric{module="my_module"} 240`) {
+			t.Errorf("sub target %v: expected my_metric but got %v: %v", subTarget, code, body)
+		}
+	}
+
+	code, body := get("/exporter/modbus", url.Values{"target": {address}, "module": {"my_module"}, "sub_target": {"1"}, "extra_label[site]": {"berlin"}})
+	if code != http.StatusOK || !strings.Contains(body, `my_metric{module="my_module",site="berlin"} 240`) {
+		t.Errorf("expected my_metric with extra label but got %v: %v", code, body)
+	}
+
+	for _, test := range []struct {
+		params url.Values
+		code   int
+	}{
+		{url.Values{"target": {address}, "sub_target": {"1"}}, http.StatusBadRequest},
+		{url.Values{"target": {address}, "module": {"other"}, "sub_target": {"1"}}, http.StatusBadRequest},
+		{url.Values{"module": {"my_module"}, "sub_target": {"1"}}, http.StatusBadRequest},
+		{url.Values{"target": {address}, "module": {"my_module"}}, http.StatusBadRequest},
+		{url.Values{"target": {address}, "module": {"my_module"}, "sub_target": {"256"}}, http.StatusBadRequest},
+		{url.Values{"target": {"192.0.2.1:502"}, "module": {"my_module"}, "sub_target": {"1"}}, http.StatusForbidden},
+	} {
+		if code, body := get("/exporter/modbus", test.params); code != test.code {
+			t.Errorf("%v: expected %v but got %v: %v", test.params, test.code, code, body)
+		}
+	}
+
+	code, body = get("/exporter/metrics", nil)
+	if code != http.StatusOK || !strings.Contains(body, "modbus_target_scrapes_total") {
+		t.Errorf("expected telemetry but got %v: %v", code, body)
+	}
+}
+
+func TestHandlerScrapeFailure(t *testing.T) {
+	address := freeAddress(t)
+	srv := httptest.NewServer(NewHandler(testConfig()))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/modbus?" + url.Values{"target": {address}, "module": {"my_module"}, "sub_target": {"1"}}.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 for target refusing connections but got %v", resp.StatusCode)
+	}
+}
@@ -0,0 +1,212 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package modbus
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/goburrow/modbus"
+
+	"github.com/RichiH/modbus_exporter/config"
+)
+
+const (
+	// funcCodeEncapsulatedInterface is the function code of the Modbus
+	// encapsulated interface, used to read the device identification.
+	funcCodeEncapsulatedInterface = 0x2b
+	// meiReadDeviceIdentification is the MEI type reading the device
+	// identification.
+	meiReadDeviceIdentification = 0x0e
+	// readDeviceIDBasic requests the basic identification objects, vendor
+	// name, product code and revision.
+	readDeviceIDBasic = 0x01
+	// maxIdentificationResponses bounds the number of requests reading the
+	// identification objects, which the device may split up.
+	maxIdentificationResponses = 8
+)
+
+// identities remembers the identification of devices by target and sub
+// target.
+type identities struct {
+	mtx     sync.Mutex
+	entries map[string]identity
+}
+
+type identity struct {
+	identification string
+	expires        time.Time
+}
+
+func newIdentities() *identities {
+	return &identities{entries: map[string]identity{}}
+}
+
+func (i *identities) get(key string, now time.Time) (string, bool) {
+	i.mtx.Lock()
+	defer i.mtx.Unlock()
+
+	e, ok := i.entries[key]
+	if !ok || now.After(e.expires) {
+		delete(i.entries, key)
+		return "", false
+	}
+
+	return e.identification, true
+}
+
+func (i *identities) put(key, identification string, expires time.Time) {
+	i.mtx.Lock()
+	defer i.mtx.Unlock()
+
+	i.entries[key] = identity{identification, expires}
+}
+
+// Identify returns the name of the module selected for the device at the given
+// target and sub target by its identification. The identification is read via
+// function code 43 or from the configured register and remembered for the
+// configured cache TTL.
+func (e *Exporter) Identify(ctx context.Context, targetAddress string, subTarget byte) (string, error) {
+	c := e.GetConfig().Identification
+	if c == nil {
+		return "", fmt.Errorf("no identification configured to select a module")
+	}
+
+	key := fmt.Sprintf("%s/%d", targetAddress, subTarget)
+	identification, ok := e.identities.get(key, time.Now())
+	if !ok {
+		var err error
+		identification, err = e.readIdentification(ctx, targetAddress, subTarget, c)
+		if err != nil {
+			return "", fmt.Errorf("failed to identify device: %w", err)
+		}
+		e.identities.put(key, identification, time.Now().Add(time.Duration(c.CacheTTL)))
+	}
+
+	module, ok := c.ModuleFor(identification)
+	if !ok {
+		return "", fmt.Errorf("no module configured for device identified as '%v'", identification)
+	}
+
+	return module, nil
+}
+
+func (e *Exporter) readIdentification(ctx context.Context, targetAddress string, subTarget byte, c *config.Identification) (string, error) {
+	release, err := e.acquire(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer release()
+
+	handler, _, err := e.connect(ctx, splitTargets(targetAddress), subTarget, &config.Module{Name: "identification"})
+	if err != nil {
+		return "", err
+	}
+	defer handler.Close()
+
+	if c.Register != nil {
+		functionCode, address, err := c.Register.Address.Split()
+		if err != nil {
+			return "", err
+		}
+		data, err := readFunc(modbus.NewClient(handler), functionCode)(address, uint16(c.Register.Length))
+		if err != nil {
+			return "", fmt.Errorf("reading %v from address %v with function code %v: %w",
+				c.Register.Length, address, functionCode, err)
+		}
+		return parseString(data), nil
+	}
+
+	objects, err := readDeviceIdentification(handler)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.Join(objects, " "), nil
+}
+
+// readDeviceIdentification reads the basic device identification objects via
+// function code 43, vendor name, product code and revision.
+func readDeviceIdentification(h *ctxHandler) ([]string, error) {
+	objects := []string{}
+	objectID := byte(0)
+	for i := 0; i < maxIdentificationResponses; i++ {
+		data, err := send(h, &modbus.ProtocolDataUnit{
+			FunctionCode: funcCodeEncapsulatedInterface,
+			Data:         []byte{meiReadDeviceIdentification, readDeviceIDBasic, objectID},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("reading device identification: %w", err)
+		}
+
+		// MEI type, read device ID code, conformity level, more follows,
+		// next object ID and number of objects precede the objects.
+		if len(data) < 6 || data[0] != meiReadDeviceIdentification {
+			return nil, fmt.Errorf("invalid device identification response of %v bytes", len(data))
+		}
+		moreFollows, next, count := data[3], data[4], int(data[5])
+
+		rest := data[6:]
+		for j := 0; j < count; j++ {
+			if len(rest) < 2 || len(rest) < 2+int(rest[1]) {
+				return nil, fmt.Errorf("truncated device identification object")
+			}
+			objects = append(objects, parseString(rest[2:2+int(rest[1])]))
+			rest = rest[2+int(rest[1]):]
+		}
+
+		if moreFollows != 0xff {
+			return objects, nil
+		}
+		objectID = next
+	}
+
+	return nil, fmt.Errorf("device identification exceeds %v responses", maxIdentificationResponses)
+}
+
+// send sends a request the modbus client doesn't support and returns the
+// data of the response.
+func send(h *ctxHandler, request *modbus.ProtocolDataUnit) ([]byte, error) {
+	aduRequest, err := h.Encode(request)
+	if err != nil {
+		return nil, err
+	}
+	aduResponse, err := h.Send(aduRequest)
+	if err != nil {
+		return nil, err
+	}
+	if err := h.Verify(aduRequest, aduResponse); err != nil {
+		return nil, err
+	}
+	response, err := h.Decode(aduResponse)
+	if err != nil {
+		return nil, err
+	}
+
+	if response.FunctionCode == request.FunctionCode|0x80 {
+		if len(response.Data) == 0 {
+			return nil, fmt.Errorf("empty exception response")
+		}
+		return nil, &modbus.ModbusError{FunctionCode: request.FunctionCode, ExceptionCode: response.Data[0]}
+	}
+	if response.FunctionCode != request.FunctionCode {
+		return nil, fmt.Errorf("response function code %v doesn't match request function code %v",
+			response.FunctionCode, request.FunctionCode)
+	}
+
+	return response.Data, nil
+}
@@ -0,0 +1,126 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package modbus
+
+import (
+	"context"
+	"testing"
+
+	"github.com/tbrandon/mbserver"
+
+	"github.com/RichiH/modbus_exporter/config"
+)
+
+// deviceIdentification responds to read device identification requests with
+// the given vendor name, product code and revision.
+func deviceIdentification(objects ...string) func(*mbserver.Server, mbserver.Framer) ([]byte, *mbserver.Exception) {
+	return func(s *mbserver.Server, frame mbserver.Framer) ([]byte, *mbserver.Exception) {
+		data := []byte{0x0e, 0x01, 0x01, 0x00, 0x00, byte(len(objects))}
+		for i, o := range objects {
+			data = append(data, byte(i), byte(len(o)))
+			data = append(data, o...)
+		}
+		return data, &mbserver.Success
+	}
+}
+
+func TestScrapeIdentified(t *testing.T) {
+	s, address := startServer(t)
+	s.HoldingRegisters[1] = 1
+	s.HoldingRegisters[2] = 2
+	s.RegisterFunctionHandler(43, deviceIdentification("Eastron", "SDM630", "1.0"))
+
+	module := func(name string, address config.RegisterAddr) config.Module {
+		return config.Module{
+			Name:     name,
+			Protocol: config.ModbusProtocolTCPIP,
+			Timeout:  500,
+			Metrics: []config.MetricDef{
+				{Name: name + "_metric", Address: address, DataType: config.ModbusInt16, MetricType: config.MetricTypeGauge},
+			},
+		}
+	}
+	c := config.Config{
+		Modules: []config.Module{module("sdm120", 300001), module("sdm630", 300002)},
+		Identification: &config.Identification{
+			CacheTTL: config.DefaultIdentificationCacheTTL,
+			Modules: []config.IdentifiedModule{
+				{Match: "^Eastron SDM120 ", Module: "sdm120"},
+				{Match: "^Eastron SDM630 ", Module: "sdm630"},
+			},
+		},
+	}
+	e := NewExporter(c)
+
+	g, err := e.scrapeIdentified(context.Background(), address, []SubTarget{{1, "1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mfs, err := g.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mfs) != 1 || mfs[0].GetName() != "sdm630_metric" || mfs[0].Metric[0].GetGauge().GetValue() != 2 {
+		t.Fatalf("expected the metric of module sdm630 but got %v", mfs)
+	}
+
+	// The identification is remembered.
+	s.RegisterFunctionHandler(43, deviceIdentification("Eastron", "SDM120", "1.0"))
+	moduleName, err := e.Identify(context.Background(), address, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if moduleName != "sdm630" {
+		t.Fatalf("expected remembered module sdm630 but got %v", moduleName)
+	}
+
+	moduleName, err = e.Identify(context.Background(), address, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if moduleName != "sdm120" {
+		t.Fatalf("expected sub target 2 to be identified separately as sdm120 but got %v", moduleName)
+	}
+
+	s.RegisterFunctionHandler(43, deviceIdentification("Acme", "Meter", "1.0"))
+	if _, err := e.scrapeIdentified(context.Background(), address, []SubTarget{{3, "3"}}); err == nil {
+		t.Fatal("expected error for device without matching module")
+	}
+}
+
+func TestIdentifyRegister(t *testing.T) {
+	s, address := startServer(t)
+	// "SDM120"
+	s.HoldingRegisters[10] = 0x5344
+	s.HoldingRegisters[11] = 0x4d31
+	s.HoldingRegisters[12] = 0x3230
+
+	c := config.Config{
+		Modules: []config.Module{{Name: "sdm120"}},
+		Identification: &config.Identification{
+			Register: &config.LabelRegister{Address: 300010, Length: 4},
+			CacheTTL: config.DefaultIdentificationCacheTTL,
+			Modules:  []config.IdentifiedModule{{Match: "^SDM120$", Module: "sdm120"}},
+		},
+	}
+	e := NewExporter(c)
+
+	moduleName, err := e.Identify(context.Background(), address, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if moduleName != "sdm120" {
+		t.Fatalf("expected module sdm120 but got %v", moduleName)
+	}
+}
@@ -0,0 +1,60 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package modbus
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/RichiH/modbus_exporter/config"
+	"github.com/goburrow/modbus"
+)
+
+// readLabels executes the given label blocks of a read plan and returns a copy
+// of the given definitions with the label values read added to their labels.
+func readLabels(blocks []config.ReadBlock, definitions []config.MetricDef, c modbus.Client) ([]config.MetricDef, error) {
+	// Copy the labels, they are shared with the metric definitions of the
+	// config across concurrent scrapes.
+	labeled := make([]config.MetricDef, len(definitions))
+	for i, def := range definitions {
+		if len(def.LabelRegisters) > 0 {
+			labels := make(map[string]string, len(def.Labels)+len(def.LabelRegisters))
+			for k, v := range def.Labels {
+				labels[k] = v
+			}
+			def.Labels = labels
+		}
+		labeled[i] = def
+	}
+
+	for _, block := range blocks {
+		data, err := readFunc(c, block.FunctionCode)(block.Address, block.Quantity)
+		if err != nil {
+			return nil, fmt.Errorf("reading labels, %v registers from address %v with function code %v: %w",
+				block.Quantity, block.Address, block.FunctionCode, err)
+		}
+
+		for _, r := range block.Reads {
+			labeled[r.Metric].Labels[r.Label] = parseString(blockData(block.FunctionCode, data, r))
+		}
+	}
+
+	return labeled, nil
+}
+
+// parseString interprets the given register data as ASCII string, two
+// characters per register, trimming trailing NUL characters and spaces.
+func parseString(data []byte) string {
+	return strings.ToValidUTF8(strings.TrimRight(string(data), "\x00 "), "")
+}
@@ -0,0 +1,99 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package modbus
+
+import (
+	"context"
+	"testing"
+
+	"github.com/RichiH/modbus_exporter/config"
+	"github.com/goburrow/modbus"
+)
+
+func TestScrapeLabelRegisters(t *testing.T) {
+	s, address := startServer(t)
+	s.HoldingRegisters[22] = 240
+	// "Pump 1" followed by NUL padding.
+	s.HoldingRegisters[100] = 'P'<<8 | 'u'
+	s.HoldingRegisters[101] = 'm'<<8 | 'p'
+	s.HoldingRegisters[102] = ' '<<8 | '1'
+	s.HoldingRegisters[103] = 0
+
+	c := testConfig()
+	c.Modules[0].Metrics[0].LabelRegisters = map[string]config.LabelRegister{
+		"channel": {Address: 300100, Length: 4},
+	}
+
+	e := NewExporter(c)
+	gatherer, err := e.Scrape(context.Background(), address, 1, "my_module")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	metricFamilies, err := gatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, mf := range metricFamilies {
+		if mf.GetName() != "my_metric" {
+			continue
+		}
+		for _, l := range mf.Metric[0].GetLabel() {
+			if l.GetName() == "channel" {
+				if l.GetValue() != "Pump 1" {
+					t.Fatalf("expected channel label to be 'Pump 1' but got %q", l.GetValue())
+				}
+				return
+			}
+		}
+	}
+	t.Fatal("expected my_metric with channel label")
+}
+
+func TestReadLabelsKeepsDefinitions(t *testing.T) {
+	definitions := []config.MetricDef{
+		{
+			Name:           "my_metric",
+			Labels:         map[string]string{"phase": "1"},
+			LabelRegisters: map[string]config.LabelRegister{"channel": {Address: 300100, Length: 1}},
+		},
+	}
+	block := config.ReadBlock{
+		FunctionCode: config.FuncCodeReadHoldingRegisters,
+		Address:      100,
+		Quantity:     1,
+		Reads:        []config.PlannedRead{{Metric: 0, Quantity: 1, Label: "channel"}},
+	}
+
+	s, address := startServer(t)
+	s.HoldingRegisters[100] = 'A'<<8 | ' '
+	h, _, err := NewExporter(config.Config{}).connect(context.Background(), []string{address}, 1, &config.Module{Timeout: 500})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+
+	labeled, err := readLabels([]config.ReadBlock{block}, definitions, modbus.NewClient(h))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v := labeled[0].Labels["channel"]; v != "A" {
+		t.Fatalf("expected channel label to be 'A' but got %q", v)
+	}
+	if len(definitions[0].Labels) != 1 {
+		t.Fatalf("expected labels of the definition not to be modified but got %v", definitions[0].Labels)
+	}
+}
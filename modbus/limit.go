@@ -0,0 +1,83 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package modbus
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrTooManyScrapes is returned by Scrape whenever the concurrency limit is
+// reached and no queue slot is left.
+var ErrTooManyScrapes = errors.New("too many concurrent scrapes")
+
+// limiter bounds the number of concurrent scrapes. Scrapes exceeding the limit
+// wait in a bounded queue or are rejected once the queue is full.
+type limiter struct {
+	slots chan struct{}
+	queue chan struct{}
+}
+
+func newLimiter(scrapes, queued int) *limiter {
+	if scrapes <= 0 {
+		return nil
+	}
+
+	return &limiter{
+		slots: make(chan struct{}, scrapes),
+		queue: make(chan struct{}, queued),
+	}
+}
+
+// acquire blocks until the scrape may proceed or the context is done and
+// returns a function releasing the slot again.
+func (e *Exporter) acquire(ctx context.Context) (func(), error) {
+	release := func() {
+		e.telemetry.scrapesInFlight.Dec()
+	}
+
+	l := e.limiter
+	if l == nil {
+		e.telemetry.scrapesInFlight.Inc()
+		return release, nil
+	}
+
+	select {
+	case l.slots <- struct{}{}:
+	default:
+		select {
+		case l.queue <- struct{}{}:
+		default:
+			e.telemetry.scrapesRejected.Inc()
+			return nil, ErrTooManyScrapes
+		}
+
+		e.telemetry.scrapesQueued.Inc()
+		select {
+		case l.slots <- struct{}{}:
+		case <-ctx.Done():
+			<-l.queue
+			e.telemetry.scrapesQueued.Dec()
+			return nil, ctx.Err()
+		}
+		<-l.queue
+		e.telemetry.scrapesQueued.Dec()
+	}
+
+	e.telemetry.scrapesInFlight.Inc()
+	return func() {
+		release()
+		<-l.slots
+	}, nil
+}
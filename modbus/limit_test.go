@@ -0,0 +1,64 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package modbus
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/RichiH/modbus_exporter/config"
+)
+
+func TestAcquireRejectsWhenExhausted(t *testing.T) {
+	e := NewExporter(config.Config{}, WithMaxConcurrency(1, 0))
+
+	release, err := e.acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := e.acquire(context.Background()); !errors.Is(err, ErrTooManyScrapes) {
+		t.Fatalf("expected ErrTooManyScrapes but got %v", err)
+	}
+
+	release()
+
+	release, err = e.acquire(context.Background())
+	if err != nil {
+		t.Fatalf("expected slot to be free again but got %v", err)
+	}
+	release()
+}
+
+func TestAcquireQueues(t *testing.T) {
+	e := NewExporter(config.Config{}, WithMaxConcurrency(1, 1))
+
+	release, err := e.acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	acquired := make(chan func())
+	go func() {
+		r, err := e.acquire(context.Background())
+		if err != nil {
+			t.Error(err)
+		}
+		acquired <- r
+	}()
+
+	release()
+	(<-acquired)()
+}
@@ -14,6 +14,11 @@
 package modbus
 
 import (
+	"sync"
+
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/proto"
+
 	"github.com/RichiH/modbus_exporter/config"
 )
 
@@ -23,4 +28,76 @@ type metric struct {
 	Labels     map[string]string
 	Value      float64
 	MetricType config.MetricType
+	// Transform of the value, applied once all metrics of the scrape are
+	// parsed.
+	Transform *config.Transform
+}
+
+// staticGatherer returns copies of the gathered result of a scrape, so it may
+// be gathered repeatedly, e.g. when served from a cache.
+type staticGatherer []*dto.MetricFamily
+
+// Gather implements prometheus.Gatherer.
+func (g staticGatherer) Gather() ([]*dto.MetricFamily, error) {
+	mfs := make([]*dto.MetricFamily, 0, len(g))
+	for _, mf := range g {
+		mfs = append(mfs, proto.Clone(mf).(*dto.MetricFamily))
+	}
+
+	return mfs, nil
+}
+
+// scrapeFailures collects the partial failures of a scrape, which leave out
+// the affected metrics rather than failing the scrape: the names of the metric
+// definitions whose registers failed to parse and the number of responses
+// shorter than requested. It is safe for concurrent use.
+type scrapeFailures struct {
+	mtx            sync.Mutex
+	names          []string
+	shortResponses int
+}
+
+func (f *scrapeFailures) add(name string) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	f.names = append(f.names, name)
+}
+
+func (f *scrapeFailures) addShortResponse() {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	f.shortResponses++
+}
+
+// applyTransforms replaces the values of the metrics having a transform with
+// its result. Transforms see the parsed values of the other metrics, so they
+// don't depend on the order of the metrics. Metrics whose transform fails,
+// e.g. as a metric it refers to wasn't read, are left out.
+func applyTransforms(metrics []metric, failures *scrapeFailures) []metric {
+	values := map[string]float64{}
+	transformed := false
+	for _, m := range metrics {
+		values[m.Name] = m.Value
+		transformed = transformed || m.Transform != nil
+	}
+	if !transformed {
+		return metrics
+	}
+
+	result := make([]metric, 0, len(metrics))
+	for _, m := range metrics {
+		if m.Transform != nil {
+			v, err := m.Transform.Eval(m.Value, values)
+			if err != nil {
+				failures.add(m.Name)
+				continue
+			}
+			m.Value = v
+		}
+		result = append(result, m)
+	}
+
+	return result
 }
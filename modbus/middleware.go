@@ -0,0 +1,78 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package modbus
+
+import (
+	"fmt"
+
+	"github.com/RichiH/modbus_exporter/config"
+)
+
+// Sample is a value read by a scrape, passed to middleware before it is
+// exposed as series of the metric of its name. Names are without the metric
+// prefix of the module and labels without the ones of the module, which are
+// added afterwards. Histograms are passed as a sample per bucket, with the
+// upper bound of the bucket as "le" label, and one without "le" label holding
+// the sum, if read.
+type Sample struct {
+	Name       string
+	Help       string
+	Labels     map[string]string
+	Value      float64
+	MetricType config.MetricType
+}
+
+// Middleware inspects and modifies the samples of a scrape of the given target
+// and module before they are exposed, e.g. to rename or drop metrics, add
+// labels or convert units, and returns the samples to expose. The label maps
+// of the samples are copies, which may be modified. An error fails the
+// scrape.
+type Middleware func(target string, subTarget byte, module *config.Module, samples []Sample) ([]Sample, error)
+
+// applyMiddleware passes the metrics of a scrape through the middleware of
+// the exporter in order of registration.
+func (e *Exporter) applyMiddleware(target string, subTarget byte, module *config.Module, metrics []metric) ([]metric, error) {
+	if len(e.middleware) == 0 {
+		return metrics, nil
+	}
+
+	samples := make([]Sample, 0, len(metrics))
+	for _, m := range metrics {
+		labels := make(map[string]string, len(m.Labels))
+		for k, v := range m.Labels {
+			labels[k] = v
+		}
+		samples = append(samples, Sample{m.Name, m.Help, labels, m.Value, m.MetricType})
+	}
+
+	for _, mw := range e.middleware {
+		var err error
+		samples, err = mw(target, subTarget, module, samples)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	metrics = make([]metric, 0, len(samples))
+	for _, s := range samples {
+		switch s.MetricType {
+		case config.MetricTypeGauge, config.MetricTypeCounter, config.MetricTypeHistogram:
+		default:
+			return nil, fmt.Errorf("sample of metric %v has invalid metric type '%v'", s.Name, s.MetricType)
+		}
+		metrics = append(metrics, metric{s.Name, s.Help, s.Labels, s.Value, s.MetricType, nil})
+	}
+
+	return metrics, nil
+}
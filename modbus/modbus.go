@@ -11,85 +11,551 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
+// Package modbus scrapes Modbus devices according to the modules of a
+// configuration and returns the values read as Prometheus metrics. Besides
+// the Exporter serving scrapes, the read plan of a module can be executed
+// step by step with Exporter.ReadRaw and ParseBlock, e.g. by programs
+// embedding the register maps in daemons of their own.
 package modbus
 
 import (
+	"context"
+	"crypto/tls"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"math"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
+	promconfig "github.com/prometheus/common/config"
 
 	"github.com/RichiH/modbus_exporter/config"
 	"github.com/goburrow/modbus"
+	"golang.org/x/sync/singleflight"
 )
 
 // Exporter represents a Prometheus exporter converting modbus information
 // retrieved from remote targets via TCP as Prometheus style metrics.
 type Exporter struct {
-	config config.Config
+	configMtx     sync.RWMutex
+	config        config.Config
+	configChanged chan struct{}
+
+	limiter   *limiter
+	telemetry *telemetry
+
+	// inFlight deduplicates concurrent identical scrapes, e.g. by HA
+	// Prometheus pairs.
+	inFlight singleflight.Group
+	cache    *scrapeCache
+	polled   *polledResults
+
+	lastScrapes *lastScrapes
+	statuses    *targetStatuses
+	counters    *counterStates
+	identities  *identities
+
+	// telemetryExpiry is the duration after which the telemetry series of
+	// targets not scraped anymore are removed, 0 keeping them.
+	telemetryExpiry time.Duration
+
+	// writes enables Write, the only code path writing to targets.
+	writes bool
+	// replays holds the recordings answering replay: targets, nil if
+	// disabled.
+	replays *replays
+
+	pollListeners    []func(config.PollTarget, prometheus.Gatherer)
+	scrapeListeners  []func(TargetStatus)
+	requestListeners []func(Request)
+	frameListeners   []func(Frame)
+	middleware       []Middleware
+}
+
+// Option configures optional behaviour of an Exporter.
+type Option func(*Exporter)
+
+// WithMaxConcurrency limits the number of scrapes talking to targets at the
+// same time. Up to queued further scrapes wait for a free slot, any others
+// fail with ErrTooManyScrapes. A limit of 0 disables limiting.
+func WithMaxConcurrency(scrapes, queued int) Option {
+	return func(e *Exporter) {
+		e.limiter = newLimiter(scrapes, queued)
+	}
+}
+
+// WithPollListener registers a function called with the result of every
+// successful poll of a target polled in the background, e.g. to publish it
+// elsewhere.
+func WithPollListener(f func(config.PollTarget, prometheus.Gatherer)) Option {
+	return func(e *Exporter) {
+		e.pollListeners = append(e.pollListeners, f)
+	}
+}
+
+// WithScrapeListener registers a function called with the outcome of every
+// scrape talking to a target, e.g. to log it. Scrapes served from cache or
+// background polls don't talk to targets.
+func WithScrapeListener(f func(TargetStatus)) Option {
+	return func(e *Exporter) {
+		e.scrapeListeners = append(e.scrapeListeners, f)
+	}
+}
+
+// WithRequestListener registers a function called with every request sent to
+// a target while scraping, e.g. to show the timing of the blocks of a module.
+func WithRequestListener(f func(Request)) Option {
+	return func(e *Exporter) {
+		e.requestListeners = append(e.requestListeners, f)
+	}
+}
+
+// WithFrameListener registers a function called with every request ADU sent
+// to a target and the response ADU received, by scrapes, polls, writes and
+// raw reads alike, e.g. to capture wire traces.
+func WithFrameListener(f func(Frame)) Option {
+	return func(e *Exporter) {
+		e.frameListeners = append(e.frameListeners, f)
+	}
+}
+
+// WithMiddleware registers middleware modifying the samples of every scrape
+// talking to a target before they are exposed. Middleware is called in order
+// of registration.
+func WithMiddleware(m Middleware) Option {
+	return func(e *Exporter) {
+		e.middleware = append(e.middleware, m)
+	}
+}
+
+// WithTelemetryExpiry removes the telemetry series and status of targets not
+// scraped for the given duration, e.g. decommissioned devices. 0 keeps them.
+func WithTelemetryExpiry(d time.Duration) Option {
+	return func(e *Exporter) {
+		e.telemetryExpiry = d
+	}
+}
+
+// WithWrites enables writing to targets via Write, which fails with
+// ErrReadOnly otherwise.
+func WithWrites() Option {
+	return func(e *Exporter) {
+		e.writes = true
+	}
+}
+
+// WithReplays enables targets of the form replay:<file> answered from a
+// recording written by WriteRecording instead of a device, e.g. to reproduce
+// the scrapes of a device offline. As they read local files, they must not be
+// enabled for exporters scraping targets passed by untrusted clients.
+func WithReplays() Option {
+	return func(e *Exporter) {
+		e.replays = &replays{loaded: map[string]*replay{}}
+	}
 }
 
 // NewExporter returns a new modbus exporter.
-func NewExporter(config config.Config) *Exporter {
-	return &Exporter{config}
+func NewExporter(config config.Config, opts ...Option) *Exporter {
+	e := &Exporter{
+		config:        config,
+		configChanged: make(chan struct{}, 1),
+		telemetry:     newTelemetry(),
+		cache:         newScrapeCache(),
+		polled:        &polledResults{results: map[string]pollResult{}},
+
+		lastScrapes: newLastScrapes(),
+		statuses:    newTargetStatuses(),
+		counters:    newCounterStates(),
+		identities:  newIdentities(),
+	}
+	for _, opt := range opts {
+		opt(e)
+	}
+
+	return e
 }
 
 // GetConfig loads the config file
 func (e *Exporter) GetConfig() *config.Config {
-	return &e.config
+	e.configMtx.RLock()
+	defer e.configMtx.RUnlock()
+
+	c := e.config
+	return &c
+}
+
+// SetConfig replaces the configuration of the exporter, e.g. on reload.
+// Background polling is restarted with the targets of the new configuration.
+func (e *Exporter) SetConfig(c config.Config) {
+	e.configMtx.Lock()
+	e.config = c
+	e.configMtx.Unlock()
+
+	select {
+	case e.configChanged <- struct{}{}:
+	default:
+	}
 }
 
 // Scrape scrapes the given target via TCP based on the configuration of the
 // specified module returning a Prometheus gatherer with the resulting metrics.
-func (e *Exporter) Scrape(targetAddress string, subTarget byte, moduleName string) (prometheus.Gatherer, error) {
-	reg := prometheus.NewRegistry()
-
-	module := e.config.GetModule(moduleName)
+//
+// The call returns once the given context is done. Concurrent calls with
+// identical arguments share one underlying scrape and receive the same result,
+// which isn't aborted by the context of any one of them.
+// If the module has a cache TTL configured, results are served from cache
+// until they expire. Scrapes arriving within the minimum interval of the
+// module get the previous result or ErrThrottled. Targets polled in the
+// background are served from their latest poll.
+func (e *Exporter) Scrape(ctx context.Context, targetAddress string, subTarget byte, moduleName string) (prometheus.Gatherer, error) {
+	module := e.GetConfig().GetModule(moduleName)
 	if module == nil {
 		return nil, fmt.Errorf("failed to find '%v' in config", moduleName)
 	}
 
-	// TODO: We should probably be reusing these, right?
-	handler := modbus.NewTCPClientHandler(targetAddress)
-	if module.Timeout != 0 {
-		handler.Timeout = time.Duration(module.Timeout) * time.Millisecond
+	key := scrapeKey(targetAddress, subTarget, moduleName)
+	if r, ok := e.polled.get(key); ok {
+		return r.gatherer, r.err
+	}
+
+	ttl := time.Duration(module.CacheTTL)
+	if ttl > 0 {
+		if g, ok := e.cache.get(key, time.Now()); ok {
+			e.telemetry.scrapeCacheHits.Inc()
+			return g, nil
+		}
+	}
+
+	minInterval := time.Duration(module.MinInterval)
+	if minInterval > 0 {
+		if throttled, g := e.lastScrapes.throttled(key, time.Now(), minInterval); throttled {
+			e.telemetry.scrapesThrottled.Inc()
+			if g == nil {
+				return nil, ErrThrottled
+			}
+			return g, nil
+		}
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	// The shared scrape must not be aborted when the caller starting it goes
+	// away while others still wait for it. It runs detached from that caller,
+	// bounded by the module timeout or else by the deadline of the caller.
+	// Shared is reported to the caller running the scrape as well, only the
+	// ones joining it are deduplicated.
+	leader := false
+	ch := e.inFlight.DoChan(key, func() (interface{}, error) {
+		leader = true
+		sctx := context.Context(detachedContext{ctx})
+		if deadline, ok := ctx.Deadline(); ok && module.ScrapeTimeout == 0 {
+			var cancel context.CancelFunc
+			sctx, cancel = context.WithDeadline(sctx, deadline)
+			defer cancel()
+		}
+
+		now := time.Now()
+		g, err := e.scrape(sctx, targetAddress, subTarget, module)
+		if err == nil && ttl > 0 {
+			e.cache.put(key, g, now, time.Now().Add(ttl))
+		}
+		if minInterval > 0 {
+			e.lastScrapes.record(key, now, minInterval, g)
+		}
+		return g, err
+	})
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case r := <-ch:
+		if r.Shared && !leader {
+			e.telemetry.scrapesDeduplicated.Inc()
+		}
+		if r.Err != nil {
+			return nil, r.Err
+		}
+		return r.Val.(prometheus.Gatherer), nil
 	}
-	handler.SlaveId = subTarget
-	if err := handler.Connect(); err != nil {
-		return nil, fmt.Errorf("unable to connect with target %s via module %s",
-			targetAddress, module.Name)
+}
+
+// detachedContext carries the values of its parent but neither its deadline
+// nor its cancellation, like context.WithoutCancel of Go 1.21.
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+
+func (detachedContext) Done() <-chan struct{} { return nil }
+
+func (detachedContext) Err() error { return nil }
+
+func (c detachedContext) Value(key interface{}) interface{} { return c.parent.Value(key) }
+
+// ScrapeModule scrapes the given module from the target like Scrape, for
+// modules not part of the configuration of the exporter, e.g. ones built by
+// programs embedding it. Results of background polls, the cache and the
+// minimum interval don't apply. The module should have been validated as part
+// of a configuration, see config.Parse.
+func (e *Exporter) ScrapeModule(ctx context.Context, targetAddress string, subTarget byte, module *config.Module) (prometheus.Gatherer, error) {
+	return e.scrape(ctx, targetAddress, subTarget, module)
+}
+
+func (e *Exporter) scrape(ctx context.Context, targetAddress string, subTarget byte, module *config.Module) (g prometheus.Gatherer, err error) {
+	start := time.Now()
+	blocks := 0
+	defer func() {
+		status := e.statuses.record(targetAddress, subTarget, module.Name, start, blocks, err)
+		for _, l := range e.scrapeListeners {
+			l(status)
+		}
+		e.telemetry.scrapes.WithLabelValues(targetAddress, module.Name, ScrapeStatus(err)).Inc()
+		e.telemetry.scrapeDuration.WithLabelValues(targetAddress, module.Name).Observe(time.Since(start).Seconds())
+	}()
+
+	reg := prometheus.NewRegistry()
+	moduleName := module.Name
+
+	if module.ScrapeTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(module.ScrapeTimeout))
+		defer cancel()
 	}
 
-	// TODO: Should we reuse this?
-	c := modbus.NewClient(handler)
+	plan, err := module.ReadPlan()
+	if err != nil {
+		return nil, fmt.Errorf("invalid read plan for module '%v': %v", moduleName, err)
+	}
+
+	release, err := e.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	// The target may be an ordered, comma separated list of addresses of
+	// redundant gateways. The first one accepting a connection is used.
+	addresses := splitTargets(targetAddress)
+	handler, path, err := e.connect(ctx, addresses, subTarget, module)
+	if err != nil {
+		return nil, err
+	}
 
 	// Close tcp connection.
 	defer handler.Close()
+	handlers := []*ctxHandler{handler}
+
+	// Devices tolerating concurrent requests are read via several
+	// connections to the same address in parallel. If the device refuses
+	// further connections, make do with the ones established.
+	for i := 1; i < module.Parallelism; i++ {
+		h, _, err := e.connect(ctx, addresses[path:path+1], subTarget, module)
+		if err != nil {
+			break
+		}
+		defer h.Close()
+		handlers = append(handlers, h)
+	}
+
+	requests := e.telemetry.requests(targetAddress, subTarget, moduleName)
+	requests.listeners = e.requestListeners
+	for _, h := range handlers {
+		h.telemetry = requests
+	}
+
+	blocks = len(plan.LabelBlocks) + len(plan.Blocks)
+	definitions := module.Metrics
+	if len(plan.LabelBlocks) > 0 {
+		definitions, err = readLabels(plan.LabelBlocks, module.Metrics, modbus.NewClient(handler))
+		if err != nil {
+			return nil, fmt.Errorf("failed to scrape metrics for module '%v': %w", moduleName, err)
+		}
+	}
+
+	failures := &scrapeFailures{}
+	defer func() {
+		for _, name := range failures.names {
+			e.telemetry.metricParseErrors.WithLabelValues(
+				targetAddress, strconv.Itoa(int(subTarget)), moduleName, name,
+			).Inc()
+		}
+		if failures.shortResponses > 0 {
+			e.telemetry.shortResponses.WithLabelValues(
+				targetAddress, strconv.Itoa(int(subTarget)), moduleName,
+			).Add(float64(failures.shortResponses))
+		}
+	}()
+
+	var metrics []metric
+	if module.ChunkSize > 0 {
+		var results []chunkResult
+		metrics, results, err = scrapeChunks(ctx, plan.Blocks, definitions, module.ChunkSize, handlers, failures)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scrape metrics for module '%v': %w", moduleName, err)
+		}
+		if err := registerChunkResults(reg, results); err != nil {
+			return nil, err
+		}
+	} else {
+		// TODO: Should we reuse this?
+		clients := []modbus.Client{}
+		for _, h := range handlers {
+			clients = append(clients, modbus.NewClient(h))
+		}
+
+		metrics, err = scrapeMetrics(plan.Blocks, definitions, clients, failures)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scrape metrics for module '%v': %w", moduleName, err)
+		}
+	}
+
+	metrics = applyTransforms(metrics, failures)
 
-	metrics, err := scrapeMetrics(module.Metrics, c)
+	if module.SunSpec != nil {
+		sunspec, err := scrapeSunSpec(module.SunSpec, modbus.NewClient(handler))
+		if err != nil {
+			return nil, fmt.Errorf("failed to scrape SunSpec models for module '%v': %w", moduleName, err)
+		}
+		metrics = append(metrics, sunspec...)
+	}
+
+	metrics, err = e.applyMiddleware(targetAddress, subTarget, module, metrics)
 	if err != nil {
-		return nil, fmt.Errorf("failed to scrape metrics for module '%v': %v", moduleName, err.Error())
+		return nil, fmt.Errorf("middleware failed for module '%v': %w", moduleName, err)
 	}
 
-	if err := registerMetrics(reg, moduleName, metrics); err != nil {
+	if err := registerMetrics(reg, module, metrics); err != nil {
 		return nil, fmt.Errorf("failed to register metrics for module %v: %v", moduleName, err.Error())
 	}
 
-	return reg, nil
+	var timestamp time.Time
+	if module.TimestampRegister != nil {
+		timestamp, err = readTimestamp(module.TimestampRegister, modbus.NewClient(handler))
+		if err != nil {
+			return nil, fmt.Errorf("failed to scrape metrics for module '%v': %w", moduleName, err)
+		}
+		if err := registerTimestamp(reg, timestamp); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(addresses) > 1 {
+		targetPath := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "modbus_target_path",
+			Help: "Position of the address in the target list the scrape was served through, 0 being the primary.",
+		}, []string{"address"})
+		targetPath.WithLabelValues(addresses[path]).Set(float64(path))
+
+		if err := reg.Register(targetPath); err != nil {
+			return nil, fmt.Errorf("failed to register metric modbus_target_path: %v", err.Error())
+		}
+	}
+
+	mfs, err := reg.Gather()
+	if err != nil {
+		return nil, fmt.Errorf("failed to gather metrics for module %v: %v", moduleName, err)
+	}
+	e.counters.record(scrapeKey(targetAddress, subTarget, moduleName), mfs, time.Now())
+	if !timestamp.IsZero() {
+		setTimestamps(mfs, module, timestamp)
+	}
+
+	return staticGatherer(mfs), nil
+}
+
+// splitTargets splits a comma separated list of target addresses, dropping
+// empty entries.
+func splitTargets(targetAddress string) []string {
+	addresses := []string{}
+	for _, a := range strings.Split(targetAddress, ",") {
+		if a = strings.TrimSpace(a); a != "" {
+			addresses = append(addresses, a)
+		}
+	}
+
+	return addresses
 }
 
-func registerMetrics(reg prometheus.Registerer, moduleName string, metrics []metric) error {
+// connect tries to connect to the given addresses in order and returns a
+// handler for the first one reachable together with its position in the list.
+func (e *Exporter) connect(ctx context.Context, addresses []string, subTarget byte, module *config.Module) (*ctxHandler, int, error) {
+	// The TLS configuration is built on every connect to pick up renewed
+	// certificates.
+	var tlsConfig *tls.Config
+	if module.TLSConfig != nil {
+		var err error
+		tlsConfig, err = promconfig.NewTLSConfig(module.TLSConfig)
+		if err != nil {
+			return nil, 0, fmt.Errorf("%w %s via module %s: %v",
+				ErrConnect, strings.Join(addresses, ","), module.Name, err)
+		}
+	}
+
+	for i, address := range addresses {
+		if e.replays != nil && strings.HasPrefix(address, ReplayScheme) {
+			r, err := e.replays.get(address)
+			if err != nil {
+				return nil, 0, fmt.Errorf("%w %s via module %s: %v", ErrConnect, address, module.Name, err)
+			}
+			h := modbus.NewTCPClientHandler(address)
+			h.SlaveId = subTarget
+			handler := newCtxHandler(ctx, h)
+			handler.replay = r
+			handler.frameListeners = e.frameListeners
+			return handler, i, nil
+		}
+
+		// TODO: We should probably be reusing these, right?
+		h := modbus.NewTCPClientHandler(address)
+		if timeout := module.TimeoutFor(subTarget); timeout != 0 {
+			h.Timeout = time.Duration(timeout) * time.Millisecond
+		}
+		h.SlaveId = subTarget
+		handler := newCtxHandler(ctx, h)
+		handler.busyRetries = module.BusyRetries
+		handler.busyRetryDelay = time.Duration(module.BusyRetryDelay)
+		handler.ackPollInterval = time.Duration(module.AcknowledgePollInterval)
+		handler.ackTimeout = time.Duration(module.AcknowledgeTimeout)
+		handler.tlsConfig = tlsConfig
+		handler.frameListeners = e.frameListeners
+		if err := handler.Connect(); err == nil {
+			return handler, i, nil
+		} else if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, 0, ctxErr
+		}
+	}
+
+	return nil, 0, fmt.Errorf("%w %s via module %s",
+		ErrConnect, strings.Join(addresses, ","), module.Name)
+}
+
+func registerMetrics(reg prometheus.Registerer, module *config.Module, metrics []metric) error {
 	registeredGauges := map[string]*prometheus.GaugeVec{}
 	registeredCounters := map[string]*prometheus.CounterVec{}
+	histograms := newHistograms()
 
 	for _, m := range metrics {
-		if m.Labels == nil {
-			m.Labels = map[string]string{}
+		// Copy the labels, they are shared with the metric definition
+		// of the config across concurrent scrapes.
+		labels := make(map[string]string, len(module.Labels)+len(m.Labels)+1)
+		for k, v := range module.Labels {
+			labels[k] = v
+		}
+		for k, v := range m.Labels {
+			labels[k] = v
 		}
-		m.Labels["module"] = moduleName
+		if module.HasModuleLabel() {
+			labels["module"] = module.Name
+		}
+		m.Labels = labels
+		m.Name = module.MetricName(m.Name)
 
 		switch m.MetricType {
 		case config.MetricTypeGauge:
@@ -149,11 +615,15 @@ func registerMetrics(reg prometheus.Registerer, moduleName string, metrics []met
 					m.Name, m.MetricType, m.Value, m.Labels, err,
 				)
 			}
+		case config.MetricTypeHistogram:
+			// The bucket counts and the sum are read separately and
+			// assembled once all are known.
+			histograms.add(m)
 		}
 
 	}
 
-	return nil
+	return histograms.register(reg)
 }
 
 func keys(m map[string]string) []string {
@@ -165,100 +635,151 @@ func keys(m map[string]string) []string {
 	return keys
 }
 
-func scrapeMetrics(definitions []config.MetricDef, c modbus.Client) ([]metric, error) {
-	metrics := []metric{}
-
-	if len(definitions) == 0 {
+// scrapeMetrics executes the given blocks of a read plan, spreading them
+// across the given clients, each of which is used by one reader at a time.
+func scrapeMetrics(blocks []config.ReadBlock, definitions []config.MetricDef, clients []modbus.Client, failures *scrapeFailures) ([]metric, error) {
+	if len(blocks) == 0 {
 		return []metric{}, nil
 	}
 
-	for _, definition := range definitions {
-		var f modbusFunc
+	results := make([][]metric, len(blocks))
+	errs := make([]error, len(blocks))
 
-		// Here we are parcing Modbus Address from config file
-		// for function code and register address
-		modFunction, err := strconv.ParseUint(fmt.Sprint(definition.Address)[0:1], 10, 64)
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for _, c := range clients {
+		c := c
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				results[i], errs[i] = scrapeBlock(blocks[i], definitions, c, failures)
+			}
+		}()
+	}
+
+	for i := range blocks {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	metrics := []metric{}
+	for i, err := range errs {
 		if err != nil {
-			return []metric{}, fmt.Errorf("modbus function code parcing failed: %v", modFunction)
+			return []metric{}, err
 		}
+		metrics = append(metrics, results[i]...)
+	}
 
-		// And here we are parcing Modbus Address from config file
-		// for register address
-		modAddress, err := strconv.ParseUint(fmt.Sprint(definition.Address)[1:], 10, 64)
-		if err != nil {
-			return []metric{}, fmt.Errorf("modbus register address parcing failed  %v", modAddress)
+	return metrics, nil
+}
+
+// modbus read function type
+type modbusFunc func(address, quantity uint16) ([]byte, error)
+
+func readFunc(c modbus.Client, functionCode uint8) modbusFunc {
+	switch functionCode {
+	case config.FuncCodeReadCoils:
+		return c.ReadCoils
+	case config.FuncCodeReadDiscreteInputs:
+		return c.ReadDiscreteInputs
+	case config.FuncCodeReadHoldingRegisters:
+		return c.ReadHoldingRegisters
+	default:
+		return c.ReadInputRegisters
+	}
+}
+
+// scrapeBlock reads a single block of a read plan and parses the metrics
+// located within. Metrics failing to parse or missing from responses shorter
+// than requested are recorded in the given failures and left out, only
+// failing reads fail the block.
+func scrapeBlock(block config.ReadBlock, definitions []config.MetricDef, c modbus.Client, failures *scrapeFailures) ([]metric, error) {
+	data, err := readFunc(c, block.FunctionCode)(block.Address, block.Quantity)
+	if err != nil {
+		return nil, fmt.Errorf("reading %v from address %v with function code %v: %w",
+			block.Quantity, block.Address, block.FunctionCode, err)
+	}
+
+	if !covers(block.FunctionCode, data, block.Quantity) {
+		failures.addShortResponse()
+	}
+
+	metrics := make([]metric, 0, len(block.Reads))
+	for _, r := range block.Reads {
+		definition := definitions[r.Metric]
+		if !covers(block.FunctionCode, data, r.Offset+r.Quantity) {
+			continue
 		}
 
-		if modAddress > 65535 {
-			return []metric{}, fmt.Errorf("modbus register address is out of range: %v", definition.Address)
+		if definition.MetricType == config.MetricTypeHistogram && !r.Sum {
+			buckets, err := parseBuckets(definition, blockData(block.FunctionCode, data, r))
+			if err != nil {
+				failures.add(definition.Name)
+				continue
+			}
+			metrics = append(metrics, buckets...)
+			continue
 		}
 
-		switch modFunction {
-		case 1:
-			f = c.ReadCoils
-		case 2:
-			f = c.ReadDiscreteInputs
-		case 3:
-			f = c.ReadHoldingRegisters
-		case 4:
-			f = c.ReadInputRegisters
-		default:
-			return []metric{}, fmt.Errorf(
-				"metric: '%v', address '%v': metric address should be within the range of 10 - 465535."+
-					"'1xxxxx' for read coil / digital output, '2xxxxx' for read discrete inputs / digital input,"+
-					"'3xxxxx' read holding registers / analog output, '4xxxxx' read input registers / analog input",
-				definition.Name, definition.Address,
-			)
+		v, err := parseModbusData(definition, blockData(block.FunctionCode, data, r))
+		if err != nil {
+			failures.add(definition.Name)
+			continue
 		}
 
-		m, err := scrapeMetric(definition, f, modAddress)
+		if definition.OmitZero && v == 0 && definition.MetricType != config.MetricTypeHistogram {
+			continue
+		}
+
+		transform, err := definition.ValueTransform()
 		if err != nil {
-			return []metric{}, fmt.Errorf("metric '%v', address '%v': %v", definition.Name, definition.Address, err)
+			failures.add(definition.Name)
+			continue
 		}
 
-		metrics = append(metrics, m)
+		metrics = append(metrics, metric{definition.Name, definition.HelpText(), definition.Labels, v, definition.MetricType, transform})
 	}
 
 	return metrics, nil
 }
 
-// modbus read function type
-type modbusFunc func(address, quantity uint16) ([]byte, error)
-
-// scrapeMetric returns the list of values from a target
-func scrapeMetric(definition config.MetricDef, f modbusFunc, modAddress uint64) (metric, error) {
-	// For now we are not caching any results, thus we can request the
-	// minimum necessary amount of registers per request dependint in the dataType.
-	// For future reference, the maximum for digital in/output is 2000 registers,
-	// the maximum for analog in/output is 125.
-	var div uint16
-	switch definition.DataType {
-	case config.ModbusFloat16,
-		config.ModbusInt16,
-		config.ModbusBool,
-		config.ModbusUInt16:
-		div = uint16(1)
-	case config.ModbusFloat32,
-		config.ModbusInt32,
-		config.ModbusUInt32:
-		div = uint16(2)
-	default:
-		div = uint16(4)
+// covers returns whether the given data read with the given function code
+// contains the given number of registers, coils or discrete inputs.
+func covers(functionCode uint8, data []byte, quantity uint16) bool {
+	if config.IsBitAccess(functionCode) {
+		return len(data) >= (int(quantity)+7)/8
 	}
 
-	// TODO: We could cache the results to not repeat overlapping ones.
+	return len(data) >= int(quantity)*2
+}
 
-	modBytes, err := f(uint16(modAddress), div)
-	if err != nil {
-		return metric{}, err
+// blockData returns the part of the data read for a block belonging to the
+// given read, as if it had been read on its own.
+func blockData(functionCode uint8, data []byte, r config.PlannedRead) []byte {
+	if !config.IsBitAccess(functionCode) {
+		start, end := int(r.Offset)*2, int(r.Offset+r.Quantity)*2
+		if end > len(data) {
+			end = len(data)
+		}
+		if start > end {
+			start = end
+		}
+		return data[start:end]
 	}
 
-	v, err := parseModbusData(definition, modBytes)
-	if err != nil {
-		return metric{}, err
+	// Coils and discrete inputs are packed eight per byte, least significant
+	// bit first.
+	bits := make([]byte, (int(r.Quantity)+7)/8)
+	for i := 0; i < int(r.Quantity); i++ {
+		bit := int(r.Offset) + i
+		if bit/8 < len(data) && data[bit/8]&(1<<uint(bit%8)) > 0 {
+			bits[i/8] |= 1 << uint(i%8)
+		}
 	}
 
-	return metric{definition.Name, definition.Help, definition.Labels, v, definition.MetricType}, nil
+	return bits
 }
 
 // InsufficientRegistersError is returned in Parse() whenever not enough
@@ -272,6 +793,54 @@ func (e *InsufficientRegistersError) Error() string {
 	return fmt.Sprintf("insufficient amount of register data provided: %v", e.e)
 }
 
+// Is reports InsufficientRegistersError to be an ErrParse.
+func (e *InsufficientRegistersError) Is(target error) bool {
+	return target == ErrParse
+}
+
+// ParseValue parses the raw data read for the given metric definition like
+// scrapes do, e.g. to check values of a device outside of scrapes.
+func ParseValue(d config.MetricDef, rawData []byte) (float64, error) {
+	return parseModbusData(d, rawData)
+}
+
+// BlockValue is the value of a metric parsed from the data of a read block.
+type BlockValue struct {
+	// Index of the metric definition within the module.
+	Metric int
+	Value  float64
+}
+
+// ParseBlock parses the values of the metrics located within the data read
+// for the given block of a read plan, e.g. with ReadRaw, like scrapes do.
+// Label and histogram reads are left out. Metrics failing to parse or
+// missing from data shorter than requested are left out too and returned
+// joined as error. Transforms aren't applied, as they may refer to metrics of
+// other blocks; see config.MetricDef.ValueTransform.
+func ParseBlock(block config.ReadBlock, definitions []config.MetricDef, data []byte) ([]BlockValue, error) {
+	values := make([]BlockValue, 0, len(block.Reads))
+	var errs []error
+	for _, r := range block.Reads {
+		definition := definitions[r.Metric]
+		if r.Label != "" || definition.MetricType == config.MetricTypeHistogram {
+			continue
+		}
+		if !covers(block.FunctionCode, data, r.Offset+r.Quantity) {
+			errs = append(errs, fmt.Errorf("metric %v: %w", definition.Name, &InsufficientRegistersError{e: "response shorter than requested"}))
+			continue
+		}
+
+		v, err := parseModbusData(definition, blockData(block.FunctionCode, data, r))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("metric %v: %w", definition.Name, err))
+			continue
+		}
+		values = append(values, BlockValue{Metric: r.Metric, Value: v})
+	}
+
+	return values, errors.Join(errs...)
+}
+
 // Parse parses the given byte slice based on the specified Modbus data type and
 // returns the parsed value as a float64 (Prometheus exposition format).
 //
@@ -297,7 +866,12 @@ func parseModbusData(d config.MetricDef, rawData []byte) (float64, error) {
 			if len(rawData) != 2 {
 				return float64(0), &InsufficientRegistersError{fmt.Sprintf("expected 2 bytes, got %v", len(rawData))}
 			}
-			panic("implement")
+			rawDataWithEndianness, err := convertEndianness16b(d.Endianness, rawData)
+			if err != nil {
+				return float64(0), err
+			}
+			data := binary.BigEndian.Uint16(rawDataWithEndianness)
+			return scaleValue(d.Factor, float16ToFloat64(data)), nil
 		}
 	case config.ModbusInt16:
 		{
@@ -397,11 +971,45 @@ func parseModbusData(d config.MetricDef, rawData []byte) (float64, error) {
 		}
 	default:
 		{
-			return 0, fmt.Errorf("unknown modbus data type")
+			parse, registers, ok := config.CustomDataType(d.DataType)
+			if !ok {
+				return 0, fmt.Errorf("unknown modbus data type")
+			}
+			if len(rawData) != int(registers)*2 {
+				return float64(0), &InsufficientRegistersError{fmt.Sprintf("expected %v bytes, got %v", int(registers)*2, len(rawData))}
+			}
+			v, err := parse(rawData)
+			if err != nil {
+				return float64(0), fmt.Errorf("%w: %v", ErrParse, err)
+			}
+			return scaleValue(d.Factor, v), nil
 		}
 	}
 }
 
+// float16ToFloat64 converts the bits of an IEEE 754 half-precision float.
+func float16ToFloat64(h uint16) float64 {
+	sign := 1.0
+	if h&0x8000 != 0 {
+		sign = -1
+	}
+	exponent := int(h>>10) & 0x1f
+	fraction := float64(h & 0x3ff)
+
+	switch exponent {
+	case 0:
+		// Zero and subnormal numbers.
+		return sign * math.Ldexp(fraction, -24)
+	case 0x1f:
+		if fraction != 0 {
+			return math.NaN()
+		}
+		return math.Inf(int(sign))
+	}
+
+	return sign * math.Ldexp(1+fraction/1024, exponent-15)
+}
+
 // Scales value by factor
 func scaleValue(f *float64, d float64) float64 {
 	if f == nil {
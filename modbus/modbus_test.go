@@ -14,28 +14,510 @@
 package modbus
 
 import (
+	"context"
 	"encoding/binary"
+	"errors"
+	"fmt"
 	"math"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/RichiH/modbus_exporter/config"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/common/model"
+	"github.com/tbrandon/mbserver"
 )
 
+// freeAddress returns a local address nothing is listening on.
+func freeAddress(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	return l.Addr().String()
+}
+
+// startServer starts a modbus TCP server on a free local port.
+func startServer(t *testing.T) (*mbserver.Server, string) {
+	address := freeAddress(t)
+	s := mbserver.NewServer()
+	if err := s.ListenTCP(address); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.Close)
+
+	return s, address
+}
+
+func testConfig() config.Config {
+	return config.Config{
+		Modules: []config.Module{
+			{
+				Name:     "my_module",
+				Protocol: config.ModbusProtocolTCPIP,
+				Timeout:  500,
+				Metrics: []config.MetricDef{
+					{
+						Name:       "my_metric",
+						Help:       "my_help",
+						Address:    300022,
+						DataType:   config.ModbusInt16,
+						MetricType: config.MetricTypeGauge,
+					},
+				},
+			},
+		},
+	}
+}
+
+func TestScrapeFailover(t *testing.T) {
+	s, address := startServer(t)
+	s.HoldingRegisters[22] = 240
+
+	e := NewExporter(testConfig())
+	gatherer, err := e.Scrape(context.Background(), freeAddress(t)+","+address, 1, "my_module")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	metricFamilies, err := gatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	values := map[string]float64{}
+	for _, mf := range metricFamilies {
+		values[mf.GetName()] = mf.Metric[0].GetGauge().GetValue()
+	}
+
+	if values["my_metric"] != 240 {
+		t.Fatalf("expected my_metric to be 240 but got %v", values["my_metric"])
+	}
+	if values["modbus_target_path"] != 1 {
+		t.Fatalf("expected modbus_target_path to be 1 but got %v", values["modbus_target_path"])
+	}
+}
+
+func TestScrapeOmitZero(t *testing.T) {
+	s, address := startServer(t)
+	s.HoldingRegisters[23] = 240
+
+	c := testConfig()
+	c.Modules[0].Metrics[0].OmitZero = true
+	c.Modules[0].Metrics = append(c.Modules[0].Metrics, config.MetricDef{
+		Name:       "my_other_metric",
+		Address:    300023,
+		DataType:   config.ModbusInt16,
+		MetricType: config.MetricTypeGauge,
+		OmitZero:   true,
+	})
+
+	e := NewExporter(c)
+	gatherer, err := e.Scrape(context.Background(), address, 1, "my_module")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	metricFamilies, err := gatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := map[string]bool{}
+	for _, mf := range metricFamilies {
+		names[mf.GetName()] = true
+	}
+	if names["my_metric"] {
+		t.Fatal("expected my_metric reading zero to be omitted")
+	}
+	if !names["my_other_metric"] {
+		t.Fatal("expected my_other_metric to be exposed")
+	}
+}
+
+func TestScrapeModule(t *testing.T) {
+	s, address := startServer(t)
+	s.HoldingRegisters[5] = 42
+
+	module := &config.Module{
+		Name:     "embedded",
+		Protocol: config.ModbusProtocolTCPIP,
+		Metrics: []config.MetricDef{
+			{Name: "embedded_metric", Address: 300005, DataType: config.ModbusInt16, MetricType: config.MetricTypeGauge},
+		},
+	}
+
+	// The module isn't part of the configuration.
+	gatherer, err := NewExporter(config.Config{}).ScrapeModule(context.Background(), address, 1, module)
+	if err != nil {
+		t.Fatal(err)
+	}
+	metricFamilies, err := gatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(metricFamilies) != 1 || metricFamilies[0].Metric[0].GetGauge().GetValue() != 42 {
+		t.Fatalf("expected embedded_metric of 42 but got %v", metricFamilies)
+	}
+}
+
+func TestParseBlock(t *testing.T) {
+	module := &config.Module{
+		Name: "m",
+		Metrics: []config.MetricDef{
+			{Name: "a", Address: 300000, DataType: config.ModbusInt16, MetricType: config.MetricTypeGauge},
+			{Name: "b", Address: 300001, DataType: config.ModbusUInt32, MetricType: config.MetricTypeGauge},
+			{Name: "c", Address: 300003, DataType: config.ModbusUInt16, MetricType: config.MetricTypeGauge},
+		},
+	}
+	plan, err := module.ReadPlan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Blocks) != 1 {
+		t.Fatalf("expected a single block but got %+v", plan.Blocks)
+	}
+
+	// The response lacks the register of c.
+	values, err := ParseBlock(plan.Blocks[0], module.Metrics, []byte{0xff, 0xfe, 0, 1, 0, 2})
+	if !errors.Is(err, ErrParse) {
+		t.Errorf("expected parse error for the missing register but got %v", err)
+	}
+	expected := []BlockValue{{Metric: 0, Value: -2}, {Metric: 1, Value: 65538}}
+	if !reflect.DeepEqual(values, expected) {
+		t.Fatalf("expected %v but got %v", expected, values)
+	}
+}
+
+func TestScrapeMetricParseError(t *testing.T) {
+	s, address := startServer(t)
+	s.HoldingRegisters[22] = 240
+
+	c := testConfig()
+	c.Modules[0].Metrics = append(c.Modules[0].Metrics, config.MetricDef{
+		Name:       "my_broken_metric",
+		Address:    300023,
+		DataType:   config.ModbusBool,
+		MetricType: config.MetricTypeGauge,
+	})
+
+	e := NewExporter(c)
+	gatherer, err := e.Scrape(context.Background(), address, 1, "my_module")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	metricFamilies, err := gatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := map[string]bool{}
+	for _, mf := range metricFamilies {
+		names[mf.GetName()] = true
+	}
+	if !names["my_metric"] {
+		t.Fatal("expected my_metric to be exposed")
+	}
+	if names["my_broken_metric"] {
+		t.Fatal("expected my_broken_metric failing to parse to be omitted")
+	}
+
+	count := testutil.ToFloat64(e.telemetry.metricParseErrors.WithLabelValues(address, "1", "my_module", "my_broken_metric"))
+	if count != 1 {
+		t.Fatalf("expected 1 parse error for my_broken_metric but got %v", count)
+	}
+}
+
+func TestScrapeTransform(t *testing.T) {
+	s, address := startServer(t)
+	s.HoldingRegisters[22] = 240
+	s.HoldingRegisters[23] = 0xffff // -1
+
+	c := testConfig()
+	c.Modules[0].Metrics[0].Transform = "value * pow(10, scale_factor)"
+	c.Modules[0].Metrics = append(c.Modules[0].Metrics,
+		config.MetricDef{
+			Name:       "scale_factor",
+			Address:    300023,
+			DataType:   config.ModbusInt16,
+			MetricType: config.MetricTypeGauge,
+			Transform:  "value * 2",
+		},
+		config.MetricDef{
+			Name:       "my_broken_metric",
+			Address:    300024,
+			DataType:   config.ModbusInt16,
+			MetricType: config.MetricTypeGauge,
+			Transform:  "scale_factor / value",
+		},
+	)
+
+	e := NewExporter(c)
+	gatherer, err := e.Scrape(context.Background(), address, 1, "my_module")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	metricFamilies, err := gatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	values := map[string]float64{}
+	for _, mf := range metricFamilies {
+		values[mf.GetName()] = mf.GetMetric()[0].GetGauge().GetValue()
+	}
+	// Transforms see the parsed values of the metrics referred to, not the
+	// transformed ones.
+	if values["my_metric"] != 24 {
+		t.Errorf("expected my_metric to be 24 but got %v", values["my_metric"])
+	}
+	if values["scale_factor"] != -2 {
+		t.Errorf("expected scale_factor to be -2 but got %v", values["scale_factor"])
+	}
+	if _, ok := values["my_broken_metric"]; ok {
+		t.Error("expected my_broken_metric dividing by zero to be omitted")
+	}
+
+	count := testutil.ToFloat64(e.telemetry.metricParseErrors.WithLabelValues(address, "1", "my_module", "my_broken_metric"))
+	if count != 1 {
+		t.Fatalf("expected 1 parse error for my_broken_metric but got %v", count)
+	}
+}
+
+func TestScrapeMiddleware(t *testing.T) {
+	s, address := startServer(t)
+	s.HoldingRegisters[22] = 240
+	s.HoldingRegisters[23] = 1500
+
+	c := testConfig()
+	c.Modules[0].Metrics[0].Labels = map[string]string{"phase": "1"}
+	c.Modules[0].Metrics = append(c.Modules[0].Metrics, config.MetricDef{
+		Name:       "my_other_metric",
+		Address:    300023,
+		DataType:   config.ModbusInt16,
+		MetricType: config.MetricTypeGauge,
+	})
+
+	var seen []string
+	e := NewExporter(c,
+		WithMiddleware(func(target string, subTarget byte, module *config.Module, samples []Sample) ([]Sample, error) {
+			if target != address || subTarget != 1 || module.Name != "my_module" {
+				t.Errorf("unexpected scrape of %v, %v, %v", target, subTarget, module.Name)
+			}
+			result := []Sample{}
+			for _, s := range samples {
+				if s.Name == "my_other_metric" {
+					continue
+				}
+				s.Name = "my_metric_kilovolts"
+				s.Value /= 1000
+				s.Labels["site"] = "north"
+				result = append(result, s)
+			}
+			return result, nil
+		}),
+		WithMiddleware(func(_ string, _ byte, _ *config.Module, samples []Sample) ([]Sample, error) {
+			for _, s := range samples {
+				seen = append(seen, s.Name)
+			}
+			return samples, nil
+		}),
+	)
+	gatherer, err := e.Scrape(context.Background(), address, 1, "my_module")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	metricFamilies, err := gatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(metricFamilies) != 1 || metricFamilies[0].GetName() != "my_metric_kilovolts" {
+		t.Fatalf("expected only my_metric_kilovolts but got %v", metricFamilies)
+	}
+	m := metricFamilies[0].Metric[0]
+	if m.GetGauge().GetValue() != 0.24 {
+		t.Errorf("expected 0.24 but got %v", m.GetGauge().GetValue())
+	}
+	labels := map[string]string{}
+	for _, l := range m.Label {
+		labels[l.GetName()] = l.GetValue()
+	}
+	if !reflect.DeepEqual(labels, map[string]string{"module": "my_module", "phase": "1", "site": "north"}) {
+		t.Errorf("expected labels phase and site but got %v", labels)
+	}
+	if !reflect.DeepEqual(seen, []string{"my_metric_kilovolts"}) {
+		t.Errorf("expected second middleware to see the output of the first but got %v", seen)
+	}
+	if _, ok := c.Modules[0].Metrics[0].Labels["site"]; ok {
+		t.Error("expected middleware not to modify the labels of the metric definition")
+	}
+
+	e = NewExporter(c, WithMiddleware(func(string, byte, *config.Module, []Sample) ([]Sample, error) {
+		return nil, errors.New("broken")
+	}))
+	if _, err := e.Scrape(context.Background(), address, 1, "my_module"); err == nil || !strings.Contains(err.Error(), "broken") {
+		t.Errorf("expected middleware error to fail the scrape but got %v", err)
+	}
+	e = NewExporter(c, WithMiddleware(func(_ string, _ byte, _ *config.Module, samples []Sample) ([]Sample, error) {
+		return append(samples, Sample{Name: "summary", MetricType: "summary"}), nil
+	}))
+	if _, err := e.Scrape(context.Background(), address, 1, "my_module"); err == nil || !strings.Contains(err.Error(), "invalid metric type") {
+		t.Errorf("expected invalid metric type to fail the scrape but got %v", err)
+	}
+}
+
+func TestScrapeDurationTelemetry(t *testing.T) {
+	_, address := startServer(t)
+
+	e := NewExporter(testConfig())
+	if _, err := e.Scrape(context.Background(), address, 1, "my_module"); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := testutil.CollectAndCount(e.telemetry.scrapeDuration); n != 1 {
+		t.Fatalf("expected 1 scrape duration histogram but got %v", n)
+	}
+	if n := testutil.CollectAndCount(e.telemetry.requestDuration); n != 1 {
+		t.Fatalf("expected 1 request duration histogram but got %v", n)
+	}
+}
+
+func TestScrapeTimestampRegister(t *testing.T) {
+	s, address := startServer(t)
+	s.HoldingRegisters[22] = 240
+	// 1672531200 seconds since epoch.
+	s.HoldingRegisters[100] = 0x63b0
+	s.HoldingRegisters[101] = 0xcd00
+
+	c := testConfig()
+	c.Modules[0].TimestampRegister = &config.TimestampRegister{
+		Address:    300100,
+		DataType:   config.ModbusUInt32,
+		Endianness: config.EndiannessBigEndian,
+	}
+
+	e := NewExporter(c)
+	gatherer, err := e.Scrape(context.Background(), address, 1, "my_module")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	metricFamilies, err := gatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, mf := range metricFamilies {
+		ts := mf.Metric[0].TimestampMs
+		switch mf.GetName() {
+		case "my_metric":
+			if ts == nil || *ts != 1672531200000 {
+				t.Fatalf("expected my_metric to have timestamp 1672531200000 but got %v", ts)
+			}
+		case "modbus_data_timestamp_seconds":
+			if ts != nil {
+				t.Fatalf("expected modbus_data_timestamp_seconds not to have a timestamp but got %v", *ts)
+			}
+			if v := mf.Metric[0].Gauge.GetValue(); v != 1672531200 {
+				t.Fatalf("expected modbus_data_timestamp_seconds to be 1672531200 but got %v", v)
+			}
+		default:
+			t.Fatalf("unexpected metric %v", mf.GetName())
+		}
+	}
+}
+
+func TestScrapeHistogram(t *testing.T) {
+	s, address := startServer(t)
+	s.HoldingRegisters[30] = 5
+	s.HoldingRegisters[31] = 3
+	s.HoldingRegisters[32] = 2
+	s.HoldingRegisters[40] = 123
+
+	c := testConfig()
+	factor := 0.1
+	c.Modules[0].Metrics = []config.MetricDef{
+		{
+			Name:       "voltage_dip_duration_seconds",
+			Help:       "voltage dips",
+			Labels:     map[string]string{"phase": "1"},
+			Address:    300030,
+			DataType:   config.ModbusUInt16,
+			Endianness: config.EndiannessBigEndian,
+			MetricType: config.MetricTypeHistogram,
+			Buckets:    []float64{0.1, 1, math.Inf(1)},
+			SumAddress: func() *config.RegisterAddr { a := config.RegisterAddr(300040); return &a }(),
+			Factor:     &factor,
+		},
+	}
+
+	e := NewExporter(c)
+	gatherer, err := e.Scrape(context.Background(), address, 1, "my_module")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	metricFamilies, err := gatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(metricFamilies) != 1 || len(metricFamilies[0].Metric) != 1 {
+		t.Fatalf("expected a single histogram series but got %v", metricFamilies)
+	}
+
+	h := metricFamilies[0].Metric[0].GetHistogram()
+	if h.GetSampleCount() != 10 {
+		t.Fatalf("expected count 10 but got %v", h.GetSampleCount())
+	}
+	if math.Abs(h.GetSampleSum()-12.3) > 1e-9 {
+		t.Fatalf("expected sum 12.3 but got %v", h.GetSampleSum())
+	}
+
+	expected := map[float64]uint64{0.1: 5, 1: 8}
+	if len(h.Bucket) != len(expected) {
+		t.Fatalf("expected buckets %v but got %v", expected, h.Bucket)
+	}
+	for _, b := range h.Bucket {
+		if expected[b.GetUpperBound()] != b.GetCumulativeCount() {
+			t.Fatalf("expected buckets %v but got %v", expected, h.Bucket)
+		}
+	}
+
+	labels := map[string]string{}
+	for _, l := range metricFamilies[0].Metric[0].Label {
+		labels[l.GetName()] = l.GetValue()
+	}
+	if !reflect.DeepEqual(labels, map[string]string{"phase": "1", "module": "my_module"}) {
+		t.Fatalf("expected phase and module labels but got %v", labels)
+	}
+}
+
 func TestRegisterMetrics(t *testing.T) {
 	t.Run("does not fail", func(t *testing.T) {
 		reg := prometheus.NewRegistry()
-		moduleName := "my_module"
+		module := &config.Module{Name: "my_module"}
 		metrics := []metric{}
 
-		if err := registerMetrics(reg, moduleName, metrics); err != nil {
+		if err := registerMetrics(reg, module, metrics); err != nil {
 			t.Fatal(err)
 		}
 	})
 
 	t.Run("registers metrics with same name and same label keys", func(t *testing.T) {
 		reg := prometheus.NewRegistry()
-		moduleName := "my_module"
+		module := &config.Module{Name: "my_module"}
 		metrics := []metric{
 			{
 				Name: "my_metric",
@@ -59,7 +541,7 @@ func TestRegisterMetrics(t *testing.T) {
 			},
 		}
 
-		if err := registerMetrics(reg, moduleName, metrics); err != nil {
+		if err := registerMetrics(reg, module, metrics); err != nil {
 			t.Fatal(err)
 		}
 
@@ -481,6 +963,58 @@ func TestParseModbusData(t *testing.T) {
 	}
 }
 
+// parseBCD parses packed binary coded decimals, two digits per byte.
+func parseBCD(rawData []byte) (float64, error) {
+	v := 0.0
+	for _, b := range rawData {
+		if b>>4 > 9 || b&0xf > 9 {
+			return 0, fmt.Errorf("invalid BCD byte %x", b)
+		}
+		v = v*100 + float64(b>>4)*10 + float64(b&0xf)
+	}
+
+	return v, nil
+}
+
+func TestParseModbusDataCustomDataType(t *testing.T) {
+	if _, _, ok := config.CustomDataType("bcd32"); !ok {
+		config.RegisterDataType("bcd32", 2, parseBCD)
+	}
+
+	c, err := config.Parse([]byte(`modules:
+  - name: "m"
+    protocol: "tcp/ip"
+    metrics:
+      - name: "energy"
+        address: 300000
+        dataType: bcd32
+        factor: 0.1
+        metricType: gauge
+`), config.LoadOptions{Strict: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := c.Modules[0].Metrics[0]
+	if d.RegisterCount() != 2 {
+		t.Fatalf("expected 2 registers but got %v", d.RegisterCount())
+	}
+
+	v, err := ParseValue(d, []byte{0x00, 0x12, 0x34, 0x56})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(v-12345.6) > 1e-9 {
+		t.Errorf("expected 12345.6 but got %v", v)
+	}
+
+	if _, err := ParseValue(d, []byte{0x00, 0x12, 0x34, 0x5f}); !errors.Is(err, ErrParse) {
+		t.Errorf("expected parse error for invalid digit but got %v", err)
+	}
+	if _, err := ParseValue(d, []byte{0x00, 0x12}); !errors.Is(err, ErrParse) {
+		t.Errorf("expected parse error for missing register but got %v", err)
+	}
+}
+
 func TestParseModbusDataInsufficientRegisters(t *testing.T) {
 	d := config.MetricDef{
 		DataType: config.ModbusInt16,
@@ -517,28 +1051,448 @@ func TestParseModbusDataFloat32(t *testing.T) {
 	}
 }
 
+func TestParseModbusDataFloat16(t *testing.T) {
+	for _, test := range []struct {
+		data     []byte
+		expected float64
+	}{
+		{[]byte{0x3c, 0x00}, 1},
+		{[]byte{0xc0, 0x00}, -2},
+		{[]byte{0x57, 0xb0}, 123},
+		{[]byte{0x35, 0x55}, 0.333251953125},
+		{[]byte{0x7b, 0xff}, 65504},
+		{[]byte{0x00, 0x01}, math.Ldexp(1, -24)},
+		{[]byte{0x00, 0x00}, 0},
+		{[]byte{0x7c, 0x00}, math.Inf(1)},
+	} {
+		v, err := parseModbusData(config.MetricDef{DataType: config.ModbusFloat16}, test.data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v != test.expected {
+			t.Errorf("%x: expected %v but got %v", test.data, test.expected, v)
+		}
+	}
+
+	v, err := parseModbusData(config.MetricDef{DataType: config.ModbusFloat16}, []byte{0x7e, 0x00})
+	if err != nil || !math.IsNaN(v) {
+		t.Errorf("expected NaN but got %v, %v", v, err)
+	}
+
+	factor := 0.5
+	v, err = parseModbusData(config.MetricDef{DataType: config.ModbusFloat16, Endianness: config.EndiannessLittleEndian, Factor: &factor}, []byte{0x00, 0x3c})
+	if err != nil || v != 0.5 {
+		t.Errorf("expected little endian 1 with factor 0.5 but got %v, %v", v, err)
+	}
+}
+
 // TestRegisterMetricTwoMetricsSameName makes sure registerMetrics reuses a
 // registered metric in case there is a second one with the same name instead of
 // reregistering which would cause an exception.
 func TestRegisterMetricTwoMetricsSameName(t *testing.T) {
 	reg := prometheus.NewRegistry()
-	a := metric{"my_metric", "", map[string]string{}, 1, config.MetricTypeCounter}
-	b := metric{"my_metric", "", map[string]string{}, 1, config.MetricTypeCounter}
+	a := metric{"my_metric", "", map[string]string{}, 1, config.MetricTypeCounter, nil}
+	b := metric{"my_metric", "", map[string]string{}, 1, config.MetricTypeCounter, nil}
 
-	err := registerMetrics(reg, "my_module", []metric{a, b})
+	err := registerMetrics(reg, &config.Module{Name: "my_module"}, []metric{a, b})
 	if err != nil {
 		t.Fatalf("expected no error but got: %v", err)
 	}
 }
 
+func TestRegisterMetricsModuleLabels(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	module := &config.Module{
+		Name:   "my_module",
+		Labels: map[string]string{"vendor": "eastron", "phase": "all"},
+	}
+	a := metric{"my_metric", "", map[string]string{"phase": "1"}, 1, config.MetricTypeGauge, nil}
+
+	if err := registerMetrics(reg, module, []metric{a}); err != nil {
+		t.Fatal(err)
+	}
+
+	metricFamilies, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	labels := map[string]string{}
+	for _, l := range metricFamilies[0].GetMetric()[0].GetLabel() {
+		labels[l.GetName()] = l.GetValue()
+	}
+	expected := map[string]string{"module": "my_module", "vendor": "eastron", "phase": "1"}
+	if !reflect.DeepEqual(labels, expected) {
+		t.Fatalf("expected labels %v but got %v", expected, labels)
+	}
+
+	if len(a.Labels) != 1 {
+		t.Fatalf("expected labels of the metric not to be modified but got %v", a.Labels)
+	}
+}
+
+func TestRegisterMetricsWithoutModuleLabel(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	disabled := false
+	module := &config.Module{Name: "my_module", ModuleLabel: &disabled}
+	a := metric{"my_metric", "", map[string]string{}, 1, config.MetricTypeGauge, nil}
+
+	if err := registerMetrics(reg, module, []metric{a}); err != nil {
+		t.Fatal(err)
+	}
+
+	metricFamilies, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if labels := metricFamilies[0].GetMetric()[0].GetLabel(); len(labels) != 0 {
+		t.Fatalf("expected no labels but got %v", labels)
+	}
+}
+
 // TestRegisterMetricsRecoverNegativeCounter makes sure the function properly
 // recovers from a prometheus client library panic on negative counter changes.
 func TestRegisterMetricsRecoverNegativeCounter(t *testing.T) {
 	reg := prometheus.NewRegistry()
-	a := metric{"my_metric", "", map[string]string{"key1": "value1", "key2": "value2"}, -1, config.MetricTypeCounter}
+	a := metric{"my_metric", "", map[string]string{"key1": "value1", "key2": "value2"}, -1, config.MetricTypeCounter, nil}
 
-	err := registerMetrics(reg, "my_module", []metric{a})
+	err := registerMetrics(reg, &config.Module{Name: "my_module"}, []metric{a})
 	if err == nil {
 		t.Fatal("expected an error but got nil")
 	}
 }
+
+func TestScrapeServesPolledResult(t *testing.T) {
+	s, address := startServer(t)
+	s.HoldingRegisters[22] = 240
+
+	c := testConfig()
+	c.Targets = []config.PollTarget{
+		{Target: address, SubTarget: 1, Module: "my_module", Interval: 1},
+	}
+	e := NewExporter(c)
+	e.pollOnce(context.Background(), c.Targets[0])
+
+	// Stopping the device must not affect scrapes of the polled target.
+	s.Close()
+
+	gatherer, err := e.Scrape(context.Background(), address, 1, "my_module")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	metricFamilies, err := gatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(metricFamilies) != 1 || metricFamilies[0].Metric[0].GetGauge().GetValue() != 240 {
+		t.Fatalf("expected polled my_metric of 240 but got %v", metricFamilies)
+	}
+}
+
+func TestScrapeParallel(t *testing.T) {
+	s, address := startServer(t)
+
+	c := testConfig()
+	c.Modules[0].Parallelism = 4
+	c.Modules[0].MaxReadRegisters = 3
+	c.Modules[0].Metrics = nil
+	for i := 0; i < 20; i++ {
+		s.HoldingRegisters[i] = uint16(i)
+		c.Modules[0].Metrics = append(c.Modules[0].Metrics, config.MetricDef{
+			Name:       "my_metric",
+			Labels:     map[string]string{"register": strconv.Itoa(i)},
+			Address:    config.RegisterAddr(300000 + i),
+			DataType:   config.ModbusUInt16,
+			MetricType: config.MetricTypeGauge,
+		})
+	}
+
+	gatherer, err := NewExporter(c).Scrape(context.Background(), address, 1, "my_module")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	metricFamilies, err := gatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, m := range metricFamilies[0].Metric {
+		for _, l := range m.Label {
+			if l.GetName() == "register" && l.GetValue() != strconv.Itoa(int(m.GetGauge().GetValue())) {
+				t.Fatalf("expected register %v to have value %v but got %v", l.GetValue(), l.GetValue(), m.GetGauge().GetValue())
+			}
+		}
+	}
+	if len(metricFamilies[0].Metric) != 20 {
+		t.Fatalf("expected 20 metrics but got %v", len(metricFamilies[0].Metric))
+	}
+}
+
+func TestScrapeContextDeadline(t *testing.T) {
+	_, address := startServer(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := NewExporter(testConfig()).Scrape(ctx, address, 1, "my_module")
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled but got %v", err)
+	}
+}
+
+func TestScrapeDeduplicated(t *testing.T) {
+	s, address := startServer(t)
+	var reads int32
+	s.RegisterFunctionHandler(3, func(s *mbserver.Server, frame mbserver.Framer) ([]byte, *mbserver.Exception) {
+		atomic.AddInt32(&reads, 1)
+		time.Sleep(200 * time.Millisecond)
+		return mbserver.ReadHoldingRegisters(s, frame)
+	})
+
+	e := NewExporter(testConfig())
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := e.Scrape(context.Background(), address, 1, "my_module"); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := atomic.LoadInt32(&reads); n != 1 {
+		t.Fatalf("expected 1 read from the device but got %v", n)
+	}
+	if v := testutil.ToFloat64(e.telemetry.scrapesDeduplicated); v != 2 {
+		t.Fatalf("expected 2 deduplicated scrapes but got %v", v)
+	}
+}
+
+func TestScrapeSharedOutlivesCaller(t *testing.T) {
+	s, address := startServer(t)
+	s.HoldingRegisters[22] = 240
+	s.RegisterFunctionHandler(3, func(s *mbserver.Server, frame mbserver.Framer) ([]byte, *mbserver.Exception) {
+		time.Sleep(200 * time.Millisecond)
+		return mbserver.ReadHoldingRegisters(s, frame)
+	})
+
+	e := NewExporter(testConfig())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errs := make(chan error)
+	go func() {
+		_, err := e.Scrape(ctx, address, 1, "my_module")
+		errs <- err
+	}()
+
+	// Join the scrape started above, then abort its caller.
+	time.Sleep(50 * time.Millisecond)
+	time.AfterFunc(50*time.Millisecond, cancel)
+	gatherer, err := e.Scrape(context.Background(), address, 1, "my_module")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errs; !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled for the aborted caller but got %v", err)
+	}
+
+	metricFamilies, err := gatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(metricFamilies) != 1 || metricFamilies[0].Metric[0].GetGauge().GetValue() != 240 {
+		t.Fatalf("expected my_metric of 240 but got %v", metricFamilies)
+	}
+}
+
+func TestScrapeModuleTimeout(t *testing.T) {
+	_, address := startServer(t)
+
+	c := testConfig()
+	c.Modules[0].ScrapeTimeout = 1
+
+	_, err := NewExporter(c).Scrape(context.Background(), address, 1, "my_module")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected context.DeadlineExceeded but got %v", err)
+	}
+}
+
+func TestScrapeChunks(t *testing.T) {
+	s, address := startServer(t)
+	s.HoldingRegisters[22] = 240
+	s.RegisterFunctionHandler(4, func(*mbserver.Server, mbserver.Framer) ([]byte, *mbserver.Exception) {
+		return nil, &mbserver.IllegalDataAddress
+	})
+
+	c := testConfig()
+	c.Modules[0].ChunkSize = 1
+	c.Modules[0].Metrics = append(c.Modules[0].Metrics, config.MetricDef{
+		Name:       "broken_metric",
+		Address:    400001,
+		DataType:   config.ModbusInt16,
+		MetricType: config.MetricTypeGauge,
+	})
+
+	gatherer, err := NewExporter(c).Scrape(context.Background(), address, 1, "my_module")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	metricFamilies, err := gatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	success := map[string]float64{}
+	found := false
+	for _, mf := range metricFamilies {
+		switch mf.GetName() {
+		case "my_metric":
+			found = true
+		case "modbus_scrape_chunk_success":
+			for _, m := range mf.Metric {
+				success[m.Label[0].GetValue()] = m.GetGauge().GetValue()
+			}
+		}
+	}
+
+	if !found {
+		t.Fatal("expected my_metric of the successful chunk")
+	}
+	if success["0"] != 1 || success["1"] != 0 {
+		t.Fatalf("expected first chunk to succeed and second to fail but got %v", success)
+	}
+}
+
+func TestScrapeBusyRetries(t *testing.T) {
+	s, address := startServer(t)
+	s.HoldingRegisters[22] = 240
+	busy := 2
+	s.RegisterFunctionHandler(3, func(s *mbserver.Server, frame mbserver.Framer) ([]byte, *mbserver.Exception) {
+		if busy > 0 {
+			busy--
+			return nil, &mbserver.SlaveDeviceBusy
+		}
+		return mbserver.ReadHoldingRegisters(s, frame)
+	})
+
+	c := testConfig()
+	c.Modules[0].BusyRetries = 2
+	c.Modules[0].BusyRetryDelay = model.Duration(time.Millisecond)
+
+	e := NewExporter(c)
+	if _, err := e.Scrape(context.Background(), address, 1, "my_module"); err != nil {
+		t.Fatal(err)
+	}
+	if v := testutil.ToFloat64(e.telemetry.busyRetries.WithLabelValues(address, "1")); v != 2 {
+		t.Fatalf("expected 2 busy retries but got %v", v)
+	}
+
+	busy = 3
+	_, err := e.Scrape(context.Background(), address, 1, "my_module")
+	var exception *ExceptionError
+	if !errors.As(err, &exception) || exception.ExceptionCode != 6 {
+		t.Fatalf("expected slave busy exception after exhausting the retries but got %v", err)
+	}
+}
+
+func TestScrapeAcknowledgePolling(t *testing.T) {
+	s, address := startServer(t)
+	s.HoldingRegisters[22] = 240
+	acknowledged := 0
+	s.RegisterFunctionHandler(3, func(s *mbserver.Server, frame mbserver.Framer) ([]byte, *mbserver.Exception) {
+		if acknowledged < 3 {
+			acknowledged++
+			return nil, &mbserver.AcknowledgeSlave
+		}
+		return mbserver.ReadHoldingRegisters(s, frame)
+	})
+
+	c := testConfig()
+	c.Modules[0].AcknowledgePollInterval = model.Duration(time.Millisecond)
+	c.Modules[0].AcknowledgeTimeout = model.Duration(time.Second)
+
+	gatherer, err := NewExporter(c).Scrape(context.Background(), address, 1, "my_module")
+	if err != nil {
+		t.Fatal(err)
+	}
+	metricFamilies, err := gatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(metricFamilies) != 1 || metricFamilies[0].Metric[0].GetGauge().GetValue() != 240 {
+		t.Fatalf("expected my_metric to be 240 but got %v", metricFamilies)
+	}
+
+	acknowledged = 0
+	c.Modules[0].AcknowledgePollInterval = model.Duration(50 * time.Millisecond)
+	c.Modules[0].AcknowledgeTimeout = model.Duration(75 * time.Millisecond)
+	_, err = NewExporter(c).Scrape(context.Background(), address, 1, "my_module")
+	var exception *ExceptionError
+	if !errors.As(err, &exception) || exception.ExceptionCode != 5 {
+		t.Fatalf("expected acknowledge exception after the acknowledge timeout but got %v", err)
+	}
+}
+
+func TestScrapeAcknowledgeTimeout(t *testing.T) {
+	s, address := startServer(t)
+	s.RegisterFunctionHandler(3, func(*mbserver.Server, mbserver.Framer) ([]byte, *mbserver.Exception) {
+		return nil, &mbserver.AcknowledgeSlave
+	})
+
+	c := testConfig()
+	c.Modules[0].AcknowledgePollInterval = model.Duration(10 * time.Millisecond)
+	c.Modules[0].AcknowledgeTimeout = model.Duration(100 * time.Millisecond)
+
+	start := time.Now()
+	_, err := NewExporter(c).Scrape(context.Background(), address, 1, "my_module")
+	var exception *ExceptionError
+	if !errors.As(err, &exception) || exception.ExceptionCode != 5 {
+		t.Fatalf("expected acknowledge exception after the acknowledge timeout but got %v", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("expected polling to give up after the acknowledge timeout but took %v", d)
+	}
+}
+
+func TestScrapeShortResponse(t *testing.T) {
+	s, address := startServer(t)
+	// Only the first of the two registers requested.
+	s.RegisterFunctionHandler(3, func(*mbserver.Server, mbserver.Framer) ([]byte, *mbserver.Exception) {
+		return []byte{2, 0, 240}, &mbserver.Success
+	})
+
+	c := testConfig()
+	c.Modules[0].Metrics = append(c.Modules[0].Metrics, config.MetricDef{
+		Name:       "my_other_metric",
+		Address:    300023,
+		DataType:   config.ModbusInt16,
+		MetricType: config.MetricTypeGauge,
+	})
+
+	e := NewExporter(c)
+	gatherer, err := e.Scrape(context.Background(), address, 1, "my_module")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	metricFamilies, err := gatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(metricFamilies) != 1 || metricFamilies[0].GetName() != "my_metric" || metricFamilies[0].Metric[0].GetGauge().GetValue() != 240 {
+		t.Fatalf("expected only my_metric to be exposed but got %v", metricFamilies)
+	}
+
+	if v := testutil.ToFloat64(e.telemetry.shortResponses.WithLabelValues(address, "1", "my_module")); v != 1 {
+		t.Fatalf("expected 1 short response but got %v", v)
+	}
+	if n := testutil.CollectAndCount(e.telemetry.metricParseErrors); n != 0 {
+		t.Fatalf("expected no parse errors but got %v", n)
+	}
+}
@@ -0,0 +1,134 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package modbus
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/RichiH/modbus_exporter/config"
+)
+
+// pollResult is the outcome of the latest background poll of a target.
+type pollResult struct {
+	gatherer prometheus.Gatherer
+	err      error
+}
+
+// polledResults holds the latest poll result per target, sub target and
+// module.
+type polledResults struct {
+	mtx     sync.RWMutex
+	results map[string]pollResult
+}
+
+func (p *polledResults) get(key string) (pollResult, bool) {
+	p.mtx.RLock()
+	defer p.mtx.RUnlock()
+
+	r, ok := p.results[key]
+	return r, ok
+}
+
+func (p *polledResults) set(key string, r pollResult) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	p.results[key] = r
+}
+
+func (p *polledResults) reset() {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	p.results = map[string]pollResult{}
+}
+
+// Poll scrapes all targets configured for background polling at their
+// interval until the given context is canceled. Scrapes of polled targets are
+// served from the latest poll result instead of querying the device.
+func (e *Exporter) Poll(ctx context.Context) {
+	for {
+		pollCtx, cancel := context.WithCancel(ctx)
+		var wg sync.WaitGroup
+
+		for _, t := range e.GetConfig().Targets {
+			t := t
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				e.pollTarget(pollCtx, t)
+			}()
+		}
+
+		select {
+		case <-ctx.Done():
+			cancel()
+			wg.Wait()
+			return
+		case <-e.configChanged:
+			cancel()
+			wg.Wait()
+			// Don't serve results of targets no longer polled.
+			e.polled.reset()
+		}
+	}
+}
+
+func (e *Exporter) pollTarget(ctx context.Context, t config.PollTarget) {
+	ticker := time.NewTicker(time.Duration(t.Interval))
+	defer ticker.Stop()
+
+	for {
+		e.pollOnce(ctx, t)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (e *Exporter) pollOnce(ctx context.Context, t config.PollTarget) {
+	key := scrapeKey(t.Target, byte(t.SubTarget), t.Module)
+
+	module := e.GetConfig().GetModule(t.Module)
+	if module == nil {
+		e.polled.set(key, pollResult{err: fmt.Errorf("failed to find '%v' in config", t.Module)})
+		return
+	}
+
+	g, err := e.scrape(ctx, t.Target, byte(t.SubTarget), module)
+	e.polled.set(key, pollResult{gatherer: g, err: err})
+
+	if err == nil {
+		e.telemetry.pollLastSuccess.WithLabelValues(
+			t.Target, strconv.Itoa(t.SubTarget), t.Module,
+		).SetToCurrentTime()
+
+		for _, l := range e.pollListeners {
+			l(t, g)
+		}
+	}
+}
+
+func scrapeKey(targetAddress string, subTarget byte, moduleName string) string {
+	return fmt.Sprintf("%s/%d/%s", targetAddress, subTarget, moduleName)
+}
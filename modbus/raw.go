@@ -0,0 +1,64 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package modbus
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/RichiH/modbus_exporter/config"
+	"github.com/goburrow/modbus"
+)
+
+// ReadRaw reads the given quantity of registers (or coils, discrete inputs)
+// starting at address with the given function code and returns the raw data,
+// e.g. to reverse engineer undocumented register maps. The timeouts of the
+// given module apply, which may be nil. Reads count towards the limit of
+// concurrent scrapes.
+func (e *Exporter) ReadRaw(ctx context.Context, targetAddress string, subTarget byte, module *config.Module, functionCode uint8, address, quantity uint16) ([]byte, error) {
+	max := uint16(config.MaxReadRegisters)
+	if config.IsBitAccess(functionCode) {
+		max = config.MaxReadBits
+	}
+	if quantity == 0 || quantity > max {
+		return nil, fmt.Errorf("quantity must be from 1 to %d", max)
+	}
+	if int(address)+int(quantity) > 65536 {
+		return nil, fmt.Errorf("%v registers from address %v exceed the address space", quantity, address)
+	}
+
+	if module == nil {
+		module = &config.Module{}
+	}
+
+	release, err := e.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	handler, _, err := e.connect(ctx, splitTargets(targetAddress), subTarget, module)
+	if err != nil {
+		return nil, err
+	}
+	defer handler.Close()
+
+	data, err := readFunc(modbus.NewClient(handler), functionCode)(address, quantity)
+	if err != nil {
+		return nil, fmt.Errorf("reading %v from address %v with function code %v: %w",
+			quantity, address, functionCode, err)
+	}
+
+	return data, nil
+}
@@ -0,0 +1,162 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package modbus
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// ReplayScheme prefixes targets answered from a recording instead of a
+// device, e.g. replay:session.jsonl, if enabled with WithReplays.
+const ReplayScheme = "replay:"
+
+// recordedRequest is a request answered by a target as stored in recordings,
+// one JSON object per line with the PDUs hex encoded.
+type recordedRequest struct {
+	SubTarget byte   `json:"sub_target"`
+	Request   string `json:"request"`
+	Response  string `json:"response"`
+}
+
+// WriteRecording writes the given requests answered by targets, e.g. the ones
+// of a scrape passed to a request listener, in the format replayed by
+// replay: targets. Requests not answered are left out.
+func WriteRecording(w io.Writer, requests []Request) error {
+	enc := json.NewEncoder(w)
+	for _, r := range requests {
+		if r.RequestPDU == nil || r.ResponsePDU == nil {
+			continue
+		}
+		if err := enc.Encode(recordedRequest{
+			SubTarget: r.SubTarget,
+			Request:   hex.EncodeToString(r.RequestPDU),
+			Response:  hex.EncodeToString(r.ResponsePDU),
+		}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// replay answers requests with the responses of a recording. Requests
+// recorded several times are answered with their responses in turn, the last
+// one repeating.
+type replay struct {
+	mtx       sync.Mutex
+	responses map[string][][]byte
+	answered  map[string]int
+}
+
+// replays are the recordings of replay: targets, loaded once so that
+// consecutive scrapes get the responses in turn.
+type replays struct {
+	mtx    sync.Mutex
+	loaded map[string]*replay
+}
+
+// get returns the replay of the given replay: target, loading it on first
+// use.
+func (r *replays) get(target string) (*replay, error) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	if loaded, ok := r.loaded[target]; ok {
+		return loaded, nil
+	}
+	loaded, err := loadReplay(target)
+	if err != nil {
+		return nil, err
+	}
+	r.loaded[target] = loaded
+
+	return loaded, nil
+}
+
+// loadReplay reads the recording of the given replay: target.
+func loadReplay(target string) (*replay, error) {
+	f, err := os.Open(strings.TrimPrefix(target, ReplayScheme))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := &replay{responses: map[string][][]byte{}, answered: map[string]int{}}
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var recorded recordedRequest
+		if err := json.Unmarshal(scanner.Bytes(), &recorded); err != nil {
+			return nil, fmt.Errorf("line %v: %v", line, err)
+		}
+		request, err := hex.DecodeString(recorded.Request)
+		if err != nil {
+			return nil, fmt.Errorf("line %v: invalid request: %v", line, err)
+		}
+		response, err := hex.DecodeString(recorded.Response)
+		if err != nil || len(response) == 0 {
+			return nil, fmt.Errorf("line %v: invalid response: %v", line, err)
+		}
+		key := replayKey(recorded.SubTarget, request)
+		r.responses[key] = append(r.responses[key], response)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func replayKey(subTarget byte, pdu []byte) string {
+	return fmt.Sprintf("%v/%x", subTarget, pdu)
+}
+
+// answer returns the ADU answering the given request ADU.
+func (r *replay) answer(aduRequest []byte) ([]byte, error) {
+	if len(aduRequest) <= tcpHeaderLength {
+		return nil, fmt.Errorf("invalid request of %v bytes", len(aduRequest))
+	}
+	key := replayKey(aduRequest[tcpHeaderLength-1], aduRequest[tcpHeaderLength:])
+
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	responses := r.responses[key]
+	if len(responses) == 0 {
+		return nil, fmt.Errorf("request %x of sub target %v not in recording",
+			aduRequest[tcpHeaderLength:], aduRequest[tcpHeaderLength-1])
+	}
+	i := r.answered[key]
+	if i < len(responses)-1 {
+		r.answered[key]++
+	}
+	pdu := responses[i]
+
+	// The MBAP header echoes the transaction, protocol and unit IDs of the
+	// request.
+	aduResponse := make([]byte, tcpHeaderLength, tcpHeaderLength+len(pdu))
+	copy(aduResponse, aduRequest[:tcpHeaderLength])
+	binary.BigEndian.PutUint16(aduResponse[4:], uint16(len(pdu)+1))
+
+	return append(aduResponse, pdu...), nil
+}
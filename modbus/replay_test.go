@@ -0,0 +1,74 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package modbus
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReplay(t *testing.T) {
+	s, address := startServer(t)
+	s.HoldingRegisters[22] = 240
+
+	requests := []Request{}
+	e := NewExporter(testConfig(), WithRequestListener(func(r Request) {
+		requests = append(requests, r)
+	}))
+	if _, err := e.Scrape(context.Background(), address, 1, "my_module"); err != nil {
+		t.Fatal(err)
+	}
+	s.HoldingRegisters[22] = 250
+	if _, err := e.Scrape(context.Background(), address, 1, "my_module"); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	var b bytes.Buffer
+	if err := WriteRecording(&b, requests); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(t.TempDir(), "session.jsonl")
+	if err := os.WriteFile(file, b.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	target := ReplayScheme + file
+
+	if _, err := NewExporter(testConfig()).Scrape(context.Background(), target, 1, "my_module"); err == nil {
+		t.Fatal("expected replay target to fail without enabling replays")
+	}
+
+	// The responses are replayed in turn, the last one repeating.
+	e = NewExporter(testConfig(), WithReplays())
+	for _, expected := range []float64{240, 250, 250} {
+		g, err := e.Scrape(context.Background(), target, 1, "my_module")
+		if err != nil {
+			t.Fatal(err)
+		}
+		mfs, err := g.Gather()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v := mfs[0].Metric[0].GetGauge().GetValue(); v != expected {
+			t.Fatalf("expected replayed value %v but got %v", expected, v)
+		}
+	}
+
+	if _, err := e.Scrape(context.Background(), target, 2, "my_module"); err == nil {
+		t.Fatal("expected request of other sub target not in recording to fail")
+	}
+}
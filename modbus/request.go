@@ -0,0 +1,364 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package modbus
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/model"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/RichiH/modbus_exporter/config"
+)
+
+// ScrapeRequest is a scrape requested via the parameters of the /modbus
+// endpoint.
+type ScrapeRequest struct {
+	// Target is the address of the device, possibly a comma separated
+	// list of addresses tried in turn.
+	Target string
+	// Modules to scrape with, merging their metrics. Without, the module
+	// is selected by the identification of the device.
+	Modules []string
+	// SubTargets to scrape, distinguished by a sub_target label if there
+	// is more than one.
+	SubTargets []SubTarget
+	// Labels added to all metrics.
+	Labels map[string]string
+}
+
+// ParseScrapeRequest parses the target, module, sub_target and
+// extra_label[<name>] parameters of a scrape request. Several modules, e.g. a
+// common one and a device specific one, may be given by repeating the
+// parameter or comma separated. The sub target defaults to the given one if
+// not specified.
+func ParseScrapeRequest(c *config.Config, query url.Values, defaultSubTarget string) (ScrapeRequest, error) {
+	moduleNames := []string{}
+	for _, v := range query["module"] {
+		moduleNames = append(moduleNames, strings.Split(v, ",")...)
+	}
+	// Without module, it is selected by the identification of the device
+	// if configured.
+	if len(moduleNames) == 0 || moduleNames[0] == "" {
+		if c.Identification == nil {
+			return ScrapeRequest{}, fmt.Errorf("'module' parameter must be specified")
+		}
+		moduleNames = nil
+	}
+
+	modules := []*config.Module{}
+	for _, name := range moduleNames {
+		module := c.GetModule(name)
+		if module == nil {
+			return ScrapeRequest{}, fmt.Errorf("module '%v' not defined in configuration file", name)
+		}
+		modules = append(modules, module)
+	}
+
+	target := query.Get("target")
+	if target == "" {
+		return ScrapeRequest{}, fmt.Errorf("'target' parameter must be specified")
+	}
+
+	sT := query.Get("sub_target")
+	if sT == "" {
+		sT = defaultSubTarget
+	}
+	if sT == "" {
+		return ScrapeRequest{}, fmt.Errorf("'sub_target' parameter must be specified")
+	}
+	subTargets, err := ParseSubTargets(modules, sT)
+	if err != nil {
+		return ScrapeRequest{}, err
+	}
+
+	labels, err := extraLabels(query)
+	if err != nil {
+		return ScrapeRequest{}, err
+	}
+
+	return ScrapeRequest{Target: target, Modules: moduleNames, SubTargets: subTargets, Labels: labels}, nil
+}
+
+// ScrapeAll scrapes the sub targets of the given request one after the other
+// with all its modules.
+func (e *Exporter) ScrapeAll(ctx context.Context, r ScrapeRequest) (prometheus.Gatherer, error) {
+	var g prometheus.Gatherer
+	var err error
+	if len(r.Modules) == 0 {
+		g, err = e.scrapeIdentified(ctx, r.Target, r.SubTargets)
+	} else {
+		g, err = e.scrapeModules(ctx, r.Target, r.SubTargets, r.Modules)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(r.Labels) > 0 {
+		g = LabelGatherer{g, r.Labels}
+	}
+
+	return g, nil
+}
+
+// scrapeModules scrapes the given sub targets one after the other with all
+// given modules. If there is more than one sub target, their metrics are
+// distinguished by a sub_target label.
+func (e *Exporter) scrapeModules(ctx context.Context, target string, subTargets []SubTarget, moduleNames []string) (prometheus.Gatherer, error) {
+	if len(subTargets) == 1 && len(moduleNames) == 1 {
+		return e.Scrape(ctx, target, subTargets[0].ID, moduleNames[0])
+	}
+
+	gatherers := prometheus.Gatherers{}
+	for _, s := range subTargets {
+		for _, moduleName := range moduleNames {
+			g, err := e.Scrape(ctx, target, s.ID, moduleName)
+			if err != nil {
+				if len(moduleNames) > 1 {
+					err = fmt.Errorf("module %v: %w", moduleName, err)
+				}
+				if len(subTargets) > 1 {
+					err = fmt.Errorf("sub target %v: %w", s.Label, err)
+				}
+				return nil, err
+			}
+			if len(subTargets) > 1 {
+				g = LabelGatherer{g, map[string]string{"sub_target": s.Label}}
+			}
+			gatherers = append(gatherers, g)
+		}
+	}
+
+	return gatherers, nil
+}
+
+// scrapeIdentified scrapes each of the given sub targets with the module
+// selected by the identification of the device. If there is more than one sub
+// target, their metrics are distinguished by a sub_target label.
+func (e *Exporter) scrapeIdentified(ctx context.Context, target string, subTargets []SubTarget) (prometheus.Gatherer, error) {
+	gatherers := prometheus.Gatherers{}
+	for _, s := range subTargets {
+		g, err := e.scrapeIdentifiedSubTarget(ctx, target, s.ID)
+		if err != nil {
+			if len(subTargets) > 1 {
+				err = fmt.Errorf("sub target %v: %w", s.Label, err)
+			}
+			return nil, err
+		}
+		if len(subTargets) == 1 {
+			return g, nil
+		}
+		gatherers = append(gatherers, LabelGatherer{g, map[string]string{"sub_target": s.Label}})
+	}
+
+	return gatherers, nil
+}
+
+func (e *Exporter) scrapeIdentifiedSubTarget(ctx context.Context, target string, subTarget byte) (prometheus.Gatherer, error) {
+	moduleName, err := e.Identify(ctx, target, subTarget)
+	if err != nil {
+		return nil, err
+	}
+
+	g, err := e.Scrape(ctx, target, subTarget, moduleName)
+	if err != nil {
+		return nil, fmt.Errorf("module %v: %w", moduleName, err)
+	}
+
+	return g, nil
+}
+
+// ScrapeContext returns the context of the given scrape request, bounded by
+// the scrape timeout announced by Prometheus minus the given offset. Timeouts
+// that aren't a positive number of seconds are rejected.
+func ScrapeContext(r *http.Request, offset time.Duration) (context.Context, context.CancelFunc, error) {
+	v := r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds")
+	if v == "" {
+		ctx, cancel := context.WithCancel(r.Context())
+		return ctx, cancel, nil
+	}
+
+	seconds, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse timeout from Prometheus header: %v", err)
+	}
+	// Also rejects NaN as well as timeouts not representable as a duration.
+	if !(seconds > 0) || seconds >= math.MaxInt64/float64(time.Second) {
+		return nil, nil, fmt.Errorf("invalid timeout in Prometheus header, must be a positive number of seconds: %v", v)
+	}
+
+	timeout := time.Duration(seconds * float64(time.Second))
+	// Ignore the offset if it doesn't leave any time to scrape.
+	if offset < timeout {
+		timeout -= offset
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	return ctx, cancel, nil
+}
+
+// SubTarget is a sub target (unit ID) to scrape along with the value of its
+// sub_target label, which is the name it was referred to by if any.
+type SubTarget struct {
+	ID    byte
+	Label string
+}
+
+// ParseSubTargets parses the sub_target parameter, a comma separated list of
+// sub targets, ranges like 5-8 and sub target names of the given modules.
+func ParseSubTargets(modules []*config.Module, v string) ([]SubTarget, error) {
+	subTargets := []SubTarget{}
+	seen := map[byte]bool{}
+	add := func(id uint64, label string) {
+		if !seen[byte(id)] {
+			seen[byte(id)] = true
+			subTargets = append(subTargets, SubTarget{byte(id), label})
+		}
+	}
+
+	for _, s := range strings.Split(v, ",") {
+		if from, to, ok := strings.Cut(s, "-"); ok {
+			first, err := ParseSubTarget(from)
+			if err != nil {
+				return nil, err
+			}
+			last, err := ParseSubTarget(to)
+			if err != nil {
+				return nil, err
+			}
+			if first > last {
+				return nil, fmt.Errorf("'sub_target' parameter contains invalid range %v", s)
+			}
+			for id := first; id <= last; id++ {
+				add(id, strconv.FormatUint(id, 10))
+			}
+			continue
+		}
+
+		id, err := strconv.ParseUint(s, 10, 32)
+		if err != nil {
+			named, ok := subTargetByName(modules, s)
+			if !ok {
+				return nil, fmt.Errorf("'sub_target' parameter must be a valid integer or sub target name of module '%v': %v", moduleNames(modules), err)
+			}
+			add(uint64(named), s)
+			continue
+		}
+		if id > 255 {
+			return nil, fmt.Errorf("'sub_target' parameter must be from 0 to 255. Invalid value: %d", id)
+		}
+		add(id, s)
+	}
+
+	return subTargets, nil
+}
+
+// ParseSubTarget parses a single unit ID, e.g. one end of a sub target range.
+func ParseSubTarget(s string) (uint64, error) {
+	id, err := strconv.ParseUint(s, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("'sub_target' parameter must be a valid integer: %v", err)
+	}
+	if id > 255 {
+		return 0, fmt.Errorf("'sub_target' parameter must be from 0 to 255. Invalid value: %d", id)
+	}
+
+	return id, nil
+}
+
+// subTargetByName returns the sub target with the given name as defined by
+// the first of the given modules defining it.
+func subTargetByName(modules []*config.Module, name string) (byte, bool) {
+	for _, m := range modules {
+		if id, ok := m.SubTarget(name); ok {
+			return id, true
+		}
+	}
+
+	return 0, false
+}
+
+// moduleNames returns the comma separated names of the given modules.
+func moduleNames(modules []*config.Module) string {
+	names := make([]string, len(modules))
+	for i, m := range modules {
+		names[i] = m.Name
+	}
+
+	return strings.Join(names, ",")
+}
+
+// extraLabels returns the labels passed as extra_label[<name>]=<value> query
+// parameters.
+func extraLabels(query url.Values) (map[string]string, error) {
+	labels := map[string]string{}
+	for k, v := range query {
+		if !strings.HasPrefix(k, "extra_label[") || !strings.HasSuffix(k, "]") {
+			continue
+		}
+
+		name := strings.TrimSuffix(strings.TrimPrefix(k, "extra_label["), "]")
+		if !model.LabelName(name).IsValid() {
+			return nil, fmt.Errorf("invalid extra label name '%v'", name)
+		}
+		if len(v) != 1 {
+			return nil, fmt.Errorf("extra label '%v' must be specified once", name)
+		}
+		labels[name] = v[0]
+	}
+
+	return labels, nil
+}
+
+// LabelGatherer adds the given labels to all metrics of the gatherer.
+type LabelGatherer struct {
+	prometheus.Gatherer
+	Labels map[string]string
+}
+
+// Gather implements prometheus.Gatherer.
+func (g LabelGatherer) Gather() ([]*dto.MetricFamily, error) {
+	mfs, err := g.Gatherer.Gather()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, mf := range mfs {
+		for _, m := range mf.Metric {
+			for _, l := range m.Label {
+				if _, ok := g.Labels[l.GetName()]; ok {
+					return nil, fmt.Errorf("label '%v' collides with label of metric %v", l.GetName(), mf.GetName())
+				}
+			}
+			for name, value := range g.Labels {
+				m.Label = append(m.Label, &dto.LabelPair{Name: proto.String(name), Value: proto.String(value)})
+			}
+			sort.Slice(m.Label, func(i, j int) bool {
+				return m.Label[i].GetName() < m.Label[j].GetName()
+			})
+		}
+	}
+
+	return mfs, nil
+}
@@ -0,0 +1,177 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package modbus
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/RichiH/modbus_exporter/config"
+)
+
+func TestParseSubTargets(t *testing.T) {
+	module := &config.Module{Name: "my_module", SubTargetNames: map[string]int{"inverter_1": 3}}
+
+	for _, test := range []struct {
+		in         string
+		subTargets []SubTarget
+		valid      bool
+	}{
+		{"1", []SubTarget{{1, "1"}}, true},
+		{"inverter_1", []SubTarget{{3, "inverter_1"}}, true},
+		{"1,2,5-7", []SubTarget{{1, "1"}, {2, "2"}, {5, "5"}, {6, "6"}, {7, "7"}}, true},
+		{"1-2,2,inverter_1", []SubTarget{{1, "1"}, {2, "2"}, {3, "inverter_1"}}, true},
+		{"256", nil, false},
+		{"7-5", nil, false},
+		{"1-256", nil, false},
+		{"1,", nil, false},
+		{"inverter_2", nil, false},
+	} {
+		subTargets, err := ParseSubTargets([]*config.Module{{Name: "common"}, module}, test.in)
+		if (err == nil) != test.valid {
+			t.Fatalf("%v: expected valid to be %v but got error %v", test.in, test.valid, err)
+		}
+		if err == nil && !reflect.DeepEqual(subTargets, test.subTargets) {
+			t.Fatalf("%v: expected %v but got %v", test.in, test.subTargets, subTargets)
+		}
+	}
+}
+
+func TestExtraLabels(t *testing.T) {
+	for _, test := range []struct {
+		query  string
+		labels map[string]string
+		valid  bool
+	}{
+		{"module=a", map[string]string{}, true},
+		{"extra_label[site]=berlin&extra_label[rack]=r12", map[string]string{"site": "berlin", "rack": "r12"}, true},
+		{"extra_label[0site]=berlin", nil, false},
+		{"extra_label[site]=berlin&extra_label[site]=paris", nil, false},
+	} {
+		query, err := url.ParseQuery(test.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		labels, err := extraLabels(query)
+		if (err == nil) != test.valid {
+			t.Fatalf("%v: expected valid to be %v but got error %v", test.query, test.valid, err)
+		}
+		if err == nil && !reflect.DeepEqual(labels, test.labels) {
+			t.Fatalf("%v: expected labels %v but got %v", test.query, test.labels, labels)
+		}
+	}
+}
+
+func TestLabelGatherer(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	g := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "my_metric", Help: "my_help"}, []string{"module"})
+	g.WithLabelValues("my_module").Set(1)
+	reg.MustRegister(g)
+
+	mfs, err := LabelGatherer{reg, map[string]string{"site": "berlin", "rack": "r12"}}.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	labels := []string{}
+	for _, l := range mfs[0].Metric[0].Label {
+		labels = append(labels, l.GetName()+"="+l.GetValue())
+	}
+	expected := []string{"module=my_module", "rack=r12", "site=berlin"}
+	if !reflect.DeepEqual(labels, expected) {
+		t.Fatalf("expected labels %v but got %v", expected, labels)
+	}
+
+	if _, err := (LabelGatherer{reg, map[string]string{"module": "other"}}).Gather(); err == nil {
+		t.Fatal("expected colliding extra label to fail")
+	}
+}
+
+func TestScrapeContext(t *testing.T) {
+	req, err := http.NewRequest("GET", "/modbus", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req.Header.Set("X-Prometheus-Scrape-Timeout-Seconds", "10")
+	ctx, cancel, err := ScrapeContext(req, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cancel()
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		t.Fatal("expected context to have a deadline")
+	}
+	if left := time.Until(deadline); left > 9*time.Second || left < 8*time.Second {
+		t.Fatalf("expected deadline in about 9s but got %v", left)
+	}
+
+	for _, v := range []string{"invalid", "0", "-1", "NaN", "Inf", "-Inf", "1e300"} {
+		req.Header.Set("X-Prometheus-Scrape-Timeout-Seconds", v)
+		if _, _, err := ScrapeContext(req, time.Second); err == nil {
+			t.Fatalf("expected header %v to fail", v)
+		}
+	}
+}
+
+func TestScrapeAll(t *testing.T) {
+	s, address := startServer(t)
+	s.HoldingRegisters[1] = 1
+	s.HoldingRegisters[2] = 2
+
+	module := func(name string, address config.RegisterAddr) config.Module {
+		return config.Module{
+			Name:     name,
+			Protocol: config.ModbusProtocolTCPIP,
+			Timeout:  500,
+			Metrics: []config.MetricDef{
+				{Name: name + "_metric", Address: address, DataType: config.ModbusInt16, MetricType: config.MetricTypeGauge},
+			},
+		}
+	}
+	e := NewExporter(config.Config{Modules: []config.Module{module("common", 300001), module("device", 300002)}})
+
+	g, err := e.ScrapeAll(context.Background(), ScrapeRequest{Target: address, SubTargets: []SubTarget{{1, "1"}, {2, "meter"}}, Modules: []string{"common", "device"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mfs, err := g.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	series := map[string]float64{}
+	for _, mf := range mfs {
+		for _, m := range mf.Metric {
+			for _, l := range m.Label {
+				if l.GetName() == "sub_target" {
+					series[mf.GetName()+"/"+l.GetValue()] = m.GetGauge().GetValue()
+				}
+			}
+		}
+	}
+	expected := map[string]float64{"common_metric/1": 1, "common_metric/meter": 1, "device_metric/1": 2, "device_metric/meter": 2}
+	if !reflect.DeepEqual(series, expected) {
+		t.Fatalf("expected %v but got %v", expected, series)
+	}
+}
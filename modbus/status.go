@@ -0,0 +1,119 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package modbus
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// TargetStatus summarizes the latest scrape of a target, sub target and
+// module.
+type TargetStatus struct {
+	Target     string    `json:"target"`
+	SubTarget  byte      `json:"subTarget"`
+	Module     string    `json:"module"`
+	LastScrape time.Time `json:"lastScrape"`
+	// Duration of the latest scrape in seconds.
+	Duration float64 `json:"durationSeconds"`
+	// Blocks of registers requested by the latest scrape.
+	Blocks  int    `json:"blocks"`
+	Success bool   `json:"success"`
+	Error   string `json:"error,omitempty"`
+}
+
+// targetStatuses tracks the status of each target scraped.
+type targetStatuses struct {
+	mtx      sync.Mutex
+	statuses map[string]TargetStatus
+}
+
+func newTargetStatuses() *targetStatuses {
+	return &targetStatuses{statuses: map[string]TargetStatus{}}
+}
+
+// record records the outcome of a scrape started at the given time and
+// returns it.
+func (s *targetStatuses) record(targetAddress string, subTarget byte, moduleName string, start time.Time, blocks int, err error) TargetStatus {
+	status := TargetStatus{
+		Target:     targetAddress,
+		SubTarget:  subTarget,
+		Module:     moduleName,
+		LastScrape: start,
+		Duration:   time.Since(start).Seconds(),
+		Blocks:     blocks,
+		Success:    err == nil,
+	}
+	if err != nil {
+		status.Error = err.Error()
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.statuses[scrapeKey(targetAddress, subTarget, moduleName)] = status
+
+	return status
+}
+
+// expire removes the statuses of targets none of whose sub targets and
+// modules were scraped since the given time and returns these targets.
+func (s *targetStatuses) expire(since time.Time) []string {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	active := map[string]bool{}
+	for _, status := range s.statuses {
+		active[status.Target] = active[status.Target] || !status.LastScrape.Before(since)
+	}
+
+	expired := []string{}
+	for key, status := range s.statuses {
+		if !active[status.Target] {
+			delete(s.statuses, key)
+		}
+	}
+	for target, ok := range active {
+		if !ok {
+			expired = append(expired, target)
+		}
+	}
+	sort.Strings(expired)
+
+	return expired
+}
+
+// TargetStatuses returns the status of the latest scrape of each target, sub
+// target and module scraped so far, ordered by target, sub target and module.
+func (e *Exporter) TargetStatuses() []TargetStatus {
+	e.statuses.mtx.Lock()
+	statuses := make([]TargetStatus, 0, len(e.statuses.statuses))
+	for _, s := range e.statuses.statuses {
+		statuses = append(statuses, s)
+	}
+	e.statuses.mtx.Unlock()
+
+	sort.Slice(statuses, func(i, j int) bool {
+		a, b := statuses[i], statuses[j]
+		if a.Target != b.Target {
+			return a.Target < b.Target
+		}
+		if a.SubTarget != b.SubTarget {
+			return a.SubTarget < b.SubTarget
+		}
+		return a.Module < b.Module
+	})
+
+	return statuses
+}
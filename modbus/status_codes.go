@@ -0,0 +1,46 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package modbus
+
+import "net/http"
+
+// DefaultStatus is the key of StatusCodes applying to the statuses not
+// listed.
+const DefaultStatus = "default"
+
+// StatusCodes maps the status of failed scrapes, as returned by ScrapeStatus,
+// to the HTTP status code to respond with. 200 responds with modbus_up 0
+// instead.
+type StatusCodes map[string]int
+
+// DefaultStatusCodes are the status codes failed scrapes are responded to
+// with unless configured otherwise.
+var DefaultStatusCodes = StatusCodes{
+	"too_many_scrapes":  http.StatusServiceUnavailable,
+	"throttled":         http.StatusTooManyRequests,
+	"deadline_exceeded": http.StatusGatewayTimeout,
+	"connect_failed":    http.StatusServiceUnavailable,
+	"timeout":           http.StatusGatewayTimeout,
+	DefaultStatus:       http.StatusInternalServerError,
+}
+
+// Code returns the HTTP status code to respond to a scrape failing with the
+// given error with.
+func (c StatusCodes) Code(err error) int {
+	if code, ok := c[ScrapeStatus(err)]; ok {
+		return code
+	}
+
+	return c[DefaultStatus]
+}
@@ -0,0 +1,64 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package modbus
+
+import (
+	"context"
+	"testing"
+)
+
+func TestTargetStatuses(t *testing.T) {
+	_, address := startServer(t)
+	unreachable := freeAddress(t)
+
+	e := NewExporter(testConfig())
+	if _, err := e.Scrape(context.Background(), address, 1, "my_module"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.Scrape(context.Background(), unreachable, 2, "my_module"); err == nil {
+		t.Fatal("expected scraping an unreachable target to fail")
+	}
+
+	statuses := e.TargetStatuses()
+	if len(statuses) != 2 {
+		t.Fatalf("expected 2 statuses but got %v", statuses)
+	}
+
+	byTarget := map[string]TargetStatus{}
+	for _, s := range statuses {
+		byTarget[s.Target] = s
+	}
+	if s := byTarget[address]; !s.Success || s.Error != "" || s.SubTarget != 1 || s.Module != "my_module" || s.Blocks != 1 || s.LastScrape.IsZero() {
+		t.Fatalf("expected successful scrape of %v but got %+v", address, s)
+	}
+	if s := byTarget[unreachable]; s.Success || s.Error == "" {
+		t.Fatalf("expected failed scrape of %v but got %+v", unreachable, s)
+	}
+}
+
+func TestScrapeListener(t *testing.T) {
+	_, address := startServer(t)
+
+	var statuses []TargetStatus
+	e := NewExporter(testConfig(), WithScrapeListener(func(s TargetStatus) {
+		statuses = append(statuses, s)
+	}))
+	if _, err := e.Scrape(context.Background(), address, 1, "my_module"); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(statuses) != 1 || !statuses[0].Success || statuses[0].Target != address {
+		t.Fatalf("expected the successful scrape of %v to be passed to the listener but got %+v", address, statuses)
+	}
+}
@@ -0,0 +1,377 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package modbus
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/RichiH/modbus_exporter/config"
+	"github.com/goburrow/modbus"
+)
+
+const (
+	// sunspecMarker starts the SunSpec model chain, "SunS" in ASCII.
+	sunspecMarker uint32 = 0x53756e53
+	// sunspecEndID ends the SunSpec model chain.
+	sunspecEndID uint16 = 0xffff
+	// sunspecCommonID is the ID of the common model describing the device.
+	sunspecCommonID uint16 = 1
+)
+
+// sunspecBaseAddresses are the holding registers the model chain may start at
+// according to SunSpec, in the order they are tried.
+var sunspecBaseAddresses = []uint16{40000, 0, 50000}
+
+type sunspecPointType int
+
+const (
+	sunspecInt16 sunspecPointType = iota
+	sunspecUInt16
+	sunspecEnum16
+	sunspecAcc32
+)
+
+// sunspecPoint is a numeric point of a SunSpec model exposed as metric.
+type sunspecPoint struct {
+	name   string
+	help   string
+	labels map[string]string
+	// Offset of the point within the model, not counting the ID and length
+	// registers.
+	offset uint16
+	typ    sunspecPointType
+	// Offset of the scale factor of the point, negative if unscaled.
+	scaleFactor int
+	// Factor converting the value to the base unit of the metric, e.g.
+	// percent to ratio. 0 means 1.
+	factor     float64
+	metricType config.MetricType
+}
+
+// sunspecModel describes the points of a group of SunSpec models sharing a
+// layout, e.g. single and three phase inverters.
+type sunspecModel struct {
+	points []sunspecPoint
+}
+
+func sunspecPhases(name, help string, offset uint16, scaleFactor int, phases ...string) []sunspecPoint {
+	points := make([]sunspecPoint, 0, len(phases))
+	for i, phase := range phases {
+		points = append(points, sunspecPoint{
+			name: name, help: help, labels: map[string]string{"phase": phase},
+			offset: offset + uint16(i), typ: sunspecUInt16, scaleFactor: scaleFactor,
+			metricType: config.MetricTypeGauge,
+		})
+	}
+
+	return points
+}
+
+func sunspecSigned(points []sunspecPoint) []sunspecPoint {
+	for i := range points {
+		points[i].typ = sunspecInt16
+	}
+
+	return points
+}
+
+func joinPoints(groups ...[]sunspecPoint) []sunspecPoint {
+	points := []sunspecPoint{}
+	for _, g := range groups {
+		points = append(points, g...)
+	}
+
+	return points
+}
+
+// sunspecInverter are the integer inverter models 101, 102 and 103 of single,
+// split and three phase inverters.
+var sunspecInverter = sunspecModel{points: joinPoints(
+	[]sunspecPoint{
+		{name: "sunspec_inverter_ac_current_amperes", help: "Total AC current of the inverter.", offset: 0, typ: sunspecUInt16, scaleFactor: 4, metricType: config.MetricTypeGauge},
+	},
+	sunspecPhases("sunspec_inverter_ac_phase_current_amperes", "AC current of the inverter per phase.", 1, 4, "A", "B", "C"),
+	sunspecPhases("sunspec_inverter_ac_phase_voltage_volts", "AC voltage of the inverter per phase to neutral.", 8, 11, "A", "B", "C"),
+	[]sunspecPoint{
+		{name: "sunspec_inverter_ac_power_watts", help: "AC power of the inverter.", offset: 12, typ: sunspecInt16, scaleFactor: 13, metricType: config.MetricTypeGauge},
+		{name: "sunspec_inverter_ac_frequency_hertz", help: "AC frequency of the inverter.", offset: 14, typ: sunspecUInt16, scaleFactor: 15, metricType: config.MetricTypeGauge},
+		{name: "sunspec_inverter_ac_apparent_power_volt_amperes", help: "AC apparent power of the inverter.", offset: 16, typ: sunspecInt16, scaleFactor: 17, metricType: config.MetricTypeGauge},
+		{name: "sunspec_inverter_ac_reactive_power_var", help: "AC reactive power of the inverter.", offset: 18, typ: sunspecInt16, scaleFactor: 19, metricType: config.MetricTypeGauge},
+		{name: "sunspec_inverter_ac_power_factor_ratio", help: "AC power factor of the inverter.", offset: 20, typ: sunspecInt16, scaleFactor: 21, factor: 0.01, metricType: config.MetricTypeGauge},
+		{name: "sunspec_inverter_ac_energy_watt_hours_total", help: "AC energy produced by the inverter.", offset: 22, typ: sunspecAcc32, scaleFactor: 24, metricType: config.MetricTypeCounter},
+		{name: "sunspec_inverter_dc_current_amperes", help: "DC current of the inverter.", offset: 25, typ: sunspecUInt16, scaleFactor: 26, metricType: config.MetricTypeGauge},
+		{name: "sunspec_inverter_dc_voltage_volts", help: "DC voltage of the inverter.", offset: 27, typ: sunspecUInt16, scaleFactor: 28, metricType: config.MetricTypeGauge},
+		{name: "sunspec_inverter_dc_power_watts", help: "DC power of the inverter.", offset: 29, typ: sunspecInt16, scaleFactor: 30, metricType: config.MetricTypeGauge},
+		{name: "sunspec_inverter_temperature_celsius", help: "Temperature of the inverter.", labels: map[string]string{"sensor": "cabinet"}, offset: 31, typ: sunspecInt16, scaleFactor: 35, metricType: config.MetricTypeGauge},
+		{name: "sunspec_inverter_temperature_celsius", help: "Temperature of the inverter.", labels: map[string]string{"sensor": "heat_sink"}, offset: 32, typ: sunspecInt16, scaleFactor: 35, metricType: config.MetricTypeGauge},
+		{name: "sunspec_inverter_temperature_celsius", help: "Temperature of the inverter.", labels: map[string]string{"sensor": "transformer"}, offset: 33, typ: sunspecInt16, scaleFactor: 35, metricType: config.MetricTypeGauge},
+		{name: "sunspec_inverter_temperature_celsius", help: "Temperature of the inverter.", labels: map[string]string{"sensor": "other"}, offset: 34, typ: sunspecInt16, scaleFactor: 35, metricType: config.MetricTypeGauge},
+		{name: "sunspec_inverter_operating_state", help: "Operating state of the inverter, 1 off, 2 sleeping, 3 starting, 4 MPPT, 5 throttled, 6 shutting down, 7 fault, 8 standby.", offset: 36, typ: sunspecEnum16, scaleFactor: -1, metricType: config.MetricTypeGauge},
+	},
+)}
+
+// sunspecMeter are the integer meter models 201 to 204 of single, split and
+// three phase meters.
+var sunspecMeter = sunspecModel{points: joinPoints(
+	sunspecSigned([]sunspecPoint{
+		{name: "sunspec_meter_ac_current_amperes", help: "Total AC current of the meter.", offset: 0, scaleFactor: 4, metricType: config.MetricTypeGauge},
+	}),
+	sunspecSigned(sunspecPhases("sunspec_meter_ac_phase_current_amperes", "AC current of the meter per phase.", 1, 4, "A", "B", "C")),
+	sunspecSigned(sunspecPhases("sunspec_meter_ac_phase_voltage_volts", "AC voltage of the meter per phase to neutral.", 6, 13, "A", "B", "C")),
+	sunspecSigned([]sunspecPoint{
+		{name: "sunspec_meter_ac_frequency_hertz", help: "AC frequency of the meter.", offset: 14, scaleFactor: 15, metricType: config.MetricTypeGauge},
+		{name: "sunspec_meter_ac_power_watts", help: "Total AC power of the meter.", offset: 16, scaleFactor: 20, metricType: config.MetricTypeGauge},
+	}),
+	sunspecSigned(sunspecPhases("sunspec_meter_ac_phase_power_watts", "AC power of the meter per phase.", 17, 20, "A", "B", "C")),
+	sunspecSigned([]sunspecPoint{
+		{name: "sunspec_meter_ac_apparent_power_volt_amperes", help: "Total AC apparent power of the meter.", offset: 21, scaleFactor: 25, metricType: config.MetricTypeGauge},
+		{name: "sunspec_meter_ac_reactive_power_var", help: "Total AC reactive power of the meter.", offset: 26, scaleFactor: 30, metricType: config.MetricTypeGauge},
+		{name: "sunspec_meter_ac_power_factor_ratio", help: "Average AC power factor of the meter.", offset: 31, scaleFactor: 35, factor: 0.01, metricType: config.MetricTypeGauge},
+	}),
+	[]sunspecPoint{
+		{name: "sunspec_meter_exported_energy_watt_hours_total", help: "Total AC energy exported, as measured by the meter.", offset: 36, typ: sunspecAcc32, scaleFactor: 52, metricType: config.MetricTypeCounter},
+		{name: "sunspec_meter_imported_energy_watt_hours_total", help: "Total AC energy imported, as measured by the meter.", offset: 44, typ: sunspecAcc32, scaleFactor: 52, metricType: config.MetricTypeCounter},
+	},
+)}
+
+// sunspecModels are the models exposed as metrics by their ID.
+var sunspecModels = map[uint16]*sunspecModel{
+	101: &sunspecInverter,
+	102: &sunspecInverter,
+	103: &sunspecInverter,
+	201: &sunspecMeter,
+	202: &sunspecMeter,
+	203: &sunspecMeter,
+	204: &sunspecMeter,
+}
+
+// sunspecBlock locates a model of the chain of a device.
+type sunspecBlock struct {
+	id uint16
+	// Address of the first register after the ID and length registers.
+	address uint16
+	length  uint16
+}
+
+// scrapeSunSpec walks the SunSpec model chain of the device and returns the
+// metrics of the common, inverter and meter models found. Only the first model
+// of each ID is exposed.
+func scrapeSunSpec(s *config.SunSpec, c modbus.Client) ([]metric, error) {
+	blocks, err := walkSunSpec(s, c)
+	if err != nil {
+		return nil, err
+	}
+
+	metrics := []metric{}
+	seen := map[uint16]bool{}
+	for _, b := range blocks {
+		m, known := sunspecModels[b.id]
+		if (!known && b.id != sunspecCommonID) || seen[b.id] {
+			continue
+		}
+		seen[b.id] = true
+
+		data, err := readHoldingRegisters(c, b.address, b.length)
+		if err != nil {
+			return nil, fmt.Errorf("reading SunSpec model %v: %w", b.id, err)
+		}
+
+		if b.id == sunspecCommonID {
+			metrics = append(metrics, sunspecCommon(data))
+			continue
+		}
+		metrics = append(metrics, m.parse(b.id, data)...)
+	}
+
+	return metrics, nil
+}
+
+// walkSunSpec returns the models of the chain starting at the configured base
+// address, or at the first of the default ones holding the marker.
+func walkSunSpec(s *config.SunSpec, c modbus.Client) ([]sunspecBlock, error) {
+	bases := sunspecBaseAddresses
+	if s.BaseAddress != nil {
+		_, address, err := s.BaseAddress.Split()
+		if err != nil {
+			return nil, err
+		}
+		bases = []uint16{address}
+	}
+
+	for _, base := range bases {
+		data, err := c.ReadHoldingRegisters(base, 2)
+		if err != nil || len(data) < 4 || binary.BigEndian.Uint32(data) != sunspecMarker {
+			continue
+		}
+
+		return walkModels(c, base+2)
+	}
+
+	return nil, fmt.Errorf("no SunSpec marker found at holding registers %v", bases)
+}
+
+func walkModels(c modbus.Client, address uint16) ([]sunspecBlock, error) {
+	blocks := []sunspecBlock{}
+	for {
+		if int(address)+2 > 65536 {
+			return nil, fmt.Errorf("SunSpec model chain exceeds the address space")
+		}
+		header, err := c.ReadHoldingRegisters(address, 2)
+		if err != nil {
+			return nil, fmt.Errorf("reading SunSpec model header at %v: %w", address, err)
+		}
+		if len(header) < 4 {
+			return nil, &InsufficientRegistersError{fmt.Sprintf("expected 4 bytes of SunSpec model header at %v but got %v", address, len(header))}
+		}
+
+		id, length := binary.BigEndian.Uint16(header), binary.BigEndian.Uint16(header[2:])
+		if id == sunspecEndID {
+			return blocks, nil
+		}
+		if int(address)+2+int(length) > 65536 {
+			return nil, fmt.Errorf("SunSpec model %v at %v exceeds the address space", id, address)
+		}
+
+		blocks = append(blocks, sunspecBlock{id: id, address: address + 2, length: length})
+		address += 2 + length
+	}
+}
+
+// readHoldingRegisters reads the given number of holding registers, split into
+// several requests if needed.
+func readHoldingRegisters(c modbus.Client, address, quantity uint16) ([]byte, error) {
+	data := make([]byte, 0, int(quantity)*2)
+	for quantity > 0 {
+		n := quantity
+		if n > config.MaxReadRegisters {
+			n = config.MaxReadRegisters
+		}
+
+		d, err := c.ReadHoldingRegisters(address, n)
+		if err != nil {
+			return nil, fmt.Errorf("reading %v from address %v with function code %v: %w",
+				n, address, config.FuncCodeReadHoldingRegisters, err)
+		}
+		if len(d) < int(n)*2 {
+			return nil, &InsufficientRegistersError{fmt.Sprintf("expected %v bytes from address %v but got %v", n*2, address, len(d))}
+		}
+
+		data = append(data, d[:n*2]...)
+		address += n
+		quantity -= n
+	}
+
+	return data, nil
+}
+
+// sunspecCommon returns an info metric with the identification of the device
+// from the data of the common model.
+func sunspecCommon(data []byte) metric {
+	str := func(offset, length int) string {
+		if (offset+length)*2 > len(data) {
+			return ""
+		}
+		return parseString(data[offset*2 : (offset+length)*2])
+	}
+
+	return metric{
+		Name: "sunspec_common_info",
+		Help: "Identification of the SunSpec device, always 1.",
+		Labels: map[string]string{
+			"manufacturer":  str(0, 16),
+			"device_model":  str(16, 16),
+			"version":       str(40, 8),
+			"serial_number": str(48, 16),
+		},
+		Value:      1,
+		MetricType: config.MetricTypeGauge,
+	}
+}
+
+// parse returns the metrics of the points implemented by the device, given the
+// data of the model.
+func (m *sunspecModel) parse(id uint16, data []byte) []metric {
+	register := func(offset int) (uint16, bool) {
+		if offset < 0 || (offset+1)*2 > len(data) {
+			return 0, false
+		}
+		return binary.BigEndian.Uint16(data[offset*2:]), true
+	}
+
+	metrics := []metric{}
+	for _, p := range m.points {
+		v, ok := p.value(register)
+		if !ok {
+			continue
+		}
+
+		if p.scaleFactor >= 0 {
+			sf, ok := register(p.scaleFactor)
+			// Scale factors are exponents to the base of 10, 0x8000 means
+			// not implemented.
+			if !ok || sf == 0x8000 {
+				continue
+			}
+			v *= math.Pow10(int(int16(sf)))
+		}
+		if p.factor != 0 {
+			v *= p.factor
+		}
+
+		labels := make(map[string]string, len(p.labels)+1)
+		for k, v := range p.labels {
+			labels[k] = v
+		}
+		labels["model_id"] = strconv.Itoa(int(id))
+
+		metrics = append(metrics, metric{p.name, p.help, labels, v, p.metricType, nil})
+	}
+
+	return metrics
+}
+
+// value returns the unscaled value of the point, or false if the device
+// doesn't implement it.
+func (p *sunspecPoint) value(register func(int) (uint16, bool)) (float64, bool) {
+	r, ok := register(int(p.offset))
+	if !ok {
+		return 0, false
+	}
+
+	switch p.typ {
+	case sunspecInt16:
+		if r == 0x8000 {
+			return 0, false
+		}
+		return float64(int16(r)), true
+	case sunspecAcc32:
+		low, ok := register(int(p.offset) + 1)
+		if !ok {
+			return 0, false
+		}
+		v := uint32(r)<<16 | uint32(low)
+		if v == 0 {
+			return 0, false
+		}
+		return float64(v), true
+	default:
+		if r == 0xffff {
+			return 0, false
+		}
+		return float64(r), true
+	}
+}
@@ -0,0 +1,149 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package modbus
+
+import (
+	"context"
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/RichiH/modbus_exporter/config"
+)
+
+// setString sets the given string at the registers starting at address, two
+// characters per register.
+func setString(registers []uint16, address int, s string) {
+	for i := 0; i < len(s); i += 2 {
+		r := uint16(s[i]) << 8
+		if i+1 < len(s) {
+			r |= uint16(s[i+1])
+		}
+		registers[address+i/2] = r
+	}
+}
+
+func TestScrapeSunSpec(t *testing.T) {
+	s, address := startServer(t)
+	r := s.HoldingRegisters
+
+	r[40000], r[40001] = 0x5375, 0x6e53
+	// Common model.
+	r[40002], r[40003] = 1, 66
+	setString(r, 40004, "Acme")
+	setString(r, 40020, "Sun 3000")
+	setString(r, 40044, "1.2.3")
+	setString(r, 40052, "SN42")
+	// Single phase inverter model.
+	base := 40070
+	r[base], r[base+1] = 101, 50
+	data := base + 2
+	// Total and phase A current, 12.5 A with scale factor -1.
+	r[data], r[data+1], r[data+4] = 125, 125, 0xffff
+	// Phases B and C are not implemented.
+	r[data+2], r[data+3] = 0xffff, 0xffff
+	// AC power, -300 W, scale factor 0.
+	r[data+12], r[data+13] = 0xfed4, 0
+	// Frequency scale factor not implemented.
+	r[data+14], r[data+15] = 5000, 0x8000
+	// Energy, 70000 * 10 Wh.
+	r[data+22], r[data+23], r[data+24] = 0x0001, 0x1170, 1
+	// Operating state MPPT.
+	r[data+36] = 4
+	// Unknown model, skipped.
+	r[base+52], r[base+53] = 64001, 3
+	r[base+57] = 0xffff
+
+	c := config.Config{Modules: []config.Module{{
+		Name:     "inverter",
+		Protocol: config.ModbusProtocolTCPIP,
+		SunSpec:  &config.SunSpec{},
+	}}}
+
+	e := NewExporter(c)
+	gatherer, err := e.Scrape(context.Background(), address, 1, "inverter")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mfs, err := gatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	values := map[string][]*dto.Metric{}
+	for _, mf := range mfs {
+		values[mf.GetName()] = mf.GetMetric()
+	}
+
+	info := values["sunspec_common_info"]
+	if len(info) != 1 {
+		t.Fatalf("expected one info metric but got %v", info)
+	}
+	labels := map[string]string{}
+	for _, l := range info[0].GetLabel() {
+		labels[l.GetName()] = l.GetValue()
+	}
+	expectedLabels := map[string]string{
+		"manufacturer": "Acme", "device_model": "Sun 3000", "version": "1.2.3",
+		"serial_number": "SN42", "module": "inverter",
+	}
+	for k, v := range expectedLabels {
+		if labels[k] != v {
+			t.Errorf("expected label %v=%q but got %q", k, v, labels[k])
+		}
+	}
+
+	gauge := func(name string) float64 {
+		if len(values[name]) != 1 {
+			t.Fatalf("expected one %v metric but got %v", name, values[name])
+		}
+		return values[name][0].GetGauge().GetValue()
+	}
+	if v := gauge("sunspec_inverter_ac_current_amperes"); v != 12.5 {
+		t.Errorf("expected current of 12.5 but got %v", v)
+	}
+	if v := gauge("sunspec_inverter_ac_phase_current_amperes"); v != 12.5 {
+		t.Errorf("expected current of phase A of 12.5 but got %v", v)
+	}
+	if v := gauge("sunspec_inverter_ac_power_watts"); v != -300 {
+		t.Errorf("expected power of -300 but got %v", v)
+	}
+	if v := gauge("sunspec_inverter_operating_state"); v != 4 {
+		t.Errorf("expected operating state 4 but got %v", v)
+	}
+	if _, ok := values["sunspec_inverter_ac_frequency_hertz"]; ok {
+		t.Errorf("expected no frequency without scale factor")
+	}
+
+	energy := values["sunspec_inverter_ac_energy_watt_hours_total"]
+	if len(energy) != 1 || energy[0].GetCounter().GetValue() != 700000 {
+		t.Errorf("expected energy of 700000 but got %v", energy)
+	}
+}
+
+func TestScrapeSunSpecNoMarker(t *testing.T) {
+	_, address := startServer(t)
+
+	c := config.Config{Modules: []config.Module{{
+		Name:     "inverter",
+		Protocol: config.ModbusProtocolTCPIP,
+		SunSpec:  &config.SunSpec{},
+	}}}
+
+	e := NewExporter(c)
+	if _, err := e.Scrape(context.Background(), address, 1, "inverter"); err == nil {
+		t.Fatal("expected error without SunSpec marker")
+	}
+}
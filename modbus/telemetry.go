@@ -0,0 +1,315 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package modbus
+
+import (
+	"encoding/binary"
+	"strconv"
+	"time"
+
+	"github.com/goburrow/modbus"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// telemetry holds the exporter's own metrics, as opposed to the ones scraped
+// from targets.
+type telemetry struct {
+	scrapesInFlight prometheus.Gauge
+	scrapesQueued   prometheus.Gauge
+	scrapesRejected prometheus.Counter
+
+	scrapesDeduplicated prometheus.Counter
+	scrapeCacheHits     prometheus.Counter
+	scrapesThrottled    prometheus.Counter
+
+	pollLastSuccess *prometheus.GaugeVec
+
+	metricParseErrors *prometheus.CounterVec
+	shortResponses    *prometheus.CounterVec
+
+	scrapes         *prometheus.CounterVec
+	scrapeDuration  *prometheus.HistogramVec
+	requestDuration *prometheus.HistogramVec
+
+	pduRequests      *prometheus.CounterVec
+	pduRequestErrors *prometheus.CounterVec
+	exceptions       *prometheus.CounterVec
+
+	bytesTransmitted *prometheus.CounterVec
+	bytesReceived    *prometheus.CounterVec
+
+	busyRetries *prometheus.CounterVec
+}
+
+func newTelemetry() *telemetry {
+	return &telemetry{
+		scrapesInFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "modbus_scrapes_in_flight",
+			Help: "Number of scrapes currently talking to targets.",
+		}),
+		scrapesQueued: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "modbus_scrapes_queued",
+			Help: "Number of scrapes waiting for a free concurrency slot.",
+		}),
+		scrapesRejected: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "modbus_scrapes_rejected_total",
+			Help: "Number of scrapes rejected because the concurrency limit and queue were exhausted.",
+		}),
+		scrapesDeduplicated: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "modbus_scrapes_deduplicated_total",
+			Help: "Number of scrapes served by sharing the result of a concurrent identical scrape.",
+		}),
+		scrapeCacheHits: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "modbus_scrape_cache_hits_total",
+			Help: "Number of scrapes served from the scrape result cache.",
+		}),
+		scrapesThrottled: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "modbus_scrapes_throttled_total",
+			Help: "Number of scrapes arriving before the minimum interval of their module passed.",
+		}),
+		pollLastSuccess: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "modbus_poll_last_success_timestamp_seconds",
+			Help: "Timestamp of the last successful background poll of a target.",
+		}, []string{"target", "sub_target", "module"}),
+		metricParseErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "modbus_metric_parse_errors_total",
+			Help: "Number of scrapes the registers of a metric definition failed to parse in, leaving out the metric.",
+		}, []string{"target", "sub_target", "module", "name"}),
+		shortResponses: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "modbus_short_responses_total",
+			Help: "Number of responses containing fewer registers than requested, leaving out the metrics not contained.",
+		}, []string{"target", "sub_target", "module"}),
+		scrapes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "modbus_target_scrapes_total",
+			Help: "Number of scrapes talking to targets by status, e.g. success, connect_failed, timeout, deadline_exceeded, gateway_target_failed, exception_2 or parse_error.",
+		}, []string{"target", "module", "status"}),
+		scrapeDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "modbus_target_scrape_duration_seconds",
+			Help:    "Duration of scrapes talking to targets.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"target", "module"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "modbus_request_duration_seconds",
+			Help:    "Round-trip time of individual modbus requests sent to targets.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"target", "module"}),
+		pduRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "modbus_pdu_requests_total",
+			Help: "Number of modbus requests sent to targets by function code.",
+		}, []string{"target", "module", "function_code"}),
+		pduRequestErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "modbus_pdu_request_errors_total",
+			Help: "Number of modbus requests sent to targets by function code, which failed or were answered with an exception.",
+		}, []string{"target", "module", "function_code"}),
+		exceptions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "modbus_exceptions_total",
+			Help: "Number of exception responses received from targets by exception code, e.g. 2 for illegal data address or 11 for a gateway target failing to respond.",
+		}, []string{"target", "sub_target", "exception_code"}),
+		bytesTransmitted: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "modbus_transmitted_bytes_total",
+			Help: "Number of bytes of modbus requests sent to targets.",
+		}, []string{"target", "sub_target"}),
+		bytesReceived: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "modbus_received_bytes_total",
+			Help: "Number of bytes of modbus responses received from targets.",
+		}, []string{"target", "sub_target"}),
+		busyRetries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "modbus_busy_retries_total",
+			Help: "Number of requests retried because the target responded with the slave busy exception.",
+		}, []string{"target", "sub_target"}),
+	}
+}
+
+func (t *telemetry) collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		t.scrapesInFlight,
+		t.scrapesQueued,
+		t.scrapesRejected,
+		t.scrapesDeduplicated,
+		t.scrapeCacheHits,
+		t.scrapesThrottled,
+		t.pollLastSuccess,
+		t.metricParseErrors,
+		t.shortResponses,
+		t.scrapes,
+		t.scrapeDuration,
+		t.requestDuration,
+		t.pduRequests,
+		t.pduRequestErrors,
+		t.exceptions,
+		t.bytesTransmitted,
+		t.bytesReceived,
+		t.busyRetries,
+	}
+}
+
+// deleteTarget removes all series of the given target.
+func (t *telemetry) deleteTarget(targetAddress string) {
+	for _, c := range t.collectors() {
+		if v, ok := c.(interface {
+			DeletePartialMatch(prometheus.Labels) int
+		}); ok {
+			v.DeletePartialMatch(prometheus.Labels{"target": targetAddress})
+		}
+	}
+}
+
+// requestTelemetry observes the requests sent to a sub target on behalf of a
+// module.
+type requestTelemetry struct {
+	duration   prometheus.Observer
+	requests   *prometheus.CounterVec
+	errors     *prometheus.CounterVec
+	exceptions *prometheus.CounterVec
+
+	transmitted prometheus.Counter
+	received    prometheus.Counter
+	busyRetries prometheus.Counter
+
+	target    string
+	subTarget byte
+	module    string
+	listeners []func(Request)
+}
+
+// Request is a single request sent to a target on behalf of a module.
+type Request struct {
+	Target       string
+	SubTarget    byte
+	Module       string
+	FunctionCode uint8
+	// Address and Quantity are 0 for function codes not reading or
+	// writing a range.
+	Address  uint16
+	Quantity uint16
+	Duration time.Duration
+	// Err is the error of the request, including exception responses.
+	Err error
+
+	// RequestPDU and ResponsePDU are the raw request and response, the
+	// latter nil if not answered.
+	RequestPDU  []byte
+	ResponsePDU []byte
+}
+
+// Frame is a request ADU sent to a target along with the response ADU
+// received, nil if not answered.
+type Frame struct {
+	Time      time.Time
+	Target    string
+	SubTarget byte
+	Request   []byte
+	Response  []byte
+	Duration  time.Duration
+	Err       error
+}
+
+func (t *telemetry) requests(targetAddress string, subTarget byte, moduleName string) *requestTelemetry {
+	labels := prometheus.Labels{"target": targetAddress, "module": moduleName}
+	subTargetLabels := prometheus.Labels{"target": targetAddress, "sub_target": strconv.Itoa(int(subTarget))}
+
+	return &requestTelemetry{
+		duration:    t.requestDuration.With(labels),
+		requests:    t.pduRequests.MustCurryWith(labels),
+		errors:      t.pduRequestErrors.MustCurryWith(labels),
+		exceptions:  t.exceptions.MustCurryWith(subTargetLabels),
+		transmitted: t.bytesTransmitted.With(subTargetLabels),
+		received:    t.bytesReceived.With(subTargetLabels),
+		busyRetries: t.busyRetries.With(subTargetLabels),
+
+		target:    targetAddress,
+		subTarget: subTarget,
+		module:    moduleName,
+	}
+}
+
+// observe records a request given its ADU, the ADU of the response and the
+// round-trip time.
+func (t *requestTelemetry) observe(aduRequest, aduResponse []byte, err error, duration time.Duration) {
+	t.duration.Observe(duration.Seconds())
+	t.transmitted.Add(float64(len(aduRequest)))
+	t.received.Add(float64(len(aduResponse)))
+
+	if len(aduRequest) <= tcpHeaderLength {
+		return
+	}
+	functionCode := strconv.Itoa(int(aduRequest[tcpHeaderLength]))
+	t.requests.WithLabelValues(functionCode).Inc()
+
+	code, exception := exceptionCode(aduResponse)
+	if err != nil || exception {
+		t.errors.WithLabelValues(functionCode).Inc()
+	}
+	if exception {
+		t.exceptions.WithLabelValues(strconv.Itoa(int(code))).Inc()
+	}
+
+	if len(t.listeners) == 0 {
+		return
+	}
+	r := Request{
+		Target:       t.target,
+		SubTarget:    t.subTarget,
+		Module:       t.module,
+		FunctionCode: aduRequest[tcpHeaderLength],
+		Duration:     duration,
+		Err:          err,
+		RequestPDU:   aduRequest[tcpHeaderLength:],
+	}
+	if len(aduResponse) > tcpHeaderLength {
+		r.ResponsePDU = aduResponse[tcpHeaderLength:]
+	}
+	if data := aduRequest[tcpHeaderLength+1:]; len(data) >= 4 {
+		switch r.FunctionCode {
+		case modbus.FuncCodeReadCoils, modbus.FuncCodeReadDiscreteInputs,
+			modbus.FuncCodeReadHoldingRegisters, modbus.FuncCodeReadInputRegisters,
+			modbus.FuncCodeWriteMultipleCoils, modbus.FuncCodeWriteMultipleRegisters:
+			r.Address = binary.BigEndian.Uint16(data)
+			r.Quantity = binary.BigEndian.Uint16(data[2:])
+		case modbus.FuncCodeWriteSingleCoil, modbus.FuncCodeWriteSingleRegister:
+			r.Address = binary.BigEndian.Uint16(data)
+			r.Quantity = 1
+		}
+	}
+	if exception && err == nil {
+		r.Err = &modbus.ModbusError{FunctionCode: r.FunctionCode | 0x80, ExceptionCode: code}
+	}
+	for _, l := range t.listeners {
+		l(r)
+	}
+}
+
+// Describe implements the prometheus.Collector interface, exposing the
+// exporter's own telemetry.
+func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
+	for _, c := range e.telemetry.collectors() {
+		c.Describe(ch)
+	}
+	ch <- tlsCertificateExpiryDesc
+}
+
+// Collect implements the prometheus.Collector interface. If a telemetry expiry
+// is set, the series of targets not scraped within it are removed first.
+func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
+	if e.telemetryExpiry > 0 {
+		for _, target := range e.statuses.expire(time.Now().Add(-e.telemetryExpiry)) {
+			e.telemetry.deleteTarget(target)
+		}
+	}
+
+	for _, c := range e.telemetry.collectors() {
+		c.Collect(ch)
+	}
+	e.collectTLSExpiry(ch)
+}
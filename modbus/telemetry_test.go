@@ -0,0 +1,96 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package modbus
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestRequestTelemetryFunctionCode(t *testing.T) {
+	_, address := startServer(t)
+
+	e := NewExporter(testConfig())
+	if _, err := e.Scrape(context.Background(), address, 1, "my_module"); err != nil {
+		t.Fatal(err)
+	}
+
+	if v := testutil.ToFloat64(e.telemetry.pduRequests.WithLabelValues(address, "my_module", "3")); v != 1 {
+		t.Fatalf("expected 1 request with function code 3 but got %v", v)
+	}
+	if n := testutil.CollectAndCount(e.telemetry.pduRequestErrors); n != 0 {
+		t.Fatalf("expected no request errors but got %v", n)
+	}
+}
+
+func TestRequestTelemetryException(t *testing.T) {
+	tel := newTelemetry()
+	r := tel.requests("target", 1, "my_module")
+
+	request := []byte{0, 1, 0, 0, 0, 6, 1, 0x01, 0, 0, 0, 1}
+	// Illegal data address.
+	response := []byte{0, 1, 0, 0, 0, 3, 1, 0x81, 0x02}
+	r.observe(request, response, nil, time.Millisecond)
+
+	if v := testutil.ToFloat64(tel.pduRequests.WithLabelValues("target", "my_module", "1")); v != 1 {
+		t.Fatalf("expected 1 request with function code 1 but got %v", v)
+	}
+	if v := testutil.ToFloat64(tel.pduRequestErrors.WithLabelValues("target", "my_module", "1")); v != 1 {
+		t.Fatalf("expected 1 request error with function code 1 but got %v", v)
+	}
+	if v := testutil.ToFloat64(tel.exceptions.WithLabelValues("target", "1", "2")); v != 1 {
+		t.Fatalf("expected 1 exception with code 2 but got %v", v)
+	}
+}
+
+func TestRequestTelemetryBytes(t *testing.T) {
+	tel := newTelemetry()
+	r := tel.requests("target", 1, "my_module")
+
+	request := []byte{0, 1, 0, 0, 0, 6, 1, 0x03, 0, 0, 0, 1}
+	response := []byte{0, 1, 0, 0, 0, 5, 1, 0x03, 2, 0, 42}
+	r.observe(request, response, nil, time.Millisecond)
+	r.observe(request, nil, errors.New("timeout"), time.Millisecond)
+
+	if v := testutil.ToFloat64(tel.bytesTransmitted.WithLabelValues("target", "1")); v != 24 {
+		t.Fatalf("expected 24 bytes transmitted but got %v", v)
+	}
+	if v := testutil.ToFloat64(tel.bytesReceived.WithLabelValues("target", "1")); v != 11 {
+		t.Fatalf("expected 11 bytes received but got %v", v)
+	}
+}
+
+func TestTelemetryExpiry(t *testing.T) {
+	_, address := startServer(t)
+
+	e := NewExporter(testConfig(), WithTelemetryExpiry(50*time.Millisecond))
+	if _, err := e.Scrape(context.Background(), address, 1, "my_module"); err != nil {
+		t.Fatal(err)
+	}
+	if n := testutil.CollectAndCount(e, "modbus_pdu_requests_total"); n != 1 {
+		t.Fatalf("expected 1 series of the scraped target but got %v", n)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if n := testutil.CollectAndCount(e, "modbus_pdu_requests_total"); n != 0 {
+		t.Fatalf("expected the series of the expired target to be removed but got %v", n)
+	}
+	if s := e.TargetStatuses(); len(s) != 0 {
+		t.Fatalf("expected the status of the expired target to be removed but got %v", s)
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package modbus
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ErrThrottled is returned by Scrape whenever a target is scraped again
+// before the minimum interval of its module passed and no previous result is
+// available to serve instead.
+var ErrThrottled = errors.New("scraped too soon after the previous scrape")
+
+type lastScrape struct {
+	time        time.Time
+	minInterval time.Duration
+	gatherer    prometheus.Gatherer
+}
+
+// lastScrapes remembers when each target was last scraped together with the
+// latest successful result.
+type lastScrapes struct {
+	mtx       sync.Mutex
+	scrapes   map[string]lastScrape
+	lastSweep time.Time
+}
+
+func newLastScrapes() *lastScrapes {
+	return &lastScrapes{scrapes: map[string]lastScrape{}}
+}
+
+// throttled returns whether the given key was scraped less than minInterval
+// before now, together with the latest successful result if any.
+func (l *lastScrapes) throttled(key string, now time.Time, minInterval time.Duration) (bool, prometheus.Gatherer) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	last, ok := l.scrapes[key]
+	if !ok || now.Sub(last.time) >= minInterval {
+		return false, nil
+	}
+
+	return true, last.gatherer
+}
+
+// record records a scrape with the given minimum interval started at the
+// given time. Results of failed scrapes are nil, keeping the previous
+// successful one.
+func (l *lastScrapes) record(key string, t time.Time, minInterval time.Duration, g prometheus.Gatherer) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	l.sweep(t)
+
+	last := l.scrapes[key]
+	last.time = t
+	last.minInterval = minInterval
+	if g != nil {
+		last.gatherer = g
+	}
+	l.scrapes[key] = last
+}
+
+// sweep forgets the scrapes whose minimum interval passed, at most once a
+// minute, so targets no longer scraped don't pile up.
+func (l *lastScrapes) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < time.Minute {
+		return
+	}
+	l.lastSweep = now
+
+	for key, last := range l.scrapes {
+		if now.Sub(last.time) >= last.minInterval {
+			delete(l.scrapes, key)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package modbus
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestLastScrapesThrottled(t *testing.T) {
+	l := newLastScrapes()
+	now := time.Now()
+	reg := prometheus.NewRegistry()
+
+	if throttled, _ := l.throttled("a", now, time.Minute); throttled {
+		t.Fatal("expected first scrape not to be throttled")
+	}
+
+	l.record("a", now, time.Minute, reg)
+	l.record("a", now.Add(time.Second), time.Minute, nil)
+
+	throttled, g := l.throttled("a", now.Add(30*time.Second), time.Minute)
+	if !throttled {
+		t.Fatal("expected scrape within minimum interval to be throttled")
+	}
+	if g != reg {
+		t.Fatal("expected latest successful result to be kept")
+	}
+
+	if throttled, _ := l.throttled("a", now.Add(2*time.Minute), time.Minute); throttled {
+		t.Fatal("expected scrape after minimum interval not to be throttled")
+	}
+}
+
+func TestLastScrapesSweep(t *testing.T) {
+	l := newLastScrapes()
+	now := time.Now()
+
+	l.record("a", now, time.Minute, prometheus.NewRegistry())
+	l.record("b", now, time.Hour, prometheus.NewRegistry())
+	l.record("c", now.Add(2*time.Minute), time.Minute, nil)
+
+	if _, ok := l.scrapes["a"]; ok {
+		t.Fatal("expected scrape past its minimum interval to be forgotten")
+	}
+	if _, ok := l.scrapes["b"]; !ok {
+		t.Fatal("expected scrape within its minimum interval to be kept")
+	}
+}
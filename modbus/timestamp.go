@@ -0,0 +1,87 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package modbus
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/goburrow/modbus"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/RichiH/modbus_exporter/config"
+)
+
+// readTimestamp reads the time the data of the device was last valid.
+func readTimestamp(r *config.TimestampRegister, c modbus.Client) (time.Time, error) {
+	def := r.MetricDef()
+	functionCode, address, err := def.Address.Split()
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	data, err := readFunc(c, functionCode)(address, def.DataType.RegisterCount())
+	if err != nil {
+		return time.Time{}, fmt.Errorf("reading timestamp from address %v with function code %v: %w",
+			address, functionCode, err)
+	}
+
+	seconds, err := parseModbusData(def, data)
+	if err != nil {
+		return time.Time{}, &classifiedError{
+			err:   fmt.Errorf("timestamp, address '%v': %w", def.Address, err),
+			class: ErrParse,
+		}
+	}
+
+	whole, frac := math.Modf(seconds)
+	return time.Unix(int64(whole), int64(frac*1e9)), nil
+}
+
+// registerTimestamp registers a metric exposing the given timestamp read from
+// a device, e.g. to alert on stale data.
+func registerTimestamp(reg prometheus.Registerer, timestamp time.Time) error {
+	g := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "modbus_data_timestamp_seconds",
+		Help: "Time the data of the device was last valid, as read from its timestamp register.",
+	})
+	g.Set(float64(timestamp.UnixNano()) / 1e9)
+
+	if err := reg.Register(g); err != nil {
+		return fmt.Errorf("failed to register metric modbus_data_timestamp_seconds: %v", err.Error())
+	}
+
+	return nil
+}
+
+// setTimestamps sets the given timestamp on all samples of the metrics
+// defined by the module.
+func setTimestamps(mfs []*dto.MetricFamily, module *config.Module, timestamp time.Time) {
+	names := make(map[string]bool, len(module.Metrics))
+	for _, def := range module.Metrics {
+		names[module.MetricName(def.Name)] = true
+	}
+
+	for _, mf := range mfs {
+		if !names[mf.GetName()] {
+			continue
+		}
+		for _, m := range mf.Metric {
+			m.TimestampMs = proto.Int64(timestamp.UnixMilli())
+		}
+	}
+}
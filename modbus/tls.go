@@ -0,0 +1,84 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package modbus
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var tlsCertificateExpiryDesc = prometheus.NewDesc(
+	"modbus_module_tls_certificate_expiry_timestamp_seconds",
+	"Expiry of the earliest expiring certificate of the TLS configuration of a module, by certificate type (client or ca).",
+	[]string{"module", "certificate"}, nil,
+)
+
+// collectTLSExpiry sends the expiry of the client and CA certificates of the
+// modules using TLS, as each site may run its own PKI. Certificates which
+// can't be read are left out, the connections to the targets fail then.
+func (e *Exporter) collectTLSExpiry(ch chan<- prometheus.Metric) {
+	for _, m := range e.GetConfig().Modules {
+		if m.TLSConfig == nil {
+			continue
+		}
+
+		for certificate, source := range map[string]struct{ inline, file string }{
+			"client": {m.TLSConfig.Cert, m.TLSConfig.CertFile},
+			"ca":     {m.TLSConfig.CA, m.TLSConfig.CAFile},
+		} {
+			data := []byte(source.inline)
+			if source.file != "" {
+				var err error
+				if data, err = os.ReadFile(source.file); err != nil {
+					continue
+				}
+			}
+
+			if expiry, ok := earliestExpiry(data); ok {
+				ch <- prometheus.MustNewConstMetric(tlsCertificateExpiryDesc, prometheus.GaugeValue,
+					float64(expiry.Unix()), m.Name, certificate)
+			}
+		}
+	}
+}
+
+// earliestExpiry returns the earliest expiry of the PEM encoded certificates,
+// or false if there are none.
+func earliestExpiry(data []byte) (time.Time, bool) {
+	var earliest time.Time
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			continue
+		}
+		if earliest.IsZero() || cert.NotAfter.Before(earliest) {
+			earliest = cert.NotAfter
+		}
+	}
+
+	return earliest, !earliest.IsZero()
+}
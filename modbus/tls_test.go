@@ -0,0 +1,180 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package modbus
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	promconfig "github.com/prometheus/common/config"
+)
+
+// writeCertificate writes a certificate for 127.0.0.1 expiring at the given
+// time, signed by the given parent or self-signed, and its key to dir.
+func writeCertificate(t *testing.T, dir, name string, notAfter time.Time, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              notAfter,
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		IsCA:                  parent == nil,
+		BasicConstraintsValid: true,
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	if err := os.WriteFile(filepath.Join(dir, name+".crt"), certPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, name+".key"), keyPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cert, key
+}
+
+func TestScrapeTLS(t *testing.T) {
+	s, address := startServer(t)
+	s.HoldingRegisters[22] = 240
+
+	dir := t.TempDir()
+	caExpiry := time.Now().Add(365 * 24 * time.Hour).Truncate(time.Second)
+	clientExpiry := time.Now().Add(30 * 24 * time.Hour).Truncate(time.Second)
+	ca, caKey := writeCertificate(t, dir, "ca", caExpiry, nil, nil)
+	writeCertificate(t, dir, "server", caExpiry, ca, caKey)
+	writeCertificate(t, dir, "client", clientExpiry, ca, caKey)
+
+	// Terminate TLS in front of the modbus server, requiring a client
+	// certificate signed by the CA.
+	serverCert, err := tls.LoadX509KeyPair(filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			backend, err := net.Dial("tcp", address)
+			if err != nil {
+				conn.Close()
+				return
+			}
+			go func() {
+				io.Copy(backend, conn)
+				backend.Close()
+			}()
+			go func() {
+				io.Copy(conn, backend)
+				conn.Close()
+			}()
+		}
+	}()
+
+	c := testConfig()
+	c.Modules[0].TLSConfig = &promconfig.TLSConfig{
+		CAFile:   filepath.Join(dir, "ca.crt"),
+		CertFile: filepath.Join(dir, "client.crt"),
+		KeyFile:  filepath.Join(dir, "client.key"),
+	}
+	e := NewExporter(c)
+
+	gatherer, err := e.Scrape(context.Background(), l.Addr().String(), 1, "my_module")
+	if err != nil {
+		t.Fatal(err)
+	}
+	metricFamilies, err := gatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, mf := range metricFamilies {
+		if mf.GetName() == "my_metric" && mf.Metric[0].GetGauge().GetValue() != 240 {
+			t.Fatalf("expected my_metric to be 240 but got %v", mf.Metric[0].GetGauge().GetValue())
+		}
+	}
+
+	// Without client certificate, the handshake fails.
+	c.Modules[0].TLSConfig = &promconfig.TLSConfig{CAFile: filepath.Join(dir, "ca.crt")}
+	e.SetConfig(c)
+	if _, err := e.Scrape(context.Background(), l.Addr().String(), 1, "my_module"); err == nil {
+		t.Fatal("expected scrape without client certificate to fail")
+	}
+
+	c.Modules[0].TLSConfig = &promconfig.TLSConfig{
+		CAFile:   filepath.Join(dir, "ca.crt"),
+		CertFile: filepath.Join(dir, "client.crt"),
+		KeyFile:  filepath.Join(dir, "client.key"),
+	}
+	e.SetConfig(c)
+	expected := `
+# HELP modbus_module_tls_certificate_expiry_timestamp_seconds Expiry of the earliest expiring certificate of the TLS configuration of a module, by certificate type (client or ca).
+# TYPE modbus_module_tls_certificate_expiry_timestamp_seconds gauge
+modbus_module_tls_certificate_expiry_timestamp_seconds{certificate="ca",module="my_module"} ` + strconv.FormatInt(caExpiry.Unix(), 10) + `
+modbus_module_tls_certificate_expiry_timestamp_seconds{certificate="client",module="my_module"} ` + strconv.FormatInt(clientExpiry.Unix(), 10) + `
+`
+	if err := testutil.CollectAndCompare(e, strings.NewReader(expected), "modbus_module_tls_certificate_expiry_timestamp_seconds"); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package modbus
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/RichiH/modbus_exporter/config"
+	"github.com/goburrow/modbus"
+)
+
+var (
+	// ErrReadOnly is returned by Write unless the exporter was created
+	// WithWrites.
+	ErrReadOnly = errors.New("exporter is read-only")
+	// ErrInvalidWrite is returned by Write for values which can't be
+	// written to the address, e.g. several ones to a coil.
+	ErrInvalidWrite = errors.New("invalid write")
+	// ErrNotWritable is returned by Write for addresses outside of the
+	// writable ranges of the module.
+	ErrNotWritable = errors.New("address not writable")
+)
+
+// Write writes the given values to the coil or holding registers at the given
+// address, in the address format of metrics, with function code 5 for a
+// coil, 6 for a single and 16 for several holding registers. Coils take 0 or
+// 1. The addresses must lie within a writable range of the module. Writes
+// count towards the limit of concurrent scrapes, sharing the connections to
+// the target with them. Writes must be enabled WithWrites.
+func (e *Exporter) Write(ctx context.Context, targetAddress string, subTarget byte, module *config.Module, address config.RegisterAddr, values []uint16) error {
+	if !e.writes {
+		return ErrReadOnly
+	}
+
+	functionCode, start, err := address.Split()
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidWrite, err)
+	}
+
+	switch {
+	case len(values) == 0:
+		return fmt.Errorf("%w: no values", ErrInvalidWrite)
+	case functionCode == config.FuncCodeReadCoils && len(values) > 1:
+		return fmt.Errorf("%w: only a single coil can be written at once", ErrInvalidWrite)
+	case functionCode == config.FuncCodeReadCoils && values[0] > 1:
+		return fmt.Errorf("%w: coils take 0 or 1 but got %v", ErrInvalidWrite, values[0])
+	case len(values) > config.MaxWriteRegisters:
+		return fmt.Errorf("%w: at most %d registers can be written at once", ErrInvalidWrite, config.MaxWriteRegisters)
+	case int(start)+len(values) > 65536:
+		return fmt.Errorf("%w: %v registers from address %v exceed the address space", ErrInvalidWrite, len(values), start)
+	}
+	if !module.CanWrite(functionCode, start, uint16(len(values))) {
+		return fmt.Errorf("%w: %v registers from %v in module %v", ErrNotWritable, len(values), address, module.Name)
+	}
+
+	release, err := e.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	handler, _, err := e.connect(ctx, splitTargets(targetAddress), subTarget, module)
+	if err != nil {
+		return err
+	}
+	defer handler.Close()
+
+	client := modbus.NewClient(handler)
+	switch {
+	case functionCode == config.FuncCodeReadCoils:
+		// Coils are switched on with 0xFF00 and off with 0x0000.
+		_, err = client.WriteSingleCoil(start, 0xFF00*values[0])
+	case len(values) == 1:
+		_, err = client.WriteSingleRegister(start, values[0])
+	default:
+		data := make([]byte, 2*len(values))
+		for i, v := range values {
+			binary.BigEndian.PutUint16(data[2*i:], v)
+		}
+		_, err = client.WriteMultipleRegisters(start, uint16(len(values)), data)
+	}
+	if err != nil {
+		return fmt.Errorf("writing %v registers to address %v: %w", len(values), address, err)
+	}
+
+	return nil
+}
@@ -14,11 +14,18 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"net/http/pprof"
 	"os"
-	"strconv"
+	"os/signal"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/alecthomas/kingpin/v2"
 	"github.com/go-kit/log"
@@ -26,6 +33,7 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/common/expfmt"
 	"github.com/prometheus/common/promlog"
 	"github.com/prometheus/common/promlog/flag"
 	"github.com/prometheus/common/version"
@@ -33,24 +41,322 @@ import (
 	webflag "github.com/prometheus/exporter-toolkit/web/kingpinflag"
 
 	"github.com/RichiH/modbus_exporter/config"
+	"github.com/RichiH/modbus_exporter/discovery"
 	"github.com/RichiH/modbus_exporter/modbus"
+	"github.com/RichiH/modbus_exporter/mqtt"
 )
 
+var (
+	configReloadSuccess = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "modbus_config_last_reload_successful",
+		Help: "Whether the last configuration reload attempt was successful.",
+	})
+	configReloadSeconds = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "modbus_config_last_reload_success_timestamp_seconds",
+		Help: "Timestamp of the last successful configuration reload.",
+	})
+)
+
+// recordReload updates the configuration reload metrics.
+func recordReload(err error) {
+	if err != nil {
+		configReloadSuccess.Set(0)
+		return
+	}
+	configReloadSuccess.Set(1)
+	configReloadSeconds.SetToCurrentTime()
+}
+
 func main() {
 	var (
 		configFile = kingpin.Flag(
 			"config.file",
-			"Sets the configuration file.",
+			"Sets the configuration file. May also be a directory or glob pattern, whose YAML and JSON files are merged, or a HTTP(S) URL.",
 		).Default("modbus.yml").String()
+		configStrict = kingpin.Flag(
+			"config.strict",
+			"Reject configuration files containing unknown fields, e.g. misspelled ones.",
+		).Default("false").Bool()
+		configBearerTokenFile = kingpin.Flag(
+			"config.bearer-token-file",
+			"File containing the bearer token sent when loading the configuration from a HTTP(S) URL.",
+		).Default("").String()
+		configCacheFile = kingpin.Flag(
+			"config.cache-file",
+			"File keeping a copy of the configuration loaded from a HTTP(S) URL, used when the URL can't be fetched.",
+		).Default("").String()
+		configRefreshInterval = kingpin.Flag(
+			"config.refresh-interval",
+			"Interval at which the configuration is reloaded. 0 disables reloading.",
+		).Default("0s").Duration()
+		configWatch = kingpin.Flag(
+			"config.watch",
+			"Reload the configuration whenever its files change, e.g. a mounted Kubernetes ConfigMap.",
+		).Default("false").Bool()
+		configCheck = kingpin.Flag(
+			"config.check",
+			"Validate the configuration file and exit without starting the server.",
+		).Default("false").Bool()
+		maxConcurrency = kingpin.Flag(
+			"scrape.max-concurrency",
+			"Maximum number of scrapes talking to targets at the same time. 0 means no limit.",
+		).Default("0").Int()
+		maxQueued = kingpin.Flag(
+			"scrape.max-queued",
+			"Maximum number of scrapes waiting for a free slot once --scrape.max-concurrency is reached. Any further scrapes are rejected.",
+		).Default("0").Int()
+		timeoutOffset = kingpin.Flag(
+			"scrape.timeout-offset",
+			"Offset to subtract from the scrape timeout announced by Prometheus.",
+		).Default("0.5s").Duration()
+		upOnFailure = kingpin.Flag(
+			"scrape.up-on-failure",
+			"Respond to failed scrapes with modbus_up set to 0 instead of an error status.",
+		).Default("false").Bool()
+		statusCodeOverrides = kingpin.Flag(
+			"scrape.status-code",
+			"HTTP status code to respond to scrapes failing with the given status with, e.g. timeout=504. 200 responds with modbus_up set to 0. Repeatable, default applies to unlisted statuses.",
+		).PlaceHolder("STATUS=CODE").StringMap()
+		telemetryExpiry = kingpin.Flag(
+			"telemetry.target-expiry",
+			"Duration after which the series of targets not scraped anymore are removed from /metrics. 0 keeps them.",
+		).Default("0s").Duration()
+		enableLifecycle = kingpin.Flag(
+			"web.enable-lifecycle",
+			"Enable shutdown via HTTP request.",
+		).Default("false").Bool()
+		enableWrite = kingpin.Flag(
+			"web.enable-write",
+			"Enable writing coils and holding registers within the writable ranges of modules via /write, which --web.route-auth-file must require authentication for.",
+		).Default("false").Bool()
+		readOnly = kingpin.Flag(
+			"modbus.read-only",
+			"Disable every code path writing to targets, regardless of the configuration and other flags. --no-modbus.read-only is required for --web.enable-write.",
+		).Default("true").Bool()
+		writeAuditLogFile = kingpin.Flag(
+			"web.write-audit-log.file",
+			"File to append a JSON record of every write to. - logs to standard error.",
+		).Default("-").String()
+		drainTimeout = kingpin.Flag(
+			"web.drain-timeout",
+			"Maximum duration to wait for in-flight scrapes and polls to finish on shutdown.",
+		).Default("30s").Duration()
+		enablePprof = kingpin.Flag(
+			"web.enable-pprof",
+			"Enable profiling endpoints at /debug/pprof/.",
+		).Default("false").Bool()
+		textfileDirectory = kingpin.Flag(
+			"textfile.directory",
+			"Directory to write the results of targets polled in the background to as .prom files for the textfile collector of the node exporter.",
+		).Default("").String()
+		queryLogFile = kingpin.Flag(
+			"query-log.file",
+			"File to append a JSON record of every scrape talking to a target to.",
+		).Default("").String()
+		accessLogFile = kingpin.Flag(
+			"web.access-log.file",
+			"File to append a JSON access log of scrape requests to, independent of the log level. - logs to standard error.",
+		).Default("").String()
+		traceFile = kingpin.Flag(
+			"debug.trace-file",
+			"File to write every Modbus ADU sent to and received from targets to, hex encoded, as wire trace for device vendors.",
+		).Default("").String()
+		traceFileMaxSize = kingpin.Flag(
+			"debug.trace-file.max-size",
+			"Size at which the trace file is rotated, keeping the previous one suffixed with .1. 0 disables rotation.",
+		).Default("100MB").Bytes()
+		webhookURLs = kingpin.Flag(
+			"webhook.url",
+			"URL to POST a JSON notification to when a target starts failing or recovers, e.g. of a ticketing system. May be repeated.",
+		).Strings()
+		webhookDebounce = kingpin.Flag(
+			"webhook.debounce",
+			"Number of consecutive scrapes failing or succeeding before a target is notified as failing or recovered.",
+		).Default("3").Int()
+		webhookTimeout = kingpin.Flag(
+			"webhook.timeout",
+			"Timeout of sending a notification to a webhook.",
+		).Default("10s").Duration()
+		scrapeErrorInterval = kingpin.Flag(
+			"log.scrape-error-interval",
+			"Minimum interval between logging failed scrapes of the same target and cause, summarizing the ones suppressed in between. 0 logs every failure.",
+		).Default("0s").Duration()
+		clientRateLimit = kingpin.Flag(
+			"web.client-rate-limit",
+			"Scrape requests per second allowed per client IP address, rejecting the ones exceeding it with 429. 0 disables the limit.",
+		).Default("0").Float64()
+		clientRateBurst = kingpin.Flag(
+			"web.client-rate-burst",
+			"Scrape requests a client may send at once on top of --web.client-rate-limit.",
+		).Default("10").Int()
+		routeAuthFile = kingpin.Flag(
+			"web.route-auth-file",
+			"File defining the basic auth users and client certificates required per route, in addition to the web configuration file.",
+		).Default("").String()
 		toolkitFlags = webflag.AddFlags(kingpin.CommandLine, ":9602")
+
+		serveCmd = kingpin.Command("serve", "Run the exporter.").Default()
+		lintCmd  = kingpin.Command("lint", "Check the configuration file for likely mistakes like overlapping registers.")
+
+		generateCmd         = kingpin.Command("generate", "Generate files from the configuration file.")
+		dashboardCmd        = generateCmd.Command("dashboard", "Print a Grafana dashboard with one panel per metric of a module.")
+		dashboardModule     = dashboardCmd.Flag("module", "Module to generate the dashboard for.").Required().String()
+		fileSDCmd           = generateCmd.Command("file-sd", "Print the targets of the configuration file for the file based service discovery of Prometheus.")
+		fileSDExporter      = fileSDCmd.Flag("exporter-address", "Address Prometheus reaches the exporter at.").Default("localhost:9602").String()
+		fileSDFormat        = fileSDCmd.Flag("format", "Output format.").Default("json").Enum("json", "yaml")
+		alertsCmd           = generateCmd.Command("alerts", "Print a Prometheus rule file with baseline alerts for the modules.")
+		alertsModules       = alertsCmd.Flag("module", "Module to generate alerts for, all if not given. Repeatable.").Strings()
+		alertsScrapeTimeout = alertsCmd.Flag("scrape-timeout", "Scrape timeout of Prometheus, used for modules without scrapeTimeout.").Default("10s").Duration()
+
+		importCmd               = kingpin.Command("import", "Convert register maps published by vendors into modules.")
+		importCSVCmd            = importCmd.Command("csv", "Print a module converted from a CSV register map with address, name, type and optional scale, unit and description columns.")
+		importCSVFile           = importCSVCmd.Arg("file", "CSV file to convert.").Required().String()
+		importCSVModule         = importCSVCmd.Flag("module", "Name of the module.").Required().String()
+		importCSVRegisterType   = importCSVCmd.Flag("register-type", "Type of the registers of the map.").Default("holding").Enum("coil", "discrete", "holding", "input")
+		importCSVAddressOffset  = importCSVCmd.Flag("address-offset", "Added to every address, e.g. -1 for one based addresses or -40001 for 4xxxx notation.").Default("0").Int()
+		importCSVEndianness     = importCSVCmd.Flag("endianness", "Endianness of values spanning several registers.").Default("big").Enum("big", "little", "mixed", "yolo")
+		importTelegrafCmd       = importCmd.Command("telegraf", "Print modules converted from the modbus inputs of a Telegraf configuration.")
+		importTelegrafFile      = importTelegrafCmd.Arg("file", "Telegraf configuration file to convert.").Required().String()
+		importHomeAssistantCmd  = importCmd.Command("home-assistant", "Print modules converted from the sensors of a Home Assistant modbus configuration.")
+		importHomeAssistantFile = importHomeAssistantCmd.Arg("file", "Home Assistant configuration file to convert.").Required().String()
+
+		builtinCmd        = kingpin.Command("builtin", "Show the modules shipped with the exporter, referred to as builtin:<name>.")
+		builtinListCmd    = builtinCmd.Command("list", "List the builtin modules.")
+		builtinExportCmd  = builtinCmd.Command("export", "Print the configuration file of a builtin module, e.g. as starting point for customization.")
+		builtinExportName = builtinExportCmd.Arg("name", "Name of the builtin module.").Required().String()
+
+		scanCmd     = kingpin.Command("scan", "Probe the unit IDs of a target and print the ones responding.")
+		scanTarget  = scanCmd.Flag("target", "Address of the device or gateway, including the port.").Required().String()
+		scanIDs     = scanCmd.Flag("ids", "Unit IDs to probe, a comma separated list which may contain ranges.").Default("1-247").String()
+		scanAddress = scanCmd.Flag("address", "Register read from each unit ID, in the format of the configuration file.").Default("300000").Uint32()
+		scanTimeout = scanCmd.Flag("timeout", "Time to wait for the response of each unit ID.").Default("200ms").Duration()
+
+		scanRegistersCmd       = kingpin.Command("scan-registers", "Read a range of registers of a target one at a time and print which return data or an exception.")
+		scanRegistersTarget    = scanRegistersCmd.Flag("target", "Address of the device or gateway, including the port.").Required().String()
+		scanRegistersSubTarget = scanRegistersCmd.Flag("sub-target", "Unit ID of the device.").Default("1").Uint8()
+		scanRegistersFrom      = scanRegistersCmd.Flag("from", "First register to read, in the format of the configuration file.").Required().Uint32()
+		scanRegistersTo        = scanRegistersCmd.Flag("to", "Last register to read, in the format of the configuration file.").Required().Uint32()
+		scanRegistersTimeout   = scanRegistersCmd.Flag("timeout", "Time to wait for the response of each read.").Default("1s").Duration()
+
+		benchCmd         = kingpin.Command("bench", "Measure the round-trip times of reads of increasing block sizes of a target and where they start failing.")
+		benchTarget      = benchCmd.Flag("target", "Address of the device or gateway, including the port.").Required().String()
+		benchSubTarget   = benchCmd.Flag("sub-target", "Unit ID of the device.").Default("1").Uint8()
+		benchAddress     = benchCmd.Flag("address", "First register of the blocks read, in the format of the configuration file.").Default("300000").Uint32()
+		benchMaxQuantity = benchCmd.Flag("max-quantity", "Largest block size to read.").Default("125").Uint16()
+		benchRequests    = benchCmd.Flag("requests", "Number of reads per block size.").Default("20").Int()
+		benchTimeout     = benchCmd.Flag("timeout", "Time to wait for the response of each read.").Default("1s").Duration()
+
+		readCmd        = kingpin.Command("read", "Read a single value of a target with the transport and parsing of scrapes and print it.")
+		readTarget     = readCmd.Flag("target", "Address of the device or gateway, including the port.").Required().String()
+		readSubTarget  = readCmd.Flag("sub-target", "Unit ID of the device.").Default("1").Uint8()
+		readType       = readCmd.Flag("type", "Type of the register.").Default("holding").Enum("coil", "discrete", "holding", "input")
+		readAddress    = readCmd.Flag("address", "Address of the register, without the function code digit of the configuration file.").Required().Uint16()
+		readDataType   = readCmd.Flag("data-type", "Data type of the value. Coils and discrete inputs are read as bool.").Default("uint16").Enum("bool", "int16", "uint16", "int32", "uint32", "float32", "int64", "uint64", "float64")
+		readEndianness = readCmd.Flag("endianness", "Endianness of values spanning several registers.").Default("big").Enum("big", "little", "mixed", "yolo")
+		readBitOffset  = readCmd.Flag("bit-offset", "Bit of the register holding a bool value.").Default("0").Int()
+		readTimeout    = readCmd.Flag("timeout", "Time to wait for the response.").Default("1s").Duration()
+
+		dryRunCmd       = kingpin.Command("scrape", "Scrape a target once with modules of the configuration file and print the metrics and the timing of each block.")
+		dryRunTarget    = dryRunCmd.Flag("target", "Address of the device or gateway, including the port.").Required().String()
+		dryRunSubTarget = dryRunCmd.Flag("sub-target", "Sub targets to scrape, like the sub_target parameter of scrapes.").Default("1").String()
+		dryRunModule    = dryRunCmd.Flag("module", "Comma separated modules to scrape with.").Required().String()
+		dryRunRecord    = dryRunCmd.Flag("record", "File to record the requests and responses of the scrape to, which replay:<file> targets of the scrape command answer from.").String()
+		dryRunTimeout   = dryRunCmd.Flag("timeout", "Time to wait for the scrape to complete.").Default("10s").Duration()
+
+		simulateCmd            = kingpin.Command("simulate", "Run a Modbus TCP server answering the registers of a module of the configuration file, e.g. for demos and tests without devices.")
+		simulateModule         = simulateCmd.Flag("module", "Module to simulate.").Required().String()
+		simulateListenAddress  = simulateCmd.Flag("listen-address", "Address to listen on for Modbus TCP requests.").Default("127.0.0.1:5020").String()
+		simulateValues         = simulateCmd.Flag("value", "Value of a metric as NAME=VALUE, may be repeated. Other metrics get random values.").StringMap()
+		simulateUpdateInterval = simulateCmd.Flag("update-interval", "Interval of drawing new random values, 0 keeping them.").Default("0s").Duration()
+
+		verifyCmd        = kingpin.Command("verify", "Read each metric of a module from a device and report exceptions, values not available or implausible and documented registers not covered.")
+		verifyTarget     = verifyCmd.Flag("target", "Address of the device or gateway, including the port.").Required().String()
+		verifySubTarget  = verifyCmd.Flag("sub-target", "Sub target of the device, a unit ID or sub target name of the module.").Default("1").String()
+		verifyModuleName = verifyCmd.Flag("module", "Module to verify.").Required().String()
+		verifyDocumented = verifyCmd.Flag("documented", "Comma separated register ranges documented by the vendor, e.g. 300000-300099, in the format of the configuration file.").String()
+		verifyTimeout    = verifyCmd.Flag("timeout", "Time to wait for the response of each read.").Default("1s").Duration()
 	)
 
 	promlogConfig := &promlog.Config{}
 	flag.AddFlags(kingpin.CommandLine, promlogConfig)
 	kingpin.Version(version.Print("modbus_exporter"))
 	kingpin.HelpFlag.Short('h')
-	kingpin.Parse()
+	command := kingpin.Parse()
 	logger := promlog.New(promlogConfig)
+	service, serviceLogger, err := runService(promlogConfig)
+	if err != nil {
+		level.Error(logger).Log("msg", "Error running as service", "err", err)
+		os.Exit(1)
+	}
+	if serviceLogger != nil {
+		logger = serviceLogger
+	}
+	loadOptions := config.LoadOptions{
+		Strict:    *configStrict,
+		CacheFile: *configCacheFile,
+		OnFallback: func(err error) {
+			level.Warn(logger).Log("msg", "Failed to fetch configuration, using cached copy", "cache_file", *configCacheFile, "err", err)
+		},
+	}
+	if *configBearerTokenFile != "" {
+		token, err := os.ReadFile(*configBearerTokenFile)
+		if err != nil {
+			level.Error(logger).Log("msg", "Error reading bearer token file", "err", err)
+			os.Exit(1)
+		}
+		loadOptions.BearerToken = strings.TrimSpace(string(token))
+	}
+
+	switch command {
+	case lintCmd.FullCommand():
+		os.Exit(lint(*configFile, loadOptions))
+	case dashboardCmd.FullCommand():
+		os.Exit(generateDashboard(*configFile, loadOptions, *dashboardModule))
+	case fileSDCmd.FullCommand():
+		os.Exit(generateFileSD(*configFile, loadOptions, *fileSDExporter, *fileSDFormat))
+	case alertsCmd.FullCommand():
+		os.Exit(generateAlerts(os.Stdout, *configFile, loadOptions, *alertsModules, *alertsScrapeTimeout))
+	case importCSVCmd.FullCommand():
+		os.Exit(importCSV(*importCSVFile, csvImportOptions{
+			module:        *importCSVModule,
+			registerType:  *importCSVRegisterType,
+			addressOffset: *importCSVAddressOffset,
+			endianness:    *importCSVEndianness,
+		}))
+	case importTelegrafCmd.FullCommand():
+		os.Exit(importTelegraf(*importTelegrafFile))
+	case importHomeAssistantCmd.FullCommand():
+		os.Exit(importHomeAssistant(*importHomeAssistantFile))
+	case builtinListCmd.FullCommand():
+		os.Exit(listBuiltins(os.Stdout))
+	case builtinExportCmd.FullCommand():
+		os.Exit(exportBuiltin(os.Stdout, *builtinExportName))
+	case scanCmd.FullCommand():
+		os.Exit(scanUnitIDs(os.Stdout, *scanTarget, *scanIDs, *scanAddress, *scanTimeout))
+	case scanRegistersCmd.FullCommand():
+		os.Exit(scanRegisters(os.Stdout, *scanRegistersTarget, *scanRegistersSubTarget, *scanRegistersFrom, *scanRegistersTo, *scanRegistersTimeout))
+	case benchCmd.FullCommand():
+		os.Exit(benchReads(os.Stdout, *benchTarget, *benchSubTarget, *benchAddress, *benchMaxQuantity, *benchRequests, *benchTimeout))
+	case readCmd.FullCommand():
+		os.Exit(readValue(os.Stdout, *readTarget, *readSubTarget, *readType, *readAddress, *readDataType, *readEndianness, *readBitOffset, *readTimeout))
+	case dryRunCmd.FullCommand():
+		os.Exit(dryRunScrape(os.Stdout, os.Stderr, *configFile, loadOptions, *dryRunTarget, *dryRunSubTarget, *dryRunModule, *dryRunRecord, *dryRunTimeout))
+	case simulateCmd.FullCommand():
+		os.Exit(simulate(*configFile, loadOptions, *simulateModule, *simulateListenAddress, *simulateValues, *simulateUpdateInterval))
+	case verifyCmd.FullCommand():
+		os.Exit(verifyModule(os.Stdout, *configFile, loadOptions, *verifyTarget, *verifySubTarget, *verifyModuleName, *verifyDocumented, *verifyTimeout))
+	case serveCmd.FullCommand():
+	}
+
+	if *configCheck {
+		c, err := config.LoadConfigWithOptions(*configFile, loadOptions)
+		if err != nil {
+			level.Error(logger).Log("msg", "Error loading config", "config_file", *configFile, "err", err)
+			os.Exit(1)
+		}
+		level.Info(logger).Log("msg", "Configuration file is valid", "config_file", *configFile, "modules", len(c.Modules))
+		os.Exit(0)
+	}
 
 	level.Info(logger).Log("msg", "Starting modbus_exporter", "version", version.Info())
 	level.Info(logger).Log("build_context", version.BuildContext())
@@ -58,82 +364,389 @@ func main() {
 	telemetryRegistry := prometheus.NewRegistry()
 	telemetryRegistry.MustRegister(collectors.NewGoCollector())
 	telemetryRegistry.MustRegister(collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	telemetryRegistry.MustRegister(configReloadSuccess, configReloadSeconds, targetsDenied, clientsThrottled, webhookNotifications)
 
 	level.Info(logger).Log("msg", "Loading configuration file", "config_file", *configFile)
-	config, err := config.LoadConfig(*configFile)
+	config, err := config.LoadConfigWithOptions(*configFile, loadOptions)
+	recordReload(err)
 	if err != nil {
 		level.Error(logger).Log("msg", "Error loading config", "err", err)
 		os.Exit(1)
 	}
 
-	http.Handle("/metrics", promhttp.HandlerFor(telemetryRegistry, promhttp.HandlerOpts{}))
+	// A dedicated mux, as importing net/http/pprof registers its handlers
+	// with the default one.
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(telemetryRegistry, promhttp.HandlerOpts{}))
+	mux.HandleFunc("/-/healthy", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "modbus_exporter is Healthy.")
+	})
+	// The configuration is loaded before the listeners are bound, so
+	// reaching this handler means the exporter is ready. Serial devices
+	// aren't checked as only Modbus TCP is supported.
+	mux.HandleFunc("/-/ready", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "modbus_exporter is Ready.")
+	})
+
+	exporterOpts := []modbus.Option{
+		modbus.WithMaxConcurrency(*maxConcurrency, *maxQueued),
+		modbus.WithTelemetryExpiry(*telemetryExpiry),
+	}
+	// The MQTT sink is set up once, changes to it require a restart.
+	if config.MQTT != nil {
+		publisher, err := mqtt.NewPublisher(config.MQTT, logger)
+		if err != nil {
+			level.Error(logger).Log("msg", "Error setting up MQTT publishing", "err", err)
+			os.Exit(1)
+		}
+		defer publisher.Close()
+		exporterOpts = append(exporterOpts, modbus.WithPollListener(publisher.Publish))
+	}
+
+	if *textfileDirectory != "" {
+		w := textfileWriter{directory: *textfileDirectory, logger: logger}
+		exporterOpts = append(exporterOpts, modbus.WithPollListener(w.write))
+	}
+
+	if *queryLogFile != "" {
+		l, err := newQueryLog(*queryLogFile, logger)
+		if err != nil {
+			level.Error(logger).Log("msg", "Error opening query log file", "err", err)
+			os.Exit(1)
+		}
+		defer l.Close()
+		exporterOpts = append(exporterOpts, modbus.WithScrapeListener(l.record))
+	}
+
+	if len(*webhookURLs) > 0 {
+		n, err := newWebhookNotifier(*webhookURLs, *webhookDebounce, *webhookTimeout, logger)
+		if err != nil {
+			level.Error(logger).Log("msg", "Error setting up webhooks", "err", err)
+			os.Exit(1)
+		}
+		defer n.Close()
+		exporterOpts = append(exporterOpts, modbus.WithScrapeListener(n.record))
+	}
+
+	if *traceFile != "" {
+		t, err := newFrameTrace(*traceFile, int64(*traceFileMaxSize), logger)
+		if err != nil {
+			level.Error(logger).Log("msg", "Error opening trace file", "err", err)
+			os.Exit(1)
+		}
+		defer t.Close()
+		exporterOpts = append(exporterOpts, modbus.WithFrameListener(t.record))
+	}
+
+	if *enableWrite {
+		if *readOnly {
+			level.Error(logger).Log("msg", "Writes can't be enabled with --modbus.read-only, pass --no-modbus.read-only")
+			os.Exit(1)
+		}
+		exporterOpts = append(exporterOpts, modbus.WithWrites())
+	}
+
+	exporter := modbus.NewExporter(config, exporterOpts...)
+	telemetryRegistry.MustRegister(exporter)
+	pollCtx, stopPolling := context.WithCancel(context.Background())
+	pollDone := make(chan struct{})
+	go func() {
+		exporter.Poll(pollCtx)
+		close(pollDone)
+	}()
+	if *configRefreshInterval > 0 {
+		go refreshConfig(exporter, *configFile, loadOptions, *configRefreshInterval, logger)
+	}
+	if *configWatch {
+		stop, err := watchConfig(exporter, *configFile, loadOptions, watchDebounce, logger)
+		if err != nil {
+			level.Error(logger).Log("msg", "Error watching configuration file", "err", err)
+			os.Exit(1)
+		}
+		defer stop()
+	}
+	// Scrape requests are wrapped to be rate limited per client and access
+	// logged if enabled.
+	scrapeEndpoint := func(h http.Handler) http.Handler { return h }
+	if *clientRateLimit > 0 {
+		scrapeEndpoint = newClientLimiter(*clientRateLimit, *clientRateBurst).wrap
+	}
+	if *accessLogFile != "" {
+		w, err := openAccessLogFile(*accessLogFile)
+		if err != nil {
+			level.Error(logger).Log("msg", "Error opening access log file", "err", err)
+			os.Exit(1)
+		}
+		defer w.Close()
+		accessLog, limit := newAccessLog(w), scrapeEndpoint
+		scrapeEndpoint = func(h http.Handler) http.Handler { return accessLog.wrap(limit(h)) }
+	}
 
-	exporter := modbus.NewExporter(config)
-	http.Handle("/modbus",
+	codes, err := newStatusCodes(*statusCodeOverrides, *upOnFailure)
+	if err != nil {
+		level.Error(logger).Log("msg", "Invalid scrape status codes", "err", err)
+		os.Exit(1)
+	}
+	scrapeLogger := logger
+	if *scrapeErrorInterval > 0 {
+		l := newRateLimitedLogger(logger, *scrapeErrorInterval)
+		defer l.Close()
+		scrapeLogger = l
+	}
+	mux.Handle("/modbus", scrapeEndpoint(
 		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			scrapeHandler(exporter, w, r, logger)
+			scrapeHandler(exporter, w, r, scrapeLogger, *timeoutOffset, codes)
 		}),
-	)
+	))
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		landingHandler(exporter, w, r)
+	})
+	mux.HandleFunc("/debug/registers", func(w http.ResponseWriter, r *http.Request) {
+		registersHandler(exporter, w, r, logger)
+	})
+	mux.HandleFunc("/targets", func(w http.ResponseWriter, r *http.Request) {
+		targetsHandler(exporter, w, r)
+	})
+	var devices func() []discovery.Device
+	// Like the MQTT sink, changes to the discovery require a restart.
+	if config.MDNS != nil {
+		d := discovery.NewMDNS(config.MDNS, logger)
+		go d.Run(context.Background())
+		devices = d.Devices
+	}
+	mux.HandleFunc("/sd", func(w http.ResponseWriter, r *http.Request) {
+		sdHandler(exporter, devices, w, r)
+	})
+	mux.Handle("/probe", scrapeEndpoint(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			probeHandler(exporter, w, r, scrapeLogger, *timeoutOffset)
+		}),
+	))
+
+	if *enablePprof {
+		mux.HandleFunc("/debug/pprof/", pprof.Index)
+		mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	}
+
+	var handler http.Handler = mux
+	var routeAuth *routeAuthConfig
+	if *routeAuthFile != "" {
+		routeAuth, err = loadRouteAuth(*routeAuthFile)
+		if err != nil {
+			level.Error(logger).Log("msg", "Error loading route authentication", "file", *routeAuthFile, "err", err)
+			os.Exit(1)
+		}
+		handler = routeAuth.wrap(mux)
+	}
+
+	if *enableWrite {
+		// Writes change the state of devices, they are never left open.
+		if routeAuth == nil || routeAuth.route("/write") == nil {
+			level.Error(logger).Log("msg", "Writes require --web.route-auth-file to define authentication for /write")
+			os.Exit(1)
+		}
+		w, err := openAccessLogFile(*writeAuditLogFile)
+		if err != nil {
+			level.Error(logger).Log("msg", "Error opening write audit log", "file", *writeAuditLogFile, "err", err)
+			os.Exit(1)
+		}
+		defer w.Close()
+		audit := newAuditLog(w)
+		mux.HandleFunc("/write", func(w http.ResponseWriter, r *http.Request) {
+			writeHandler(exporter, w, r, logger, audit)
+		})
+	}
+
+	srv := &http.Server{Handler: handler}
+	quit := make(chan struct{})
+	var quitOnce sync.Once
+	if *enableLifecycle {
+		mux.HandleFunc("/-/quit", func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodPost && r.Method != http.MethodPut {
+				http.Error(w, "Only POST or PUT requests allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			fmt.Fprintln(w, "Requesting termination... Goodbye!")
+			quitOnce.Do(func() { close(quit) })
+		})
+	}
+
+	term := make(chan os.Signal, 1)
+	signal.Notify(term, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case <-term:
+		case <-service.stopRequested():
+		}
+		quitOnce.Do(func() { close(quit) })
+	}()
+
+	// Shutting down the server stops accepting scrapes and waits for
+	// in-flight ones to finish, closing their connections to the targets
+	// cleanly rather than aborting mid-frame. Once the drain timeout passes,
+	// the remaining scrapes are canceled, which still lets requests on the
+	// wire complete.
+	shutdown := make(chan struct{})
+	go func() {
+		<-quit
+		level.Info(logger).Log("msg", "Received termination request, waiting for in-flight scrapes")
+		ctx, cancel := context.WithTimeout(context.Background(), *drainTimeout)
+		defer cancel()
+
+		stopPolling()
+		if err := srv.Shutdown(ctx); err != nil {
+			level.Error(logger).Log("msg", "Error shutting down HTTP server", "err", err)
+			srv.Close()
+		}
+		select {
+		case <-pollDone:
+		case <-ctx.Done():
+			level.Error(logger).Log("msg", "Timed out waiting for background polls to finish")
+		}
+		close(shutdown)
+	}()
 
-	srv := &http.Server{}
 	if err := web.ListenAndServe(srv, toolkitFlags, logger); err != nil {
+		if errors.Is(err, http.ErrServerClosed) {
+			<-shutdown
+			level.Info(logger).Log("msg", "Shut down")
+			service.shutDown()
+			return
+		}
 		level.Error(logger).Log("msg", "Error starting HTTP server", "err", err)
 		os.Exit(1)
 	}
 }
 
-func scrapeHandler(e *modbus.Exporter, w http.ResponseWriter, r *http.Request, logger log.Logger) {
-	moduleName := r.URL.Query().Get("module")
-	if moduleName == "" {
-		http.Error(w, "'module' parameter must be specified", http.StatusBadRequest)
-		return
+// refreshConfig periodically reloads the configuration. Invalid
+// configurations are logged and the previous one is kept.
+func refreshConfig(e *modbus.Exporter, configFile string, opts config.LoadOptions, interval time.Duration, logger log.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		reloadConfig(e, configFile, opts, logger)
 	}
+}
 
-	if !e.GetConfig().HasModule(moduleName) {
-		http.Error(w, fmt.Sprintf("module '%v' not defined in configuration file", moduleName), http.StatusBadRequest)
+// reloadConfig reloads the configuration. Invalid configurations are logged
+// and the previous one is kept.
+func reloadConfig(e *modbus.Exporter, configFile string, opts config.LoadOptions, logger log.Logger) {
+	c, err := config.LoadConfigWithOptions(configFile, opts)
+	recordReload(err)
+	if err != nil {
+		level.Error(logger).Log("msg", "Error reloading config", "err", err)
 		return
 	}
+	e.SetConfig(c)
+	level.Debug(logger).Log("msg", "Reloaded configuration file", "config_file", configFile)
+}
 
-	target := r.URL.Query().Get("target")
-	if target == "" {
-		http.Error(w, "'target' parameter must be specified", http.StatusBadRequest)
+func scrapeHandler(e *modbus.Exporter, w http.ResponseWriter, r *http.Request, logger log.Logger, timeoutOffset time.Duration, codes modbus.StatusCodes) {
+	logger = withScrapeID(w, logger)
+
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "prometheus" && format != "influx" {
+		http.Error(w, fmt.Sprintf("'format' parameter must be prometheus or influx but got '%v'", format), http.StatusBadRequest)
 		return
 	}
 
-	sT := r.URL.Query().Get("sub_target")
-	if sT == "" {
-		http.Error(w, "'sub_target' parameter must be specified", http.StatusBadRequest)
+	start := time.Now()
+	gatherer, status, err := scrapeRequest(e, r, logger, timeoutOffset, "", codes)
+	if err != nil && status != http.StatusOK {
+		http.Error(w, err.Error(), status)
 		return
 	}
 
-	subTarget, err := strconv.ParseUint(sT, 10, 32)
+	gatherer, err = withScrapeMeta(gatherer, time.Since(start))
 	if err != nil {
-		http.Error(w, fmt.Sprintf("'sub_target' parameter must be a valid integer: %v", err), http.StatusBadRequest)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	if subTarget > 255 {
-		http.Error(w, fmt.Sprintf("'sub_target' parameter must be from 0 to 255. Invalid value: %d", subTarget), http.StatusBadRequest)
+
+	if format == "influx" {
+		mfs, err := gatherer.Gather()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		if err := writeInflux(w, mfs, time.Now()); err != nil {
+			level.Error(logger).Log("msg", "failed to write response", "err", err)
+		}
 		return
 	}
 
-	level.Info(logger).Log("msg", "got scrape request", "module", moduleName, "target", target, "sub_target", subTarget)
-
-	gatherer, err := e.Scrape(target, byte(subTarget), moduleName)
-	if err != nil {
-		httpStatus := http.StatusInternalServerError
-		if strings.Contains(fmt.Sprintf("%v", err), "unable to connect with target") {
-			httpStatus = http.StatusServiceUnavailable
-		} else if strings.Contains(fmt.Sprintf("%v", err), "i/o timeout") {
-			httpStatus = http.StatusGatewayTimeout
-		}
-		http.Error(
-			w,
-			fmt.Sprintf("failed to scrape target '%v' with module '%v': %v", target, moduleName, err),
-			httpStatus,
-		)
-		level.Error(logger).Log("msg", "failed to scrape", "target", target, "module", moduleName, "err", err)
+	// The client library doesn't expose created timestamps, which
+	// OpenMetrics supports to tell counter resets apart.
+	if contentType := expfmt.NegotiateIncludingOpenMetrics(r.Header); contentType.FormatType() == expfmt.TypeOpenMetrics {
+		mfs, err := gatherer.Gather()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", string(contentType))
+		if err := writeOpenMetrics(w, contentType, mfs); err != nil {
+			level.Error(logger).Log("msg", "failed to write response", "err", err)
+		}
 		return
 	}
 
 	promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{}).ServeHTTP(w, r)
 }
+
+// scrapeRequest validates the parameters of the given scrape request and
+// scrapes the target. On failure, it returns the HTTP status to respond with:
+// http.StatusBadRequest for invalid parameters, http.StatusForbidden for
+// targets not allowed and the one of the given codes for failed scrapes. The
+// sub target defaults to the given one if not specified.
+func scrapeRequest(e *modbus.Exporter, r *http.Request, logger log.Logger, timeoutOffset time.Duration, defaultSubTarget string, codes modbus.StatusCodes) (prometheus.Gatherer, int, error) {
+	c := e.GetConfig()
+	req, err := modbus.ParseScrapeRequest(c, r.URL.Query(), defaultSubTarget)
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+	if err := checkTarget(c, req.Target, r, logger); err != nil {
+		return nil, http.StatusForbidden, err
+	}
+
+	ctx, cancel, err := modbus.ScrapeContext(r, timeoutOffset)
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+	defer cancel()
+
+	moduleName := strings.Join(req.Modules, ",")
+	level.Info(logger).Log("msg", "got scrape request", "module", moduleName, "target", req.Target, "sub_target", subTargetLabels(req.SubTargets))
+
+	gatherer, err := e.ScrapeAll(ctx, req)
+	if err != nil {
+		httpStatus := codes.Code(err)
+		level.Error(logger).Log("msg", "failed to scrape", "target", req.Target, "module", moduleName, "err", err)
+		return nil, httpStatus, fmt.Errorf("failed to scrape target '%v' with module '%v': %v", req.Target, moduleName, err)
+	}
+
+	return gatherer, http.StatusOK, nil
+}
+
+// subTargetLabels returns the comma separated labels of the given sub targets.
+func subTargetLabels(subTargets []modbus.SubTarget) string {
+	labels := make([]string, len(subTargets))
+	for i, s := range subTargets {
+		labels[i] = s.Label
+	}
+
+	return strings.Join(labels, ",")
+}
+
+// targetsHandler responds with the status of the latest scrape of each target
+// as JSON.
+func targetsHandler(e *modbus.Exporter, w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(e.TargetStatuses()); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
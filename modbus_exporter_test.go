@@ -14,13 +14,19 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 
 	"github.com/RichiH/modbus_exporter/config"
 	"github.com/RichiH/modbus_exporter/modbus"
+	"github.com/RichiH/modbus_exporter/modbustest"
 	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 )
 
 func TestScrapeHandler(t *testing.T) {
@@ -86,6 +92,69 @@ func TestScrapeHandler(t *testing.T) {
 			},
 			params: map[string]string{"module": "my_module", "target": "10.0.0.10", "sub_target": "10"},
 		},
+		{
+			name: "unknown second module",
+			code: http.StatusBadRequest,
+			config: func() config.Config {
+				c := config.Config{}
+				c.Modules = []config.Module{
+					{
+						Name: "my_module",
+					},
+				}
+
+				return c
+			},
+			params: map[string]string{"module": "my_module,other", "target": "10.0.0.10", "sub_target": "1"},
+		},
+		{
+			name: "unknown sub_target name",
+			code: http.StatusBadRequest,
+			config: func() config.Config {
+				c := config.Config{}
+				c.Modules = []config.Module{
+					{
+						Name:           "my_module",
+						SubTargetNames: map[string]int{"inverter_1": 3},
+					},
+				}
+
+				return c
+			},
+			params: map[string]string{"module": "my_module", "target": "10.0.0.10", "sub_target": "inverter_2"},
+		},
+		{
+			name: "sub_target name",
+			code: http.StatusServiceUnavailable,
+			config: func() config.Config {
+				c := config.Config{}
+				c.Modules = []config.Module{
+					{
+						Name:           "my_module",
+						SubTargetNames: map[string]int{"inverter_1": 3},
+					},
+				}
+
+				return c
+			},
+			params: map[string]string{"module": "my_module", "target": "10.0.0.10", "sub_target": "inverter_1"},
+		},
+		{
+			name: "target not allowed",
+			code: http.StatusForbidden,
+			config: func() config.Config {
+				c := config.Config{}
+				c.Modules = []config.Module{
+					{
+						Name: "my_module",
+					},
+				}
+				c.AllowedTargets = &config.AllowedTargets{Networks: []string{"192.168.0.0/16"}}
+
+				return c
+			},
+			params: map[string]string{"module": "my_module", "target": "192.168.1.10,10.0.0.10", "sub_target": "1"},
+		},
 	}
 
 	for _, loopTest := range tests {
@@ -108,7 +177,7 @@ func TestScrapeHandler(t *testing.T) {
 
 			rr := httptest.NewRecorder()
 
-			scrapeHandler(exporter, rr, req, log.NewNopLogger())
+			scrapeHandler(exporter, rr, req, log.NewNopLogger(), 0, modbus.DefaultStatusCodes)
 
 			if status := rr.Code; status != test.code {
 				t.Errorf(
@@ -119,3 +188,62 @@ func TestScrapeHandler(t *testing.T) {
 		})
 	}
 }
+
+func TestRecordReload(t *testing.T) {
+	recordReload(nil)
+	if v := testutil.ToFloat64(configReloadSuccess); v != 1 {
+		t.Fatalf("expected successful reload but got %v", v)
+	}
+	last := testutil.ToFloat64(configReloadSeconds)
+	if last == 0 {
+		t.Fatal("expected reload timestamp to be set")
+	}
+
+	recordReload(errors.New("broken"))
+	if v := testutil.ToFloat64(configReloadSuccess); v != 0 {
+		t.Fatalf("expected failed reload but got %v", v)
+	}
+	if v := testutil.ToFloat64(configReloadSeconds); v != last {
+		t.Fatalf("expected failed reload to keep timestamp %v but got %v", last, v)
+	}
+}
+
+// freeAddress returns a local address nothing is listening on.
+func freeAddress(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	return l.Addr().String()
+}
+
+// startServer starts a modbus TCP server on a free local port.
+func startServer(t *testing.T) (*modbustest.Server, string) {
+	s, err := modbustest.NewServer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.Close)
+
+	return s, s.Addr
+}
+
+func TestTargetsHandler(t *testing.T) {
+	e := modbus.NewExporter(config.Config{Modules: []config.Module{{Name: "my_module", Protocol: config.ModbusProtocolTCPIP}}})
+	if _, err := e.Scrape(context.Background(), freeAddress(t), 1, "my_module"); err == nil {
+		t.Fatal("expected scraping an unreachable target to fail")
+	}
+
+	rr := httptest.NewRecorder()
+	targetsHandler(e, rr, httptest.NewRequest("GET", "/targets", nil))
+
+	statuses := []modbus.TargetStatus{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &statuses); err != nil {
+		t.Fatal(err)
+	}
+	if len(statuses) != 1 || statuses[0].Success || statuses[0].Module != "my_module" {
+		t.Fatalf("expected one failed scrape but got %v", rr.Body.String())
+	}
+}
@@ -0,0 +1,65 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package modbustest_test
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/RichiH/modbus_exporter/config"
+	"github.com/RichiH/modbus_exporter/modbus"
+	"github.com/RichiH/modbus_exporter/modbustest"
+)
+
+// Scrapes a module of a configuration from a server holding the registers
+// of the device, as a test of the module would.
+func Example() {
+	c, err := config.Parse([]byte(`
+modules:
+  - name: meter
+    protocol: tcp/ip
+    metrics:
+      - name: frequency_hertz
+        help: grid frequency
+        address: 300002
+        dataType: uint16
+        factor: 0.01
+        metricType: gauge
+`), config.LoadOptions{Strict: true})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	s, err := modbustest.NewServer(modbustest.Registers{300002: 4998})
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer s.Close()
+
+	g, err := modbus.NewExporter(c).Scrape(context.Background(), s.Addr, 1, "meter")
+	if err != nil {
+		log.Fatal(err)
+	}
+	mfs, err := g.Gather()
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, mf := range mfs {
+		if mf.GetName() == "frequency_hertz" {
+			fmt.Printf("%.2f\n", mf.Metric[0].GetGauge().GetValue())
+		}
+	}
+	// Output: 49.98
+}
@@ -0,0 +1,261 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package modbustest provides an in-process Modbus TCP server holding a
+// register map, to test modules of configurations against in Go without
+// physical devices, like net/http/httptest does for HTTP. Serial Modbus (RTU)
+// isn't provided, as the exporter only speaks Modbus TCP.
+package modbustest
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync"
+
+	gomodbus "github.com/goburrow/modbus"
+	"github.com/tbrandon/mbserver"
+
+	"github.com/RichiH/modbus_exporter/config"
+	"github.com/RichiH/modbus_exporter/modbus"
+)
+
+// Registers maps addresses in the notation of metric definitions, the
+// function code followed by the 0-based address, e.g. 300022 for input
+// register 22, to their values. Coils and discrete inputs are on if their
+// value isn't 0.
+type Registers map[config.RegisterAddr]uint16
+
+// Server is a Modbus TCP server answering read and write requests of all unit
+// IDs from its coils, discrete inputs, holding and input registers. The
+// embedded mbserver.Server gives direct access to them and allows to
+// override the handling of function codes, e.g. to answer with exceptions.
+type Server struct {
+	*mbserver.Server
+
+	// Addr is the address the server listens on, to be passed as target.
+	Addr string
+
+	// mtx guards the registers against updates by Set, SetValue and
+	// SetValues while requests are answered.
+	mtx sync.Mutex
+}
+
+// handlers are the functions answered by the server.
+var handlers = map[uint8]func(*mbserver.Server, mbserver.Framer) ([]byte, *mbserver.Exception){
+	config.FuncCodeReadCoils:                mbserver.ReadCoils,
+	config.FuncCodeReadDiscreteInputs:       mbserver.ReadDiscreteInputs,
+	config.FuncCodeReadHoldingRegisters:     mbserver.ReadHoldingRegisters,
+	config.FuncCodeReadInputRegisters:       mbserver.ReadInputRegisters,
+	gomodbus.FuncCodeWriteSingleCoil:        mbserver.WriteSingleCoil,
+	gomodbus.FuncCodeWriteSingleRegister:    mbserver.WriteHoldingRegister,
+	gomodbus.FuncCodeWriteMultipleCoils:     mbserver.WriteMultipleCoils,
+	gomodbus.FuncCodeWriteMultipleRegisters: mbserver.WriteHoldingRegisters,
+}
+
+// NewServer returns a server holding the given registers, listening on a
+// free port of the loopback interface. It should be closed when done.
+func NewServer(registers Registers) (*Server, error) {
+	s, err := NewUnstartedServer(registers)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.Start("127.0.0.1:0"); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// NewUnstartedServer returns a server holding the given registers, not
+// listening yet, so its registers can be set up before it is started.
+func NewUnstartedServer(registers Registers) (*Server, error) {
+	s := &Server{Server: mbserver.NewServer()}
+	for functionCode, f := range handlers {
+		f := f
+		s.RegisterFunctionHandler(functionCode, func(server *mbserver.Server, frame mbserver.Framer) ([]byte, *mbserver.Exception) {
+			s.mtx.Lock()
+			defer s.mtx.Unlock()
+			return f(server, frame)
+		})
+	}
+
+	for address, v := range registers {
+		if err := s.Set(address, v); err != nil {
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+// Start makes the server listen on the given address. If its port is 0, a
+// free one is picked.
+func (s *Server) Start(address string) error {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	// mbserver doesn't tell the port it listens on, so a free one is
+	// looked up beforehand.
+	if port == "0" {
+		l, err := net.Listen("tcp", net.JoinHostPort(host, port))
+		if err != nil {
+			return err
+		}
+		address = l.Addr().String()
+		l.Close()
+	}
+
+	if err := s.ListenTCP(address); err != nil {
+		return err
+	}
+	s.Addr = address
+
+	return nil
+}
+
+// Set sets consecutive registers, coils or discrete inputs starting at the
+// given address.
+func (s *Server) Set(address config.RegisterAddr, values ...uint16) error {
+	functionCode, start, err := address.Split()
+	if err != nil {
+		return err
+	}
+	if int(start)+len(values) > 65536 {
+		return fmt.Errorf("address '%v': values exceed the last address", address)
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	switch functionCode {
+	case config.FuncCodeReadCoils, config.FuncCodeReadDiscreteInputs:
+		bits := s.Coils
+		if functionCode == config.FuncCodeReadDiscreteInputs {
+			bits = s.DiscreteInputs
+		}
+		for i, v := range values {
+			bits[int(start)+i] = 0
+			if v != 0 {
+				bits[int(start)+i] = 1
+			}
+		}
+	default:
+		copy(s.registers(functionCode)[start:], values)
+	}
+
+	return nil
+}
+
+// registers returns the holding or input registers.
+func (s *Server) registers(functionCode uint8) []uint16 {
+	if functionCode == config.FuncCodeReadInputRegisters {
+		return s.InputRegisters
+	}
+	return s.HoldingRegisters
+}
+
+// SetValue sets the registers of the metric definition so they are parsed as
+// the given value, the inverse of scraping them. Boolean metrics of registers
+// only set their bit. Each bucket of histograms counts the value as number of
+// observations, and the sum register, if any, the value times the number of
+// buckets.
+func (s *Server) SetValue(d config.MetricDef, v float64) error {
+	if d.MetricType != config.MetricTypeHistogram {
+		return s.set(d, d.Address, v)
+	}
+
+	// The factor only applies to the sum.
+	bucket := d
+	bucket.Factor = nil
+	for i := range d.Buckets {
+		address := d.Address + config.RegisterAddr(i*int(d.DataType.RegisterCount()))
+		if err := s.set(bucket, address, v); err != nil {
+			return err
+		}
+	}
+	if d.SumAddress != nil {
+		return s.set(d, *d.SumAddress, v*float64(len(d.Buckets)))
+	}
+
+	return nil
+}
+
+// SetValues sets the metrics of the module to the given values by name, see
+// SetValue. All metric definitions of a name are set, e.g. the ones of every
+// phase. Metrics without value are left alone.
+func (s *Server) SetValues(module *config.Module, values map[string]float64) error {
+	for name := range values {
+		found := false
+		for _, d := range module.Metrics {
+			found = found || d.Name == name
+		}
+		if !found {
+			return fmt.Errorf("metric '%v' not defined in module '%v'", name, module.Name)
+		}
+	}
+
+	for _, d := range module.Metrics {
+		v, ok := values[d.Name]
+		if !ok {
+			continue
+		}
+		if err := s.SetValue(d, v); err != nil {
+			return fmt.Errorf("metric '%v': %v", d.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// set encodes the value of the metric definition at the given address.
+func (s *Server) set(d config.MetricDef, address config.RegisterAddr, v float64) error {
+	functionCode, start, err := address.Split()
+	if err != nil {
+		return err
+	}
+
+	if config.IsBitAccess(functionCode) {
+		on := uint16(0)
+		if v != 0 {
+			on = 1
+		}
+		return s.Set(address, on)
+	}
+
+	data, err := modbus.EncodeValue(d, v)
+	if err != nil {
+		return err
+	}
+	registers := s.registers(functionCode)
+	if int(start)+len(data)/2 > len(registers) {
+		return fmt.Errorf("value exceeds the last register")
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	// Bools share their register with others, only their bit is set.
+	if d.DataType == config.ModbusBool {
+		mask, err := modbus.EncodeValue(d, 1)
+		if err != nil {
+			return err
+		}
+		registers[start] = registers[start]&^binary.BigEndian.Uint16(mask) | binary.BigEndian.Uint16(data)
+		return nil
+	}
+	for i := 0; i < len(data)/2; i++ {
+		registers[int(start)+i] = binary.BigEndian.Uint16(data[i*2:])
+	}
+
+	return nil
+}
@@ -0,0 +1,101 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package modbustest_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/RichiH/modbus_exporter/config"
+	"github.com/RichiH/modbus_exporter/modbus"
+	"github.com/RichiH/modbus_exporter/modbustest"
+)
+
+func TestServer(t *testing.T) {
+	factor := 0.1
+	bit0, bit3 := 0, 3
+	module := config.Module{
+		Name:     "meter",
+		Protocol: config.ModbusProtocolTCPIP,
+		Timeout:  1000,
+		Metrics: []config.MetricDef{
+			{Name: "voltage", Address: 300010, DataType: config.ModbusFloat32, Endianness: config.EndiannessMixedEndian, MetricType: config.MetricTypeGauge},
+			{Name: "energy", Address: 400020, DataType: config.ModbusUInt32, Factor: &factor, MetricType: config.MetricTypeCounter},
+			{Name: "alarm", Address: 300030, DataType: config.ModbusBool, BitOffset: &bit3, MetricType: config.MetricTypeGauge},
+			{Name: "running", Address: 100005, DataType: config.ModbusBool, BitOffset: &bit0, MetricType: config.MetricTypeGauge},
+			{Name: "status", Address: 300040, DataType: config.ModbusUInt16, MetricType: config.MetricTypeGauge},
+			{Name: "mode", Address: 200007, DataType: config.ModbusBool, BitOffset: &bit0, MetricType: config.MetricTypeGauge},
+		},
+	}
+
+	s, err := modbustest.NewServer(modbustest.Registers{300040: 42, 200007: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if err := s.SetValues(&module, map[string]float64{"current": 1}); err == nil {
+		t.Fatal("expected value of undefined metric to fail")
+	}
+	if err := s.SetValues(&module, map[string]float64{"voltage": 230.5, "energy": 1234.5, "alarm": 1, "running": 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	e := modbus.NewExporter(config.Config{Modules: []config.Module{module}})
+	g, err := e.Scrape(context.Background(), s.Addr, 1, "meter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mfs, err := g.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	values := map[string]float64{}
+	for _, mf := range mfs {
+		m := mf.Metric[0]
+		values[mf.GetName()] = m.GetGauge().GetValue() + m.GetCounter().GetValue()
+	}
+	for name, expected := range map[string]float64{"voltage": 230.5, "energy": 1234.5, "alarm": 1, "running": 1, "status": 42, "mode": 1} {
+		if values[name] != expected {
+			t.Errorf("expected %v to be %v but got %v", name, expected, values[name])
+		}
+	}
+}
+
+func TestServerSet(t *testing.T) {
+	s, err := modbustest.NewUnstartedServer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Set(300010, 1, 2, 3); err != nil {
+		t.Fatal(err)
+	}
+	if s.HoldingRegisters[10] != 1 || s.HoldingRegisters[12] != 3 {
+		t.Errorf("expected holding registers 10 to 12 to be set but got %v", s.HoldingRegisters[10:13])
+	}
+	if err := s.Set(200001, 0, 5); err != nil {
+		t.Fatal(err)
+	}
+	if s.DiscreteInputs[1] != 0 || s.DiscreteInputs[2] != 1 {
+		t.Errorf("expected discrete input 2 to be on but got %v", s.DiscreteInputs[1:3])
+	}
+	if err := s.Set(465535, 1, 2); err == nil {
+		t.Error("expected values beyond the last register to fail")
+	}
+	if _, err := modbustest.NewUnstartedServer(modbustest.Registers{900000: 1}); err == nil {
+		t.Error("expected invalid function code to fail")
+	}
+}
@@ -0,0 +1,192 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package mqtt publishes the results of targets polled in the background to
+// a MQTT broker.
+package mqtt
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+	"text/template"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	promconfig "github.com/prometheus/common/config"
+
+	"github.com/RichiH/modbus_exporter/config"
+)
+
+// publishTimeout bounds waiting for the broker to acknowledge the messages of
+// one poll.
+const publishTimeout = 10 * time.Second
+
+// Publisher publishes every series of a poll result as separate message.
+type Publisher struct {
+	client paho.Client
+	config config.MQTT
+	topic  *template.Template
+	logger log.Logger
+}
+
+// NewPublisher returns a publisher connecting to the configured broker in
+// the background, retrying until it succeeds.
+func NewPublisher(c *config.MQTT, logger log.Logger) (*Publisher, error) {
+	topic, err := c.TopicTemplate()
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig, err := promconfig.NewTLSConfig(&c.TLSConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := paho.NewClientOptions().
+		AddBroker(c.Broker).
+		SetClientID(c.ClientID).
+		SetUsername(c.Username).
+		SetPassword(string(c.Password)).
+		SetTLSConfig(tlsConfig).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetConnectionLostHandler(func(_ paho.Client, err error) {
+			level.Warn(logger).Log("msg", "Lost connection to MQTT broker", "broker", c.Broker, "err", err)
+		})
+
+	client := paho.NewClient(opts)
+	client.Connect()
+
+	return &Publisher{client: client, config: *c, topic: topic, logger: logger}, nil
+}
+
+// Close disconnects from the broker.
+func (p *Publisher) Close() {
+	p.client.Disconnect(250)
+}
+
+// Publish publishes the given poll result. It implements the listener
+// signature of modbus.WithPollListener.
+func (p *Publisher) Publish(t config.PollTarget, g prometheus.Gatherer) {
+	mfs, err := g.Gather()
+	if err != nil {
+		level.Error(p.logger).Log("msg", "Failed to gather poll result", "target", t.Target, "err", err)
+		return
+	}
+
+	msgs, err := messages(p.topic, t, mfs, time.Now())
+	if err != nil {
+		level.Error(p.logger).Log("msg", "Failed to build MQTT messages", "target", t.Target, "err", err)
+		return
+	}
+
+	tokens := make([]paho.Token, 0, len(msgs))
+	for _, m := range msgs {
+		tokens = append(tokens, p.client.Publish(m.topic, p.config.QoS, p.config.Retain, m.payload))
+	}
+
+	// Don't hold up polling while the broker is unreachable.
+	go func() {
+		deadline := time.Now().Add(publishTimeout)
+		for _, token := range tokens {
+			if !token.WaitTimeout(time.Until(deadline)) {
+				level.Warn(p.logger).Log("msg", "Timed out publishing to MQTT broker", "target", t.Target)
+				return
+			}
+			if err := token.Error(); err != nil {
+				level.Error(p.logger).Log("msg", "Failed to publish to MQTT broker", "target", t.Target, "err", err)
+				return
+			}
+		}
+	}()
+}
+
+// message is a single MQTT message.
+type message struct {
+	topic   string
+	payload []byte
+}
+
+// topicData is passed to the topic template.
+type topicData struct {
+	Target    string
+	SubTarget int
+	Module    string
+	Name      string
+	Labels    map[string]string
+}
+
+// payload is the JSON payload of a message.
+type payload struct {
+	Value  float64           `json:"value"`
+	Labels map[string]string `json:"labels,omitempty"`
+	// Milliseconds since epoch.
+	Timestamp int64 `json:"timestamp"`
+}
+
+// messages returns one message per series of the given metric families.
+// Series with NaN or infinite values are skipped as JSON can't represent
+// them.
+func messages(topic *template.Template, t config.PollTarget, mfs []*dto.MetricFamily, now time.Time) ([]message, error) {
+	msgs := []message{}
+	for _, mf := range mfs {
+		for _, m := range mf.Metric {
+			var v float64
+			switch {
+			case m.Gauge != nil:
+				v = m.Gauge.GetValue()
+			case m.Counter != nil:
+				v = m.Counter.GetValue()
+			case m.Untyped != nil:
+				v = m.Untyped.GetValue()
+			default:
+				continue
+			}
+			if math.IsNaN(v) || math.IsInf(v, 0) {
+				continue
+			}
+
+			labels := map[string]string{}
+			for _, l := range m.Label {
+				labels[l.GetName()] = l.GetValue()
+			}
+
+			var b strings.Builder
+			err := topic.Execute(&b, topicData{
+				Target:    t.Target,
+				SubTarget: t.SubTarget,
+				Module:    t.Module,
+				Name:      mf.GetName(),
+				Labels:    labels,
+			})
+			if err != nil {
+				return nil, fmt.Errorf("metric %v: %v", mf.GetName(), err)
+			}
+
+			body, err := json.Marshal(payload{Value: v, Labels: labels, Timestamp: now.UnixMilli()})
+			if err != nil {
+				return nil, fmt.Errorf("metric %v: %v", mf.GetName(), err)
+			}
+
+			msgs = append(msgs, message{topic: b.String(), payload: body})
+		}
+	}
+
+	return msgs, nil
+}
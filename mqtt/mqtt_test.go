@@ -0,0 +1,99 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mqtt
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/RichiH/modbus_exporter/config"
+)
+
+func TestMessages(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	voltage := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "voltage_volts", Help: "voltage"}, []string{"phase"})
+	voltage.WithLabelValues("1").Set(230.5)
+	voltage.WithLabelValues("2").Set(math.NaN())
+	energy := prometheus.NewCounter(prometheus.CounterOpts{Name: "energy_wh_total", Help: "energy"})
+	energy.Add(42)
+	reg.MustRegister(voltage, energy)
+
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := config.MQTT{Broker: "tcp://localhost:1883"}
+	topic, err := c.TopicTemplate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	target := config.PollTarget{Target: "10.0.0.5:502", SubTarget: 3, Module: "sdm630"}
+	msgs, err := messages(topic, target, mfs, time.UnixMilli(1672531200000))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []message{
+		{"modbus/10.0.0.5:502/3/sdm630/energy_wh_total", []byte(`{"value":42,"timestamp":1672531200000}`)},
+		{"modbus/10.0.0.5:502/3/sdm630/voltage_volts", []byte(`{"value":230.5,"labels":{"phase":"1"},"timestamp":1672531200000}`)},
+	}
+	if len(msgs) != len(expected) {
+		t.Fatalf("expected %v messages but got %v", len(expected), msgs)
+	}
+	for i, m := range msgs {
+		if m.topic != expected[i].topic || string(m.payload) != string(expected[i].payload) {
+			t.Errorf("expected message %v %s but got %v %s", expected[i].topic, expected[i].payload, m.topic, m.payload)
+		}
+	}
+}
+
+func TestMessagesTopicLabels(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	voltage := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "voltage_volts", Help: "voltage"}, []string{"phase"})
+	voltage.WithLabelValues("1").Set(230.5)
+	reg.MustRegister(voltage)
+
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := config.MQTT{Topic: "{{ .Module }}/{{ .Name }}/{{ .Labels.phase }}"}
+	topic, err := c.TopicTemplate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msgs, err := messages(topic, config.PollTarget{Module: "sdm630"}, mfs, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 1 || msgs[0].topic != "sdm630/voltage_volts/1" {
+		t.Fatalf("expected one message to sdm630/voltage_volts/1 but got %v", msgs)
+	}
+
+	c.Topic = "{{ .Labels.line }}"
+	topic, err = c.TopicTemplate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := messages(topic, config.PollTarget{}, mfs, time.Now()); err == nil {
+		t.Fatal("expected referencing an undefined label to fail")
+	}
+}
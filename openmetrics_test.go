@@ -0,0 +1,64 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestWriteOpenMetrics(t *testing.T) {
+	mfs := []*dto.MetricFamily{
+		{
+			Name: proto.String("energy_wh_total"),
+			Help: proto.String("energy"),
+			Type: dto.MetricType_COUNTER.Enum(),
+			Metric: []*dto.Metric{
+				{Counter: &dto.Counter{Value: proto.Float64(42), CreatedTimestamp: timestamppb.New(time.Unix(1672531200, 0))}},
+			},
+		},
+		{
+			Name: proto.String("reactive_energy_varh_total"),
+			Help: proto.String("reactive energy"),
+			Type: dto.MetricType_COUNTER.Enum(),
+			Metric: []*dto.Metric{
+				{Counter: &dto.Counter{Value: proto.Float64(7)}},
+			},
+		},
+	}
+
+	var b strings.Builder
+	if err := writeOpenMetrics(&b, expfmt.NewFormat(expfmt.TypeOpenMetrics), mfs); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `# HELP energy_wh energy
+# TYPE energy_wh counter
+energy_wh_total 42.0
+energy_wh_created 1.6725312e+09
+# HELP reactive_energy_varh reactive energy
+# TYPE reactive_energy_varh counter
+reactive_energy_varh_total 7.0
+# EOF
+`
+	if b.String() != expected {
+		t.Fatalf("expected\n%s\nbut got\n%s", expected, b.String())
+	}
+}
@@ -0,0 +1,59 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/RichiH/modbus_exporter/modbus"
+)
+
+// probeHandler serves scrapes following the convention of the blackbox
+// exporter: failed scrapes are reported via probe_success instead of the HTTP
+// status, and sub_target defaults to 1.
+func probeHandler(e *modbus.Exporter, w http.ResponseWriter, r *http.Request, logger log.Logger, timeoutOffset time.Duration) {
+	logger = withScrapeID(w, logger)
+
+	start := time.Now()
+	gatherer, status, err := scrapeRequest(e, r, logger, timeoutOffset, "1", modbus.DefaultStatusCodes)
+	if err != nil && (status == http.StatusBadRequest || status == http.StatusForbidden) {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	success := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "probe_success",
+		Help: "Displays whether or not the probe was a success.",
+	})
+	duration := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "probe_duration_seconds",
+		Help: "Returns how long the probe took to complete in seconds.",
+	})
+	duration.Set(time.Since(start).Seconds())
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(success, duration)
+	gatherers := prometheus.Gatherers{reg}
+	if err == nil {
+		success.Set(1)
+		gatherers = append(gatherers, gatherer)
+	}
+
+	promhttp.HandlerFor(gatherers, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+}
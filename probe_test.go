@@ -0,0 +1,91 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/log"
+
+	"github.com/RichiH/modbus_exporter/config"
+	"github.com/RichiH/modbus_exporter/modbus"
+)
+
+func TestProbeHandler(t *testing.T) {
+	s, address := startServer(t)
+	s.HoldingRegisters[22] = 240
+
+	e := modbus.NewExporter(config.Config{
+		Modules: []config.Module{
+			{
+				Name:     "my_module",
+				Protocol: config.ModbusProtocolTCPIP,
+				Timeout:  500,
+				Metrics: []config.MetricDef{
+					{Name: "my_metric", Address: 300022, DataType: config.ModbusInt16, MetricType: config.MetricTypeGauge},
+				},
+			},
+		},
+	})
+
+	for _, test := range []struct {
+		name     string
+		params   url.Values
+		code     int
+		contains []string
+		missing  []string
+	}{
+		{
+			name:     "success",
+			params:   url.Values{"module": {"my_module"}, "target": {address}},
+			code:     http.StatusOK,
+			contains: []string{"probe_success 1", "probe_duration_seconds", `my_metric{module="my_module"} 240`},
+		},
+		{
+			name:     "failure",
+			params:   url.Values{"module": {"my_module"}, "target": {freeAddress(t)}},
+			code:     http.StatusOK,
+			contains: []string{"probe_success 0", "probe_duration_seconds"},
+			missing:  []string{"my_metric"},
+		},
+		{
+			name:   "no module",
+			params: url.Values{"target": {address}},
+			code:   http.StatusBadRequest,
+		},
+	} {
+		req := httptest.NewRequest("GET", "/probe?"+test.params.Encode(), nil)
+		rr := httptest.NewRecorder()
+
+		probeHandler(e, rr, req, log.NewNopLogger(), 0)
+
+		if rr.Code != test.code {
+			t.Fatalf("%v: expected status %v but got %v: %v", test.name, test.code, rr.Code, rr.Body.String())
+		}
+		for _, c := range test.contains {
+			if !strings.Contains(rr.Body.String(), c) {
+				t.Fatalf("%v: expected response to contain %q but got %v", test.name, c, rr.Body.String())
+			}
+		}
+		for _, c := range test.missing {
+			if strings.Contains(rr.Body.String(), c) {
+				t.Fatalf("%v: expected response not to contain %q but got %v", test.name, c, rr.Body.String())
+			}
+		}
+	}
+}
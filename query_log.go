@@ -0,0 +1,91 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+
+	"github.com/RichiH/modbus_exporter/modbus"
+)
+
+// queryLogEntry is the record of a scrape in the query log.
+type queryLogEntry struct {
+	Timestamp time.Time `json:"ts"`
+	Target    string    `json:"target"`
+	SubTarget byte      `json:"sub_target"`
+	Module    string    `json:"module"`
+	Duration  float64   `json:"duration_seconds"`
+	Blocks    int       `json:"blocks"`
+	Status    string    `json:"status"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// queryLog appends a JSON record per scrape talking to a target to a file,
+// like the query log of Prometheus, to analyze after the fact which devices
+// were scraped when.
+type queryLog struct {
+	mtx    sync.Mutex
+	w      io.WriteCloser
+	logger log.Logger
+}
+
+func newQueryLog(filename string, logger log.Logger) (*queryLog, error) {
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	return &queryLog{w: f, logger: logger}, nil
+}
+
+// record appends the given scrape to the log. It implements the listener
+// signature of modbus.WithScrapeListener.
+func (l *queryLog) record(s modbus.TargetStatus) {
+	entry := queryLogEntry{
+		Timestamp: s.LastScrape.UTC(),
+		Target:    s.Target,
+		SubTarget: s.SubTarget,
+		Module:    s.Module,
+		Duration:  s.Duration,
+		Blocks:    s.Blocks,
+		Status:    "success",
+		Error:     s.Error,
+	}
+	if !s.Success {
+		entry.Status = "failure"
+	}
+
+	b, err := json.Marshal(entry)
+	if err != nil {
+		level.Error(l.logger).Log("msg", "Failed to encode query log entry", "err", err)
+		return
+	}
+
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	if _, err := l.w.Write(append(b, '\n')); err != nil {
+		level.Error(l.logger).Log("msg", "Failed to write query log", "err", err)
+	}
+}
+
+func (l *queryLog) Close() error {
+	return l.w.Close()
+}
@@ -0,0 +1,82 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/log"
+
+	"github.com/RichiH/modbus_exporter/config"
+	"github.com/RichiH/modbus_exporter/modbus"
+)
+
+func TestQueryLog(t *testing.T) {
+	_, address := startServer(t)
+	unreachable := freeAddress(t)
+
+	filename := filepath.Join(t.TempDir(), "query.log")
+	l, err := newQueryLog(filename, log.NewNopLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := modbus.NewExporter(config.Config{Modules: []config.Module{{
+		Name:     "my_module",
+		Protocol: config.ModbusProtocolTCPIP,
+		Timeout:  500,
+		Metrics: []config.MetricDef{
+			{Name: "my_metric", Address: 300001, DataType: config.ModbusInt16, MetricType: config.MetricTypeGauge},
+		},
+	}}}, modbus.WithScrapeListener(l.record))
+	if _, err := e.Scrape(context.Background(), address, 1, "my_module"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.Scrape(context.Background(), unreachable, 2, "my_module"); err == nil {
+		t.Fatal("expected scraping an unreachable target to fail")
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 entries but got %q", lines)
+	}
+
+	var entries []queryLogEntry
+	for _, line := range lines {
+		var entry queryLogEntry
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, entry)
+	}
+
+	if e := entries[0]; e.Target != address || e.SubTarget != 1 || e.Module != "my_module" || e.Blocks != 1 || e.Status != "success" || e.Error != "" || e.Timestamp.IsZero() {
+		t.Fatalf("expected successful scrape of %v but got %+v", address, e)
+	}
+	if e := entries[1]; e.Target != unreachable || e.Status != "failure" || e.Error == "" {
+		t.Fatalf("expected failed scrape of %v but got %+v", unreachable, e)
+	}
+}
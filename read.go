@@ -0,0 +1,83 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/RichiH/modbus_exporter/config"
+	"github.com/RichiH/modbus_exporter/modbus"
+)
+
+// readValue reads a single value of the target with the transport and parsing
+// of scrapes and prints it, e.g. as quick sanity check of a device in the
+// field. The address is given without the function code digit of the
+// configuration file, the register type selects the function code. Coils and
+// discrete inputs are read as bool, bools of registers from the given bit.
+// It returns the exit code.
+func readValue(w io.Writer, target string, subTarget uint8, registerType string, address uint16, dataType, endianness string, bitOffset int, timeout time.Duration) int {
+	functionCode, ok := registerTypes[registerType]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "register type must be one of coil, discrete, holding or input but got '%v'\n", registerType)
+		return 1
+	}
+
+	def := config.MetricDef{
+		Name:       "value",
+		Address:    config.RegisterAddr(uint32(functionCode)*100000 + uint32(address)),
+		DataType:   config.ModbusDataType(dataType),
+		Endianness: config.EndiannessType(endianness),
+		MetricType: config.MetricTypeGauge,
+	}
+	if config.IsBitAccess(functionCode) {
+		def.DataType = config.ModbusBool
+		bitOffset = 0
+	}
+	if def.DataType == config.ModbusBool {
+		def.BitOffset = &bitOffset
+	}
+
+	e := modbus.NewExporter(config.Config{Modules: []config.Module{{
+		Name:     "read",
+		Protocol: config.ModbusProtocolTCPIP,
+		Timeout:  int(timeout / time.Millisecond),
+		Metrics:  []config.MetricDef{def},
+	}}})
+
+	gatherer, err := e.Scrape(context.Background(), target, subTarget, "read")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	metricFamilies, err := gatherer.Gather()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	for _, mf := range metricFamilies {
+		if mf.GetName() == def.Name {
+			fmt.Fprintln(w, strconv.FormatFloat(mf.Metric[0].GetGauge().GetValue(), 'g', -1, 64))
+			return 0
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "the response of the target didn't contain the %v value\n", def.DataType)
+	return 1
+}
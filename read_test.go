@@ -0,0 +1,57 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadValue(t *testing.T) {
+	s, address := startServer(t)
+	// 230.5 as float32 is 0x43668000.
+	s.HoldingRegisters[3027] = 0x4366
+	s.HoldingRegisters[3028] = 0x8000
+	s.InputRegisters[10] = 0xfffe
+	s.Coils[5] = 1
+
+	for _, test := range []struct {
+		registerType string
+		address      uint16
+		dataType     string
+		bitOffset    int
+		expected     string
+	}{
+		{"holding", 3027, "float32", 0, "230.5"},
+		{"holding", 3027, "uint16", 0, "17254"},
+		{"holding", 3027, "bool", 1, "1"},
+		{"holding", 3027, "bool", 2, "0"},
+		{"input", 10, "int16", 0, "-2"},
+		{"coil", 5, "uint16", 0, "1"},
+	} {
+		var b strings.Builder
+		if code := readValue(&b, address, 1, test.registerType, test.address, test.dataType, "big", test.bitOffset, time.Second); code != 0 {
+			t.Fatalf("%+v: expected exit code 0 but got %v", test, code)
+		}
+		if got := strings.TrimSpace(b.String()); got != test.expected {
+			t.Errorf("%+v: expected %v but got %v", test, test.expected, got)
+		}
+	}
+
+	var b strings.Builder
+	if code := readValue(&b, freeAddress(t), 1, "holding", 0, "uint16", "big", 0, 100*time.Millisecond); code != 1 {
+		t.Fatalf("expected exit code 1 for unreachable target but got %v", code)
+	}
+}
@@ -0,0 +1,183 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	config_util "github.com/prometheus/common/config"
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/yaml.v2"
+)
+
+// routeAuthConfig holds the authentication required per route, on top of the
+// one of the web configuration file applying to all of them, e.g. to protect
+// the device access of /modbus more strictly than the self-telemetry of
+// /metrics.
+type routeAuthConfig struct {
+	// Requirements by path. Paths ending in a slash match all paths below,
+	// the longest matching one applies.
+	Routes map[string]*routeAuth `yaml:"routes"`
+}
+
+// routeAuth holds the authentication required for a route.
+type routeAuth struct {
+	// Bcrypt hashed passwords by user, as in the web configuration file.
+	BasicAuthUsers map[string]config_util.Secret `yaml:"basic_auth_users,omitempty"`
+	// Require a client certificate verified against the client_ca_file of
+	// the web configuration file, which needs a client_auth_type verifying
+	// certificates if given.
+	ClientCertificate bool `yaml:"client_certificate,omitempty"`
+	// Common names of the client certificates allowed. Empty allows all.
+	ClientCommonNames []string `yaml:"client_common_names,omitempty"`
+
+	// verified holds the hashes of the valid credentials already checked,
+	// bcrypt being too expensive to run on every scrape.
+	mtx      sync.Mutex
+	verified map[[sha256.Size]byte]struct{}
+}
+
+// loadRouteAuth loads the route authentication from the given file.
+func loadRouteAuth(path string) (*routeAuthConfig, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &routeAuthConfig{}
+	if err := yaml.UnmarshalStrict(b, c); err != nil {
+		return nil, err
+	}
+
+	for route, a := range c.Routes {
+		if !strings.HasPrefix(route, "/") {
+			return nil, fmt.Errorf("route '%v' must start with /", route)
+		}
+		if a == nil || (len(a.BasicAuthUsers) == 0 && !a.ClientCertificate) {
+			return nil, fmt.Errorf("route '%v': neither basic_auth_users nor client_certificate set", route)
+		}
+		if len(a.ClientCommonNames) > 0 && !a.ClientCertificate {
+			return nil, fmt.Errorf("route '%v': client_common_names require client_certificate", route)
+		}
+		for user, hash := range a.BasicAuthUsers {
+			if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+				return nil, fmt.Errorf("route '%v': user '%v': %v", route, user, err)
+			}
+		}
+	}
+
+	return c, nil
+}
+
+// route returns the authentication required for the given path, or nil if
+// none is.
+func (c *routeAuthConfig) route(path string) *routeAuth {
+	var match string
+	var auth *routeAuth
+	for route, a := range c.Routes {
+		if route != path && !(strings.HasSuffix(route, "/") && strings.HasPrefix(path, route)) {
+			continue
+		}
+		if auth == nil || len(route) > len(match) {
+			match, auth = route, a
+		}
+	}
+
+	return auth
+}
+
+// wrap returns a handler enforcing the authentication of the route of each
+// request before passing it to the given one.
+func (c *routeAuthConfig) wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		a := c.route(r.URL.Path)
+		if a == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if a.ClientCertificate && !a.certificateAllowed(r) {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+
+		if len(a.BasicAuthUsers) > 0 && !a.userAllowed(r) {
+			w.Header().Set("WWW-Authenticate", "Basic")
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// certificateAllowed returns whether the request comes with a verified client
+// certificate with one of the allowed common names.
+func (a *routeAuth) certificateAllowed(r *http.Request) bool {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return false
+	}
+	if len(a.ClientCommonNames) == 0 {
+		return true
+	}
+
+	commonName := r.TLS.VerifiedChains[0][0].Subject.CommonName
+	for _, n := range a.ClientCommonNames {
+		if n == commonName {
+			return true
+		}
+	}
+
+	return false
+}
+
+// userAllowed returns whether the request comes with the credentials of one
+// of the allowed users.
+func (a *routeAuth) userAllowed(r *http.Request) bool {
+	user, pass, ok := r.BasicAuth()
+	if !ok {
+		return false
+	}
+	hash, ok := a.BasicAuthUsers[user]
+	if !ok {
+		return false
+	}
+
+	key := sha256.Sum256([]byte(user + "\x00" + string(hash) + "\x00" + pass))
+	a.mtx.Lock()
+	_, verified := a.verified[key]
+	a.mtx.Unlock()
+	if verified {
+		return true
+	}
+
+	// bcrypt is slow on purpose, so other requests must not wait for it.
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(pass)) != nil {
+		return false
+	}
+
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	if a.verified == nil {
+		a.verified = map[[sha256.Size]byte]struct{}{}
+	}
+	a.verified[key] = struct{}{}
+
+	return true
+}
@@ -0,0 +1,103 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestRouteAuth(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "route-auth.yml")
+	content := fmt.Sprintf(`routes:
+  /modbus:
+    basic_auth_users:
+      prometheus: %q
+  /debug/:
+    client_certificate: true
+    client_common_names: ["admin"]
+`, hash)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	c, err := loadRouteAuth(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := c.wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	withCert := func(commonName string) *tls.ConnectionState {
+		cert := &x509.Certificate{Subject: pkix.Name{CommonName: commonName}}
+		return &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+	}
+
+	for _, test := range []struct {
+		path     string
+		user     string
+		password string
+		tls      *tls.ConnectionState
+		code     int
+	}{
+		{path: "/metrics", code: http.StatusOK},
+		{path: "/modbus", code: http.StatusUnauthorized},
+		{path: "/modbus", user: "prometheus", password: "wrong", code: http.StatusUnauthorized},
+		{path: "/modbus", user: "other", password: "secret", code: http.StatusUnauthorized},
+		{path: "/modbus", user: "prometheus", password: "secret", code: http.StatusOK},
+		{path: "/modbus", user: "prometheus", password: "secret", code: http.StatusOK},
+		{path: "/debug/registers", code: http.StatusForbidden},
+		{path: "/debug/registers", tls: withCert("grafana"), code: http.StatusForbidden},
+		{path: "/debug/registers", tls: withCert("admin"), code: http.StatusOK},
+	} {
+		r := httptest.NewRequest("GET", test.path, nil)
+		if test.user != "" {
+			r.SetBasicAuth(test.user, test.password)
+		}
+		r.TLS = test.tls
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, r)
+
+		if rr.Code != test.code {
+			t.Errorf("%v as %q: expected status %v but got %v", test.path, test.user, test.code, rr.Code)
+		}
+	}
+
+	for _, invalid := range []string{
+		"routes:\n  modbus:\n    client_certificate: true\n",
+		"routes:\n  /modbus: {}\n",
+		"routes:\n  /modbus:\n    client_common_names: [admin]\n    basic_auth_users: {a: b}\n",
+		"routes:\n  /modbus:\n    basic_auth_users: {prometheus: secret}\n",
+		"routes:\n  /modbus:\n    unknown: true\n",
+	} {
+		if err := os.WriteFile(path, []byte(invalid), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := loadRouteAuth(path); err == nil {
+			t.Errorf("expected %q to fail loading", invalid)
+		}
+	}
+}
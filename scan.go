@@ -0,0 +1,153 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	gomodbus "github.com/goburrow/modbus"
+
+	"github.com/RichiH/modbus_exporter/config"
+	"github.com/RichiH/modbus_exporter/modbus"
+)
+
+// scanUnitIDs probes the given unit IDs of the target one after the other,
+// e.g. to find the slaves on an undocumented RS485 bus behind a gateway, and
+// prints the ones responding. A slave responding with an exception exists
+// too. It returns the exit code, non-zero if none responded.
+func scanUnitIDs(w io.Writer, target, ids string, address uint32, timeout time.Duration) int {
+	subTargets, err := modbus.ParseSubTargets(nil, ids)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	functionCode, start, err := config.RegisterAddr(address).Split()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	e := modbus.NewExporter(config.Config{})
+	module := &config.Module{Timeout: int(timeout / time.Millisecond)}
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "unit id\tresponse\tduration")
+	responding := 0
+	for _, st := range subTargets {
+		begin := time.Now()
+		_, err := e.ReadRaw(context.Background(), target, st.ID, module, functionCode, start, 1)
+		duration := time.Since(begin)
+
+		response, ok := scanResponse(err)
+		if !ok {
+			continue
+		}
+		responding++
+		fmt.Fprintf(tw, "%d\t%v\t%v\n", st.ID, response, duration.Round(time.Millisecond))
+	}
+	tw.Flush()
+
+	fmt.Fprintf(w, "%d of %d unit ids responded\n", responding, len(subTargets))
+	if responding == 0 {
+		return 1
+	}
+
+	return 0
+}
+
+// scanRegisters reads the given range of registers (or coils, discrete
+// inputs) of the target one at a time and prints which return data and which
+// an exception, along with the raw values, e.g. to build a module for a
+// device with incomplete documentation. Addresses are given and printed in
+// the format of the configuration file. It returns the exit code, non-zero if
+// no register returned data.
+func scanRegisters(w io.Writer, target string, subTarget uint8, from, to uint32, timeout time.Duration) int {
+	functionCode, first, err := config.RegisterAddr(from).Split()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	toFunctionCode, last, err := config.RegisterAddr(to).Split()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if toFunctionCode != functionCode || last < first {
+		fmt.Fprintf(os.Stderr, "%v to %v is not a range of addresses with the same function code\n", from, to)
+		return 1
+	}
+
+	e := modbus.NewExporter(config.Config{})
+	module := &config.Module{Timeout: int(timeout / time.Millisecond)}
+	base := uint32(functionCode) * 100000
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	if config.IsBitAccess(functionCode) {
+		fmt.Fprintln(tw, "address\tresponse\tvalue")
+	} else {
+		fmt.Fprintln(tw, "address\tresponse\thex\tint16\tuint16")
+	}
+
+	data, exceptions := 0, 0
+	for address := uint32(first); address <= uint32(last); address++ {
+		raw, err := e.ReadRaw(context.Background(), target, subTarget, module, functionCode, uint16(address), 1)
+		switch {
+		case err != nil:
+			var modbusErr *gomodbus.ModbusError
+			if errors.As(err, &modbusErr) {
+				exceptions++
+			}
+			response, _ := scanResponse(err)
+			fmt.Fprintf(tw, "%d\t%v\n", base+address, response)
+		case config.IsBitAccess(functionCode):
+			data++
+			fmt.Fprintf(tw, "%d\tok\t%d\n", base+address, raw[0]&1)
+		default:
+			data++
+			reg := binary.BigEndian.Uint16(raw)
+			fmt.Fprintf(tw, "%d\tok\t%04x\t%d\t%d\n", base+address, reg, int16(reg), reg)
+		}
+	}
+	tw.Flush()
+
+	fmt.Fprintf(w, "%d of %d addresses returned data, %d an exception\n", data, uint32(last)-uint32(first)+1, exceptions)
+	if data == 0 {
+		return 1
+	}
+
+	return 0
+}
+
+// scanResponse describes the outcome of a probing read and returns whether
+// the device responded at all.
+func scanResponse(err error) (string, bool) {
+	if err == nil {
+		return "ok", true
+	}
+
+	var modbusErr *gomodbus.ModbusError
+	if errors.As(err, &modbusErr) {
+		return fmt.Sprintf("exception %d", modbusErr.ExceptionCode), true
+	}
+
+	return err.Error(), false
+}
@@ -0,0 +1,86 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tbrandon/mbserver"
+)
+
+func TestScanUnitIDs(t *testing.T) {
+	s, address := startServer(t)
+	s.RegisterFunctionHandler(3, func(s *mbserver.Server, frame mbserver.Framer) ([]byte, *mbserver.Exception) {
+		if frame.(*mbserver.TCPFrame).Device == 2 {
+			return []byte{}, &mbserver.IllegalDataAddress
+		}
+		return mbserver.ReadHoldingRegisters(s, frame)
+	})
+
+	var b strings.Builder
+	if code := scanUnitIDs(&b, address, "1-3", 300000, time.Second); code != 0 {
+		t.Fatalf("expected exit code 0 but got %v:\n%v", code, b.String())
+	}
+
+	for _, pattern := range []string{
+		`(?m)^1\s+ok\s+\S+$`,
+		`(?m)^2\s+exception 2\s+\S+$`,
+		`(?m)^3\s+ok\s+\S+$`,
+		`(?m)^3 of 3 unit ids responded$`,
+	} {
+		if !regexp.MustCompile(pattern).MatchString(b.String()) {
+			t.Errorf("expected output to match %v but got:\n%v", pattern, b.String())
+		}
+	}
+
+	b.Reset()
+	if code := scanUnitIDs(&b, freeAddress(t), "1", 300000, 100*time.Millisecond); code != 1 {
+		t.Fatalf("expected exit code 1 without responding unit ids but got %v:\n%v", code, b.String())
+	}
+}
+
+func TestScanRegisters(t *testing.T) {
+	s, address := startServer(t)
+	s.HoldingRegisters[10] = 0xfffe
+	s.HoldingRegisters[11] = 42
+	s.RegisterFunctionHandler(3, func(s *mbserver.Server, frame mbserver.Framer) ([]byte, *mbserver.Exception) {
+		if frame.GetData()[1] == 12 {
+			return []byte{}, &mbserver.IllegalDataAddress
+		}
+		return mbserver.ReadHoldingRegisters(s, frame)
+	})
+
+	var b strings.Builder
+	if code := scanRegisters(&b, address, 1, 300010, 300012, time.Second); code != 0 {
+		t.Fatalf("expected exit code 0 but got %v:\n%v", code, b.String())
+	}
+
+	for _, pattern := range []string{
+		`(?m)^300010\s+ok\s+fffe\s+-2\s+65534$`,
+		`(?m)^300011\s+ok\s+002a\s+42\s+42$`,
+		`(?m)^300012\s+exception 2$`,
+		`(?m)^2 of 3 addresses returned data, 1 an exception$`,
+	} {
+		if !regexp.MustCompile(pattern).MatchString(b.String()) {
+			t.Errorf("expected output to match %v but got:\n%v", pattern, b.String())
+		}
+	}
+
+	if code := scanRegisters(&b, address, 1, 300010, 400012, time.Second); code != 1 {
+		t.Fatal("expected a range across function codes to fail")
+	}
+}
@@ -0,0 +1,43 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+
+	"github.com/go-kit/log"
+)
+
+// scrapeIDHeader is the response header carrying the ID of a scrape.
+const scrapeIDHeader = "X-Scrape-Id"
+
+// withScrapeID generates an ID for a scrape request and returns it in the
+// response header. The returned logger adds it to every log line, so failed
+// scrapes can be correlated with the logs of the exporter.
+func withScrapeID(w http.ResponseWriter, logger log.Logger) log.Logger {
+	id := newScrapeID()
+	w.Header().Set(scrapeIDHeader, id)
+
+	return log.With(logger, "scrape_id", id)
+}
+
+func newScrapeID() string {
+	b := make([]byte, 8)
+	// crypto/rand doesn't fail on supported platforms.
+	_, _ = rand.Read(b)
+
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,57 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/log"
+
+	"github.com/RichiH/modbus_exporter/config"
+	"github.com/RichiH/modbus_exporter/modbus"
+)
+
+func TestScrapeHandlerScrapeID(t *testing.T) {
+	e := modbus.NewExporter(config.Config{Modules: []config.Module{{
+		Name:     "my_module",
+		Protocol: config.ModbusProtocolTCPIP,
+		Timeout:  500,
+	}}})
+
+	ids := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		var logs bytes.Buffer
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/modbus?module=my_module&sub_target=1&target="+freeAddress(t), nil)
+		scrapeHandler(e, w, r, log.NewLogfmtLogger(&logs), 0, modbus.DefaultStatusCodes)
+
+		id := w.Header().Get(scrapeIDHeader)
+		if id == "" {
+			t.Fatal("expected a scrape ID header")
+		}
+		if ids[id] {
+			t.Fatalf("expected unique scrape IDs but got %v twice", id)
+		}
+		ids[id] = true
+
+		for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+			if !strings.Contains(line, "scrape_id="+id) {
+				t.Fatalf("expected log line to contain scrape ID %v but got %v", id, line)
+			}
+		}
+	}
+}
@@ -0,0 +1,66 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// withScrapeMeta returns a gatherer adding modbus_up,
+// modbus_scrape_duration_seconds and modbus_scraped_metrics to the result of
+// a scrape, like the snmp_exporter does, so alerts on devices don't need the
+// telemetry of the exporter. The given gatherer is nil if the scrape failed.
+func withScrapeMeta(g prometheus.Gatherer, duration time.Duration) (prometheus.Gatherer, error) {
+	var mfs []*dto.MetricFamily
+	if g != nil {
+		var err error
+		mfs, err = g.Gather()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	up := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "modbus_up",
+		Help: "Whether the scrape of the target was successful.",
+	})
+	scrapeDuration := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "modbus_scrape_duration_seconds",
+		Help: "Duration of the scrape of the target.",
+	})
+	scraped := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "modbus_scraped_metrics",
+		Help: "Number of series returned by the scrape of the target.",
+	})
+
+	if g != nil {
+		up.Set(1)
+	}
+	scrapeDuration.Set(duration.Seconds())
+	series := 0
+	for _, mf := range mfs {
+		series += len(mf.GetMetric())
+	}
+	scraped.Set(float64(series))
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(up, scrapeDuration, scraped)
+
+	return prometheus.Gatherers{reg, prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		return mfs, nil
+	})}, nil
+}
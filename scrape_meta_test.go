@@ -0,0 +1,84 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/log"
+
+	"github.com/RichiH/modbus_exporter/config"
+	"github.com/RichiH/modbus_exporter/modbus"
+)
+
+func TestScrapeHandlerMeta(t *testing.T) {
+	s, address := startServer(t)
+	s.HoldingRegisters[1] = 1
+
+	e := modbus.NewExporter(config.Config{Modules: []config.Module{{
+		Name:     "my_module",
+		Protocol: config.ModbusProtocolTCPIP,
+		Timeout:  500,
+		Metrics: []config.MetricDef{
+			{Name: "my_metric", Address: 300001, DataType: config.ModbusInt16, MetricType: config.MetricTypeGauge},
+		},
+	}}})
+
+	for _, test := range []struct {
+		name        string
+		target      string
+		upOnFailure bool
+		code        int
+		contains    []string
+	}{
+		{
+			name:     "success",
+			target:   address,
+			code:     http.StatusOK,
+			contains: []string{"modbus_up 1\n", "modbus_scraped_metrics 1\n", "modbus_scrape_duration_seconds ", "my_metric{"},
+		},
+		{
+			name:        "failure",
+			target:      freeAddress(t),
+			upOnFailure: true,
+			code:        http.StatusOK,
+			contains:    []string{"modbus_up 0\n", "modbus_scraped_metrics 0\n"},
+		},
+		{
+			name:   "failure with error status",
+			target: freeAddress(t),
+			code:   http.StatusServiceUnavailable,
+		},
+	} {
+		req := httptest.NewRequest("GET", "/modbus?module=my_module&sub_target=1&target="+test.target, nil)
+		rr := httptest.NewRecorder()
+		codes, err := newStatusCodes(nil, test.upOnFailure)
+		if err != nil {
+			t.Fatal(err)
+		}
+		scrapeHandler(e, rr, req, log.NewNopLogger(), 0, codes)
+
+		if rr.Code != test.code {
+			t.Fatalf("%v: expected status %v but got %v: %v", test.name, test.code, rr.Code, rr.Body.String())
+		}
+		for _, c := range test.contains {
+			if !strings.Contains(rr.Body.String(), c) {
+				t.Errorf("%v: expected response to contain %q but got:\n%v", test.name, c, rr.Body.String())
+			}
+		}
+	}
+}
@@ -0,0 +1,50 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+// serviceName is the name the exporter is registered as with the service
+// manager and logs to the event log with on Windows.
+const serviceName = "modbus_exporter"
+
+// service connects the exporter to the service manager of the platform,
+// which is the service control manager of Windows. A nil service means the
+// exporter isn't run as one.
+type service struct {
+	// stop is closed once the service manager asks the exporter to stop.
+	stop chan struct{}
+	// exited is closed once the exporter shut down, done once the service
+	// manager was told so.
+	exited chan struct{}
+	done   chan struct{}
+}
+
+// stopRequested returns a channel closed once the service manager asks the
+// exporter to stop, which is never for nil services.
+func (s *service) stopRequested() <-chan struct{} {
+	if s == nil {
+		return nil
+	}
+
+	return s.stop
+}
+
+// shutDown reports the exporter as stopped to the service manager.
+func (s *service) shutDown() {
+	if s == nil {
+		return
+	}
+
+	close(s.exited)
+	<-s.done
+}
@@ -0,0 +1,26 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+
+package main
+
+import (
+	"github.com/go-kit/log"
+	"github.com/prometheus/common/promlog"
+)
+
+// runService returns nil, as only Windows has a service manager to report to.
+func runService(*promlog.Config) (*service, log.Logger, error) {
+	return nil, nil, nil
+}
@@ -0,0 +1,109 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build windows
+
+package main
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/common/promlog"
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/eventlog"
+)
+
+// eventID is the ID of all events logged, the exporter doesn't ship a message
+// file defining others.
+const eventID = 1
+
+// runService reports the exporter as running to the service control manager
+// if started by it and returns a logger writing to the event log, as the
+// output of services isn't kept. It returns nil otherwise.
+func runService(promlogConfig *promlog.Config) (*service, log.Logger, error) {
+	isService, err := svc.IsWindowsService()
+	if err != nil || !isService {
+		return nil, nil, err
+	}
+
+	elog, err := eventlog.Open(serviceName)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	s := &service{stop: make(chan struct{}), exited: make(chan struct{}), done: make(chan struct{})}
+	go func() {
+		defer close(s.done)
+		if err := svc.Run(serviceName, s); err != nil {
+			elog.Error(eventID, "Error running as service: "+err.Error())
+		}
+	}()
+
+	return s, promlog.NewWithLogger(&eventLogLogger{elog: elog}, promlogConfig), nil
+}
+
+// Execute implements the svc.Handler interface, treating stop and shutdown
+// requests like SIGTERM.
+func (s *service) Execute(args []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	status <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+
+	for {
+		select {
+		case r := <-requests:
+			switch r.Cmd {
+			case svc.Interrogate:
+				status <- r.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				status <- svc.Status{State: svc.StopPending}
+				close(s.stop)
+				<-s.exited
+				return false, 0
+			}
+		case <-s.exited:
+			// Shut down otherwise, e.g. via /-/quit.
+			return false, 0
+		}
+	}
+}
+
+// eventLogLogger logs logfmt formatted lines to the event log, as error or
+// warning events for the respective levels and information events otherwise.
+type eventLogLogger struct {
+	elog *eventlog.Log
+}
+
+// Log implements the log.Logger interface.
+func (l *eventLogLogger) Log(keyvals ...interface{}) error {
+	var buf bytes.Buffer
+	if err := log.NewLogfmtLogger(&buf).Log(keyvals...); err != nil {
+		return err
+	}
+	msg := strings.TrimSpace(buf.String())
+
+	for i := 0; i+1 < len(keyvals); i += 2 {
+		if keyvals[i] != level.Key() {
+			continue
+		}
+		switch keyvals[i+1] {
+		case level.ErrorValue():
+			return l.elog.Error(eventID, msg)
+		case level.WarnValue():
+			return l.elog.Warning(eventID, msg)
+		}
+	}
+
+	return l.elog.Info(eventID, msg)
+}
@@ -0,0 +1,153 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/RichiH/modbus_exporter/config"
+	"github.com/RichiH/modbus_exporter/modbustest"
+)
+
+// simulator answers the registers of a module, e.g. for demos and
+// integration tests without physical devices. It answers all unit IDs the
+// same.
+type simulator struct {
+	server *modbustest.Server
+	module *config.Module
+	// values of metrics by name, others get random ones.
+	values map[string]float64
+	rand   *rand.Rand
+}
+
+func newSimulator(server *modbustest.Server, module *config.Module, values map[string]float64, r *rand.Rand) (*simulator, error) {
+	for name := range values {
+		found := false
+		for _, d := range module.Metrics {
+			found = found || d.Name == name
+		}
+		if !found {
+			return nil, fmt.Errorf("metric '%v' not defined in module '%v'", name, module.Name)
+		}
+	}
+
+	s := &simulator{
+		server: server,
+		module: module,
+		values: values,
+		rand:   r,
+	}
+
+	return s, s.update()
+}
+
+// update sets the registers of all metrics of the module, drawing new random
+// values for the ones without configured value.
+func (s *simulator) update() error {
+	for _, d := range s.module.Metrics {
+		v, ok := s.values[d.Name]
+		if !ok {
+			v = s.random(d)
+		}
+		if err := s.server.SetValue(d, v); err != nil {
+			return fmt.Errorf("metric '%v': %v", d.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// random returns a random value of the metric definition, a bool or a
+// whole number below 1000 before applying the factor.
+func (s *simulator) random(d config.MetricDef) float64 {
+	raw := float64(s.rand.Intn(1000))
+	if d.DataType == config.ModbusBool {
+		raw = float64(s.rand.Intn(2))
+	}
+	if d.Factor != nil {
+		return raw * *d.Factor
+	}
+
+	return raw
+}
+
+// simulate serves the registers of the given module of the configuration
+// file on the given address until interrupted, updating the random values
+// every interval if not 0. It returns the exit code.
+func simulate(configFile string, opts config.LoadOptions, moduleName, address string, values map[string]string, interval time.Duration) int {
+	c, err := config.LoadConfigWithOptions(configFile, opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v: %v\n", configFile, err)
+		return 1
+	}
+	module := c.GetModule(moduleName)
+	if module == nil {
+		fmt.Fprintf(os.Stderr, "module '%v' not defined in configuration file\n", moduleName)
+		return 1
+	}
+
+	parsed := map[string]float64{}
+	for name, v := range values {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid value of metric '%v': %v\n", name, err)
+			return 1
+		}
+		parsed[name] = f
+	}
+
+	server, err := modbustest.NewUnstartedServer(nil)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	s, err := newSimulator(server, module, parsed, rand.New(rand.NewSource(time.Now().UnixNano())))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if err := server.Start(address); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer server.Close()
+	fmt.Fprintf(os.Stderr, "simulating module '%v' on %v\n", moduleName, address)
+
+	term := make(chan os.Signal, 1)
+	signal.Notify(term, os.Interrupt, syscall.SIGTERM)
+
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-tick:
+			if err := s.update(); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return 1
+			}
+		case <-term:
+			return 0
+		}
+	}
+}
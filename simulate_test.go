@@ -0,0 +1,73 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"math/rand"
+	"testing"
+
+	"github.com/RichiH/modbus_exporter/config"
+	"github.com/RichiH/modbus_exporter/modbus"
+)
+
+func TestSimulator(t *testing.T) {
+	factor := 0.1
+	bit0, bit1 := 0, 1
+	module := config.Module{
+		Name:     "meter",
+		Protocol: config.ModbusProtocolTCPIP,
+		Timeout:  1000,
+		Metrics: []config.MetricDef{
+			{Name: "voltage", Address: 300010, DataType: config.ModbusFloat32, Endianness: config.EndiannessMixedEndian, MetricType: config.MetricTypeGauge},
+			{Name: "energy", Address: 400020, DataType: config.ModbusUInt32, Factor: &factor, MetricType: config.MetricTypeCounter},
+			{Name: "alarm", Address: 300030, DataType: config.ModbusBool, BitOffset: &bit0, MetricType: config.MetricTypeGauge},
+			{Name: "warning", Address: 300030, DataType: config.ModbusBool, BitOffset: &bit1, MetricType: config.MetricTypeGauge},
+			{Name: "running", Address: 100005, DataType: config.ModbusBool, BitOffset: &bit0, MetricType: config.MetricTypeGauge},
+		},
+	}
+
+	server, address := startServer(t)
+	if _, err := newSimulator(server, &module, map[string]float64{"current": 1}, rand.New(rand.NewSource(1))); err == nil {
+		t.Fatal("expected value of undefined metric to fail")
+	}
+
+	if _, err := newSimulator(server, &module, map[string]float64{"voltage": 230.5, "alarm": 1, "warning": 1, "running": 1}, rand.New(rand.NewSource(1))); err != nil {
+		t.Fatal(err)
+	}
+
+	e := modbus.NewExporter(config.Config{Modules: []config.Module{module}})
+	g, err := e.Scrape(context.Background(), address, 1, "meter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mfs, err := g.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	values := map[string]float64{}
+	for _, mf := range mfs {
+		m := mf.Metric[0]
+		values[mf.GetName()] = m.GetGauge().GetValue() + m.GetCounter().GetValue()
+	}
+	for name, expected := range map[string]float64{"voltage": 230.5, "alarm": 1, "warning": 1, "running": 1} {
+		if values[name] != expected {
+			t.Errorf("expected %v to be %v but got %v", name, expected, values[name])
+		}
+	}
+	if energy, ok := values["energy"]; !ok || energy < 0 || energy >= 100 {
+		t.Errorf("expected random energy below 100 but got %v", energy)
+	}
+}
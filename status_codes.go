@@ -0,0 +1,44 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/RichiH/modbus_exporter/modbus"
+)
+
+// newStatusCodes returns the default status codes overridden by the given
+// ones. With upOnFailure, all failures default to 200.
+func newStatusCodes(overrides map[string]string, upOnFailure bool) (modbus.StatusCodes, error) {
+	codes := modbus.StatusCodes{}
+	for status, code := range modbus.DefaultStatusCodes {
+		if upOnFailure {
+			code = http.StatusOK
+		}
+		codes[status] = code
+	}
+
+	for status, v := range overrides {
+		code, err := strconv.Atoi(v)
+		if err != nil || (code != http.StatusOK && (code < 400 || code > 599)) {
+			return nil, fmt.Errorf("invalid HTTP status code '%v' for status %v, must be 200 or between 400 and 599", v, status)
+		}
+		codes[status] = code
+	}
+
+	return codes, nil
+}
@@ -0,0 +1,66 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/RichiH/modbus_exporter/modbus"
+)
+
+func TestStatusCodes(t *testing.T) {
+	connect := fmt.Errorf("%w 10.0.0.5:502 via module my_module", modbus.ErrConnect)
+	other := errors.New("something else")
+
+	for _, test := range []struct {
+		name        string
+		overrides   map[string]string
+		upOnFailure bool
+		err         error
+		code        int
+	}{
+		{name: "default connect", err: connect, code: http.StatusServiceUnavailable},
+		{name: "default other", err: other, code: http.StatusInternalServerError},
+		{name: "up on failure", upOnFailure: true, err: connect, code: http.StatusOK},
+		{
+			name:        "override up on failure",
+			overrides:   map[string]string{"connect_failed": "502"},
+			upOnFailure: true,
+			err:         connect,
+			code:        http.StatusBadGateway,
+		},
+		{name: "override default", overrides: map[string]string{"default": "200"}, err: other, code: http.StatusOK},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			codes, err := newStatusCodes(test.overrides, test.upOnFailure)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if code := codes.Code(test.err); code != test.code {
+				t.Fatalf("expected %v but got %v", test.code, code)
+			}
+		})
+	}
+}
+
+func TestStatusCodesInvalid(t *testing.T) {
+	for _, v := range []string{"abc", "302", "600"} {
+		if _, err := newStatusCodes(map[string]string{"timeout": v}, false); err == nil {
+			t.Fatalf("expected status code %v to be rejected", v)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"path/filepath"
+	"regexp"
+	"strconv"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/RichiH/modbus_exporter/config"
+	"github.com/RichiH/modbus_exporter/modbus"
+)
+
+// invalidFileNameChars matches characters replaced in textfile names.
+var invalidFileNameChars = regexp.MustCompile(`[^a-zA-Z0-9_.-]`)
+
+// textfileWriter writes the results of polled targets into a directory read
+// by the textfile collector of the node exporter.
+type textfileWriter struct {
+	directory string
+	logger    log.Logger
+}
+
+// write writes the given poll result to the file of its target. It
+// implements the listener signature of modbus.WithPollListener.
+func (w textfileWriter) write(t config.PollTarget, g prometheus.Gatherer) {
+	// Files of all targets are exposed side by side, so their metrics
+	// need to be told apart.
+	g = modbus.LabelGatherer{
+		Gatherer: g,
+		Labels:   map[string]string{"target": t.Target, "sub_target": strconv.Itoa(t.SubTarget)},
+	}
+
+	filename := filepath.Join(w.directory, textfileName(t))
+	// Writes to a temporary file first, which the textfile collector
+	// ignores, and renames it.
+	if err := prometheus.WriteToTextfile(filename, g); err != nil {
+		level.Error(w.logger).Log("msg", "Failed to write textfile", "file", filename, "err", err)
+	}
+}
+
+// textfileName returns the name of the file of the given target.
+func textfileName(t config.PollTarget) string {
+	name := "modbus_" + t.Target + "_" + strconv.Itoa(t.SubTarget) + "_" + t.Module
+	return invalidFileNameChars.ReplaceAllString(name, "_") + ".prom"
+}
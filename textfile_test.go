@@ -0,0 +1,58 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/RichiH/modbus_exporter/config"
+)
+
+func TestTextfileWriter(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	voltage := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "voltage_volts", Help: "voltage"}, []string{"phase"})
+	voltage.WithLabelValues("1").Set(230.5)
+	reg.MustRegister(voltage)
+
+	dir := t.TempDir()
+	w := textfileWriter{directory: dir, logger: log.NewNopLogger()}
+	target := config.PollTarget{Target: "10.0.0.5:502", SubTarget: 3, Module: "sdm630"}
+	w.write(target, reg)
+
+	b, err := os.ReadFile(filepath.Join(dir, "modbus_10.0.0.5_502_3_sdm630.prom"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `# HELP voltage_volts voltage
+# TYPE voltage_volts gauge
+voltage_volts{phase="1",sub_target="3",target="10.0.0.5:502"} 230.5
+`
+	if string(b) != expected {
+		t.Fatalf("expected textfile\n%s\nbut got\n%s", expected, b)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("expected only the textfile to be left but got %v", entries)
+	}
+}
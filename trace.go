@@ -0,0 +1,120 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+
+	"github.com/RichiH/modbus_exporter/modbus"
+)
+
+// frameTrace writes every ADU sent to and received from targets to a file, a
+// line each with the timestamp, target, unit ID, direction and the bytes in
+// hex, as wire trace to hand to vendors of misbehaving devices. Once the file
+// exceeds the maximum size it is moved to the file name suffixed with .1,
+// replacing the previous one, and a new file is started.
+type frameTrace struct {
+	mtx      sync.Mutex
+	filename string
+	maxSize  int64
+	f        *os.File
+	size     int64
+	logger   log.Logger
+}
+
+func newFrameTrace(filename string, maxSize int64, logger log.Logger) (*frameTrace, error) {
+	t := &frameTrace{filename: filename, maxSize: maxSize, logger: logger}
+	if err := t.open(); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+func (t *frameTrace) open() error {
+	f, err := os.OpenFile(t.filename, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	t.f, t.size = f, info.Size()
+
+	return nil
+}
+
+// rotate moves the current file aside and starts a new one.
+func (t *frameTrace) rotate() error {
+	if err := t.f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(t.filename, t.filename+".1"); err != nil {
+		return err
+	}
+
+	return t.open()
+}
+
+// record writes the given frame to the trace. It implements the listener
+// signature of modbus.WithFrameListener.
+func (t *frameTrace) record(f modbus.Frame) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%v %v unit=%d tx %v\n", f.Time.UTC().Format(time.RFC3339Nano), f.Target, f.SubTarget, hex.EncodeToString(f.Request))
+	received := f.Time.Add(f.Duration).UTC().Format(time.RFC3339Nano)
+	if f.Response != nil {
+		fmt.Fprintf(&b, "%v %v unit=%d rx %v\n", received, f.Target, f.SubTarget, hex.EncodeToString(f.Response))
+	}
+	if f.Err != nil {
+		fmt.Fprintf(&b, "%v %v unit=%d error %v\n", received, f.Target, f.SubTarget, f.Err)
+	}
+
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	if t.f == nil {
+		return
+	}
+	if t.maxSize > 0 && t.size > 0 && t.size+int64(b.Len()) > t.maxSize {
+		if err := t.rotate(); err != nil {
+			level.Error(t.logger).Log("msg", "Failed to rotate trace file, tracing stopped", "err", err)
+			t.f = nil
+			return
+		}
+	}
+	n, err := t.f.WriteString(b.String())
+	t.size += int64(n)
+	if err != nil {
+		level.Error(t.logger).Log("msg", "Failed to write trace file", "err", err)
+	}
+}
+
+func (t *frameTrace) Close() error {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	if t.f == nil {
+		return nil
+	}
+
+	return t.f.Close()
+}
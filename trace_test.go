@@ -0,0 +1,89 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/log"
+
+	"github.com/RichiH/modbus_exporter/config"
+	"github.com/RichiH/modbus_exporter/modbus"
+)
+
+func TestFrameTrace(t *testing.T) {
+	s, address := startServer(t)
+	s.HoldingRegisters[1] = 0x1234
+
+	filename := filepath.Join(t.TempDir(), "trace.log")
+	tr, err := newFrameTrace(filename, 0, log.NewNopLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := modbus.NewExporter(config.Config{}, modbus.WithFrameListener(tr.record))
+	if _, err := e.ReadRaw(context.Background(), address, 1, nil, 3, 1, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := tr.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected a request and a response but got %q", lines)
+	}
+	// The transaction ID is left out, it differs per request.
+	for i, pattern := range []string{
+		`^\S+Z ` + regexp.QuoteMeta(address) + ` unit=1 tx [0-9a-f]{4}00000006010300010001$`,
+		`^\S+Z ` + regexp.QuoteMeta(address) + ` unit=1 rx [0-9a-f]{4}000000050103021234$`,
+	} {
+		if !regexp.MustCompile(pattern).MatchString(lines[i]) {
+			t.Errorf("expected line %d to match %v but got %q", i, pattern, lines[i])
+		}
+	}
+}
+
+func TestFrameTraceRotation(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "trace.log")
+	tr, err := newFrameTrace(filename, 100, log.NewNopLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tr.Close()
+
+	frame := modbus.Frame{Target: "10.0.0.1:502", SubTarget: 1, Request: []byte{0, 1, 0, 0, 0, 6, 1, 3, 0, 0, 0, 1}}
+	for i := 0; i < 3; i++ {
+		tr.record(frame)
+	}
+
+	for _, f := range []string{filename, filename + ".1"} {
+		b, err := os.ReadFile(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(b) == 0 || len(b) > 100 {
+			t.Errorf("expected %v to hold up to 100 bytes but got %d", f, len(b))
+		}
+	}
+}
@@ -0,0 +1,268 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/RichiH/modbus_exporter/config"
+	"github.com/RichiH/modbus_exporter/modbus"
+)
+
+// verifyModule reads each metric of the module from the target on its own and
+// reports the ones answered with exceptions or parsing to values devices use
+// as "not available" or implausible ones hinting at a wrong data type or
+// endianness, as conformance check of a module against a device. Registers of
+// the given documented ranges not covered by any metric are listed too. It
+// returns the exit code, non-zero if there are any problems.
+func verifyModule(w io.Writer, configFile string, opts config.LoadOptions, target, subTargetParam, moduleName, documented string, timeout time.Duration) int {
+	c, err := config.LoadConfigWithOptions(configFile, opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v: %v\n", configFile, err)
+		return 1
+	}
+	module := c.GetModule(moduleName)
+	if module == nil {
+		fmt.Fprintf(os.Stderr, "module '%v' not defined in configuration file\n", moduleName)
+		return 1
+	}
+	subTargets, err := modbus.ParseSubTargets([]*config.Module{module}, subTargetParam)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if len(subTargets) != 1 {
+		fmt.Fprintln(os.Stderr, "exactly one sub target must be given")
+		return 1
+	}
+	ranges, err := parseRegisterRanges(documented)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	e := modbus.NewExporter(config.Config{})
+	problems := 0
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "metric\taddress\tresult\tvalue")
+	for _, d := range module.Metrics {
+		result, value := verifyMetric(e, target, subTargets[0].ID, module, d, timeout)
+		if result != "ok" {
+			problems++
+		}
+		fmt.Fprintf(tw, "%v\t%v\t%v\t%v\n", d.Name, d.Address, result, value)
+	}
+	tw.Flush()
+
+	if uncovered := uncoveredRegisters(module, ranges); len(uncovered) > 0 {
+		problems++
+		fmt.Fprintf(w, "\ndocumented registers not covered by any metric: %v\n", strings.Join(uncovered, ", "))
+	}
+
+	if problems > 0 {
+		return 1
+	}
+
+	return 0
+}
+
+// verifyMetric reads the given metric definition and returns the outcome
+// along with the value if parsed.
+func verifyMetric(e *modbus.Exporter, target string, subTarget byte, module *config.Module, d config.MetricDef, timeout time.Duration) (string, string) {
+	functionCode, address, err := d.Address.Split()
+	if err != nil {
+		return err.Error(), ""
+	}
+	quantity := d.RegisterCount()
+	if config.IsBitAccess(functionCode) {
+		quantity = 1
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	data, err := e.ReadRaw(ctx, target, subTarget, module, functionCode, address, quantity)
+	if err != nil {
+		response, _ := scanResponse(err)
+		return response, ""
+	}
+
+	// Histograms aren't parsed on their own.
+	if d.MetricType == config.MetricTypeHistogram {
+		return "ok", ""
+	}
+
+	raw := d
+	raw.Factor = nil
+	unscaled, err := modbus.ParseValue(raw, data)
+	if err != nil {
+		return fmt.Sprintf("parse error: %v", err), ""
+	}
+	value, _ := modbus.ParseValue(d, data)
+	formatted := strconv.FormatFloat(value, 'g', -1, 64)
+
+	if isSentinel(d.DataType, unscaled) {
+		return "not available value", formatted
+	}
+	if isImplausible(d.DataType, unscaled) {
+		return "implausible value, wrong data type or endianness?", formatted
+	}
+
+	return "ok", formatted
+}
+
+// isSentinel returns whether the unscaled value is one devices commonly use
+// for values not available, e.g. the ones of SunSpec.
+func isSentinel(t config.ModbusDataType, v float64) bool {
+	switch t {
+	case config.ModbusInt16:
+		return v == math.MinInt16
+	case config.ModbusUInt16:
+		return v == math.MaxUint16
+	case config.ModbusInt32:
+		return v == math.MinInt32
+	case config.ModbusUInt32:
+		return v == math.MaxUint32
+	case config.ModbusInt64:
+		return v == math.MinInt64
+	case config.ModbusUInt64:
+		return v == math.MaxUint64
+	case config.ModbusFloat16, config.ModbusFloat32, config.ModbusFloat64:
+		return math.IsNaN(v)
+	}
+
+	return false
+}
+
+// isImplausible returns whether the unscaled floating point value is unlikely
+// to be measured by a device, as values with misordered bytes tend to be huge
+// or tiny.
+func isImplausible(t config.ModbusDataType, v float64) bool {
+	if t != config.ModbusFloat32 && t != config.ModbusFloat64 {
+		return false
+	}
+	abs := math.Abs(v)
+
+	return math.IsInf(v, 0) || abs > 1e12 || (abs != 0 && abs < 1e-12)
+}
+
+// registerRange is a range of registers (or coils, discrete inputs) in the
+// format of the configuration file, both ends included.
+type registerRange struct {
+	from, to config.RegisterAddr
+}
+
+// parseRegisterRanges parses a comma separated list of register ranges like
+// 300000-300099 and single registers.
+func parseRegisterRanges(v string) ([]registerRange, error) {
+	ranges := []registerRange{}
+	if v == "" {
+		return ranges, nil
+	}
+
+	for _, s := range strings.Split(v, ",") {
+		from, to, ok := strings.Cut(s, "-")
+		if !ok {
+			to = from
+		}
+		first, err := strconv.ParseUint(from, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid register range %v: %v", s, err)
+		}
+		last, err := strconv.ParseUint(to, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid register range %v: %v", s, err)
+		}
+		r := registerRange{config.RegisterAddr(first), config.RegisterAddr(last)}
+		firstCode, _, err := r.from.Split()
+		if err != nil {
+			return nil, err
+		}
+		lastCode, _, err := r.to.Split()
+		if err != nil {
+			return nil, err
+		}
+		if firstCode != lastCode || r.from > r.to {
+			return nil, fmt.Errorf("invalid register range %v", s)
+		}
+		ranges = append(ranges, r)
+	}
+
+	return ranges, nil
+}
+
+// uncoveredRegisters returns the registers of the given ranges not read by
+// any metric of the module, consecutive ones merged into ranges.
+func uncoveredRegisters(module *config.Module, ranges []registerRange) []string {
+	covered := map[config.RegisterAddr]bool{}
+	cover := func(address config.RegisterAddr, quantity int) {
+		for i := 0; i < quantity; i++ {
+			covered[address+config.RegisterAddr(i)] = true
+		}
+	}
+	for _, d := range module.Metrics {
+		functionCode, _, err := d.Address.Split()
+		if err != nil {
+			continue
+		}
+		if config.IsBitAccess(functionCode) {
+			cover(d.Address, 1)
+		} else {
+			cover(d.Address, int(d.RegisterCount()))
+		}
+		if d.SumAddress != nil {
+			cover(*d.SumAddress, int(d.DataType.RegisterCount()))
+		}
+		for _, l := range d.LabelRegisters {
+			cover(l.Address, l.Length)
+		}
+	}
+
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].from < ranges[j].from })
+	uncovered := []string{}
+	var start config.RegisterAddr
+	open := false
+	flush := func(end config.RegisterAddr) {
+		if start == end {
+			uncovered = append(uncovered, fmt.Sprint(start))
+		} else {
+			uncovered = append(uncovered, fmt.Sprintf("%v-%v", start, end))
+		}
+		open = false
+	}
+	for _, r := range ranges {
+		for a := r.from; a <= r.to; a++ {
+			switch {
+			case !covered[a] && !open:
+				start, open = a, true
+			case covered[a] && open:
+				flush(a - 1)
+			}
+		}
+		if open {
+			flush(r.to)
+		}
+	}
+
+	return uncovered
+}
@@ -0,0 +1,82 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/RichiH/modbus_exporter/config"
+)
+
+func TestVerifyModule(t *testing.T) {
+	s, address := startServer(t)
+	// 230.5 as float32 is 0x43668000, read with swapped words it is tiny.
+	s.HoldingRegisters[10] = 0x4366
+	s.HoldingRegisters[11] = 0x8000
+	s.HoldingRegisters[20] = 0x8000
+	s.HoldingRegisters[30] = 50
+
+	file := filepath.Join(t.TempDir(), "modbus.yml")
+	if err := os.WriteFile(file, []byte(`
+modules:
+  - name: "meter"
+    protocol: "tcp/ip"
+    metrics:
+      - name: "voltage"
+        help: "Voltage"
+        address: 300010
+        dataType: float32
+        metricType: gauge
+      - name: "voltage_swapped"
+        help: "Voltage"
+        address: 300010
+        dataType: float32
+        endianness: yolo
+        metricType: gauge
+      - name: "power"
+        help: "Power"
+        address: 300020
+        dataType: int16
+        metricType: gauge
+      - name: "frequency"
+        help: "Frequency"
+        address: 300030
+        dataType: uint16
+        factor: 0.1
+        metricType: gauge
+`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var b strings.Builder
+	if code := verifyModule(&b, file, config.LoadOptions{}, address, "1", "meter", "300010-300015,300030", time.Second); code != 1 {
+		t.Fatalf("expected exit code 1 but got %v", code)
+	}
+	for _, p := range []string{
+		`(?m)^voltage\s+300010\s+ok\s+230\.5$`,
+		`(?m)^voltage_swapped\s+300010\s+implausible value`,
+		`(?m)^power\s+300020\s+not available value\s+-32768$`,
+		`(?m)^frequency\s+300030\s+ok\s+5$`,
+		`(?m)^documented registers not covered by any metric: 300012-300015$`,
+	} {
+		if !regexp.MustCompile(p).MatchString(b.String()) {
+			t.Errorf("expected output to match %q but got\n%v", p, b.String())
+		}
+	}
+}
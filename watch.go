@@ -0,0 +1,101 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+
+	"github.com/RichiH/modbus_exporter/config"
+	"github.com/RichiH/modbus_exporter/modbus"
+)
+
+// watchDebounce is the time to wait for further changes after one was
+// noticed, as editors and ConfigMap updates change files in several steps.
+const watchDebounce = time.Second
+
+// watchDirectories returns the directories holding the configuration files the
+// given path refers to. Directories are watched rather than the files, as
+// mounted ConfigMaps are updated by swapping a symlink.
+func watchDirectories(path string) ([]string, error) {
+	if strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://") {
+		return nil, fmt.Errorf("configuration loaded from URL %v can't be watched", path)
+	}
+	if strings.ContainsAny(path, "*?[") {
+		return []string{filepath.Dir(path)}, nil
+	}
+
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		return []string{path}, nil
+	}
+
+	return []string{filepath.Dir(path)}, nil
+}
+
+// watchConfig reloads the configuration whenever the files it is loaded from
+// change, waiting for the given debounce after a change, until the returned
+// function is called. It returns once watching stopped. Invalid configurations
+// are logged and the previous one is kept.
+func watchConfig(e *modbus.Exporter, configFile string, opts config.LoadOptions, debounceDelay time.Duration, logger log.Logger) (func(), error) {
+	dirs, err := watchDirectories(configFile)
+	if err != nil {
+		return nil, err
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range dirs {
+		if err := watcher.Add(d); err != nil {
+			watcher.Close()
+			return nil, fmt.Errorf("failed to watch %v: %w", d, err)
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		var debounce <-chan time.Time
+		for {
+			select {
+			case _, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				debounce = time.After(debounceDelay)
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				level.Error(logger).Log("msg", "Error watching configuration file", "err", err)
+			case <-debounce:
+				debounce = nil
+				reloadConfig(e, configFile, opts, logger)
+			}
+		}
+	}()
+
+	return func() {
+		watcher.Close()
+		<-done
+	}, nil
+}
@@ -0,0 +1,102 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+
+	"github.com/RichiH/modbus_exporter/config"
+	"github.com/RichiH/modbus_exporter/modbus"
+)
+
+func TestWatchDirectories(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "modbus.yml")
+	if err := os.WriteFile(file, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for path, expected := range map[string][]string{
+		file:                               {dir},
+		dir:                                {dir},
+		filepath.Join(dir, "conf.d/*.yml"): {filepath.Join(dir, "conf.d")},
+	} {
+		dirs, err := watchDirectories(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(dirs, expected) {
+			t.Errorf("%v: expected %v but got %v", path, expected, dirs)
+		}
+	}
+
+	if _, err := watchDirectories("https://example.com/modbus.yml"); err == nil {
+		t.Error("expected error for URL")
+	}
+}
+
+func testModules(names ...string) string {
+	s := "modules:\n"
+	for _, n := range names {
+		s += "  - name: " + n + "\n    protocol: tcp/ip\n    metrics:\n" +
+			"      - name: a\n        address: 300001\n        dataType: int16\n        metricType: gauge\n"
+	}
+	return s
+}
+
+func TestWatchConfig(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "modbus.yml")
+	if err := os.WriteFile(file, []byte(testModules("a")), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	c, err := config.LoadConfig(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := modbus.NewExporter(c)
+
+	stop, err := watchConfig(e, file, config.LoadOptions{}, 10*time.Millisecond, log.NewNopLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stop()
+
+	waitForModules := func(n int) {
+		deadline := time.Now().Add(5 * time.Second)
+		for len(e.GetConfig().Modules) != n {
+			if time.Now().After(deadline) {
+				t.Fatalf("expected %d modules but got %d", n, len(e.GetConfig().Modules))
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	if err := os.WriteFile(file, []byte(testModules("a", "b")), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	waitForModules(2)
+
+	// Invalid configurations are not applied.
+	if err := os.WriteFile(file, []byte("modules: [{name: a}]"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	waitForModules(2)
+}
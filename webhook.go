@@ -0,0 +1,209 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/RichiH/modbus_exporter/modbus"
+)
+
+var webhookNotifications = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "modbus_webhook_notifications_total",
+	Help: "Notifications of targets starting to fail or recovering sent to webhooks, by result.",
+}, []string{"result"})
+
+// webhookQueueSize is the number of notifications waiting to be sent, beyond
+// which further ones are dropped.
+const webhookQueueSize = 100
+
+// webhookNotification is the JSON body posted to webhooks.
+type webhookNotification struct {
+	// Status is "failing" or "healthy".
+	Status    string `json:"status"`
+	Target    string `json:"target"`
+	SubTarget byte   `json:"sub_target"`
+	Module    string `json:"module"`
+	// Error of the latest failed scrape.
+	Error string `json:"error,omitempty"`
+	// Since is the time of the first scrape with the new status.
+	Since     time.Time `json:"since"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// webhookTarget is the state of a target, sub target and module.
+type webhookTarget struct {
+	failing bool
+	// Consecutive scrapes with the other outcome than the notified one
+	// and the time of the first of them.
+	streak int
+	since  time.Time
+}
+
+// webhookNotifier posts a notification to webhooks when a target, sub target
+// and module starts failing or recovers, e.g. to open tickets at sites without
+// Alertmanager. Transitions are debounced: only the given number of
+// consecutive scrapes with the new outcome notify. Targets are considered
+// healthy until notified otherwise. Notifications are sent one after the
+// other in the background, so scrapes aren't held up by slow webhooks.
+type webhookNotifier struct {
+	urls     []string
+	debounce int
+	client   *http.Client
+	logger   log.Logger
+
+	mtx     sync.Mutex
+	targets map[string]*webhookTarget
+	closed  bool
+
+	queue chan webhookNotification
+	done  chan struct{}
+}
+
+func newWebhookNotifier(urls []string, debounce int, timeout time.Duration, logger log.Logger) (*webhookNotifier, error) {
+	if debounce < 1 {
+		return nil, fmt.Errorf("debounce must be at least 1")
+	}
+
+	n := &webhookNotifier{
+		urls:     urls,
+		debounce: debounce,
+		client:   &http.Client{Timeout: timeout},
+		logger:   logger,
+		targets:  map[string]*webhookTarget{},
+		queue:    make(chan webhookNotification, webhookQueueSize),
+		done:     make(chan struct{}),
+	}
+	go n.run()
+
+	return n, nil
+}
+
+// record tracks the outcome of the given scrape and queues a notification on
+// a transition. It implements the listener signature of
+// modbus.WithScrapeListener.
+func (n *webhookNotifier) record(s modbus.TargetStatus) {
+	key := fmt.Sprintf("%v/%d/%v", s.Target, s.SubTarget, s.Module)
+
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+	if n.closed {
+		return
+	}
+	t, ok := n.targets[key]
+	if !ok {
+		t = &webhookTarget{}
+	}
+	// Only targets deviating from the default, healthy without a streak,
+	// are kept so that targets no longer scraped don't pile up.
+	defer func() {
+		if t.failing || t.streak > 0 {
+			n.targets[key] = t
+		} else {
+			delete(n.targets, key)
+		}
+	}()
+	if s.Success != t.failing {
+		t.streak = 0
+		return
+	}
+	if t.streak == 0 {
+		t.since = s.LastScrape
+	}
+	t.streak++
+	if t.streak < n.debounce {
+		return
+	}
+	t.failing = !t.failing
+	t.streak = 0
+	notification := webhookNotification{
+		Status:    "healthy",
+		Target:    s.Target,
+		SubTarget: s.SubTarget,
+		Module:    s.Module,
+		Error:     s.Error,
+		Since:     t.since.UTC(),
+		Timestamp: s.LastScrape.UTC(),
+	}
+	if t.failing {
+		notification.Status = "failing"
+	}
+
+	select {
+	case n.queue <- notification:
+	default:
+		webhookNotifications.WithLabelValues("dropped").Inc()
+		level.Error(n.logger).Log("msg", "Webhook queue full, dropping notification", "target", s.Target, "module", s.Module, "status", notification.Status)
+	}
+}
+
+// run sends the queued notifications until the notifier is closed.
+func (n *webhookNotifier) run() {
+	defer close(n.done)
+	for notification := range n.queue {
+		body, err := json.Marshal(notification)
+		if err != nil {
+			level.Error(n.logger).Log("msg", "Failed to encode webhook notification", "err", err)
+			continue
+		}
+		for _, url := range n.urls {
+			if err := n.post(url, body); err != nil {
+				webhookNotifications.WithLabelValues("failure").Inc()
+				level.Error(n.logger).Log("msg", "Failed to send webhook notification", "url", url, "target", notification.Target, "status", notification.Status, "err", err)
+				continue
+			}
+			webhookNotifications.WithLabelValues("success").Inc()
+		}
+	}
+}
+
+func (n *webhookNotifier) post(url string, body []byte) error {
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected status %v", resp.Status)
+	}
+
+	return nil
+}
+
+// Close sends the notifications still queued and stops the notifier.
+func (n *webhookNotifier) Close() error {
+	n.mtx.Lock()
+	n.closed = true
+	close(n.queue)
+	n.mtx.Unlock()
+	<-n.done
+
+	return nil
+}
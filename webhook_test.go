@@ -0,0 +1,96 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+
+	"github.com/RichiH/modbus_exporter/modbus"
+)
+
+func TestWebhookNotifier(t *testing.T) {
+	var mtx sync.Mutex
+	received := []webhookNotification{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("unexpected %v request with content type %v", r.Method, r.Header.Get("Content-Type"))
+		}
+		var n webhookNotification
+		if err := json.NewDecoder(r.Body).Decode(&n); err != nil {
+			t.Error(err)
+		}
+		mtx.Lock()
+		defer mtx.Unlock()
+		received = append(received, n)
+	}))
+	defer srv.Close()
+
+	if _, err := newWebhookNotifier([]string{srv.URL}, 0, time.Second, log.NewNopLogger()); err == nil {
+		t.Fatal("expected debounce of 0 to fail")
+	}
+	n, err := newWebhookNotifier([]string{srv.URL}, 2, time.Second, log.NewNopLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	scrape := func(i int, target string, success bool) {
+		s := modbus.TargetStatus{Target: target, SubTarget: 1, Module: "meter", LastScrape: start.Add(time.Duration(i) * time.Minute), Success: success}
+		if !success {
+			s.Error = "connection refused"
+		}
+		n.record(s)
+	}
+	// A single failure is debounced, two in a row notify once.
+	scrape(0, "a", true)
+	scrape(1, "a", false)
+	scrape(2, "a", true)
+	scrape(3, "a", false)
+	scrape(4, "a", false)
+	scrape(5, "a", false)
+	// Targets are tracked on their own.
+	scrape(5, "b", false)
+	scrape(6, "a", true)
+	scrape(7, "a", true)
+	n.mtx.Lock()
+	if _, ok := n.targets["b/1/meter"]; !ok || len(n.targets) != 1 {
+		t.Errorf("expected only the failure streak of b to be tracked but got %v", n.targets)
+	}
+	n.mtx.Unlock()
+	if err := n.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// Scrapes after closing are ignored.
+	scrape(8, "a", false)
+	scrape(9, "a", false)
+
+	mtx.Lock()
+	defer mtx.Unlock()
+	if len(received) != 2 {
+		t.Fatalf("expected 2 notifications but got %+v", received)
+	}
+	if r := received[0]; r.Status != "failing" || r.Target != "a" || r.SubTarget != 1 || r.Module != "meter" || r.Error != "connection refused" || !r.Since.Equal(start.Add(3*time.Minute)) || !r.Timestamp.Equal(start.Add(4*time.Minute)) {
+		t.Errorf("expected failing notification of a since minute 3 but got %+v", r)
+	}
+	if r := received[1]; r.Status != "healthy" || r.Target != "a" || r.Error != "" || !r.Since.Equal(start.Add(6*time.Minute)) {
+		t.Errorf("expected healthy notification of a since minute 6 but got %+v", r)
+	}
+}
@@ -0,0 +1,125 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log"
+
+	"github.com/RichiH/modbus_exporter/config"
+	"github.com/RichiH/modbus_exporter/modbus"
+)
+
+// auditLog records a JSON record of every write, successful or not, as
+// required by security policies for anything modifying the state of devices.
+type auditLog struct {
+	logger log.Logger
+}
+
+func newAuditLog(w io.Writer) *auditLog {
+	return &auditLog{logger: log.NewJSONLogger(log.NewSyncWriter(w))}
+}
+
+// writeHandler writes the values given as comma separated value parameter to
+// the coil or holding registers at the address parameter, in the address
+// format of metrics, if the module allows it.
+func writeHandler(e *modbus.Exporter, w http.ResponseWriter, r *http.Request, logger log.Logger, audit *auditLog) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST requests allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := r.URL.Query()
+	c := e.GetConfig()
+
+	name := q.Get("module")
+	if name == "" {
+		http.Error(w, "'module' parameter must be specified", http.StatusBadRequest)
+		return
+	}
+	module := c.GetModule(name)
+	if module == nil {
+		http.Error(w, fmt.Sprintf("module '%v' not defined in configuration file", name), http.StatusBadRequest)
+		return
+	}
+
+	target := q.Get("target")
+	if target == "" {
+		http.Error(w, "'target' parameter must be specified", http.StatusBadRequest)
+		return
+	}
+	if err := checkTarget(c, target, r, logger); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	subTarget, err := modbus.ParseSubTarget(q.Get("sub_target"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	address, err := strconv.ParseUint(q.Get("address"), 10, 32)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("'address' parameter must be a valid address: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	values := []uint16{}
+	for _, v := range strings.Split(q.Get("value"), ",") {
+		value, err := strconv.ParseUint(v, 10, 16)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("'value' parameter must be a comma separated list of values from 0 to 65535: %v", err), http.StatusBadRequest)
+			return
+		}
+		values = append(values, uint16(value))
+	}
+
+	err = e.Write(r.Context(), target, byte(subTarget), module, config.RegisterAddr(address), values)
+
+	result := "success"
+	if err != nil {
+		result = err.Error()
+	}
+	user, _, _ := r.BasicAuth()
+	audit.logger.Log(
+		"ts", time.Now().UTC().Format(time.RFC3339Nano),
+		"client", r.RemoteAddr,
+		"user", user,
+		"target", target,
+		"sub_target", subTarget,
+		"module", name,
+		"address", address,
+		"values", values,
+		"result", result,
+	)
+
+	switch {
+	case errors.Is(err, modbus.ErrInvalidWrite):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, modbus.ErrNotWritable), errors.Is(err, modbus.ErrReadOnly):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case err != nil:
+		http.Error(w, fmt.Sprintf("failed to write to target '%v': %v", target, err), modbus.DefaultStatusCodes.Code(err))
+	default:
+		fmt.Fprintln(w, "OK")
+	}
+}
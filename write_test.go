@@ -0,0 +1,136 @@
+// Copyright 2023 Richard Hartmann
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/log"
+
+	"github.com/RichiH/modbus_exporter/config"
+	"github.com/RichiH/modbus_exporter/modbus"
+)
+
+func TestWriteHandler(t *testing.T) {
+	s, address := startServer(t)
+
+	e := modbus.NewExporter(config.Config{
+		Modules: []config.Module{
+			{
+				Name:     "my_module",
+				Protocol: config.ModbusProtocolTCPIP,
+				Timeout:  500,
+				Writable: []config.WritableRange{{From: 100010, To: 100019}, {From: 300100, To: 300103}},
+			},
+		},
+	}, modbus.WithWrites())
+
+	var buf bytes.Buffer
+	audit := newAuditLog(&buf)
+
+	for _, test := range []struct {
+		name   string
+		method string
+		params url.Values
+		code   int
+	}{
+		{
+			name:   "coil",
+			params: url.Values{"module": {"my_module"}, "target": {address}, "sub_target": {"1"}, "address": {"100010"}, "value": {"1"}},
+			code:   http.StatusOK,
+		},
+		{
+			name:   "holding register",
+			params: url.Values{"module": {"my_module"}, "target": {address}, "sub_target": {"1"}, "address": {"300100"}, "value": {"240"}},
+			code:   http.StatusOK,
+		},
+		{
+			name:   "holding registers",
+			params: url.Values{"module": {"my_module"}, "target": {address}, "sub_target": {"1"}, "address": {"300102"}, "value": {"1,2"}},
+			code:   http.StatusOK,
+		},
+		{
+			name:   "not writable",
+			params: url.Values{"module": {"my_module"}, "target": {address}, "sub_target": {"1"}, "address": {"300103"}, "value": {"1,2"}},
+			code:   http.StatusForbidden,
+		},
+		{
+			name:   "several coils",
+			params: url.Values{"module": {"my_module"}, "target": {address}, "sub_target": {"1"}, "address": {"100010"}, "value": {"1,1"}},
+			code:   http.StatusBadRequest,
+		},
+		{
+			name:   "invalid value",
+			params: url.Values{"module": {"my_module"}, "target": {address}, "sub_target": {"1"}, "address": {"300100"}, "value": {"65536"}},
+			code:   http.StatusBadRequest,
+		},
+		{
+			name:   "no module",
+			params: url.Values{"target": {address}, "sub_target": {"1"}, "address": {"300100"}, "value": {"1"}},
+			code:   http.StatusBadRequest,
+		},
+		{
+			name:   "GET",
+			method: "GET",
+			params: url.Values{"module": {"my_module"}, "target": {address}, "sub_target": {"1"}, "address": {"300100"}, "value": {"1"}},
+			code:   http.StatusMethodNotAllowed,
+		},
+	} {
+		method := test.method
+		if method == "" {
+			method = "POST"
+		}
+		rr := httptest.NewRecorder()
+		writeHandler(e, rr, httptest.NewRequest(method, "/write?"+test.params.Encode(), nil), log.NewNopLogger(), audit)
+
+		if rr.Code != test.code {
+			t.Fatalf("%v: expected status %v but got %v: %v", test.name, test.code, rr.Code, rr.Body.String())
+		}
+	}
+
+	if s.Coils[10] != 1 {
+		t.Fatalf("expected coil 10 to be on but got %v", s.Coils[10])
+	}
+	if expected := []uint16{240, 0, 1, 2}; !reflect.DeepEqual(s.HoldingRegisters[100:104], expected) {
+		t.Fatalf("expected holding registers %v but got %v", expected, s.HoldingRegisters[100:104])
+	}
+
+	// Only writes reaching the exporter, successful or not, are recorded.
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 5 {
+		t.Fatalf("expected 5 audit records but got %q", lines)
+	}
+	if !strings.Contains(lines[1], `"address":300100`) || !strings.Contains(lines[1], `"result":"success"`) || !strings.Contains(lines[1], `"values":[240]`) {
+		t.Fatalf("unexpected audit record %v", lines[1])
+	}
+	if !strings.Contains(lines[3], `"result":"address not writable`) {
+		t.Fatalf("expected failed write to be recorded but got %v", lines[3])
+	}
+
+	// Read-only exporters refuse all writes.
+	e = modbus.NewExporter(*e.GetConfig())
+	s.HoldingRegisters[100] = 0
+	rr := httptest.NewRecorder()
+	params := url.Values{"module": {"my_module"}, "target": {address}, "sub_target": {"1"}, "address": {"300100"}, "value": {"240"}}
+	writeHandler(e, rr, httptest.NewRequest("POST", "/write?"+params.Encode(), nil), log.NewNopLogger(), audit)
+	if rr.Code != http.StatusForbidden || s.HoldingRegisters[100] != 0 {
+		t.Fatalf("expected write to read-only exporter to be refused but got %v", rr.Code)
+	}
+}